@@ -3,263 +3,1804 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"maps"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/galadd/cogsworth/api"
+)
+
+// The envelope, its error codes and the errors they stand for are shared
+// with clients through package api.
+const (
+	apiVersion = api.Version
+	apiPrefix  = api.Prefix
+)
+
+type (
+	apiMeta          = api.Meta
+	apiResponse      = api.Response
+	apiError         = api.ErrorBody
+	apiErrorResponse = api.ErrorResponse
+)
+
+const (
+	codeBadRequest       = api.CodeBadRequest
+	codeUnauthorized     = api.CodeUnauthorized
+	codeForbidden        = api.CodeForbidden
+	codeNotFound         = api.CodeNotFound
+	codeMethodNotAllowed = api.CodeMethodNotAllowed
+	codeConflict         = api.CodeConflict
+	codeGone             = api.CodeGone
+	codeKeyReused        = api.CodeKeyReused
+	codeInternal         = api.CodeInternal
+	codeBadGateway       = api.CodeBadGateway
+	codeUnavailable      = api.CodeUnavailable
+)
+
+var (
+	ErrBadRequest   = api.ErrBadRequest
+	ErrUnauthorized = api.ErrUnauthorized
+	ErrForbidden    = api.ErrForbidden
+	ErrNotFound     = api.ErrNotFound
+	ErrConflict     = api.ErrConflict
+	ErrNodeRemoved  = api.ErrNodeRemoved
+	ErrKeyReused    = api.ErrKeyReused
+)
+
+func writeData(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiResponse{
+		Data: data,
+		Meta: apiMeta{Version: apiVersion},
+	})
+}
+
+// writePage writes a page of results with the cursor for the next page.
+func writePage(w http.ResponseWriter, data any, nextCursor string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(apiResponse{
+		Data: data,
+		Meta: apiMeta{Version: apiVersion, NextCursor: nextCursor},
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiErrorResponse{
+		Error: apiError{Code: code, Message: msg},
+		Meta:  apiMeta{Version: apiVersion},
+	})
+}
+
+// writeErrorDetails is writeError with structured details attached.
+func writeErrorDetails(w http.ResponseWriter, status int, code, msg string, details any) {
+	data, err := json.Marshal(details)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiErrorResponse{
+		Error: apiError{Code: code, Message: msg, Details: data},
+		Meta:  apiMeta{Version: apiVersion},
+	})
+}
+
+// writeStoreError maps store errors onto API errors.
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrInvalidTransition) || errors.Is(err, ErrNameInUse) || errors.Is(err, ErrConflict) {
+		writeError(w, http.StatusConflict, codeConflict, err.Error())
+		return
+	}
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, codeNotFound, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+}
+
+const (
+	defaultPageLimit = api.DefaultPageLimit
+	maxPageLimit     = 1000
 )
 
-type APIServer struct {
-	store Store
-	addr  string
+// watchKeepalive is how often an idle watch stream is pinged so proxies and
+// clients can tell a quiet stream from a dead one.
+const watchKeepalive = 15 * time.Second
+
+// tokenEnv names the environment variable holding the shared cluster token
+// that guards sensitive endpoints such as secret resolution.
+const tokenEnv = "COGS_TOKEN"
+
+// agentTimeout bounds a call to a worker's agent. Proxied logs and exec
+// output may stream for longer; only their response headers must arrive
+// within it.
+const agentTimeout = 10 * time.Second
+
+const nodeIDHeader = api.NodeIDHeader
+
+type APIServer struct {
+	store Store
+	feed  *changeFeed
+	token string
+
+	logger      *slog.Logger
+	logRequests bool
+
+	// requireNodeCapacity rejects nodes registering without positive CPU
+	// and memory capacity.
+	requireNodeCapacity bool
+
+	// trigger starts an immediate control plane reconcile.
+	trigger func()
+
+	idempotency keyLocks
+	removed     nodeTombstones
+
+	// agents calls worker agents, and agentStreams carries proxied output
+	// that lasts as long as the caller keeps reading it.
+	agents       *http.Client
+	agentStreams *http.Client
+
+	startedAt time.Time
+	server    *http.Server
+	done      chan struct{}
+	closeDone sync.Once
+}
+
+func NewAPIServer(store Store, feed *changeFeed, addr string) *APIServer {
+	return &APIServer{
+		store: store,
+		feed:  feed,
+		token: os.Getenv(tokenEnv),
+
+		logger:      slog.Default(),
+		logRequests: true,
+
+		agents:       &http.Client{Timeout: agentTimeout},
+		agentStreams: &http.Client{Transport: streamTransport(agentTimeout)},
+
+		startedAt: time.Now(),
+		server:    &http.Server{Addr: addr},
+		done:      make(chan struct{}),
+	}
+}
+
+// authorized checks the request's bearer token against the cluster token.
+// Without a configured token the guarded endpoints are disabled.
+func (s *APIServer) authorized(w http.ResponseWriter, r *http.Request) bool {
+	return checkToken(w, r, s.token, "control plane")
+}
+
+// checkToken is authorized for any server holding the cluster token; where
+// names the server in the error when it has none.
+func checkToken(w http.ResponseWriter, r *http.Request, want, where string) bool {
+	if want == "" {
+		writeError(w, http.StatusForbidden, codeForbidden, tokenEnv+" is not set on the "+where)
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+		writeError(w, http.StatusUnauthorized, codeUnauthorized, "invalid or missing token")
+		return false
+	}
+
+	return true
+}
+
+// streamTransport is the default transport with a bound on the wait for
+// response headers in place of an overall timeout.
+func streamTransport(timeout time.Duration) http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = timeout
+	return t
+}
+
+// Shutdown ends open watch streams and gracefully stops the HTTP server.
+func (s *APIServer) Shutdown(ctx context.Context) error {
+	s.closeDone.Do(func() { close(s.done) })
+	return s.server.Shutdown(ctx)
+}
+
+func (s *APIServer) assignedContainers(ctx context.Context, nodeID string) ([]*Container, error) {
+	containers, err := s.store.ListContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	assigned := []*Container{}
+	versions := make(map[string]int64)
+	for _, c := range containers {
+		if c.NodeID != nodeID || !c.Scheduled {
+			continue
+		}
+
+		c.SecretVersions = nil
+		for _, name := range c.SecretRefs {
+			version, ok := versions[name]
+			if !ok {
+				// a missing secret surfaces when the worker resolves env
+				if secret, err := s.store.GetSecret(ctx, name); err == nil {
+					version = secret.Version
+				}
+				versions[name] = version
+			}
+
+			if c.SecretVersions == nil {
+				c.SecretVersions = make(map[string]int64, len(c.SecretRefs))
+			}
+			c.SecretVersions[name] = version
+		}
+
+		assigned = append(assigned, c)
+	}
+
+	return assigned, nil
+}
+
+// nodeTombstoneTTL is how long a removed node's heartbeats are answered
+// with 410, long enough for its worker to see one and shut down.
+const nodeTombstoneTTL = 10 * time.Minute
+
+// nodeTombstones remembers the nodes removed with node rm, so their
+// workers are told to stop rather than register again. They live in memory
+// only.
+type nodeTombstones struct {
+	mu    sync.Mutex
+	nodes map[string]time.Time
+}
+
+func (t *nodeTombstones) add(id string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.nodes == nil {
+		t.nodes = make(map[string]time.Time)
+	}
+	t.nodes[id] = now.Add(nodeTombstoneTTL)
+}
+
+func (t *nodeTombstones) has(id string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	expires, ok := t.nodes[id]
+	if ok && now.After(expires) {
+		delete(t.nodes, id)
+		return false
+	}
+	return ok
+}
+
+func (t *nodeTombstones) forget(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.nodes, id)
+}
+
+// handleDeleteNode removes a node record. It refuses while the node still
+// runs containers unless forced, in which case those containers are
+// unscheduled so they get placed elsewhere.
+func (s *APIServer) handleDeleteNode(w http.ResponseWriter, r *http.Request, nodeID string, force bool) {
+	node, err := s.store.GetNode(r.Context(), nodeID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if node.Role == ControlPlane {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "the control plane node can't be removed")
+		return
+	}
+
+	containers, err := s.store.ListContainers(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+
+	var hosted []*Container
+	for _, c := range containers {
+		if c.NodeID == nodeID && c.DesiredState != Destroyed {
+			hosted = append(hosted, c)
+		}
+	}
+
+	running := 0
+	for _, c := range hosted {
+		if c.State == Running || c.State == Paused {
+			running++
+		}
+	}
+	if running > 0 && !force {
+		writeError(w, http.StatusConflict, codeConflict,
+			fmt.Sprintf("node %s still runs %d containers, use force to remove it anyway", nodeID, running))
+		return
+	}
+	// force is for nodes that are gone; a live one would keep running
+	// the containers rescheduled elsewhere
+	if running > 0 && !heartbeatExpired(node, time.Now()) {
+		writeError(w, http.StatusConflict, codeConflict,
+			fmt.Sprintf("node %s is still heartbeating and runs %d containers, drain it or stop its worker first", nodeID, running))
+		return
+	}
+
+	if err := s.store.DelNode(r.Context(), nodeID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	s.removed.add(nodeID, time.Now())
+
+	for _, c := range hosted {
+		_, err := modifyContainer(r.Context(), s.store, c.ID, func(c *Container) error {
+			// the runtime container belonged to the departed node
+			c.LastNodeID = c.NodeID
+			c.NodeID = ""
+			c.ContainerID = ""
+			c.Scheduled = false
+			c.UpdatedAt = time.Now()
+			return nil
+		})
+		if err != nil {
+			log.Printf("[API] Failed to unschedule container %s from removed node %s: %v", c.ID, nodeID, err)
+		}
+	}
+
+	log.Printf("[API] Node removed: %s (%d containers unscheduled)", nodeID, len(hosted))
+	writeData(w, http.StatusOK, nil)
+}
+
+// resolveContainerRef maps a container name onto the ID holding it. IDs,
+// and references that match nothing, are returned unchanged.
+func (s *APIServer) resolveContainerRef(ctx context.Context, ref string) string {
+	if _, err := s.store.GetContainer(ctx, ref); err == nil {
+		return ref
+	}
+	if c, err := s.store.GetContainerByName(ctx, ref); err == nil {
+		return c.ID
+	}
+	return ref
+}
+
+// parseContainerFilter reads the state and selector query parameters of a
+// container list. state may be repeated or comma-separated.
+func parseContainerFilter(query url.Values) (ContainerFilter, error) {
+	var filter ContainerFilter
+	for _, value := range query["state"] {
+		states, err := parseStates(value)
+		if err != nil {
+			return ContainerFilter{}, err
+		}
+		filter.States = append(filter.States, states...)
+	}
+
+	selector, err := parseSelector(query.Get("selector"))
+	if err != nil {
+		return ContainerFilter{}, err
+	}
+	if len(selector) > 0 {
+		filter.Selector = selector
+	}
+	return filter, nil
+}
+
+// listContainersPage returns up to limit containers matching filter,
+// starting at cursor. The next cursor is the ID of the first match left
+// over, so paging stays stable while the filter skips store pages.
+func (s *APIServer) listContainersPage(ctx context.Context, cursor string, limit int, filter ContainerFilter) ([]*Container, string, error) {
+	if len(filter.States) == 0 && len(filter.Selector) == 0 {
+		return s.store.ListContainersPage(ctx, cursor, limit)
+	}
+
+	var matched []*Container
+	for {
+		page, next, err := s.store.ListContainersPage(ctx, cursor, limit)
+		if err != nil {
+			return nil, "", err
+		}
+
+		for _, c := range page {
+			if !matchesFilter(filter, c) {
+				continue
+			}
+			if len(matched) == limit {
+				return matched, c.ID, nil
+			}
+			matched = append(matched, c)
+		}
+
+		if next == "" {
+			return matched, "", nil
+		}
+		cursor = next
+	}
+}
+
+// handleBulkDelete marks every container matching the request Destroyed.
+// It requires either all=true or a label selector, and skips protected
+// containers unless force_protected=true. With dry_run=true nothing is saved.
+func (s *APIServer) handleBulkDelete(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	all := query.Get("all") == "true"
+	forceProtected := query.Get("force_protected") == "true"
+	dryRun := query.Get("dry_run") == "true"
+
+	selector, err := parseSelector(query.Get("selector"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	if !all && len(selector) == 0 {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "either all=true or a selector is required")
+		return
+	}
+
+	containers, err := s.store.ListContainers(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+
+	result := BulkDeleteResult{Destroyed: []string{}, Skipped: []string{}}
+	leaving := make(map[string]bool)
+	for _, c := range containers {
+		if c.DesiredState != Destroyed && matchesSelector(c.Labels, selector) && (!c.Protected || forceProtected) {
+			leaving[c.ID] = true
+		}
+	}
+	// a container that stays keeps its dependencies, and they in turn
+	// keep theirs
+	for kept := true; kept; {
+		kept = false
+		for _, c := range containers {
+			if leaving[c.ID] || c.DesiredState == Destroyed {
+				continue
+			}
+			for _, dep := range c.DependsOn {
+				if leaving[dep] {
+					delete(leaving, dep)
+					kept = true
+				}
+			}
+		}
+	}
+
+	for _, c := range containers {
+		if c.DesiredState == Destroyed || !matchesSelector(c.Labels, selector) {
+			continue
+		}
+
+		if !leaving[c.ID] {
+			result.Skipped = append(result.Skipped, c.ID)
+			continue
+		}
+
+		if !dryRun {
+			_, err := modifyContainer(r.Context(), s.store, c.ID, func(c *Container) error {
+				c.DesiredState = Destroyed
+				c.UpdatedAt = time.Now()
+				return nil
+			})
+			if err != nil {
+				writeStoreError(w, err)
+				return
+			}
+		}
+		result.Destroyed = append(result.Destroyed, c.ID)
+	}
+
+	if !dryRun {
+		log.Printf("[API] Bulk delete (selector %q): %d destroyed, %d protected or depended on skipped",
+			api.FormatSelector(selector), len(result.Destroyed), len(result.Skipped))
+	}
+	writeData(w, http.StatusOK, result)
+}
+
+// handleStatusBatch saves the statuses a worker reported for one tick in a
+// single transaction, in the order they happened. A container may appear
+// more than once, each entry checked against the one before it.
+func (s *APIServer) handleStatusBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var containers []*Container
+	if err := json.NewDecoder(r.Body).Decode(&containers); err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	if len(containers) == 0 {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "batch is empty")
+		return
+	}
+
+	if err := s.saveStatuses(r.Context(), containers); err != nil {
+		if errors.Is(err, ErrInvalidTransition) {
+			log.Printf("[API] Rejected status batch: %v", err)
+		}
+		writeStoreError(w, err)
+		return
+	}
+
+	log.Printf("[API] %d container statuses updated", len(containers))
+	writeData(w, http.StatusOK, nil)
+}
+
+// saveStatuses saves worker status reports in one transaction, rebasing
+// them again whenever a container changes before they land.
+func (s *APIServer) saveStatuses(ctx context.Context, reports []*Container) error {
+	for attempt := 1; ; attempt++ {
+		rebased, err := s.rebaseStatuses(ctx, reports)
+		if err != nil {
+			return err
+		}
+
+		err = s.store.SaveContainers(ctx, rebased)
+		if errors.Is(err, ErrConflict) && attempt < maxConflictRetries {
+			continue
+		}
+		return err
+	}
+}
+
+// rebaseStatuses readies worker status reports for saving. A worker builds
+// its reports on the version it was last handed, so while that is still the
+// stored one each report is taken whole, in order. Once the container has
+// changed since, only what the worker observed is copied onto the stored
+// record, keeping the newer change. Reports for containers no longer stored
+// are dropped, so a queued status can't bring back a deleted or compacted
+// record.
+func (s *APIServer) rebaseStatuses(ctx context.Context, reports []*Container) ([]*Container, error) {
+	type head struct {
+		c       *Container
+		base    int64 // the version reports taken whole are built on
+		version int64 // the version the next entry must carry
+	}
+
+	heads := make(map[string]*head)
+	rebased := make([]*Container, 0, len(reports))
+	for _, report := range reports {
+		h, ok := heads[report.ID]
+		if !ok {
+			stored, err := s.store.GetContainer(ctx, report.ID)
+			switch {
+			case errors.Is(err, ErrNotFound):
+				// h stays nil
+			case err != nil:
+				return nil, err
+			default:
+				h = &head{c: stored, base: stored.ResourceVersion, version: stored.ResourceVersion}
+			}
+			heads[report.ID] = h
+		}
+		if h == nil {
+			continue
+		}
+
+		next := *report
+		if report.ResourceVersion != h.base {
+			next = *withStatus(h.c, report)
+		}
+		next.ResourceVersion = h.version
+		h.c, h.version = &next, nextVersion(&next)
+		rebased = append(rebased, &next)
+	}
+	return rebased, nil
+}
+
+// handleBatchCreate validates every submitted container and saves them in
+// one transaction. A single invalid spec rejects the whole batch, with the
+// per-item results in the error details.
+func (s *APIServer) handleBatchCreate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var containers []*Container
+	if err := json.NewDecoder(r.Body).Decode(&containers); err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	if len(containers) == 0 {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "batch is empty")
+		return
+	}
+
+	existing, err := s.store.ListContainers(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	// members may depend on each other as well as on existing containers
+	known := append(existing, containers...)
+
+	results := make([]BatchItemResult, len(containers))
+	seen := make(map[string]bool, len(containers))
+	failed := 0
+	for i, c := range containers {
+		results[i] = BatchItemResult{Index: i, ID: c.ID}
+		defaultNetwork(c)
+
+		err := admitContainer(c, known)
+		if err == nil && seen[c.ID] {
+			err = fmt.Errorf("duplicate id %s in batch", c.ID)
+		}
+		seen[c.ID] = true
+
+		if err != nil {
+			results[i].Error = strings.ReplaceAll(err.Error(), "\n", "; ")
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		writeErrorDetails(w, http.StatusBadRequest, codeBadRequest,
+			fmt.Sprintf("%d of %d containers are invalid, nothing was saved", failed, len(containers)), results)
+		return
+	}
+
+	if err := s.store.SaveContainers(r.Context(), containers); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	log.Printf("[API] Batch created %d containers", len(containers))
+	writeData(w, http.StatusOK, results)
+}
+
+// handleServices lists services or creates (replacing) one. A new
+// definition's endpoints are filled in straight away rather than on the
+// next reconcile.
+func (s *APIServer) handleServices(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		services, err := s.store.ListServices(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		if services == nil {
+			services = []*Service{}
+		}
+		writeData(w, http.StatusOK, services)
+
+	case http.MethodPost:
+		var svc Service
+		if err := json.NewDecoder(r.Body).Decode(&svc); err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+		if err := validateService(&svc); err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest,
+				strings.ReplaceAll(err.Error(), "\n", "; "))
+			return
+		}
+
+		containers, err := s.store.ListContainers(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+
+		now := time.Now()
+		svc.CreatedAt = now
+		svc.UpdatedAt = now
+		if existing, err := s.store.GetService(r.Context(), svc.Name); err == nil {
+			svc.CreatedAt = existing.CreatedAt
+		}
+		svc.Endpoints = serviceEndpoints(&svc, containers)
+
+		if err := s.store.SaveService(r.Context(), &svc); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		log.Printf("[API] Service saved: %s (selector %q, %d endpoints)", svc.Name, api.FormatSelector(svc.Selector), len(svc.Endpoints))
+		writeData(w, http.StatusOK, &svc)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleService serves GET and DELETE /services/{name}.
+func (s *APIServer) handleService(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/services/")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Service name required")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		svc, err := s.store.GetService(r.Context(), name)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, svc)
+
+	case http.MethodDelete:
+		if err := s.store.DelService(r.Context(), name); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		log.Printf("[API] Service deleted: %s", name)
+		writeData(w, http.StatusOK, nil)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleNodeMaintenance serves POST and DELETE /nodes/{id}/maintenance,
+// scheduling a node's maintenance window or cancelling it.
+func (s *APIServer) handleNodeMaintenance(w http.ResponseWriter, r *http.Request, nodeID string) {
+	var update func(node *Node) error
+	switch r.Method {
+	case http.MethodPost:
+		var window MaintenanceWindow
+		if err := json.NewDecoder(r.Body).Decode(&window); err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+		if err := validateMaintenance(&window); err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest,
+				strings.ReplaceAll(err.Error(), "\n", "; "))
+			return
+		}
+
+		update = func(node *Node) error {
+			// moving a window that already cordoned the node keeps that
+			// cordon its own to lift
+			scheduled := window
+			scheduled.Cordoned = node.Maintenance != nil && node.Maintenance.Cordoned
+			node.Maintenance = &scheduled
+			return nil
+		}
+
+	case http.MethodDelete:
+		update = func(node *Node) error {
+			if node.Maintenance != nil && node.Maintenance.Cordoned {
+				node.Unschedulable = false
+			}
+			node.Maintenance = nil
+			return nil
+		}
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	// written in one transaction, so a heartbeat or maintenance pass saved
+	// meanwhile isn't overwritten
+	node, err := s.store.UpdateNode(r.Context(), nodeID, update)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if m := node.Maintenance; m != nil {
+		log.Printf("[API] Node %s maintenance scheduled from %s for %s", node.ID, m.Start.Format(time.RFC3339), m.Duration)
+	} else {
+		log.Printf("[API] Node %s maintenance cancelled", node.ID)
+	}
+	if s.trigger != nil {
+		s.trigger()
+	}
+	writeData(w, http.StatusOK, node)
+}
+
+func (s *APIServer) handleAutoscalers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		autoscalers, err := s.store.ListAutoscalers(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+			return
+		}
+		if autoscalers == nil {
+			autoscalers = []*HorizontalAutoscaler{}
+		}
+		writeData(w, http.StatusOK, autoscalers)
+
+	case http.MethodPost:
+		var hpa HorizontalAutoscaler
+		if err := json.NewDecoder(r.Body).Decode(&hpa); err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+		if err := validateAutoscaler(&hpa); err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest,
+				strings.ReplaceAll(err.Error(), "\n", "; "))
+			return
+		}
+
+		// status is the reconciler's to fill in; zero replicas has it
+		// start from the deployment's current size
+		now := time.Now()
+		hpa.CreatedAt = now
+		hpa.UpdatedAt = now
+		hpa.Replicas = 0
+		hpa.CurrentCPUPercent = 0
+		hpa.LastScaleAt = time.Time{}
+		if existing, err := s.store.GetAutoscaler(r.Context(), hpa.Deployment); err == nil {
+			hpa.CreatedAt = existing.CreatedAt
+			hpa.Replicas = existing.Replicas
+			hpa.CurrentCPUPercent = existing.CurrentCPUPercent
+			hpa.LastScaleAt = existing.LastScaleAt
+		}
+
+		if err := s.store.SaveAutoscaler(r.Context(), &hpa); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		log.Printf("[API] Autoscaler saved: %s (%d-%d replicas, target CPU %.1f%%)", hpa.Deployment, hpa.MinReplicas, hpa.MaxReplicas, hpa.TargetCPUPercent)
+		writeData(w, http.StatusOK, &hpa)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleAutoscaler serves GET and DELETE /autoscalers/{deployment}.
+func (s *APIServer) handleAutoscaler(w http.ResponseWriter, r *http.Request) {
+	deployment := strings.TrimPrefix(r.URL.Path, "/autoscalers/")
+	if deployment == "" {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Deployment name required")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		hpa, err := s.store.GetAutoscaler(r.Context(), deployment)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, hpa)
+
+	case http.MethodDelete:
+		if err := s.store.DelAutoscaler(r.Context(), deployment); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		log.Printf("[API] Autoscaler deleted: %s", deployment)
+		writeData(w, http.StatusOK, nil)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// handleScale serves POST /deployments/{name}/scale, setting the number of
+// replicas a deployment runs by copying or destroying them.
+func (s *APIServer) handleScale(w http.ResponseWriter, r *http.Request) {
+	deployment, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/deployments/"), "/")
+	if deployment == "" {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "Deployment name required")
+		return
+	}
+	if action != "scale" {
+		writeError(w, http.StatusNotFound, codeNotFound, "unknown deployment action")
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var scale Scale
+	if err := json.NewDecoder(r.Body).Decode(&scale); err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	if scale.Replicas < 0 {
+		writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("replicas %d is negative", scale.Replicas))
+		return
+	}
+
+	// the autoscaler would undo the change on its next pass
+	if _, err := s.store.GetAutoscaler(r.Context(), deployment); err == nil {
+		writeError(w, http.StatusConflict, codeConflict,
+			fmt.Sprintf("deployment %s is autoscaled, change its autoscaler's min and max instead", deployment))
+		return
+	} else if !errors.Is(err, ErrNotFound) {
+		writeStoreError(w, err)
+		return
+	}
+
+	containers, err := s.store.ListContainers(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	replicas := deploymentReplicas(containers, deployment)
+	if len(replicas) == 0 {
+		writeError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("deployment %s has no replicas", deployment))
+		return
+	}
+
+	created, destroyed, err := scaleDeployment(r.Context(), s.store, deployment, scale.Replicas, replicas, time.Now())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if s.trigger != nil && len(created) > 0 {
+		s.trigger()
+	}
+
+	reached := len(replicas) + len(created) - len(destroyed)
+	log.Printf("[API] Deployment %s scaled from %d to %d replicas", deployment, len(replicas), reached)
+	writeData(w, http.StatusOK, &Scale{Deployment: deployment, Replicas: reached})
+}
+
+// proxyToWorker forwards a per-container request to the agent of the worker
+// running it, rewriting the path to use the runtime container ID.
+func (s *APIServer) proxyToWorker(w http.ResponseWriter, r *http.Request, containerID, action string) {
+	container, err := s.store.GetContainer(r.Context(), containerID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if container.NodeID == "" || container.ContainerID == "" {
+		writeError(w, http.StatusConflict, codeConflict, "container is not running on a worker")
+		return
+	}
+
+	node, err := s.store.GetNode(r.Context(), container.NodeID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if node.AgentAddr == "" {
+		writeError(w, http.StatusBadGateway, codeBadGateway, "node "+node.ID+" has no agent address")
+		return
+	}
+
+	target := fmt.Sprintf("http://%s/containers/%s/%s", node.AgentAddr, container.ContainerID, action)
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, target, r.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	s.agentAuth(req)
+
+	client := s.agents
+	if action == "logs" || action == "exec" {
+		client = s.agentStreams
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, codeBadGateway, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	copyFlush(w, resp.Body)
+}
+
+// agentAuth has req carry the cluster token worker agents require.
+func (s *APIServer) agentAuth(req *http.Request) {
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+}
+
+// proxyReconcile forwards a reconcile trigger to a worker's agent.
+func (s *APIServer) proxyReconcile(w http.ResponseWriter, r *http.Request, nodeID string) {
+	node, err := s.store.GetNode(r.Context(), nodeID)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	if node.AgentAddr == "" {
+		writeError(w, http.StatusBadGateway, codeBadGateway, "node "+node.ID+" has no agent address")
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, "http://"+node.AgentAddr+"/reconcile", nil)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	s.agentAuth(req)
+
+	resp, err := s.agents.Do(req)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, codeBadGateway, err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// summarizeNodes joins nodes with the containers assigned to them.
+func summarizeNodes(nodes []*Node, containers []*Container) []*NodeSummary {
+	byNode := make(map[string]*NodeSummary, len(nodes))
+	summaries := make([]*NodeSummary, 0, len(nodes))
+	for _, node := range nodes {
+		summary := &NodeSummary{Node: node}
+		byNode[node.ID] = summary
+		summaries = append(summaries, summary)
+	}
+
+	for _, c := range containers {
+		summary, ok := byNode[c.NodeID]
+		if !ok || !c.Scheduled {
+			continue
+		}
+
+		summary.Containers++
+		if c.State == Running {
+			summary.Running++
+		}
+	}
+
+	return summaries
+}
+
+// maxClockSkew is how far a node's clock may drift from the control plane's
+// before it is reported. It leaves room for heartbeat latency.
+const maxClockSkew = 2 * time.Second
+
+func skewed(skew time.Duration) bool {
+	return skew > maxClockSkew || skew < -maxClockSkew
+}
+
+// summarizeCluster aggregates nodes and containers into a ClusterStatus.
+func summarizeCluster(nodes []*Node, containers []*Container, startedAt, now time.Time) *ClusterStatus {
+	status := &ClusterStatus{
+		Nodes:         len(nodes),
+		Containers:    len(containers),
+		ByState:       make(map[ContainerState]int),
+		StartedAt:     startedAt,
+		UptimeSeconds: int64(now.Sub(startedAt) / time.Second),
+	}
+
+	for _, node := range nodes {
+		if node.State == NodeReady {
+			status.ReadyNodes++
+		} else {
+			status.NotReadyNodes++
+		}
+
+		if node.Role == Worker {
+			status.Capacity = status.Capacity.Add(node.Capacity)
+			status.Allocated = status.Allocated.Add(node.Allocated)
+		}
+	}
+
+	for _, c := range containers {
+		status.ByState[c.State]++
+	}
+
+	return status
 }
 
-func NewAPIServer(store Store, addr string) *APIServer {
-	return &APIServer{
-		store: store,
-		addr:  addr,
+// handleWatch streams the node's assigned containers as server-sent events,
+// sending the current set on connect and again whenever it changes.
+func (s *APIServer) handleWatch(w http.ResponseWriter, r *http.Request) {
+	nodeID := r.URL.Query().Get("node_id")
+	if nodeID == "" {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "node_id parameter required")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, codeInternal, "streaming not supported")
+		return
+	}
+
+	changes, cancel := s.feed.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var last []byte
+	send := func() error {
+		assigned, err := s.assignedContainers(r.Context(), nodeID)
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(apiResponse{
+			Data: assigned,
+			Meta: apiMeta{Version: apiVersion},
+		})
+		if err != nil {
+			return err
+		}
+
+		if bytes.Equal(data, last) {
+			return nil
+		}
+		last = data
+
+		if _, err := fmt.Fprintf(w, "event: containers\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	keepalive := time.NewTicker(watchKeepalive)
+	defer keepalive.Stop()
+
+	if err := send(); err != nil {
+		log.Printf("[API] Watch for %s failed: %v", nodeID, err)
+		return
+	}
+
+	for {
+		select {
+		case <-changes:
+			if err := send(); err != nil {
+				log.Printf("[API] Watch for %s failed: %v", nodeID, err)
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		}
 	}
 }
 
 func (s *APIServer) Start() error {
-	http.HandleFunc("/nodes/register", func(w http.ResponseWriter, r *http.Request) {
+	// the server itself is built up front so Shutdown never races Start
+	s.server.Handler = s.handler()
+	go s.sweepIdempotencyKeys(idempotencyKeySweepInterval)
+	if err := s.server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *APIServer) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/nodes/register", func(w http.ResponseWriter, r *http.Request) {
 		var node Node
 		if err := json.NewDecoder(r.Body).Decode(&node); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+		if err := validateNode(&node); err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest,
+				strings.ReplaceAll(err.Error(), "\n", "; "))
+			return
+		}
+		if s.requireNodeCapacity && (node.Capacity.CPUCores == 0 || node.Capacity.MemoryMB == 0) {
+			writeError(w, http.StatusBadRequest, codeBadRequest,
+				fmt.Sprintf("node %s must report cpu_cores and memory_mb capacity", node.ID))
 			return
 		}
 
+		// a cordon and a scheduled window outlive the worker restarting
+		if existing, err := s.store.GetNode(r.Context(), node.ID); err == nil {
+			node.Unschedulable = existing.Unschedulable
+			node.Maintenance = existing.Maintenance
+		}
 		node.LastSeen = time.Now()
 		node.State = NodeReady
 
+		// registering is how a removed node rejoins on purpose
+		s.removed.forget(node.ID)
 		if err := s.store.SaveNode(context.Background(), &node); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 
 		log.Printf("Node registered: %s at %v\n", node.ID, node.Address)
-		w.WriteHeader(http.StatusOK)
+		writeData(w, http.StatusOK, &node)
 	})
 
-	http.HandleFunc("/nodes/heartbeat", func(w http.ResponseWriter, r *http.Request) {
-		var hb struct {
-			NodeID string `json:"node_id"`
+	mux.HandleFunc("/nodes", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
 		}
-		if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+
+		nodes, err := s.store.ListNodes(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 
-		node, err := s.store.GetNode(context.Background(), hb.NodeID)
+		containers, err := s.store.ListContainers(r.Context())
 		if err != nil {
-			http.Error(w, "node not found", http.StatusNotFound)
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 
-		node.LastSeen = time.Now()
-		s.store.SaveNode(context.Background(), node)
-		w.WriteHeader(http.StatusOK)
+		writeData(w, http.StatusOK, summarizeNodes(nodes, containers))
 	})
 
-	http.HandleFunc("/containers/assigned", func(w http.ResponseWriter, r *http.Request) {
-		nodeID := r.URL.Query().Get("node_id")
-		if nodeID == "" {
-			http.Error(w, "node_id parameter required", http.StatusBadRequest)
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
 			return
 		}
 
-		containers, err := s.store.ListContainers(context.Background())
+		nodes, err := s.store.ListNodes(r.Context())
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
 			return
 		}
 
-		assigned := []*Container{}
-		for _, c := range containers {
-			if c.NodeID == nodeID && c.Scheduled {
-				assigned = append(assigned, c)
-			}
+		containers, err := s.store.ListContainers(r.Context())
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(assigned)
+		writeData(w, http.StatusOK, summarizeCluster(nodes, containers, s.startedAt, time.Now()))
 	})
 
-	http.HandleFunc("/containers", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			var container Container
-			if err := json.NewDecoder(r.Body).Decode(&container); err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+	mux.HandleFunc("/nodes/", func(w http.ResponseWriter, r *http.Request) {
+		nodeID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/nodes/"), "/")
+		if nodeID == "" {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "Node ID required")
+			return
+		}
+
+		switch action {
+		case "":
+			if r.Method != http.MethodDelete {
+				writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
 				return
 			}
+			s.handleDeleteNode(w, r, nodeID, r.URL.Query().Get("force") == "true")
 
-			if err := s.store.SaveContainer(context.Background(), &container); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+		case "cordon", "uncordon":
+			if r.Method != http.MethodPost {
+				writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
 				return
 			}
 
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(map[string]string{
-				"id":     container.ID,
-				"status": "scheduled",
+			node, err := s.store.UpdateNode(r.Context(), nodeID, func(node *Node) error {
+				node.Unschedulable = action == "cordon"
+				return nil
 			})
+			if err != nil {
+				writeStoreError(w, err)
+				return
+			}
 
-		case http.MethodDelete:
-			parts := strings.Split(r.URL.Path, "/")
-			if len(parts) < 3 {
-				http.Error(w, "Container ID required", http.StatusBadRequest)
+			log.Printf("[API] Node %s %sed", node.ID, action)
+			writeData(w, http.StatusOK, node)
+
+		case "maintenance":
+			s.handleNodeMaintenance(w, r, nodeID)
+
+		case "containers":
+			if r.Method != http.MethodGet {
+				writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
 				return
 			}
-			containerID := parts[2]
 
-			if err := s.store.DelContainer(context.Background(), containerID); err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+			if _, err := s.store.GetNode(r.Context(), nodeID); err != nil {
+				writeStoreError(w, err)
 				return
 			}
 
-			log.Printf("[API] Container deleted: %s", containerID)
-			w.WriteHeader(http.StatusOK)
+			assigned, err := s.assignedContainers(r.Context(), nodeID)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+				return
+			}
+
+			writeData(w, http.StatusOK, assigned)
+
+		default:
+			writeError(w, http.StatusNotFound, codeNotFound, "unknown node action")
+		}
+	})
+
+	mux.HandleFunc("/nodes/heartbeat", func(w http.ResponseWriter, r *http.Request) {
+		var hb Heartbeat
+		if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+
+		// a worker whose runtime is down is alive but can't run anything,
+		// so it stays out of scheduling until the runtime recovers
+		state, reason := NodeReady, ""
+		if hb.RuntimeError != "" {
+			state, reason = NodeNotReady, hb.RuntimeError
+		}
+
+		// only the fields a heartbeat owns are written, so a cordon or
+		// maintenance window saved meanwhile is kept
+		_, err := s.store.UpdateNode(r.Context(), hb.NodeID, func(node *Node) error {
+			if node.State != state {
+				log.Printf("[API] Node %s is now %s %s", node.ID, state, reason)
+			}
+
+			now := time.Now()
+			if !hb.SentAt.IsZero() {
+				skew := hb.SentAt.Sub(now)
+				if skewed(skew) && !skewed(node.ClockSkew) {
+					log.Printf("[API] Node %s clock is off by %s, its timestamps are unreliable", node.ID, skew.Round(time.Millisecond))
+				} else if !skewed(skew) && skewed(node.ClockSkew) {
+					log.Printf("[API] Node %s clock is back in sync", node.ID)
+				}
+				node.ClockSkew = skew
+			}
+
+			node.State = state
+			node.Reason = reason
+			node.Allocated = hb.Allocated
+			node.LastSeen = now
+			return nil
+		})
+		if errors.Is(err, ErrNotFound) && s.removed.has(hb.NodeID, time.Now()) {
+			writeError(w, http.StatusGone, codeGone, fmt.Sprintf("node %s was removed from the cluster", hb.NodeID))
+			return
+		}
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeData(w, http.StatusOK, nil)
+	})
+
+	mux.HandleFunc("/containers/assigned", func(w http.ResponseWriter, r *http.Request) {
+		nodeID := r.URL.Query().Get("node_id")
+		if nodeID == "" {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "node_id parameter required")
+			return
+		}
+
+		assigned, err := s.assignedContainers(context.Background(), nodeID)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+			return
 		}
 
+		writeData(w, http.StatusOK, assigned)
 	})
 
-	http.HandleFunc("/containers/status", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/containers/watch", s.handleWatch)
+
+	mux.HandleFunc("/reconcile", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
 			return
 		}
 
-		var container Container
-		if err := json.NewDecoder(r.Body).Decode(&container); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if nodeID := r.URL.Query().Get("node_id"); nodeID != "" {
+			s.proxyReconcile(w, r, nodeID)
+			return
+		}
+
+		if s.trigger != nil {
+			s.trigger()
+		}
+		writeData(w, http.StatusAccepted, nil)
+	})
+
+	mux.HandleFunc("/secrets/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
 			return
 		}
 
-		if err := s.store.SaveContainer(context.Background(), &container); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if !s.authorized(w, r) {
 			return
 		}
 
-		log.Printf("[API] Container status updated: %s -> %s", container.ID, container.State)
-		w.WriteHeader(http.StatusOK)
+		name := strings.TrimPrefix(r.URL.Path, "/secrets/")
+		secret, err := s.store.GetSecret(r.Context(), name)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		log.Printf("[API] Secret %s resolved", secret.Name)
+		writeData(w, http.StatusOK, secret)
 	})
 
-	return http.ListenAndServe(s.addr, nil)
-}
+	mux.HandleFunc("/containers", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			limit := defaultPageLimit
+			if v := r.URL.Query().Get("limit"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 1 {
+					writeError(w, http.StatusBadRequest, codeBadRequest, "limit must be a positive integer")
+					return
+				}
+				limit = min(n, maxPageLimit)
+			}
 
-type APIClient struct {
-	controlPlaneURL string
-	nodeID          string
-	client          *http.Client
-}
+			filter, err := parseContainerFilter(r.URL.Query())
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+				return
+			}
 
-func NewAPIClient(controlPlaneURL string, nodeID string) *APIClient {
-	return &APIClient{
-		controlPlaneURL: controlPlaneURL,
-		nodeID:          nodeID,
-		client:          &http.Client{Timeout: 5 * time.Second},
-	}
-}
+			containers, next, err := s.listContainersPage(context.Background(), r.URL.Query().Get("cursor"), limit, filter)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+				return
+			}
 
-func (c *APIClient) Register(node *Node) error {
-	data, _ := json.Marshal(node)
-	resp, err := c.client.Post(
-		c.controlPlaneURL+"/nodes/register",
-		"application/json",
-		bytes.NewBuffer(data),
-	)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	return nil
-}
+			if containers == nil {
+				containers = []*Container{}
+			}
+			writePage(w, containers, next)
+
+		case http.MethodPost:
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+				return
+			}
+			var container Container
+			if err := json.Unmarshal(body, &container); err != nil {
+				writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+				return
+			}
+
+			key := r.Header.Get(idempotencyKeyHeader)
+			hash := bodyHash(body)
+			if key != "" {
+				defer s.idempotency.lock(key)()
+
+				prior, err := s.store.GetIdempotencyKey(r.Context(), key)
+				if err != nil && !errors.Is(err, ErrNotFound) {
+					writeStoreError(w, err)
+					return
+				}
+				// expired keys wait for the sweep but are no longer honoured
+				if err == nil && time.Now().Before(prior.ExpiresAt) {
+					if prior.BodyHash != hash {
+						writeError(w, http.StatusUnprocessableEntity, codeKeyReused,
+							"Idempotency-Key "+key+" was already used with a different request body")
+						return
+					}
+					if _, err := s.store.GetContainer(r.Context(), prior.ContainerID); err == nil {
+						writeData(w, http.StatusOK, map[string]string{
+							"id":     prior.ContainerID,
+							"status": "scheduled",
+						})
+						return
+					}
+				}
+			}
+
+			if container.ID == "" {
+				id, err := GenerateID()
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+					return
+				}
+				container.ID = id
+			}
+
+			defaultNetwork(&container)
+
+			existing, err := s.store.ListContainers(r.Context())
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+				return
+			}
+			if err := admitContainer(&container, existing); err != nil {
+				// errors.Join separates with newlines
+				writeError(w, http.StatusBadRequest, codeBadRequest,
+					strings.ReplaceAll(err.Error(), "\n", "; "))
+				return
+			}
+
+			if err := s.store.SaveContainer(context.Background(), &container); err != nil {
+				writeStoreError(w, err)
+				return
+			}
+			if key != "" {
+				record := &IdempotencyKey{
+					Key:         key,
+					ContainerID: container.ID,
+					BodyHash:    hash,
+					ExpiresAt:   time.Now().Add(idempotencyKeyTTL),
+				}
+				if err := s.store.SaveIdempotencyKey(context.Background(), record); err != nil {
+					// the container is saved, so fail only a retry's deduplication
+					log.Printf("[API] Failed to save idempotency key for %s: %v", container.ID, err)
+				}
+			}
+
+			writeData(w, http.StatusOK, map[string]string{
+				"id":     container.ID,
+				"status": "scheduled",
+			})
+
+		case http.MethodDelete:
+			s.handleBulkDelete(w, r)
+
+		default:
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
 
-func (c *APIClient) SendHeartbeat() error {
-	data, _ := json.Marshal(map[string]string{
-		"node_id": c.nodeID,
 	})
-	resp, err := c.client.Post(
-		c.controlPlaneURL+"/nodes/heartbeat",
-		"application/json",
-		bytes.NewBuffer(data),
-	)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	return nil
-}
 
-func (c *APIClient) GetAssignedContainers(nodeID string) ([]*Container, error) {
-	url := fmt.Sprintf("%s/containers/assigned?node_id=%s", c.controlPlaneURL, nodeID)
+	mux.HandleFunc("/containers/batch", s.handleBatchCreate)
+	mux.HandleFunc("/containers/status/batch", s.handleStatusBatch)
 
-	resp, err := c.client.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("Failed to get containers: %v", err)
-	}
-	defer resp.Body.Close()
+	mux.HandleFunc("/services", s.handleServices)
+	mux.HandleFunc("/services/", s.handleService)
+	mux.HandleFunc("/autoscalers", s.handleAutoscalers)
+	mux.HandleFunc("/autoscalers/", s.handleAutoscaler)
+	mux.HandleFunc("/deployments/", s.handleScale)
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API returned status: %v", err)
-	}
+	mux.HandleFunc("/containers/", func(w http.ResponseWriter, r *http.Request) {
+		ref, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/containers/"), "/")
+		if ref == "" {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "Container ID required")
+			return
+		}
+		containerID := s.resolveContainerRef(r.Context(), ref)
 
-	var containers []*Container
-	if err := json.NewDecoder(resp.Body).Decode(&containers); err != nil {
-		return nil, fmt.Errorf("Failed to decode response: %v", err)
-	}
+		switch action {
+		case "":
+		case "exec":
+			// a shell in any container is as good as the cluster token
+			if !s.authorized(w, r) {
+				return
+			}
+			s.proxyToWorker(w, r, containerID, action)
+			return
+		case "stats", "logs":
+			s.proxyToWorker(w, r, containerID, action)
+			return
+		default:
+			writeError(w, http.StatusNotFound, codeNotFound, "unknown container action")
+			return
+		}
 
-	return containers, nil
-}
+		switch r.Method {
+		case http.MethodGet:
+			container, err := s.store.GetContainer(r.Context(), containerID)
+			if err != nil {
+				writeStoreError(w, err)
+				return
+			}
 
-func (c *APIClient) UpdateContainerStatus(container *Container) error {
-	data, err := json.Marshal(container)
-	if err != nil {
-		return err
-	}
+			writeData(w, http.StatusOK, container)
 
-	resp, err := c.client.Post(
-		c.controlPlaneURL+"/containers/status",
-		"application/json",
-		bytes.NewBuffer(data),
-	)
-	if err != nil {
-		return fmt.Errorf("failed to update status: %w", err)
-	}
-	defer resp.Body.Close()
+		case http.MethodPatch:
+			var patch ContainerPatch
+			if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+				writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+				return
+			}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
-	}
+			var existing []*Container
+			if patch.Image != nil || patch.Env != nil || patch.Ports != nil {
+				var err error
+				existing, err = s.store.ListContainers(r.Context())
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+					return
+				}
+			}
 
-	return nil
-}
+			for attempt := 1; ; attempt++ {
+				container, err := s.store.GetContainer(r.Context(), containerID)
+				if err != nil {
+					writeStoreError(w, err)
+					return
+				}
 
-func (c *APIClient) DeleteContainer(containerID string) error {
-	req, err := http.NewRequest(
-		http.MethodDelete,
-		fmt.Sprintf("%s/containers/%s", c.controlPlaneURL, containerID),
-		nil,
-	)
-	if err != nil {
-		return err
-	}
+				changed := false
+				if patch.Image != nil && *patch.Image != container.Image {
+					container.Image = *patch.Image
+					changed = true
+				}
 
-	resp, err := c.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("Failed to delete container: %v", err)
-	}
-	defer resp.Body.Close()
+				if patch.Env != nil && !maps.Equal(*patch.Env, container.Env) {
+					container.Env = *patch.Env
+					changed = true
+				}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("API error %d: %s", resp.StatusCode, string(body))
-	}
+				if patch.Ports != nil {
+					normalizePorts(*patch.Ports)
+					if !slices.Equal(*patch.Ports, specPorts(container.Ports)) {
+						container.Ports = *patch.Ports
+						changed = true
+					}
+				}
+				if changed {
+					if err := admitContainer(container, existing); err != nil {
+						writeError(w, http.StatusBadRequest, codeBadRequest,
+							strings.ReplaceAll(err.Error(), "\n", "; "))
+						return
+					}
+				}
 
-	return nil
+				desiredChanged := false
+				if patch.DesiredState != nil && *patch.DesiredState != container.DesiredState {
+					if *patch.DesiredState != Running && *patch.DesiredState != Paused {
+						writeError(w, http.StatusBadRequest, codeBadRequest,
+							fmt.Sprintf("desired_state can only be set to %s or %s", Running, Paused))
+						return
+					}
+					if container.DesiredState != Running && container.DesiredState != Paused {
+						writeError(w, http.StatusConflict, codeConflict,
+							fmt.Sprintf("container %s is %s, only a running or paused container can be paused or resumed", container.ID, container.DesiredState))
+						return
+					}
+					container.DesiredState = *patch.DesiredState
+					desiredChanged = true
+				}
+
+				if changed {
+					container.Generation++
+				}
+				if changed || desiredChanged {
+					container.UpdatedAt = time.Now()
+
+					if err := s.store.SaveContainer(r.Context(), container); err != nil {
+						// someone else saved it first, so patch their version
+						if errors.Is(err, ErrConflict) && attempt < maxConflictRetries {
+							continue
+						}
+						writeStoreError(w, err)
+						return
+					}
+					log.Printf("[API] Container updated: %s (generation %d, desired %s)", container.ID, container.Generation, container.DesiredState)
+				}
+
+				writeData(w, http.StatusOK, container)
+				return
+			}
+
+		case http.MethodDelete:
+			container, err := s.store.GetContainer(r.Context(), containerID)
+			if err != nil {
+				writeStoreError(w, err)
+				return
+			}
+
+			if container.DesiredState != Destroyed {
+				if container.Protected && r.URL.Query().Get("force_protected") != "true" {
+					writeError(w, http.StatusConflict, codeConflict,
+						fmt.Sprintf("container %s is protected, set force_protected=true to delete it", container.ID))
+					return
+				}
+				containers, err := s.store.ListContainers(r.Context())
+				if err != nil {
+					writeStoreError(w, err)
+					return
+				}
+				if deps := dependents(map[string]bool{container.ID: true}, containers); len(deps) > 0 {
+					writeError(w, http.StatusConflict, codeConflict,
+						fmt.Sprintf("container %s is a dependency of %s, delete those first", container.ID, strings.Join(deps, ", ")))
+					return
+				}
+
+				// a scheduled container is torn down by its worker, which
+				// deletes the record once the runtime container is gone
+				if container.NodeID != "" {
+					_, err = modifyContainer(r.Context(), s.store, containerID, func(c *Container) error {
+						c.DesiredState = Destroyed
+						c.UpdatedAt = time.Now()
+						return nil
+					})
+					if err != nil {
+						writeStoreError(w, err)
+						return
+					}
+					log.Printf("[API] Container %s marked Destroyed for node %s to remove", containerID, container.NodeID)
+					writeData(w, http.StatusOK, nil)
+					return
+				}
+			} else if container.NodeID != "" && requestNodeID(r) != container.NodeID {
+				// still being torn down; only its worker drops the record
+				writeData(w, http.StatusOK, nil)
+				return
+			}
+
+			if err := s.store.DelContainer(r.Context(), containerID); err != nil {
+				writeStoreError(w, err)
+				return
+			}
+
+			log.Printf("[API] Container deleted: %s", containerID)
+			writeData(w, http.StatusOK, nil)
+
+		default:
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		}
+	})
+
+	mux.HandleFunc("/containers/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		var container Container
+		if err := json.NewDecoder(r.Body).Decode(&container); err != nil {
+			writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+
+		if err := s.saveStatuses(context.Background(), []*Container{&container}); err != nil {
+			if errors.Is(err, ErrInvalidTransition) {
+				log.Printf("[API] Rejected status update: %v", err)
+			}
+			writeStoreError(w, err)
+			return
+		}
+
+		log.Printf("[API] Container status updated: %s -> %s", container.ID, container.State)
+		writeData(w, http.StatusOK, nil)
+	})
+
+	root := http.NewServeMux()
+	root.Handle(apiPrefix+"/", http.StripPrefix(apiPrefix, mux))
+	root.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeData(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	root.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		writeData(w, http.StatusOK, currentVersion())
+	})
+	root.Handle("/", deprecatedPaths(mux))
+
+	var handler http.Handler = root
+	if s.logRequests {
+		handler = logRequests(s.logger, handler)
+	}
+	return handler
 }
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Version versions the response envelope. Clients should reject envelopes
+// with a version they don't understand.
+const Version = "v1"
+
+// Prefix is where the versioned endpoints are mounted. Health and version
+// checks stay at the root so they work across API versions.
+const Prefix = "/" + Version
+
+// NodeIDHeader carries a worker's node ID on every request so the control
+// plane can attribute traffic in its request log.
+const NodeIDHeader = "X-Node-ID"
+
+// IdempotencyKeyHeader lets a client retry POST /containers safely: every
+// submission under the same key gets the container the first one created.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// DefaultPageLimit is the page size of a list that doesn't ask for one.
+const DefaultPageLimit = 100
+
+type Meta struct {
+	Version    string `json:"version"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Response is the envelope of every successful answer.
+type Response struct {
+	Data any  `json:"data"`
+	Meta Meta `json:"meta"`
+}
+
+// ErrorBody is what went wrong, as carried in an ErrorResponse.
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details carries structured context for some errors, such as the
+	// per-item results of a rejected batch.
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+// ErrorResponse is the envelope of every error answer.
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+	Meta  Meta      `json:"meta"`
+}
+
+// FormatSelector renders a label selector as the comma-separated key=value
+// terms the selector query parameter takes, sorted by key.
+func FormatSelector(selector map[string]string) string {
+	terms := make([]string, 0, len(selector))
+	for _, key := range slices.Sorted(maps.Keys(selector)) {
+		terms = append(terms, key+"="+selector[key])
+	}
+	return strings.Join(terms, ",")
+}
@@ -0,0 +1,50 @@
+package api
+
+import "errors"
+
+// Error codes carried in ErrorBody.Code.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeGone             = "gone"
+	CodeKeyReused        = "idempotency_key_reused"
+	CodeInternal         = "internal"
+	CodeBadGateway       = "bad_gateway"
+	CodeUnavailable      = "unavailable"
+)
+
+// Errors returned by the client wrap one of these when the server sent the
+// matching error code, so callers can branch with errors.Is. Stores wrap
+// ErrNotFound for missing records and ErrConflict for stale writes too.
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	// ErrNodeRemoved answers the heartbeat of a node removed with node rm.
+	ErrNodeRemoved = errors.New("node removed")
+	// ErrKeyReused rejects an Idempotency-Key replayed with a different
+	// request body.
+	ErrKeyReused = errors.New("idempotency key reused")
+)
+
+var errorsByCode = map[string]error{
+	CodeBadRequest:   ErrBadRequest,
+	CodeUnauthorized: ErrUnauthorized,
+	CodeForbidden:    ErrForbidden,
+	CodeNotFound:     ErrNotFound,
+	CodeConflict:     ErrConflict,
+	CodeGone:         ErrNodeRemoved,
+	CodeKeyReused:    ErrKeyReused,
+}
+
+// ErrorFor returns the sentinel an error code stands for, or nil for codes
+// without one.
+func ErrorFor(code string) error {
+	return errorsByCode[code]
+}
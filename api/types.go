@@ -0,0 +1,466 @@
+// Package api holds the resources the Cogsworth control plane serves, the
+// envelope it wraps them in and the errors it answers with. The server and
+// the client package share it.
+package api
+
+import "time"
+
+type ContainerState string
+
+const (
+	Requested ContainerState = "requested"
+	Pulling   ContainerState = "pulling"
+	Created   ContainerState = "created"
+	Starting  ContainerState = "starting"
+	Running   ContainerState = "running"
+	Paused    ContainerState = "paused"
+	Stopping  ContainerState = "stopping"
+	Stopped   ContainerState = "stopped"
+	Failed    ContainerState = "failed"
+	Completed ContainerState = "completed"
+	Destroyed ContainerState = "destroyed"
+)
+
+// transitions lists the states each state may move to. Staying in the same
+// state is always allowed. Requested is initial only, only a running
+// container can be paused, Completed can only be destroyed and Destroyed is
+// final.
+var transitions = map[ContainerState][]ContainerState{
+	Requested: {Pulling, Created, Failed, Stopped, Destroyed},
+	Pulling:   {Created, Failed, Stopped, Destroyed},
+	Created:   {Starting, Running, Stopping, Stopped, Failed, Completed, Destroyed},
+	Starting:  {Running, Stopping, Stopped, Failed, Completed, Destroyed},
+	Running:   {Paused, Stopping, Stopped, Failed, Completed, Created, Destroyed},
+	Paused:    {Running, Stopping, Stopped, Failed, Created, Destroyed},
+	Stopping:  {Stopped, Running, Failed, Destroyed},
+	Stopped:   {Pulling, Created, Starting, Running, Stopping, Failed, Completed, Destroyed},
+	Failed:    {Pulling, Created, Starting, Running, Stopping, Stopped, Completed, Destroyed},
+	Completed: {Destroyed},
+	Destroyed: {},
+}
+
+func (s ContainerState) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+func CanTransition(from, to ContainerState) bool {
+	if !from.Valid() || !to.Valid() {
+		return false
+	}
+
+	if from == to {
+		return true
+	}
+
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+type Container struct {
+	ID           string            `json:"id"`
+	Name         string            `json:"name,omitempty"`
+	Image        string            `json:"image"`
+	State        ContainerState    `json:"state"`
+	DesiredState ContainerState    `json:"desired_state"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+	ContainerID  string            `json:"container_id"`
+	IPAddress    string            `json:"ip_address"`
+	Env          map[string]string `json:"env"`
+	Ports        []PortMapping     `json:"ports"`
+	RestartCount int               `json:"restart_count"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	Protected    bool              `json:"protected,omitempty"`
+	SecretRefs   []string          `json:"secret_refs,omitempty"`
+
+	// StopTimeout is the grace period in whole seconds, the unit the
+	// runtime takes; zero means DefaultStopTimeout.
+	StopTimeout int `json:"stop_timeout_seconds,omitempty"`
+
+	// StopSignal replaces the image's stop signal; SIGKILL still follows
+	// once StopTimeout runs out.
+	StopSignal string `json:"stop_signal,omitempty"`
+
+	// TTL, when set, has the control plane destroy the container once it
+	// has been running for that long since StartedAt, the time of its
+	// latest start.
+	TTL       time.Duration `json:"ttl,omitempty"`
+	StartedAt time.Time     `json:"started_at,omitempty"`
+
+	// MaxRestarts is how many times the worker retries a failed start, so
+	// it gives up on failed start MaxRestarts+1; zero uses the worker's
+	// default.
+	MaxRestarts int `json:"max_restarts,omitempty"`
+
+	// RestartPolicy says whether a container that exits on its own is
+	// started again: RestartAlways (the default), RestartOnFailure or
+	// RestartNever. One left exited is Completed after exit 0, otherwise
+	// Failed.
+	RestartPolicy string `json:"restart_policy,omitempty"`
+
+	// Command overrides the image entrypoint and Args its default command.
+	Command []string `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+
+	// ImagePullPolicy is "always" or "ifnotpresent" (the default).
+	ImagePullPolicy string `json:"image_pull_policy,omitempty"`
+
+	// Resources is what the container requests from its node.
+	Resources Resources `json:"resources,omitempty"`
+
+	// Group names a set of containers that are always scheduled onto the
+	// same node together.
+	Group string `json:"group,omitempty"`
+
+	// Deployment names the set of replicas this container belongs to. The
+	// scheduler spreads a deployment's replicas across zones.
+	Deployment string `json:"deployment,omitempty"`
+
+	// Network is the bridge network the container is attached to, where
+	// the others on it can reach it by ID or Name. The control plane fills
+	// it in for a deployment's replicas if it isn't set.
+	Network string `json:"network,omitempty"`
+
+	// Affinity prefers nodes already running a container whose labels match
+	// the selector; AntiAffinity rules such nodes out.
+	Affinity     map[string]string `json:"affinity,omitempty"`
+	AntiAffinity map[string]string `json:"anti_affinity,omitempty"`
+
+	// DependsOn lists container IDs that must be Running before this one
+	// is started.
+	DependsOn []string `json:"depends_on,omitempty"`
+
+	// ReadinessProbe gates Ready, which says whether a Running container
+	// can take traffic. Without a probe a container is ready once it runs.
+	ReadinessProbe *ReadinessProbe `json:"readiness_probe,omitempty"`
+	Ready          bool            `json:"ready"`
+
+	// Generation is bumped on every spec change; the worker recreates the
+	// runtime container while ObservedGeneration lags behind it.
+	Generation         int64 `json:"generation"`
+	ObservedGeneration int64 `json:"observed_generation"`
+
+	// SpecHash is the specHash of the spec the runtime container was
+	// created from.
+	SpecHash string `json:"spec_hash,omitempty"`
+
+	// SecretVersions is filled in by the control plane when it hands out
+	// assignments, so a secret update changes the spec hash without the
+	// worker fetching every secret on every tick.
+	SecretVersions map[string]int64 `json:"secret_versions,omitempty"`
+
+	// LastError is why the most recent reconcile of this container failed,
+	// cleared once one succeeds. LastReconcileAt is when it was recorded.
+	LastError       string    `json:"last_error,omitempty"`
+	LastReconcileAt time.Time `json:"last_reconcile_at,omitempty"`
+
+	NodeID    string `json:"node_id"`
+	Scheduled bool   `json:"scheduled"`
+
+	// Usage is the resource usage the worker last sampled while the
+	// container ran.
+	Usage *ContainerUsage `json:"usage,omitempty"`
+
+	// LastNodeID is the node the container was assigned to before it was
+	// last unscheduled, which a sticky scheduler tries first.
+	LastNodeID string `json:"last_node_id,omitempty"`
+
+	// Draining holds the desired state a maintenance drain stopped the
+	// container from, until it is moved to another node with it restored.
+	Draining ContainerState `json:"draining,omitempty"`
+
+	// ResourceVersion is bumped by the store on every save. A save must
+	// carry the version it read, so concurrent writers can't overwrite
+	// each other's changes.
+	ResourceVersion int64 `json:"resource_version"`
+}
+
+const (
+	RestartAlways    = "always"
+	RestartOnFailure = "on-failure"
+	RestartNever     = "never"
+)
+
+// DefaultStopTimeout is the grace period of a container without a
+// StopTimeout.
+const DefaultStopTimeout = 10 * time.Second
+
+// StopTimeoutSeconds is the grace period handed to the runtime before the
+// container is killed.
+func (c *Container) StopTimeoutSeconds() int {
+	if c.StopTimeout <= 0 {
+		return int(DefaultStopTimeout / time.Second)
+	}
+	return c.StopTimeout
+}
+
+// BulkDeleteResult reports which containers a bulk delete marked Destroyed
+// and which it left alone, being protected or depended on by a container
+// that stays.
+type BulkDeleteResult struct {
+	Destroyed []string `json:"destroyed"`
+	Skipped   []string `json:"skipped"`
+}
+
+// BatchItemResult is the outcome for one container of a batch submission.
+type BatchItemResult struct {
+	Index int    `json:"index"`
+	ID    string `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// ExecRequest is the body of POST /containers/{id}/exec.
+type ExecRequest struct {
+	Cmd []string `json:"cmd"`
+}
+
+// ContainerFilter narrows a container list. A container matches when its
+// state is one of States and its labels satisfy Selector; empty fields
+// match everything.
+type ContainerFilter struct {
+	States   []ContainerState
+	Selector map[string]string
+}
+
+// ContainerPatch lists the fields of a container that can be updated in
+// place. Nil fields are left unchanged.
+type ContainerPatch struct {
+	Image *string            `json:"image,omitempty"`
+	Env   *map[string]string `json:"env,omitempty"`
+	Ports *[]PortMapping     `json:"ports,omitempty"`
+
+	// DesiredState pauses or resumes the container: only Running and
+	// Paused are accepted. Unlike the spec fields it leaves Generation
+	// alone, so the runtime container is kept.
+	DesiredState *ContainerState `json:"desired_state,omitempty"`
+}
+
+type PortMapping struct {
+	HostPort      int    `json:"host_port"`
+	ContainerPort int    `json:"container_port"`
+	Protocol      string `json:"protocol"`
+
+	// Auto leaves the host port for the runtime to pick. HostPort then
+	// holds the port it picked, or 0 until the container has started.
+	Auto bool `json:"auto,omitempty"`
+}
+
+type Node struct {
+	ID        string    `json:"id"`
+	Address   string    `json:"address"`
+	Role      NodeRole  `json:"role"`
+	AgentAddr string    `json:"agent_addr,omitempty"`
+	State     NodeState `json:"state"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+
+	// Unschedulable (cordoned) keeps new containers off the node while
+	// leaving the ones already there running. It is stored inverted so
+	// records written before cordoning existed stay schedulable.
+	Unschedulable bool `json:"unschedulable,omitempty"`
+
+	Capacity  Resources `json:"capacity,omitempty"`
+	Allocated Resources `json:"allocated,omitempty"`
+
+	// ClockSkew is how far the node's clock was ahead of the control
+	// plane's at the last heartbeat. It is informational only: health is
+	// judged from LastSeen, which the control plane stamps itself.
+	ClockSkew time.Duration `json:"clock_skew,omitempty"`
+
+	// Zone is the failure domain the node belongs to, as given by the
+	// worker's --zone flag.
+	Zone string `json:"zone,omitempty"`
+
+	// MaxContainers caps how many active containers the scheduler places
+	// on the node; zero is unlimited.
+	MaxContainers int `json:"max_containers,omitempty"`
+
+	// Maintenance, when set, has the control plane cordon and drain the
+	// node for the window and uncordon it afterwards.
+	Maintenance *MaintenanceWindow `json:"maintenance,omitempty"`
+}
+
+type MaintenanceWindow struct {
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+
+	// Cordoned is set while the window holds the node cordoned, so a node
+	// that was cordoned by hand beforehand stays cordoned after it.
+	Cordoned bool `json:"cordoned,omitempty"`
+}
+
+func (m *MaintenanceWindow) End() time.Time {
+	return m.Start.Add(m.Duration)
+}
+
+// Active reports whether now falls within the window.
+func (m *MaintenanceWindow) Active(now time.Time) bool {
+	return !now.Before(m.Start) && now.Before(m.End())
+}
+
+// Heartbeat is what a worker reports every second. Allocated sums the
+// resource requests of the containers it is actually running and SentAt is
+// the worker's own clock.
+type Heartbeat struct {
+	NodeID       string    `json:"node_id"`
+	RuntimeError string    `json:"runtime_error,omitempty"`
+	Allocated    Resources `json:"allocated"`
+	SentAt       time.Time `json:"sent_at"`
+}
+
+// NodeSummary is a node joined with counts of the containers assigned to it.
+type NodeSummary struct {
+	*Node
+	Containers int `json:"containers"`
+	Running    int `json:"running"`
+}
+
+// ClusterStatus is the one-shot health summary served at GET /status.
+// Capacity and Allocated are summed over the worker nodes.
+type ClusterStatus struct {
+	Nodes         int                    `json:"nodes"`
+	ReadyNodes    int                    `json:"ready_nodes"`
+	NotReadyNodes int                    `json:"not_ready_nodes"`
+	Containers    int                    `json:"containers"`
+	ByState       map[ContainerState]int `json:"by_state"`
+	Capacity      Resources              `json:"capacity"`
+	Allocated     Resources              `json:"allocated"`
+	StartedAt     time.Time              `json:"started_at"`
+	UptimeSeconds int64                  `json:"uptime_seconds"`
+}
+
+type NodeState string
+
+const (
+	NodeReady    NodeState = "ready"
+	NodeNotReady NodeState = "not_ready"
+)
+
+type NodeRole string
+
+const (
+	ControlPlane NodeRole = "control-plane"
+	Worker       NodeRole = "worker"
+)
+
+type Resources struct {
+	CPUCores int   `json:"cpu_cores"`
+	MemoryMB int64 `json:"memory_mb"`
+	DiskGB   int64 `json:"disk_gb"`
+}
+
+func (r Resources) Add(o Resources) Resources {
+	return Resources{
+		CPUCores: r.CPUCores + o.CPUCores,
+		MemoryMB: r.MemoryMB + o.MemoryMB,
+		DiskGB:   r.DiskGB + o.DiskGB,
+	}
+}
+
+// Max returns the larger of r and o in each dimension.
+func (r Resources) Max(o Resources) Resources {
+	return Resources{
+		CPUCores: max(r.CPUCores, o.CPUCores),
+		MemoryMB: max(r.MemoryMB, o.MemoryMB),
+		DiskGB:   max(r.DiskGB, o.DiskGB),
+	}
+}
+
+// Secret holds sensitive key/value pairs that workers inject as env vars at
+// create time. Values are only served over the authenticated secrets endpoint.
+type Secret struct {
+	Name      string            `json:"name"`
+	Data      map[string]string `json:"data"`
+	Version   int64             `json:"version"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// Service gives the containers whose labels match Selector a stable name.
+// Endpoints are the ones currently Running and ready, refreshed by the
+// control plane on every reconcile.
+type Service struct {
+	Name      string            `json:"name"`
+	Selector  map[string]string `json:"selector"`
+	Port      int               `json:"port,omitempty"`
+	Endpoints []Endpoint        `json:"endpoints"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+
+	// ProxyPort, with Port set, has the control plane listen there and
+	// round-robin connections over the endpoints.
+	ProxyPort int `json:"proxy_port,omitempty"`
+}
+
+// HorizontalAutoscaler keeps between MinReplicas and MaxReplicas of a
+// deployment running, aiming for TargetCPUPercent average CPU across its
+// Running replicas. Replicas is the count it currently wants; new replicas
+// are copied from an existing one.
+type HorizontalAutoscaler struct {
+	Deployment       string        `json:"deployment"`
+	MinReplicas      int           `json:"min_replicas"`
+	MaxReplicas      int           `json:"max_replicas"`
+	TargetCPUPercent float64       `json:"target_cpu_percent"`
+	Cooldown         time.Duration `json:"cooldown,omitempty"`
+	CreatedAt        time.Time     `json:"created_at"`
+	UpdatedAt        time.Time     `json:"updated_at"`
+
+	Replicas          int       `json:"replicas"`
+	CurrentCPUPercent float64   `json:"current_cpu_percent"`
+	LastScaleAt       time.Time `json:"last_scale_at,omitempty"`
+}
+
+// Endpoint is one backend of a service. Port is the service's container
+// port, if it names one.
+type Endpoint struct {
+	ContainerID string `json:"container_id"`
+	NodeID      string `json:"node_id"`
+	IPAddress   string `json:"ip_address"`
+	Port        int    `json:"port,omitempty"`
+}
+
+// Scale is the body and the answer of POST /deployments/{name}/scale.
+// Replicas is the count asked for; the answer holds the count reached,
+// which protected replicas can keep above it.
+type Scale struct {
+	Deployment string `json:"deployment"`
+	Replicas   int    `json:"replicas"`
+}
+
+// ReadinessProbe decides when a running container can take traffic. With a
+// Path it is an HTTP GET that must answer 2xx or 3xx, otherwise a TCP
+// connect to Port. Both target the container's IP address.
+type ReadinessProbe struct {
+	Port    int           `json:"port"`
+	Path    string        `json:"path,omitempty"`
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// RuntimeStats is a point-in-time resource usage sample for a container.
+type RuntimeStats struct {
+	CPUPercent  float64 `json:"cpu_percent"`
+	MemoryBytes uint64  `json:"memory_bytes"`
+	MemoryLimit uint64  `json:"memory_limit"`
+}
+
+// ContainerUsage is a RuntimeStats sample kept on the container.
+type ContainerUsage struct {
+	RuntimeStats
+	SampledAt time.Time `json:"sampled_at"`
+}
+
+// VersionInfo is served at GET /version.
+type VersionInfo struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit,omitempty"`
+	BuildDate  string `json:"build_date,omitempty"`
+	APIVersion string `json:"api_version"`
+	GoVersion  string `json:"go_version"`
+}
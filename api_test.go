@@ -0,0 +1,996 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/galadd/cogsworth/client"
+)
+
+// newTestAPI serves an APIServer on a fresh MemStore.
+func newTestAPI(t *testing.T) (*APIServer, *MemStore, http.Handler) {
+	t.Helper()
+
+	store := NewMemStore()
+	s := NewAPIServer(store, newChangeFeed(), "")
+	s.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	s.logRequests = false
+	s.token = ""
+	return s, store, s.handler()
+}
+
+// call sends a request to handler under the API prefix. A non-nil body
+// that isn't a string is sent as JSON.
+func call(t *testing.T, handler http.Handler, method, path string, body any, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var r io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		r = strings.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, apiPrefix+path, r)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeData unpacks the data of a success envelope into v.
+func decodeData(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	if err := json.Unmarshal(envelope.Data, v); err != nil {
+		t.Fatalf("decoding data %q: %v", envelope.Data, err)
+	}
+}
+
+// errorCode returns the code of an error envelope.
+func errorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var envelope struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	return envelope.Error.Code
+}
+
+func mustSave(t *testing.T, store Store, records ...any) {
+	t.Helper()
+	ctx := context.Background()
+
+	for _, rec := range records {
+		var err error
+		switch v := rec.(type) {
+		case *Container:
+			err = store.SaveContainer(ctx, v)
+		case *Node:
+			err = store.SaveNode(ctx, v)
+		case *Service:
+			err = store.SaveService(ctx, v)
+		case *Secret:
+			err = store.SaveSecret(ctx, v)
+		default:
+			t.Fatalf("can't save %T", rec)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// runningOnAgent saves a container running on a node whose agent is a
+// WorkerServer over runtime, holding token.
+func runningOnAgent(t *testing.T, store Store, runtime *FakeRuntime, token string) *Container {
+	t.Helper()
+
+	agent := NewWorkerServer(runtime, "")
+	agent.token = token
+	srv := httptest.NewServer(agent.handler())
+	t.Cleanup(srv.Close)
+
+	runtime.SetStatus("rt-1", &RuntimeStatus{State: "running"})
+	c := &Container{ID: "c1", Image: "nginx", State: Running, DesiredState: Running,
+		NodeID: "w1", Scheduled: true, ContainerID: "rt-1"}
+	mustSave(t, store,
+		&Node{ID: "w1", Role: Worker, State: NodeReady, AgentAddr: strings.TrimPrefix(srv.URL, "http://")},
+		c)
+	return c
+}
+
+func TestExecRequiresToken(t *testing.T) {
+	s, store, handler := newTestAPI(t)
+	s.token = "secret"
+	runtime := NewFakeRuntime()
+	runtime.SetExecOutput("rt-1", "hello\n")
+	runningOnAgent(t, store, runtime, "secret")
+
+	exec := ExecRequest{Cmd: []string{"echo", "hello"}}
+
+	if rec := call(t, handler, http.MethodPost, "/containers/c1/exec", exec); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without a token: got %d, want 401", rec.Code)
+	}
+	if rec := call(t, handler, http.MethodPost, "/containers/c1/exec", exec, "Authorization", "Bearer wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("with a wrong token: got %d, want 401", rec.Code)
+	}
+
+	rec := call(t, handler, http.MethodPost, "/containers/c1/exec", exec, "Authorization", "Bearer secret")
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello\n" {
+		t.Errorf("with the token: got %d %q, want 200 and the output", rec.Code, rec.Body.String())
+	}
+}
+
+func TestProxyForwardsTokenToAgent(t *testing.T) {
+	s, store, handler := newTestAPI(t)
+	s.token = "secret"
+	runtime := NewFakeRuntime()
+	runtime.SetStats("rt-1", &RuntimeStats{MemoryBytes: 42})
+	runningOnAgent(t, store, runtime, "secret")
+
+	// stats need no token from the caller, but the agent still gets one
+	rec := call(t, handler, http.MethodGet, "/containers/c1/stats", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want 200", rec.Code, rec.Body.String())
+	}
+	var stats RuntimeStats
+	decodeData(t, rec, &stats)
+	if stats.MemoryBytes != 42 {
+		t.Errorf("got stats %+v, want the agent's", stats)
+	}
+}
+
+func TestProxyAgentRejectsOtherToken(t *testing.T) {
+	s, store, handler := newTestAPI(t)
+	s.token = "secret"
+	runningOnAgent(t, store, NewFakeRuntime(), "other")
+
+	rec := call(t, handler, http.MethodGet, "/containers/c1/stats", nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("got %d, want the agent's 401 passed through", rec.Code)
+	}
+}
+
+func TestProxyMissingContainerIsNotFound(t *testing.T) {
+	_, _, handler := newTestAPI(t)
+
+	rec := call(t, handler, http.MethodGet, "/containers/missing/stats", nil)
+	if rec.Code != http.StatusNotFound || errorCode(t, rec) != codeNotFound {
+		t.Errorf("got %d %s, want 404 not_found", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCordonSurvivesHeartbeatAndReregistration(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	ctx := context.Background()
+
+	node := Node{ID: "w1", Role: Worker, Address: "10.0.0.1"}
+	if rec := call(t, handler, http.MethodPost, "/nodes/register", node); rec.Code != http.StatusOK {
+		t.Fatalf("register: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := call(t, handler, http.MethodPost, "/nodes/w1/cordon", nil); rec.Code != http.StatusOK {
+		t.Fatalf("cordon: %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := call(t, handler, http.MethodPost, "/nodes/heartbeat", Heartbeat{NodeID: "w1"}); rec.Code != http.StatusOK {
+		t.Fatalf("heartbeat: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := call(t, handler, http.MethodPost, "/nodes/register", node); rec.Code != http.StatusOK {
+		t.Fatalf("register again: %d %s", rec.Code, rec.Body.String())
+	}
+
+	stored, err := store.GetNode(ctx, "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.Unschedulable {
+		t.Errorf("cordon lost after a heartbeat and re-registration")
+	}
+	if stored.LastSeen.IsZero() {
+		t.Errorf("heartbeat not recorded")
+	}
+}
+
+func TestUncordon(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	mustSave(t, store, &Node{ID: "w1", Role: Worker, State: NodeReady, Unschedulable: true})
+
+	rec := call(t, handler, http.MethodPost, "/nodes/w1/uncordon", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("uncordon: %d %s", rec.Code, rec.Body.String())
+	}
+	var node Node
+	decodeData(t, rec, &node)
+	if node.Unschedulable {
+		t.Errorf("node still cordoned")
+	}
+}
+
+func TestCordonUnknownNode(t *testing.T) {
+	_, _, handler := newTestAPI(t)
+
+	if rec := call(t, handler, http.MethodPost, "/nodes/missing/cordon", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("got %d, want 404", rec.Code)
+	}
+}
+
+func TestHeartbeatUnknownNodeIsNotFound(t *testing.T) {
+	_, _, handler := newTestAPI(t)
+
+	rec := call(t, handler, http.MethodPost, "/nodes/heartbeat", Heartbeat{NodeID: "missing"})
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("got %d, want 404", rec.Code)
+	}
+}
+
+// hostingNode saves node w1, last seen at seen, running container c1.
+func hostingNode(t *testing.T, store Store, seen time.Time) {
+	t.Helper()
+	mustSave(t, store,
+		&Node{ID: "w1", Role: Worker, State: NodeReady, LastSeen: seen},
+		&Container{ID: "c1", Image: "nginx", State: Running, DesiredState: Running,
+			NodeID: "w1", Scheduled: true, ContainerID: "rt-1"})
+}
+
+func TestDeleteNodeRunningContainersNeedsForce(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	hostingNode(t, store, time.Now().Add(-time.Hour))
+
+	rec := call(t, handler, http.MethodDelete, "/nodes/w1", nil)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("got %d, want 409", rec.Code)
+	}
+	if _, err := store.GetNode(context.Background(), "w1"); err != nil {
+		t.Errorf("node removed without force: %v", err)
+	}
+}
+
+func TestDeleteNodeForceUnschedulesContainers(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	ctx := context.Background()
+	hostingNode(t, store, time.Now().Add(-time.Hour))
+
+	rec := call(t, handler, http.MethodDelete, "/nodes/w1?force=true", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want 200", rec.Code, rec.Body.String())
+	}
+	if _, err := store.GetNode(ctx, "w1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("node still stored: %v", err)
+	}
+	c, err := store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Scheduled || c.NodeID != "" || c.LastNodeID != "w1" {
+		t.Errorf("container on %q (scheduled %v), want unscheduled from w1", c.NodeID, c.Scheduled)
+	}
+}
+
+func TestDeleteNodeForceRefusesLiveNode(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	hostingNode(t, store, time.Now())
+
+	rec := call(t, handler, http.MethodDelete, "/nodes/w1?force=true", nil)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("got %d, want 409 for a node still heartbeating", rec.Code)
+	}
+	if _, err := store.GetNode(context.Background(), "w1"); err != nil {
+		t.Errorf("live node removed: %v", err)
+	}
+}
+
+func TestDeleteNodeMissingIsNotFound(t *testing.T) {
+	_, _, handler := newTestAPI(t)
+
+	if rec := call(t, handler, http.MethodDelete, "/nodes/missing", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("got %d, want 404", rec.Code)
+	}
+}
+
+func TestRemovedNodeHeartbeatIsGone(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	mustSave(t, store, &Node{ID: "w1", Role: Worker, State: NodeReady, LastSeen: time.Now()})
+
+	if rec := call(t, handler, http.MethodDelete, "/nodes/w1", nil); rec.Code != http.StatusOK {
+		t.Fatalf("remove: %d %s", rec.Code, rec.Body.String())
+	}
+	rec := call(t, handler, http.MethodPost, "/nodes/heartbeat", Heartbeat{NodeID: "w1"})
+	if rec.Code != http.StatusGone || errorCode(t, rec) != codeGone {
+		t.Errorf("heartbeat after removal: got %d %s, want 410 gone", rec.Code, rec.Body.String())
+	}
+
+	// registering again is deliberate and clears the tombstone
+	if rec := call(t, handler, http.MethodPost, "/nodes/register", Node{ID: "w1", Role: Worker}); rec.Code != http.StatusOK {
+		t.Fatalf("register: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := call(t, handler, http.MethodPost, "/nodes/heartbeat", Heartbeat{NodeID: "w1"}); rec.Code != http.StatusOK {
+		t.Errorf("heartbeat after registering again: got %d, want 200", rec.Code)
+	}
+}
+
+func TestHeartbeatRecordsAllocation(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	mustSave(t, store, &Node{ID: "w1", Role: Worker, State: NodeReady})
+
+	hb := Heartbeat{NodeID: "w1", Allocated: Resources{CPUCores: 1, MemoryMB: 256}}
+	if rec := call(t, handler, http.MethodPost, "/nodes/heartbeat", hb); rec.Code != http.StatusOK {
+		t.Fatalf("heartbeat: %d %s", rec.Code, rec.Body.String())
+	}
+	node, err := store.GetNode(context.Background(), "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Allocated != hb.Allocated {
+		t.Errorf("allocated = %+v, want %+v", node.Allocated, hb.Allocated)
+	}
+}
+
+// failingStore fails every container read with err.
+type failingStore struct {
+	Store
+	err error
+}
+
+func (s failingStore) GetContainer(context.Context, string) (*Container, error) {
+	return nil, s.err
+}
+
+func TestContainerStoreErrorsAreNotNotFound(t *testing.T) {
+	store := failingStore{Store: NewMemStore(), err: errors.New("disk on fire")}
+	s := NewAPIServer(store, newChangeFeed(), "")
+	s.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	s.logRequests = false
+	s.token = ""
+	handler := s.handler()
+
+	for _, method := range []string{http.MethodGet, http.MethodPatch} {
+		rec := call(t, handler, method, "/containers/c1", map[string]any{"desired_state": "stopped"})
+		if rec.Code != http.StatusInternalServerError || errorCode(t, rec) != codeInternal {
+			t.Errorf("%s: got %d %s, want 500 internal", method, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestMissingContainerIsNotFound(t *testing.T) {
+	_, _, handler := newTestAPI(t)
+
+	for _, method := range []string{http.MethodGet, http.MethodPatch} {
+		rec := call(t, handler, method, "/containers/missing", map[string]any{"desired_state": "stopped"})
+		if rec.Code != http.StatusNotFound || errorCode(t, rec) != codeNotFound {
+			t.Errorf("%s: got %d %s, want 404 not_found", method, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestScaleCopiesAndDestroysReplicas(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	ctx := context.Background()
+	mustSave(t, store,
+		&Container{ID: "web-1", Image: "nginx", State: Running, DesiredState: Running, Deployment: "web"},
+		&Container{ID: "web-2", Image: "nginx", State: Running, DesiredState: Running, Deployment: "web", Protected: true})
+
+	replicas := func() []*Container {
+		containers, err := store.ListContainers(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return deploymentReplicas(containers, "web")
+	}
+
+	rec := call(t, handler, http.MethodPost, "/deployments/web/scale", Scale{Replicas: 4})
+	var scale Scale
+	decodeData(t, rec, &scale)
+	if rec.Code != http.StatusOK || scale.Replicas != 4 || len(replicas()) != 4 {
+		t.Fatalf("scale up: got %d %s with %d stored, want 4 replicas", rec.Code, rec.Body.String(), len(replicas()))
+	}
+
+	// the protected replica stays
+	rec = call(t, handler, http.MethodPost, "/deployments/web/scale", Scale{Replicas: 0})
+	decodeData(t, rec, &scale)
+	left := replicas()
+	if rec.Code != http.StatusOK || scale.Replicas != 1 || len(left) != 1 || left[0].ID != "web-2" {
+		t.Errorf("scale to 0: got %d %s leaving %d, want only the protected web-2", rec.Code, rec.Body.String(), len(left))
+	}
+}
+
+func TestScaleRefusesAutoscaledAndUnknownDeployments(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	mustSave(t, store, &Container{ID: "web-1", Image: "nginx", State: Running, DesiredState: Running, Deployment: "web"})
+	if err := store.SaveAutoscaler(context.Background(), &HorizontalAutoscaler{
+		Deployment: "web", MinReplicas: 1, MaxReplicas: 3, TargetCPUPercent: 50,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := call(t, handler, http.MethodPost, "/deployments/web/scale", Scale{Replicas: 2}); rec.Code != http.StatusConflict {
+		t.Errorf("autoscaled: got %d %s, want 409", rec.Code, rec.Body.String())
+	}
+	if rec := call(t, handler, http.MethodPost, "/deployments/missing/scale", Scale{Replicas: 2}); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown deployment: got %d %s, want 404", rec.Code, rec.Body.String())
+	}
+	if rec := call(t, handler, http.MethodPost, "/deployments/missing/scale", Scale{Replicas: -1}); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative replicas: got %d %s, want 400", rec.Code, rec.Body.String())
+	}
+}
+
+func TestResponsesUseEnvelope(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	mustSave(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running})
+
+	for _, tc := range []struct {
+		name, method, path string
+		status             int
+		want               string // the top-level key besides meta
+	}{
+		{"get", http.MethodGet, "/containers/c1", http.StatusOK, "data"},
+		{"list", http.MethodGet, "/containers", http.StatusOK, "data"},
+		{"not found", http.MethodGet, "/containers/missing", http.StatusNotFound, "error"},
+		{"bad method", http.MethodPut, "/containers", http.StatusMethodNotAllowed, "error"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := call(t, handler, tc.method, tc.path, nil)
+			if rec.Code != tc.status {
+				t.Fatalf("got %d %s, want %d", rec.Code, rec.Body.String(), tc.status)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+
+			var envelope map[string]json.RawMessage
+			if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+				t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+			}
+			if len(envelope) != 2 || envelope[tc.want] == nil {
+				t.Errorf("envelope %s, want only %s and meta", rec.Body.String(), tc.want)
+			}
+			var meta apiMeta
+			if err := json.Unmarshal(envelope["meta"], &meta); err != nil || meta.Version != apiVersion {
+				t.Errorf("meta = %s, want version %s", envelope["meta"], apiVersion)
+			}
+			if tc.want == "error" {
+				var body apiError
+				if err := json.Unmarshal(envelope["error"], &body); err != nil || body.Code == "" || body.Message == "" {
+					t.Errorf("error = %s, want a code and a message", envelope["error"])
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteProtectedContainerNeedsOverride(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	ctx := context.Background()
+	mustSave(t, store, &Container{ID: "db", Image: "postgres", State: Running, DesiredState: Running, Protected: true})
+
+	rec := call(t, handler, http.MethodDelete, "/containers/db", nil)
+	if rec.Code != http.StatusConflict || errorCode(t, rec) != codeConflict {
+		t.Errorf("plain delete: got %d %s, want 409", rec.Code, rec.Body.String())
+	}
+	if _, err := store.GetContainer(ctx, "db"); err != nil {
+		t.Fatalf("protected container gone after a plain delete: %v", err)
+	}
+
+	if rec := call(t, handler, http.MethodDelete, "/containers/db?force_protected=true", nil); rec.Code != http.StatusOK {
+		t.Errorf("forced delete: got %d %s, want 200", rec.Code, rec.Body.String())
+	}
+	if _, err := store.GetContainer(ctx, "db"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("after a forced delete: got %v, want it gone", err)
+	}
+}
+
+func TestBulkDeleteSkipsProtectedUnlessForced(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	ctx := context.Background()
+	mustSave(t, store,
+		&Container{ID: "web", Image: "nginx", State: Running, DesiredState: Running},
+		&Container{ID: "db", Image: "postgres", State: Running, DesiredState: Running, Protected: true})
+
+	rec := call(t, handler, http.MethodDelete, "/containers?all=true", nil)
+	var result BulkDeleteResult
+	decodeData(t, rec, &result)
+	if !slices.Equal(result.Destroyed, []string{"web"}) || !slices.Equal(result.Skipped, []string{"db"}) {
+		t.Errorf("plain bulk delete = %+v, want web destroyed and db skipped", result)
+	}
+	if db, _ := store.GetContainer(ctx, "db"); db.DesiredState != Running {
+		t.Errorf("protected db is %s, want still running", db.DesiredState)
+	}
+
+	rec = call(t, handler, http.MethodDelete, "/containers?all=true&force_protected=true", nil)
+	decodeData(t, rec, &result)
+	if !slices.Equal(result.Destroyed, []string{"db"}) {
+		t.Errorf("forced bulk delete = %+v, want db destroyed", result)
+	}
+}
+
+func TestCreateRejectsTakenNameAndResolvesByName(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	mustSave(t, store, &Container{ID: "c1", Name: "web", Image: "nginx", State: Running, DesiredState: Running})
+
+	rec := call(t, handler, http.MethodPost, "/containers",
+		`{"id":"c2","name":"web","image":"nginx","state":"requested","desired_state":"running"}`)
+	if rec.Code != http.StatusConflict || errorCode(t, rec) != codeConflict {
+		t.Errorf("duplicate name: got %d %s, want 409", rec.Code, rec.Body.String())
+	}
+
+	rec = call(t, handler, http.MethodGet, "/containers/web", nil)
+	var got struct{ ID string }
+	decodeData(t, rec, &got)
+	if got.ID != "c1" {
+		t.Errorf("GET by name returned %q, want c1", got.ID)
+	}
+}
+
+func TestListContainersFiltersByState(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	mustSave(t, store,
+		&Container{ID: "run-web", Image: "nginx", State: Running, DesiredState: Running, Labels: map[string]string{"app": "web"}},
+		&Container{ID: "run-db", Image: "postgres", State: Running, DesiredState: Running, Labels: map[string]string{"app": "db"}},
+		&Container{ID: "failed-web", Image: "nginx", State: Failed, DesiredState: Running, Labels: map[string]string{"app": "web"}},
+		&Container{ID: "stopped", Image: "nginx", State: Stopped, DesiredState: Stopped})
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"state=failed", []string{"failed-web"}},
+		{"state=failed,stopped", []string{"failed-web", "stopped"}},
+		{"state=failed&state=running", []string{"failed-web", "run-db", "run-web"}},
+		{"state=running,failed&selector=app%3Dweb", []string{"failed-web", "run-web"}},
+	} {
+		rec := call(t, handler, http.MethodGet, "/containers?"+tc.query, nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: %d %s", tc.query, rec.Code, rec.Body.String())
+			continue
+		}
+		var got []*Container
+		decodeData(t, rec, &got)
+		if ids := containerIDs(got); !slices.Equal(ids, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.query, ids, tc.want)
+		}
+	}
+
+	rec := call(t, handler, http.MethodGet, "/containers?state=running,exploded", nil)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "exploded") {
+		t.Errorf("invalid state: got %d %s, want 400 naming it", rec.Code, rec.Body.String())
+	}
+}
+
+func TestListContainersPagesWithFilter(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	for i := range 7 {
+		state := Running
+		if i%2 == 1 {
+			state = Stopped
+		}
+		mustSave(t, store, &Container{ID: fmt.Sprintf("c%d", i), Image: "nginx", State: state, DesiredState: state})
+	}
+
+	var seen []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 4 {
+			t.Fatalf("still paging after %v", seen)
+		}
+		rec := call(t, handler, http.MethodGet, "/containers?state=running&limit=2&cursor="+cursor, nil)
+		var envelope struct {
+			Data []*Container `json:"data"`
+			Meta apiMeta      `json:"meta"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+			t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+		}
+		for _, c := range envelope.Data {
+			seen = append(seen, c.ID)
+		}
+		if cursor = envelope.Meta.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if want := []string{"c0", "c2", "c4", "c6"}; !slices.Equal(seen, want) {
+		t.Errorf("paged through %v, want %v", seen, want)
+	}
+}
+
+func TestSecretValuesStayOutOfContainerResponses(t *testing.T) {
+	s, store, handler := newTestAPI(t)
+	s.token = "secret"
+	mustSave(t, store,
+		&Secret{Name: "db", Data: map[string]string{"PASSWORD": "hunter2"}},
+		&Container{ID: "c1", Image: "nginx", State: Running, DesiredState: Running,
+			NodeID: "w1", Scheduled: true, SecretRefs: []string{"db"}})
+
+	for _, path := range []string{"/containers", "/containers/c1", "/containers/assigned?node_id=w1"} {
+		rec := call(t, handler, http.MethodGet, path, nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s: %d %s", path, rec.Code, rec.Body.String())
+		}
+		if strings.Contains(rec.Body.String(), "hunter2") {
+			t.Errorf("GET %s leaks the secret value: %s", path, rec.Body.String())
+		}
+	}
+
+	if rec := call(t, handler, http.MethodGet, "/secrets/db", nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("secret without a token: got %d, want 401", rec.Code)
+	}
+	rec := call(t, handler, http.MethodGet, "/secrets/db", nil, "Authorization", "Bearer secret")
+	var secret Secret
+	decodeData(t, rec, &secret)
+	if secret.Data["PASSWORD"] != "hunter2" {
+		t.Errorf("secret with the token = %+v, want its data", secret)
+	}
+}
+
+func TestPatchImageKeepsIdentity(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	mustSave(t, store, &Container{ID: "c1", Name: "web", Image: "nginx:1", State: Running, DesiredState: Running,
+		NodeID: "w1", Scheduled: true})
+
+	rec := call(t, handler, http.MethodPatch, "/containers/c1", `{"image":"nginx:2"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("patch: %d %s", rec.Code, rec.Body.String())
+	}
+
+	c, err := store.GetContainer(context.Background(), "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Image != "nginx:2" || c.Generation != 1 {
+		t.Errorf("stored image %s at generation %d, want nginx:2 at 1", c.Image, c.Generation)
+	}
+	if c.Name != "web" || c.NodeID != "w1" || !c.Scheduled {
+		t.Errorf("patch lost the container's identity: name %q on %q (scheduled %v)", c.Name, c.NodeID, c.Scheduled)
+	}
+
+	// the same image again is no change
+	call(t, handler, http.MethodPatch, "/containers/c1", `{"image":"nginx:2"}`)
+	if c, _ := store.GetContainer(context.Background(), "c1"); c.Generation != 1 {
+		t.Errorf("an unchanged image bumped the generation to %d", c.Generation)
+	}
+}
+
+func TestSummarizeNodesCountsAssignedContainers(t *testing.T) {
+	nodes := []*Node{{ID: "w1", Role: Worker}, {ID: "w2", Role: Worker}}
+	containers := []*Container{
+		{ID: "a", NodeID: "w1", Scheduled: true, State: Running},
+		{ID: "b", NodeID: "w1", Scheduled: true, State: Stopped},
+		{ID: "c", NodeID: "w2", Scheduled: true, State: Running},
+		// recorded on w2 but not assigned there any more
+		{ID: "d", NodeID: "w2", Scheduled: false, State: Requested},
+		{ID: "e", NodeID: "gone", Scheduled: true, State: Running},
+	}
+
+	got := make(map[string][2]int)
+	for _, summary := range summarizeNodes(nodes, containers) {
+		got[summary.ID] = [2]int{summary.Containers, summary.Running}
+	}
+	want := map[string][2]int{"w1": {2, 1}, "w2": {1, 1}}
+	if !maps.Equal(got, want) {
+		t.Errorf("(containers, running) per node = %v, want %v", got, want)
+	}
+}
+
+func TestSummarizeClusterAggregatesNodesAndContainers(t *testing.T) {
+	started := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	nodes := []*Node{
+		{ID: "cp", Role: ControlPlane, State: NodeReady, Capacity: Resources{CPUCores: 2, MemoryMB: 2048}},
+		{ID: "w1", Role: Worker, State: NodeReady, Capacity: Resources{CPUCores: 4, MemoryMB: 4096}, Allocated: Resources{CPUCores: 1, MemoryMB: 512}},
+		{ID: "w2", Role: Worker, State: NodeNotReady, Capacity: Resources{CPUCores: 2, MemoryMB: 1024}, Allocated: Resources{CPUCores: 2}},
+	}
+	containers := []*Container{
+		{ID: "a", State: Running},
+		{ID: "b", State: Running},
+		{ID: "c", State: Failed},
+	}
+
+	got := summarizeCluster(nodes, containers, started, started.Add(90*time.Second))
+	if got.Nodes != 3 || got.ReadyNodes != 2 || got.NotReadyNodes != 1 {
+		t.Errorf("nodes %d ready %d not ready %d, want 3, 2 and 1", got.Nodes, got.ReadyNodes, got.NotReadyNodes)
+	}
+	if want := (Resources{CPUCores: 6, MemoryMB: 5120}); got.Capacity != want {
+		t.Errorf("capacity = %+v, want only the workers' %+v", got.Capacity, want)
+	}
+	if want := (Resources{CPUCores: 3, MemoryMB: 512}); got.Allocated != want {
+		t.Errorf("allocated = %+v, want %+v", got.Allocated, want)
+	}
+	if want := map[ContainerState]int{Running: 2, Failed: 1}; got.Containers != 3 || !maps.Equal(got.ByState, want) {
+		t.Errorf("containers %d by state %v, want 3 and %v", got.Containers, got.ByState, want)
+	}
+	if got.UptimeSeconds != 90 {
+		t.Errorf("uptime = %ds, want 90", got.UptimeSeconds)
+	}
+}
+
+func TestNodeContainersListsOnlyAssigned(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	mustSave(t, store,
+		&Node{ID: "w1", Role: Worker, State: NodeReady},
+		&Node{ID: "w2", Role: Worker, State: NodeReady},
+		&Container{ID: "a", Image: "nginx", State: Running, DesiredState: Running, NodeID: "w1", Scheduled: true},
+		&Container{ID: "b", Image: "nginx", State: Running, DesiredState: Running, NodeID: "w2", Scheduled: true},
+		// recorded on w1 but waiting to be placed again
+		&Container{ID: "c", Image: "nginx", State: Requested, DesiredState: Running, NodeID: "w1"})
+
+	rec := call(t, handler, http.MethodGet, "/nodes/w1/containers", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+	var got []*Container
+	decodeData(t, rec, &got)
+	if ids := containerIDs(got); !slices.Equal(ids, []string{"a"}) {
+		t.Errorf("w1 containers = %v, want [a]", ids)
+	}
+
+	rec = call(t, handler, http.MethodGet, "/nodes/missing/containers", nil)
+	if rec.Code != http.StatusNotFound || errorCode(t, rec) != codeNotFound {
+		t.Errorf("unknown node: got %d %s, want 404", rec.Code, rec.Body.String())
+	}
+}
+
+func TestStatusBatchIsAllOrNothing(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	ctx := context.Background()
+	mustSave(t, store,
+		&Container{ID: "c1", Image: "nginx", State: Running, DesiredState: Running, NodeID: "w1", Scheduled: true},
+		&Container{ID: "c2", Image: "busybox", State: Completed, DesiredState: Running, NodeID: "w1", Scheduled: true})
+
+	report := func(id string, state ContainerState) *Container {
+		c, err := store.GetContainer(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.State = state
+		return c
+	}
+
+	// a completed job can't be reported running again, which sinks c1's
+	// valid report with it
+	rec := call(t, handler, http.MethodPost, "/containers/status/batch", []*Container{report("c1", Stopped), report("c2", Running)})
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("got %d %s, want 409", rec.Code, rec.Body.String())
+	}
+	if c, _ := store.GetContainer(ctx, "c1"); c.State != Running {
+		t.Errorf("c1 is %s, want nothing from the rejected batch saved", c.State)
+	}
+
+	rec = call(t, handler, http.MethodPost, "/containers/status/batch", []*Container{report("c1", Stopped), report("c2", Destroyed)})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("valid batch: got %d %s", rec.Code, rec.Body.String())
+	}
+	c1, _ := store.GetContainer(ctx, "c1")
+	c2, _ := store.GetContainer(ctx, "c2")
+	if c1.State != Stopped || c2.State != Destroyed {
+		t.Errorf("states %s and %s, want both reports saved", c1.State, c2.State)
+	}
+}
+
+func TestBulkDeleteBySelectorOnlyTouchesMatches(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	ctx := context.Background()
+	mustSave(t, store,
+		&Container{ID: "web-1", Image: "nginx", State: Running, DesiredState: Running, Labels: map[string]string{"app": "web", "env": "dev"}},
+		&Container{ID: "web-2", Image: "nginx", State: Running, DesiredState: Running, Labels: map[string]string{"app": "web", "env": "prod"}},
+		&Container{ID: "db", Image: "postgres", State: Running, DesiredState: Running, Labels: map[string]string{"app": "db", "env": "dev"}})
+
+	rec := call(t, handler, http.MethodDelete, "/containers?selector=app%3Dweb,env%3Ddev&dry_run=true", nil)
+	var result BulkDeleteResult
+	decodeData(t, rec, &result)
+	if !slices.Equal(result.Destroyed, []string{"web-1"}) {
+		t.Errorf("dry run = %+v, want just web-1", result)
+	}
+	if c, _ := store.GetContainer(ctx, "web-1"); c.DesiredState != Running {
+		t.Errorf("dry run marked web-1 %s", c.DesiredState)
+	}
+
+	rec = call(t, handler, http.MethodDelete, "/containers?selector=app%3Dweb", nil)
+	decodeData(t, rec, &result)
+	if !slices.Equal(result.Destroyed, []string{"web-1", "web-2"}) {
+		t.Errorf("delete app=web = %+v, want web-1 and web-2", result)
+	}
+	for id, want := range map[string]ContainerState{"web-1": Destroyed, "web-2": Destroyed, "db": Running} {
+		if c, _ := store.GetContainer(ctx, id); c.DesiredState != want {
+			t.Errorf("%s is desired %s, want %s", id, c.DesiredState, want)
+		}
+	}
+
+	if rec := call(t, handler, http.MethodDelete, "/containers", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("bulk delete without a selector or all: got %d, want 400", rec.Code)
+	}
+}
+
+func TestHeartbeatReportsRuntimeHealth(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	ctx := context.Background()
+	mustSave(t, store, &Node{ID: "w1", Role: Worker, State: NodeReady})
+
+	call(t, handler, http.MethodPost, "/nodes/heartbeat", Heartbeat{NodeID: "w1", RuntimeError: "daemon unreachable"})
+	node, err := store.GetNode(ctx, "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.State != NodeNotReady || node.Reason != "daemon unreachable" {
+		t.Errorf("node is %s (%q), want not ready with the runtime error", node.State, node.Reason)
+	}
+
+	call(t, handler, http.MethodPost, "/nodes/heartbeat", Heartbeat{NodeID: "w1"})
+	if node, _ := store.GetNode(ctx, "w1"); node.State != NodeReady || node.Reason != "" {
+		t.Errorf("node is %s (%q) after a healthy heartbeat, want ready", node.State, node.Reason)
+	}
+}
+
+func TestHeartbeatWithSkewedClockKeepsNodeHealthy(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	ctx := context.Background()
+	mustSave(t, store, &Node{ID: "w1", Role: Worker, State: NodeReady})
+
+	// the worker's clock runs an hour behind, which alone would look like
+	// a missed heartbeat
+	before := time.Now()
+	call(t, handler, http.MethodPost, "/nodes/heartbeat", Heartbeat{NodeID: "w1", SentAt: before.Add(-time.Hour)})
+	node, err := store.GetNode(ctx, "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !skewed(node.ClockSkew) || node.ClockSkew > -59*time.Minute {
+		t.Errorf("recorded skew %s, want about -1h", node.ClockSkew)
+	}
+	if node.LastSeen.Before(before) {
+		t.Errorf("LastSeen %s, want the control plane's receive time", node.LastSeen)
+	}
+	if node.State != NodeReady || heartbeatExpired(node, time.Now()) {
+		t.Errorf("node is %s (expired %v), want a healthy node despite its clock", node.State, heartbeatExpired(node, time.Now()))
+	}
+
+	call(t, handler, http.MethodPost, "/nodes/heartbeat", Heartbeat{NodeID: "w1", SentAt: time.Now()})
+	if node, _ := store.GetNode(ctx, "w1"); skewed(node.ClockSkew) {
+		t.Errorf("skew %s after an in-sync heartbeat, want it cleared", node.ClockSkew)
+	}
+}
+
+func TestHeartbeatExpiredUsesServerTime(t *testing.T) {
+	now := time.Now()
+	if heartbeatExpired(&Node{LastSeen: now.Add(-time.Second)}, now) {
+		t.Errorf("a node seen a second ago expired")
+	}
+	if !heartbeatExpired(&Node{LastSeen: now.Add(-nodeHeartbeatTimeout - time.Second)}, now) {
+		t.Errorf("a node silent for longer than the timeout did not expire")
+	}
+}
+
+func TestClientMapsServerErrorsToSentinels(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c := NewAPIClient(srv.URL, "")
+	mustSave(t, store, &Container{ID: "db", Image: "postgres", State: Running, DesiredState: Running, Protected: true})
+
+	_, err := c.GetContainer("missing")
+	var apiErr *APIError
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+		t.Errorf("missing container: got %v, want a 404 matching ErrNotFound", err)
+	}
+	if err := c.DeleteContainer("db", false); !errors.Is(err, ErrConflict) || errors.Is(err, ErrNotFound) {
+		t.Errorf("protected delete: got %v, want ErrConflict", err)
+	}
+}
+
+func TestFollowLogsStreamsThroughProxy(t *testing.T) {
+	s, store, handler := newTestAPI(t)
+	s.token = "secret"
+	runtime := NewFakeRuntime()
+	runtime.SetLogs("rt-1", "hello\n")
+	runningOnAgent(t, store, runtime, "secret")
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL, "secret", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logs, err := c.StreamLogs(ctx, "c1", 10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+
+	// the line arrives while the followed stream is still open
+	line, err := bufio.NewReader(logs).ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Fatalf("read %q, %v, want the programmed line", line, err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, logs)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("followed stream ended on its own: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("followed stream outlived its context")
+	}
+
+	// without follow the stream ends after the existing output
+	logs, err = c.StreamLogs(context.Background(), "c1", 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+	if data, err := io.ReadAll(logs); err != nil || string(data) != "hello\n" {
+		t.Errorf("unfollowed logs = %q, %v", data, err)
+	}
+}
+
+func TestNodeMaintenanceScheduleAndCancel(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	ctx := context.Background()
+	node := workerNode("w1")
+	node.Allocated = Resources{CPUCores: 1}
+	if err := store.SaveNode(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+
+	window := `{"start":"2026-03-01T02:00:00Z","duration":3600000000000}`
+	if rec := call(t, handler, http.MethodPost, "/nodes/w1/maintenance", window); rec.Code != http.StatusOK {
+		t.Fatalf("schedule: %d %s", rec.Code, rec.Body.String())
+	}
+	// the maintenance pass cordons the node
+	if _, err := store.UpdateNode(ctx, "w1", func(n *Node) error {
+		n.Unschedulable, n.Maintenance.Cordoned = true, true
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := call(t, handler, http.MethodDelete, "/nodes/w1/maintenance", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("cancel: %d %s", rec.Code, rec.Body.String())
+	}
+	stored, err := store.GetNode(ctx, "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Maintenance != nil || stored.Unschedulable {
+		t.Errorf("after the cancel: window %+v, cordoned %v; want neither", stored.Maintenance, stored.Unschedulable)
+	}
+	if stored.Allocated.CPUCores != 1 {
+		t.Errorf("allocation %+v lost by the maintenance writes", stored.Allocated)
+	}
+}
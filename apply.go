@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+)
+
+// defaultApplyKey is the label tying a manifest entry to the container it
+// manages across applies.
+const defaultApplyKey = "name"
+
+// applyPlan is what apply -f has to do to make the cluster match a
+// manifest.
+type applyPlan struct {
+	Create []*Container
+	Update []applyUpdate
+	Prune  []*Container
+}
+
+type applyUpdate struct {
+	Current *Container
+	Patch   ContainerPatch
+	Changes []string
+}
+
+func (p *applyPlan) empty() bool {
+	return len(p.Create) == 0 && len(p.Update) == 0 && len(p.Prune) == 0
+}
+
+// planApply diffs manifest entries against the current containers, matching
+// them by the value of the key label. Only image, env and ports are
+// compared; those are what an update can change in place. With prune,
+// current containers carrying the key but absent from the manifest are
+// removed.
+func planApply(manifest, current []*Container, key string, prune bool) (*applyPlan, error) {
+	byName := make(map[string]*Container)
+	for _, c := range current {
+		name, ok := c.Labels[key]
+		if !ok || c.DesiredState == Destroyed {
+			continue
+		}
+		if other, dup := byName[name]; dup {
+			return nil, fmt.Errorf("containers %s and %s are both labelled %s=%s", other.ID, c.ID, key, name)
+		}
+		byName[name] = c
+	}
+
+	plan := &applyPlan{}
+	wanted := make(map[string]bool, len(manifest))
+	for i, want := range manifest {
+		name, ok := want.Labels[key]
+		if !ok || name == "" {
+			return nil, fmt.Errorf("manifest entry %d has no %s label", i, key)
+		}
+		if wanted[name] {
+			return nil, fmt.Errorf("manifest lists %s=%s more than once", key, name)
+		}
+		wanted[name] = true
+
+		normalizePorts(want.Ports)
+
+		have, ok := byName[name]
+		if !ok {
+			plan.Create = append(plan.Create, want)
+			continue
+		}
+
+		if update, changed := diffContainer(have, want); changed {
+			plan.Update = append(plan.Update, update)
+		}
+	}
+
+	if prune {
+		for _, name := range slices.Sorted(maps.Keys(byName)) {
+			if !wanted[name] {
+				plan.Prune = append(plan.Prune, byName[name])
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+func diffContainer(have, want *Container) (applyUpdate, bool) {
+	update := applyUpdate{Current: have}
+
+	if want.Image != have.Image {
+		update.Patch.Image = &want.Image
+		update.Changes = append(update.Changes, fmt.Sprintf("image %s -> %s", have.Image, want.Image))
+	}
+	if !maps.Equal(want.Env, have.Env) {
+		env := want.Env
+		if env == nil {
+			env = map[string]string{}
+		}
+		update.Patch.Env = &env
+		update.Changes = append(update.Changes, "env")
+	}
+	if !slices.Equal(specPorts(want.Ports), specPorts(have.Ports)) {
+		ports := want.Ports
+		if ports == nil {
+			ports = []PortMapping{}
+		}
+		update.Patch.Ports = &ports
+		update.Changes = append(update.Changes, fmt.Sprintf("ports %s -> %s", formatPorts(have.Ports), formatPorts(ports)))
+	}
+
+	return update, len(update.Changes) > 0
+}
+
+// prepareSpecs fills in what a user-written spec leaves out: a generated ID
+// and the initial states and timestamps.
+func prepareSpecs(containers []*Container) error {
+	now := time.Now()
+	for _, c := range containers {
+		if c.ID == "" {
+			id, err := GenerateID()
+			if err != nil {
+				return err
+			}
+			c.ID = id
+		}
+		if c.State == "" {
+			c.State = Requested
+		}
+		if c.DesiredState == "" {
+			c.DesiredState = Running
+		}
+		c.CreatedAt = now
+		c.UpdatedAt = now
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import "testing"
+
+func TestPlanApplyCreatesUpdatesAndPrunes(t *testing.T) {
+	labelled := func(id, name, image string) *Container {
+		return &Container{ID: id, Image: image, DesiredState: Running, Labels: map[string]string{"name": name}}
+	}
+	current := []*Container{
+		labelled("c-web", "web", "nginx:1"),
+		labelled("c-db", "db", "postgres:16"),
+		labelled("c-old", "old", "redis"),
+		// not managed by apply, so never pruned
+		{ID: "c-manual", Image: "busybox", DesiredState: Running},
+	}
+	manifest := []*Container{
+		labelled("", "web", "nginx:2"),
+		labelled("", "db", "postgres:16"),
+		labelled("", "cache", "memcached"),
+	}
+
+	plan, err := planApply(manifest, current, defaultApplyKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Create) != 1 || plan.Create[0].Labels["name"] != "cache" {
+		t.Errorf("create = %v, want only cache", plan.Create)
+	}
+	if len(plan.Update) != 1 || plan.Update[0].Current.ID != "c-web" || *plan.Update[0].Patch.Image != "nginx:2" {
+		t.Errorf("update = %+v, want web moved to nginx:2", plan.Update)
+	}
+	if plan.Update[0].Patch.Env != nil || plan.Update[0].Patch.Ports != nil {
+		t.Errorf("web patch touches %v, want only the image", plan.Update[0].Changes)
+	}
+	if len(plan.Prune) != 1 || plan.Prune[0].ID != "c-old" {
+		t.Errorf("prune = %v, want only c-old", plan.Prune)
+	}
+
+	plan, err = planApply(manifest, current, defaultApplyKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Prune) != 0 {
+		t.Errorf("pruned %v without --prune", plan.Prune)
+	}
+}
+
+func TestPlanApplyOfAppliedManifestIsEmpty(t *testing.T) {
+	current := []*Container{{ID: "c-web", Image: "nginx", DesiredState: Running, Labels: map[string]string{"name": "web"},
+		Ports: []PortMapping{{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}}}}
+	manifest := []*Container{{Image: "nginx", Labels: map[string]string{"name": "web"},
+		Ports: []PortMapping{{HostPort: 8080, ContainerPort: 80}}}}
+
+	plan, err := planApply(manifest, current, defaultApplyKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.empty() {
+		t.Errorf("plan = %+v, want nothing to do", plan)
+	}
+}
+
+func TestPlanApplyRejectsAmbiguousManifest(t *testing.T) {
+	for name, manifest := range map[string][]*Container{
+		"missing key": {{Image: "nginx"}},
+		"duplicate":   {{Image: "nginx", Labels: map[string]string{"name": "web"}}, {Image: "nginx", Labels: map[string]string{"name": "web"}}},
+	} {
+		if _, err := planApply(manifest, nil, defaultApplyKey, false); err == nil {
+			t.Errorf("%s: planned without an error", name)
+		}
+	}
+}
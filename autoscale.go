@@ -0,0 +1,216 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"time"
+)
+
+const (
+	// defaultAutoscaleCooldown is the least time between two scale changes
+	// of a deployment when its autoscaler doesn't set one.
+	defaultAutoscaleCooldown = 3 * time.Minute
+
+	// autoscaleTolerance leaves the replica count alone while the average
+	// CPU is within this fraction of the target.
+	autoscaleTolerance = 0.1
+
+	// usageMaxAge is how old a usage sample may be and still count towards
+	// the average.
+	usageMaxAge = time.Minute
+)
+
+// desiredReplicas works out how many replicas hpa wants now, and the
+// average CPU of the sampled replicas it based that on. Without samples
+// the current count is kept. A change is held back until the cooldown since
+// the last one has passed.
+func desiredReplicas(hpa *HorizontalAutoscaler, replicas []*Container, now time.Time) (int, float64) {
+	clamp := func(n int) int { return min(max(n, hpa.MinReplicas), hpa.MaxReplicas) }
+
+	current := hpa.Replicas
+	if current == 0 {
+		current = len(replicas)
+	}
+	current = clamp(current)
+
+	var sum float64
+	sampled := 0
+	for _, c := range replicas {
+		if c.State == Running && c.Usage != nil && now.Sub(c.Usage.SampledAt) <= usageMaxAge {
+			sum += c.Usage.CPUPercent
+			sampled++
+		}
+	}
+	if sampled == 0 {
+		return current, 0
+	}
+
+	avg := sum / float64(sampled)
+	ratio := avg / hpa.TargetCPUPercent
+	if math.Abs(ratio-1) <= autoscaleTolerance {
+		return current, avg
+	}
+
+	want := clamp(int(math.Ceil(float64(sampled) * ratio)))
+	if want != current && now.Sub(hpa.LastScaleAt) < cmp.Or(hpa.Cooldown, defaultAutoscaleCooldown) {
+		return current, avg
+	}
+	return want, avg
+}
+
+// autoscale adjusts every autoscaled deployment to the replica count its
+// autoscaler wants and returns the replicas it added, so they can be
+// scheduled in the same pass.
+func (r *Reconciler) autoscale(ctx context.Context, containers []*Container, now time.Time) []*Container {
+	autoscalers, err := r.cogsworth.store.ListAutoscalers(ctx)
+	if err != nil {
+		log.Printf("Failed to list autoscalers: %v", err)
+		return nil
+	}
+
+	var added []*Container
+	for _, hpa := range autoscalers {
+		replicas := deploymentReplicas(containers, hpa.Deployment)
+
+		want, avg := desiredReplicas(hpa, replicas, now)
+		if want != hpa.Replicas || avg != hpa.CurrentCPUPercent {
+			if hpa.Replicas != 0 && want != hpa.Replicas {
+				log.Printf("Scaling deployment %s from %d to %d replicas (average CPU %.1f%%, target %.1f%%)",
+					hpa.Deployment, hpa.Replicas, want, avg, hpa.TargetCPUPercent)
+				hpa.LastScaleAt = now
+			}
+			hpa.Replicas = want
+			hpa.CurrentCPUPercent = avg
+			hpa.UpdatedAt = now
+			if err := r.cogsworth.store.SaveAutoscaler(ctx, hpa); err != nil {
+				log.Printf("Failed to save autoscaler for %s: %v", hpa.Deployment, err)
+				continue
+			}
+		}
+
+		created, _, err := scaleDeployment(ctx, r.cogsworth.store, hpa.Deployment, hpa.Replicas, replicas, now)
+		if err != nil {
+			log.Printf("Failed to scale deployment %s: %v", hpa.Deployment, err)
+			continue
+		}
+		added = append(added, created...)
+	}
+	return added
+}
+
+// deploymentReplicas returns the replicas of deployment that aren't being
+// destroyed.
+func deploymentReplicas(containers []*Container, deployment string) []*Container {
+	var replicas []*Container
+	for _, c := range containers {
+		if c.Deployment == deployment && c.DesiredState != Destroyed {
+			replicas = append(replicas, c)
+		}
+	}
+	return replicas
+}
+
+// scaleDeployment adds copies of the newest replica, or destroys replicas,
+// until deployment has want of them, and returns the replicas it added and
+// destroyed. Replicas that aren't running are removed first, then the
+// newest; protected ones are kept.
+func scaleDeployment(ctx context.Context, store Store, deployment string, want int, replicas []*Container, now time.Time) (created, destroyed []*Container, err error) {
+	switch {
+	case len(replicas) < want:
+		if len(replicas) == 0 {
+			return nil, nil, fmt.Errorf("no replica to copy, add one with --deployment %s", deployment)
+		}
+		template := slices.MaxFunc(replicas, func(a, b *Container) int { return a.CreatedAt.Compare(b.CreatedAt) })
+
+		for range want - len(replicas) {
+			id, err := GenerateID()
+			if err != nil {
+				return nil, nil, err
+			}
+			replica, err := copyReplica(template, id, now)
+			if err != nil {
+				return nil, nil, err
+			}
+			created = append(created, replica)
+		}
+		if err := store.SaveContainers(ctx, created); err != nil {
+			return nil, nil, err
+		}
+		return created, nil, nil
+
+	case len(replicas) > want:
+		// protected replicas are only ever removed by hand
+		victims := slices.DeleteFunc(slices.Clone(replicas), func(c *Container) bool { return c.Protected })
+		slices.SortFunc(victims, func(a, b *Container) int {
+			if (a.State == Running) != (b.State == Running) {
+				if a.State == Running {
+					return 1
+				}
+				return -1
+			}
+			return b.CreatedAt.Compare(a.CreatedAt)
+		})
+		victims = victims[:min(len(victims), len(replicas)-want)]
+
+		for _, c := range victims {
+			c.DesiredState = Destroyed
+			c.UpdatedAt = now
+		}
+		if err := store.SaveContainers(ctx, victims); err != nil {
+			return nil, nil, err
+		}
+		return nil, victims, nil
+	}
+	return nil, nil, nil
+}
+
+// copyReplica returns a new, unscheduled container with template's spec.
+// Everything the template picked up since it was created, from its name to
+// its runtime status, is left behind.
+func copyReplica(template *Container, id string, now time.Time) (*Container, error) {
+	// the round trip keeps the copy from sharing maps and slices
+	data, err := json.Marshal(template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy replica: %w", err)
+	}
+	c := &Container{}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to copy replica: %w", err)
+	}
+
+	*c = Container{
+		ID:              id,
+		Image:           c.Image,
+		State:           Requested,
+		DesiredState:    Running,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		Env:             c.Env,
+		Ports:           specPorts(c.Ports),
+		Labels:          c.Labels,
+		Protected:       c.Protected,
+		SecretRefs:      c.SecretRefs,
+		StopTimeout:     c.StopTimeout,
+		StopSignal:      c.StopSignal,
+		TTL:             c.TTL,
+		MaxRestarts:     c.MaxRestarts,
+		RestartPolicy:   c.RestartPolicy,
+		Command:         c.Command,
+		Args:            c.Args,
+		ImagePullPolicy: c.ImagePullPolicy,
+		Resources:       c.Resources,
+		Group:           c.Group,
+		Deployment:      c.Deployment,
+		Network:         c.Network,
+		Affinity:        c.Affinity,
+		AntiAffinity:    c.AntiAffinity,
+		DependsOn:       c.DependsOn,
+		ReadinessProbe:  c.ReadinessProbe,
+	}
+	return c, nil
+}
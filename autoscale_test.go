@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// replicasAt returns n Running replicas of deployment web, each sampled at
+// cpu percent at now.
+func replicasAt(n int, cpu float64, now time.Time) []*Container {
+	replicas := make([]*Container, n)
+	for i := range replicas {
+		replicas[i] = &Container{ID: fmt.Sprintf("web-%d", i), Image: "nginx", Deployment: "web",
+			State: Running, DesiredState: Running, CreatedAt: now,
+			Usage: &ContainerUsage{RuntimeStats: RuntimeStats{CPUPercent: cpu}, SampledAt: now}}
+	}
+	return replicas
+}
+
+func TestDesiredReplicas(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name      string
+		cpu       float64
+		lastScale time.Time
+		stale     bool
+		want      int
+	}{
+		{name: "above target", cpu: 90, want: 4},
+		{name: "below target", cpu: 20, want: 1},
+		{name: "within tolerance", cpu: 52, want: 2},
+		{name: "capped at max", cpu: 400, want: 5},
+		{name: "in cooldown", cpu: 90, lastScale: now.Add(-time.Minute), want: 2},
+		{name: "cooldown passed", cpu: 90, lastScale: now.Add(-defaultAutoscaleCooldown), want: 4},
+		{name: "stale samples", cpu: 90, stale: true, want: 2},
+	} {
+		hpa := &HorizontalAutoscaler{Deployment: "web", MinReplicas: 1, MaxReplicas: 5, TargetCPUPercent: 50,
+			Replicas: 2, LastScaleAt: tc.lastScale}
+		replicas := replicasAt(2, tc.cpu, now)
+		if tc.stale {
+			for _, c := range replicas {
+				c.Usage.SampledAt = now.Add(-2 * usageMaxAge)
+			}
+		}
+
+		if got, _ := desiredReplicas(hpa, replicas, now); got != tc.want {
+			t.Errorf("%s: got %d replicas, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestAutoscaleHoldsDuringCooldown(t *testing.T) {
+	r, store, _ := newTestReconciler(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	hpa := &HorizontalAutoscaler{Deployment: "web", MinReplicas: 1, MaxReplicas: 10, TargetCPUPercent: 50,
+		Cooldown: time.Minute, Replicas: 2}
+	if err := store.SaveAutoscaler(ctx, hpa); err != nil {
+		t.Fatal(err)
+	}
+	containers := replicasAt(2, 90, now)
+	for _, c := range containers {
+		saveTestContainer(t, store, c)
+	}
+
+	added := r.autoscale(ctx, containers, now)
+	if len(added) != 2 {
+		t.Fatalf("added %d replicas at 90%% CPU, want 2", len(added))
+	}
+	stored, err := store.GetAutoscaler(ctx, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Replicas != 4 || !stored.LastScaleAt.Equal(now) {
+		t.Errorf("autoscaler wants %d replicas, last scaled %v; want 4 at %v", stored.Replicas, stored.LastScaleAt, now)
+	}
+
+	// the new replicas are busy too, but the cooldown holds the count
+	containers = append(containers, added...)
+	for _, c := range added {
+		c.State = Running
+		c.Usage = &ContainerUsage{RuntimeStats: RuntimeStats{CPUPercent: 90}, SampledAt: now}
+	}
+	later := now.Add(30 * time.Second)
+	if added := r.autoscale(ctx, containers, later); len(added) != 0 {
+		t.Errorf("added %d replicas inside the cooldown, want none", len(added))
+	}
+	if stored, _ := store.GetAutoscaler(ctx, "web"); stored.Replicas != 4 {
+		t.Errorf("autoscaler wants %d replicas inside the cooldown, want 4", stored.Replicas)
+	}
+
+	later = now.Add(time.Minute)
+	if added := r.autoscale(ctx, containers, later); len(added) != 4 {
+		t.Errorf("added %d replicas after the cooldown, want 4", len(added))
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Backup writes a consistent bbolt snapshot of the store to w. The copy is
+// taken inside a read transaction, so writers are never blocked.
+func (s *BoltStore) Backup(ctx context.Context, w io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.View(func(tx *bbolt.Tx) error {
+			if _, err := tx.WriteTo(w); err != nil {
+				return fmt.Errorf("failed to write backup: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+// RestoreBoltStore replaces the database at path with the backup read from
+// r. The backup is written next to path and renamed over it while holding
+// the database lock, so concurrent readers see either the old or the new
+// state, never a partial file.
+func RestoreBoltStore(path string, r io.Reader) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create restore file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to copy backup: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync restore file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := validateBackup(tmp.Name()); err != nil {
+		return err
+	}
+
+	// holding the live database open takes its file lock, so no other
+	// process is mid-transaction when the file is swapped
+	live, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to lock db: %w", err)
+	}
+	defer live.Close()
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace db: %w", err)
+	}
+	return nil
+}
+
+// validateBackup checks that a file is a bbolt database with the buckets a
+// store needs, and migrates it to the current schema, so a backup from an
+// older or newer binary is dealt with before it replaces the live file.
+func validateBackup(path string) error {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("not a valid backup: %w", err)
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{containersBucket, nodesBucket} {
+			if tx.Bucket(name) == nil {
+				return fmt.Errorf("not a valid backup: %s bucket missing", name)
+			}
+		}
+		if err := migrate(tx); err != nil {
+			return fmt.Errorf("failed to migrate backup: %w", err)
+		}
+		return nil
+	})
+}
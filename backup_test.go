@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// rawBackup returns a bbolt file with the store's buckets, and a schema
+// version unless version is negative.
+func rawBackup(t *testing.T, version int) []byte {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{containersBucket, nodesBucket} {
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		if version < 0 {
+			return nil
+		}
+		meta, err := tx.CreateBucket(metaBucket)
+		if err != nil {
+			return err
+		}
+		return meta.Put(schemaVersionKey, []byte(strconv.Itoa(version)))
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func storedSchemaVersion(t *testing.T, path string) string {
+	t.Helper()
+
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var version string
+	db.View(func(tx *bbolt.Tx) error {
+		if meta := tx.Bucket(metaBucket); meta != nil {
+			version = string(meta.Get(schemaVersionKey))
+		}
+		return nil
+	})
+	return version
+}
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), dbFile)
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSave(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running})
+
+	var backup bytes.Buffer
+	if err := store.Backup(ctx, &backup); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DelContainer(ctx, "c1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RestoreBoltStore(path, &backup); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if _, err := store.GetContainer(ctx, "c1"); err != nil {
+		t.Errorf("container not restored: %v", err)
+	}
+}
+
+func TestRestoreMigratesOlderBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), dbFile)
+	if _, err := NewBoltStore(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RestoreBoltStore(path, bytes.NewReader(rawBackup(t, -1))); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if got, want := storedSchemaVersion(t, path), strconv.Itoa(schemaVersion()); got != want {
+		t.Errorf("restored schema version %q, want %q", got, want)
+	}
+}
+
+func TestRestoreRefusesBackupsItCannotUse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), dbFile)
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSave(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running})
+
+	for name, backup := range map[string][]byte{
+		"not bbolt": []byte("hello"),
+		"newer":     rawBackup(t, schemaVersion()+1),
+	} {
+		if err := RestoreBoltStore(path, bytes.NewReader(backup)); err == nil {
+			t.Errorf("%s: restored", name)
+		}
+		if _, err := store.GetContainer(context.Background(), "c1"); err != nil {
+			t.Errorf("%s: live database changed: %v", name, err)
+		}
+	}
+
+	// nothing is left behind next to the database
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".restore-") {
+			t.Errorf("left %s behind", e.Name())
+		}
+	}
+}
+
+func TestBackupRestoresIntoFreshPath(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		mustSave(t, store,
+			&Container{ID: "c1", Image: "nginx", State: Running, DesiredState: Running, Labels: map[string]string{"app": "web"}},
+			&Container{ID: "c2", Name: "db", Image: "postgres", State: Stopped, DesiredState: Stopped, Protected: true},
+			&Node{ID: "w1", Role: Worker, State: NodeReady, Capacity: Resources{CPUCores: 4}},
+			&Secret{Name: "db", Data: map[string]string{"PASSWORD": "hunter2"}},
+			&Service{Name: "web", Selector: map[string]string{"app": "web"}, Port: 80})
+		if err := store.SaveAutoscaler(ctx, &HorizontalAutoscaler{Deployment: "web", MinReplicas: 1, MaxReplicas: 3, TargetCPUPercent: 50}); err != nil {
+			t.Fatal(err)
+		}
+
+		var backup bytes.Buffer
+		if err := store.Backup(ctx, &backup); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), dbFile)
+		if err := RestoreBoltStore(path, &backup); err != nil {
+			t.Fatalf("restore: %v", err)
+		}
+		restored, err := NewBoltStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer restored.Close()
+
+		for name, list := range map[string]func(Store) (any, error){
+			"containers":  func(s Store) (any, error) { return s.ListContainers(ctx) },
+			"nodes":       func(s Store) (any, error) { return s.ListNodes(ctx) },
+			"services":    func(s Store) (any, error) { return s.ListServices(ctx) },
+			"autoscalers": func(s Store) (any, error) { return s.ListAutoscalers(ctx) },
+			"secret":      func(s Store) (any, error) { return s.GetSecret(ctx, "db") },
+		} {
+			want, err := list(store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := list(restored)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("restored %s = %+v, want %+v", name, got, want)
+			}
+		}
+	})
+}
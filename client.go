@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+
+	"github.com/galadd/cogsworth/client"
+)
+
+// APIClient is the control plane client that workers and the CLI use.
+type APIClient = client.Client
+
+type APIError = client.APIError
+
+// NewAPIClient is client.New configured from the environment, acting for
+// nodeID when it is set.
+func NewAPIClient(controlPlaneURL string, nodeID string) *APIClient {
+	c := client.New(controlPlaneURL, os.Getenv(tokenEnv), nil)
+	if nodeID != "" {
+		c = c.ForNode(nodeID)
+	}
+	return c
+}
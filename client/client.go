@@ -0,0 +1,941 @@
+// Package client is a typed client for the Cogsworth control plane API. It
+// is the client the cogs CLI and its workers use.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/galadd/cogsworth/api"
+)
+
+// APIError is a decoded error envelope.
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Details json.RawMessage
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("API error %d: %s", e.Status, e.Message)
+	}
+	return fmt.Sprintf("API error %d: %s: %s", e.Status, e.Code, e.Message)
+}
+
+func (e *APIError) Is(target error) bool {
+	return api.ErrorFor(e.Code) == target
+}
+
+// decodeResponse unwraps an API envelope into v. Error envelopes and
+// non-2xx statuses are returned as errors. v may be nil to discard the data.
+func decodeResponse(resp *http.Response, v any) error {
+	_, err := decodeResponseMeta(resp, v)
+	return err
+}
+
+func decodeResponseMeta(resp *http.Response, v any) (api.Meta, error) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return api.Meta{}, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var errResp api.ErrorResponse
+		if err := json.Unmarshal(body, &errResp); err != nil || errResp.Error.Message == "" {
+			return api.Meta{}, &APIError{Status: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+		}
+		return api.Meta{}, &APIError{
+			Status:  resp.StatusCode,
+			Code:    errResp.Error.Code,
+			Message: errResp.Error.Message,
+			Details: errResp.Error.Details,
+		}
+	}
+
+	return decodeEnvelope(body, v)
+}
+
+// decodeEnvelope unwraps a success envelope into v.
+func decodeEnvelope(body []byte, v any) (api.Meta, error) {
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+		Meta api.Meta        `json:"meta"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return api.Meta{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if envelope.Meta.Version != api.Version {
+		return api.Meta{}, fmt.Errorf("unsupported API version %q", envelope.Meta.Version)
+	}
+
+	if v == nil || len(envelope.Data) == 0 {
+		return envelope.Meta, nil
+	}
+
+	if err := json.Unmarshal(envelope.Data, v); err != nil {
+		return api.Meta{}, fmt.Errorf("failed to decode response data: %w", err)
+	}
+
+	return envelope.Meta, nil
+}
+
+// defaultClientTimeout bounds every request except streams, which run
+// until they are closed.
+const defaultClientTimeout = 5 * time.Second
+
+// Client talks to the control plane API. It is what workers and the CLI
+// use, and what other tools should use instead of hand-rolled requests.
+// Failed calls return an *APIError that matches the api.Err* sentinels
+// with errors.Is.
+type Client struct {
+	controlPlaneURL string
+	apiURL          string
+	nodeID          string
+	client          *http.Client
+
+	// stream has no overall timeout so long-lived watches aren't cut off.
+	stream *http.Client
+}
+
+// New returns a client for the control plane at baseURL. A non-empty token
+// is sent as a bearer token on every request. httpClient may be nil; its
+// Timeout applies to every call except streams such as watches and
+// followed logs.
+func New(baseURL, token string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultClientTimeout}
+	}
+
+	client := httpClient
+	if token != "" {
+		client = withHeader(client, "Authorization", "Bearer "+token)
+	}
+	stream := *client
+	stream.Timeout = 0
+
+	return &Client{
+		controlPlaneURL: baseURL,
+		apiURL:          strings.TrimSuffix(baseURL, "/") + api.Prefix,
+		client:          client,
+		stream:          &stream,
+	}
+}
+
+// ForNode returns a copy of c acting for the worker nodeID: every request
+// is tagged with it and heartbeats are sent for it.
+func (c *Client) ForNode(nodeID string) *Client {
+	node := *c
+	node.nodeID = nodeID
+	node.client = withHeader(c.client, api.NodeIDHeader, nodeID)
+	node.stream = withHeader(c.stream, api.NodeIDHeader, nodeID)
+	return &node
+}
+
+// withHeader returns a copy of client that sets key on requests that don't
+// carry it.
+func withHeader(client *http.Client, key, value string) *http.Client {
+	clone := *client
+	clone.Transport = headerTransport{base: client.Transport, key: key, value: value}
+	return &clone
+}
+
+type headerTransport struct {
+	base       http.RoundTripper
+	key, value string
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get(t.key) == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set(t.key, t.value)
+	}
+	return cmp.Or(t.base, http.DefaultTransport).RoundTrip(req)
+}
+
+// Version returns the control plane's build and API version.
+func (c *Client) Version() (*api.VersionInfo, error) {
+	resp, err := c.client.Get(strings.TrimSuffix(c.controlPlaneURL, "/") + "/version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var info api.VersionInfo
+	if err := decodeResponse(resp, &info); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// CreateContainer submits a single container spec. Its ID doubles as the
+// idempotency key, so resubmitting the same spec doesn't fail.
+func (c *Client) CreateContainer(container *api.Container) error {
+	data, err := json.Marshal(container)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.apiURL+"/containers", bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if container.ID != "" {
+		req.Header.Set(api.IdempotencyKeyHeader, container.ID)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, nil)
+}
+
+func (c *Client) Register(node *api.Node) error {
+	data, _ := json.Marshal(node)
+	resp, err := c.client.Post(
+		c.apiURL+"/nodes/register",
+		"application/json",
+		bytes.NewBuffer(data),
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, nil)
+}
+
+// SendHeartbeat reports the node alive along with what it is running. A
+// non-nil runtimeErr tells the control plane the runtime is unavailable.
+func (c *Client) SendHeartbeat(runtimeErr error, allocated api.Resources) error {
+	hb := api.Heartbeat{
+		NodeID:    c.nodeID,
+		Allocated: allocated,
+		SentAt:    time.Now(),
+	}
+	if runtimeErr != nil {
+		hb.RuntimeError = runtimeErr.Error()
+	}
+
+	data, _ := json.Marshal(hb)
+	resp, err := c.client.Post(
+		c.apiURL+"/nodes/heartbeat",
+		"application/json",
+		bytes.NewBuffer(data),
+	)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, nil)
+}
+
+func (c *Client) GetAssignedContainers(nodeID string) ([]*api.Container, error) {
+	resp, err := c.client.Get(fmt.Sprintf("%s/containers/assigned?node_id=%s", c.apiURL, url.QueryEscape(nodeID)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get containers: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var containers []*api.Container
+	if err := decodeResponse(resp, &containers); err != nil {
+		return nil, err
+	}
+
+	return containers, nil
+}
+
+// ListContainersPage fetches a single page of the containers matching filter.
+func (c *Client) ListContainersPage(cursor string, limit int, filter api.ContainerFilter) ([]*api.Container, string, error) {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(limit))
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	for _, state := range filter.States {
+		query.Add("state", string(state))
+	}
+	if len(filter.Selector) > 0 {
+		query.Set("selector", api.FormatSelector(filter.Selector))
+	}
+
+	resp, err := c.client.Get(c.apiURL + "/containers?" + query.Encode())
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list containers: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var containers []*api.Container
+	meta, err := decodeResponseMeta(resp, &containers)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return containers, meta.NextCursor, nil
+}
+
+// ListContainers pages through every container on the control plane.
+func (c *Client) ListContainers() ([]*api.Container, error) {
+	return c.FilterContainers(api.ContainerFilter{})
+}
+
+// FilterContainers pages through the containers matching filter.
+func (c *Client) FilterContainers(filter api.ContainerFilter) ([]*api.Container, error) {
+	var all []*api.Container
+	cursor := ""
+	for {
+		containers, next, err := c.ListContainersPage(cursor, api.DefaultPageLimit, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, containers...)
+		if next == "" {
+			return all, nil
+		}
+		cursor = next
+	}
+}
+
+// WatchAssignedContainers streams the node's assigned containers, calling fn
+// with the full set on connect and after every change. It blocks until the
+// context is cancelled or the stream ends.
+func (c *Client) WatchAssignedContainers(ctx context.Context, nodeID string, fn func([]*api.Container)) error {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		fmt.Sprintf("%s/containers/watch?node_id=%s", c.apiURL, url.QueryEscape(nodeID)),
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to watch containers: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return decodeResponse(resp, nil)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+
+	var data []byte
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		switch {
+		case len(line) == 0:
+			if len(data) == 0 {
+				continue
+			}
+
+			var containers []*api.Container
+			if _, err := decodeEnvelope(data, &containers); err != nil {
+				return err
+			}
+			data = data[:0]
+			fn(containers)
+
+		case bytes.HasPrefix(line, []byte("data:")):
+			data = append(data, bytes.TrimSpace(line[len("data:"):])...)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("watch stream failed: %w", err)
+	}
+	return fmt.Errorf("watch stream closed by server")
+}
+
+// GetSecret resolves a secret using the cluster token.
+func (c *Client) GetSecret(name string) (*api.Secret, error) {
+	req, err := http.NewRequest(
+		http.MethodGet,
+		fmt.Sprintf("%s/secrets/%s", c.apiURL, url.PathEscape(name)),
+		nil,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var secret api.Secret
+	if err := decodeResponse(resp, &secret); err != nil {
+		return nil, err
+	}
+
+	return &secret, nil
+}
+
+// ListNodes returns every node with its container counts.
+func (c *Client) ListNodes() ([]*api.NodeSummary, error) {
+	resp, err := c.client.Get(c.apiURL + "/nodes")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var nodes []*api.NodeSummary
+	if err := decodeResponse(resp, &nodes); err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+func (c *Client) GetStatus() (*api.ClusterStatus, error) {
+	resp, err := c.client.Get(c.apiURL + "/status")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var status api.ClusterStatus
+	if err := decodeResponse(resp, &status); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// DeleteNode removes a node. Without force the control plane refuses while
+// the node still runs containers.
+func (c *Client) DeleteNode(nodeID string, force bool) error {
+	target := fmt.Sprintf("%s/nodes/%s", c.apiURL, url.PathEscape(nodeID))
+	if force {
+		target += "?force=true"
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, target, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete node: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, nil)
+}
+
+// ListNodeContainers returns the containers assigned to a node.
+func (c *Client) ListNodeContainers(nodeID string) ([]*api.Container, error) {
+	resp, err := c.client.Get(fmt.Sprintf("%s/nodes/%s/containers", c.apiURL, url.PathEscape(nodeID)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list node containers: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var containers []*api.Container
+	if err := decodeResponse(resp, &containers); err != nil {
+		return nil, err
+	}
+
+	return containers, nil
+}
+
+// SetNodeSchedulable cordons (false) or uncordons (true) a node.
+func (c *Client) SetNodeSchedulable(nodeID string, schedulable bool) (*api.Node, error) {
+	action := "cordon"
+	if schedulable {
+		action = "uncordon"
+	}
+
+	resp, err := c.client.Post(
+		fmt.Sprintf("%s/nodes/%s/%s", c.apiURL, url.PathEscape(nodeID), action),
+		"application/json",
+		nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s node: %w", action, err)
+	}
+	defer resp.Body.Close()
+
+	var node api.Node
+	if err := decodeResponse(resp, &node); err != nil {
+		return nil, err
+	}
+
+	return &node, nil
+}
+
+// SetNodeMaintenance schedules a maintenance window for a node, replacing
+// any it had. A nil window cancels it.
+func (c *Client) SetNodeMaintenance(nodeID string, window *api.MaintenanceWindow) (*api.Node, error) {
+	method, body := http.MethodDelete, []byte(nil)
+	if window != nil {
+		data, err := json.Marshal(window)
+		if err != nil {
+			return nil, err
+		}
+		method, body = http.MethodPost, data
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/nodes/%s/maintenance", c.apiURL, url.PathEscape(nodeID)), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set node maintenance: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var node api.Node
+	if err := decodeResponse(resp, &node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+func (c *Client) GetContainer(id string) (*api.Container, error) {
+	resp, err := c.client.Get(fmt.Sprintf("%s/containers/%s", c.apiURL, url.PathEscape(id)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get container: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var container api.Container
+	if err := decodeResponse(resp, &container); err != nil {
+		return nil, err
+	}
+
+	return &container, nil
+}
+
+// TriggerReconcile asks the control plane, or the worker nodeID when set, to
+// reconcile now instead of waiting for the next tick.
+func (c *Client) TriggerReconcile(nodeID string) error {
+	target := c.apiURL + "/reconcile"
+	if nodeID != "" {
+		target += "?node_id=" + url.QueryEscape(nodeID)
+	}
+
+	resp, err := c.client.Post(target, "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("failed to trigger reconcile: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, nil)
+}
+
+// DeleteContainers marks all containers (or those matching selector)
+// Destroyed. With dryRun the matching set is returned without changes.
+func (c *Client) DeleteContainers(all bool, selector map[string]string, forceProtected, dryRun bool) (*api.BulkDeleteResult, error) {
+	query := url.Values{}
+	if all {
+		query.Set("all", "true")
+	}
+	if len(selector) > 0 {
+		query.Set("selector", api.FormatSelector(selector))
+	}
+	if forceProtected {
+		query.Set("force_protected", "true")
+	}
+	if dryRun {
+		query.Set("dry_run", "true")
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, c.apiURL+"/containers?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete containers: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result api.BulkDeleteResult
+	if err := decodeResponse(resp, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// StreamLogs opens a container's log output. With follow the stream stays
+// open until ctx is cancelled.
+func (c *Client) StreamLogs(ctx context.Context, id string, tail int, follow bool) (io.ReadCloser, error) {
+	query := url.Values{}
+	query.Set("tail", strconv.Itoa(tail))
+	if follow {
+		query.Set("follow", "true")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		fmt.Sprintf("%s/containers/%s/logs?%s", c.apiURL, url.PathEscape(id), query.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get logs: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, decodeResponse(resp, nil)
+	}
+
+	return resp.Body, nil
+}
+
+// CreateContainers submits a batch that is saved all-or-nothing. When the
+// batch is rejected the per-item results are returned along with the error.
+func (c *Client) CreateContainers(containers []*api.Container) ([]api.BatchItemResult, error) {
+	data, err := json.Marshal(containers)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Post(c.apiURL+"/containers/batch", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit containers: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var results []api.BatchItemResult
+	err = decodeResponse(resp, &results)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && len(apiErr.Details) > 0 {
+		json.Unmarshal(apiErr.Details, &results)
+	}
+
+	return results, err
+}
+
+// Exec runs cmd in a container and returns its output as it is produced.
+func (c *Client) Exec(ctx context.Context, id string, cmd []string) (io.ReadCloser, error) {
+	body, err := json.Marshal(api.ExecRequest{Cmd: cmd})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		fmt.Sprintf("%s/containers/%s/exec", c.apiURL, url.PathEscape(id)), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.stream.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exec: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, decodeResponse(resp, nil)
+	}
+
+	return resp.Body, nil
+}
+
+// GetContainerStats fetches live resource usage from the container's worker.
+func (c *Client) GetContainerStats(id string) (*api.RuntimeStats, error) {
+	resp, err := c.client.Get(fmt.Sprintf("%s/containers/%s/stats", c.apiURL, url.PathEscape(id)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var stats api.RuntimeStats
+	if err := decodeResponse(resp, &stats); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// UpdateContainer applies a patch to a container's spec.
+func (c *Client) UpdateContainer(id string, patch *api.ContainerPatch) (*api.Container, error) {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(
+		http.MethodPatch,
+		fmt.Sprintf("%s/containers/%s", c.apiURL, url.PathEscape(id)),
+		bytes.NewBuffer(data),
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update container: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var container api.Container
+	if err := decodeResponse(resp, &container); err != nil {
+		return nil, err
+	}
+
+	return &container, nil
+}
+
+func (c *Client) UpdateContainerStatus(container *api.Container) error {
+	data, err := json.Marshal(container)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Post(
+		c.apiURL+"/containers/status",
+		"application/json",
+		bytes.NewBuffer(data),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, nil)
+}
+
+// UpdateContainerStatuses reports several statuses in one request. They are
+// saved all together or not at all.
+func (c *Client) UpdateContainerStatuses(containers []*api.Container) error {
+	data, err := json.Marshal(containers)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Post(
+		c.apiURL+"/containers/status/batch",
+		"application/json",
+		bytes.NewBuffer(data),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update statuses: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, nil)
+}
+
+// DeleteContainer deletes a container. One scheduled on a node is marked
+// Destroyed, and its record dropped once that node has removed it. A
+// protected container is only deleted with forceProtected.
+func (c *Client) DeleteContainer(containerID string, forceProtected bool) error {
+	query := url.Values{}
+	if forceProtected {
+		query.Set("force_protected", "true")
+	}
+
+	req, err := http.NewRequest(
+		http.MethodDelete,
+		fmt.Sprintf("%s/containers/%s?%s", c.apiURL, url.PathEscape(containerID), query.Encode()),
+		nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to delete container: %v", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, nil)
+}
+
+// SaveService creates or replaces a service and returns it with its
+// current endpoints.
+func (c *Client) SaveService(svc *api.Service) (*api.Service, error) {
+	data, err := json.Marshal(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Post(c.apiURL+"/services", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var saved api.Service
+	if err := decodeResponse(resp, &saved); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// GetService resolves a service to its endpoints.
+func (c *Client) GetService(name string) (*api.Service, error) {
+	resp, err := c.client.Get(fmt.Sprintf("%s/services/%s", c.apiURL, url.PathEscape(name)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var svc api.Service
+	if err := decodeResponse(resp, &svc); err != nil {
+		return nil, err
+	}
+	return &svc, nil
+}
+
+func (c *Client) ListServices() ([]*api.Service, error) {
+	resp, err := c.client.Get(c.apiURL + "/services")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var services []*api.Service
+	if err := decodeResponse(resp, &services); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+func (c *Client) DeleteService(name string) error {
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/services/%s", c.apiURL, url.PathEscape(name)), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, nil)
+}
+
+// SaveAutoscaler creates or replaces the autoscaler for a deployment.
+func (c *Client) SaveAutoscaler(hpa *api.HorizontalAutoscaler) (*api.HorizontalAutoscaler, error) {
+	data, err := json.Marshal(hpa)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Post(c.apiURL+"/autoscalers", "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save autoscaler: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var saved api.HorizontalAutoscaler
+	if err := decodeResponse(resp, &saved); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+func (c *Client) GetAutoscaler(deployment string) (*api.HorizontalAutoscaler, error) {
+	resp, err := c.client.Get(fmt.Sprintf("%s/autoscalers/%s", c.apiURL, url.PathEscape(deployment)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get autoscaler: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var hpa api.HorizontalAutoscaler
+	if err := decodeResponse(resp, &hpa); err != nil {
+		return nil, err
+	}
+	return &hpa, nil
+}
+
+func (c *Client) ListAutoscalers() ([]*api.HorizontalAutoscaler, error) {
+	resp, err := c.client.Get(c.apiURL + "/autoscalers")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list autoscalers: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var autoscalers []*api.HorizontalAutoscaler
+	if err := decodeResponse(resp, &autoscalers); err != nil {
+		return nil, err
+	}
+	return autoscalers, nil
+}
+
+func (c *Client) DeleteAutoscaler(deployment string) error {
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/autoscalers/%s", c.apiURL, url.PathEscape(deployment)), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete autoscaler: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeResponse(resp, nil)
+}
+
+// Scale sets how many replicas a deployment runs and returns the count it
+// reached. A deployment with an autoscaler is scaled through it instead.
+func (c *Client) Scale(deployment string, replicas int) (*api.Scale, error) {
+	data, err := json.Marshal(api.Scale{Deployment: deployment, Replicas: replicas})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Post(
+		fmt.Sprintf("%s/deployments/%s/scale", c.apiURL, url.PathEscape(deployment)),
+		"application/json",
+		bytes.NewReader(data),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scale deployment: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var scale api.Scale
+	if err := decodeResponse(resp, &scale); err != nil {
+		return nil, err
+	}
+
+	return &scale, nil
+}
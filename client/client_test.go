@@ -0,0 +1,543 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/galadd/cogsworth/api"
+)
+
+// received is what the fake control plane saw of a request.
+type received struct {
+	method, path, query string
+	header              http.Header
+	body                []byte
+}
+
+// serve starts a control plane that answers every request with data in a
+// success envelope, and returns a client for it and the last request seen.
+func serve(t *testing.T, data any) (*Client, *received) {
+	t.Helper()
+
+	got := &received{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		*got = received{method: r.Method, path: r.URL.Path, query: r.URL.RawQuery, header: r.Header, body: body}
+		json.NewEncoder(w).Encode(api.Response{Data: data, Meta: api.Meta{Version: api.Version}})
+	}))
+	t.Cleanup(srv.Close)
+	return New(srv.URL, "", nil), got
+}
+
+// serveError starts a control plane that answers every request with an
+// error envelope.
+func serveError(t *testing.T, status int, code string, details any) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := api.ErrorBody{Code: code, Message: "it went wrong"}
+		if details != nil {
+			body.Details, _ = json.Marshal(details)
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(api.ErrorResponse{Error: body, Meta: api.Meta{Version: api.Version}})
+	}))
+	t.Cleanup(srv.Close)
+	return New(srv.URL, "", nil)
+}
+
+func (r *received) expect(t *testing.T, method, path string) {
+	t.Helper()
+	if r.method != method || r.path != path {
+		t.Errorf("sent %s %s, want %s %s", r.method, r.path, method, path)
+	}
+}
+
+func TestContainerMethods(t *testing.T) {
+	container := &api.Container{ID: "c1", Image: "nginx", State: api.Running, DesiredState: api.Running}
+
+	t.Run("CreateContainer", func(t *testing.T) {
+		c, got := serve(t, map[string]string{"id": "c1"})
+		if err := c.CreateContainer(container); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodPost, "/v1/containers")
+		if key := got.header.Get(api.IdempotencyKeyHeader); key != "c1" {
+			t.Errorf("idempotency key %q, want the container ID", key)
+		}
+	})
+
+	t.Run("CreateContainers", func(t *testing.T) {
+		c, got := serve(t, []api.BatchItemResult{{Index: 0, ID: "c1"}})
+		results, err := c.CreateContainers([]*api.Container{container})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodPost, "/v1/containers/batch")
+		if len(results) != 1 || results[0].ID != "c1" {
+			t.Errorf("got %+v, want the result for c1", results)
+		}
+	})
+
+	t.Run("GetContainer", func(t *testing.T) {
+		c, got := serve(t, container)
+		fetched, err := c.GetContainer("c1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodGet, "/v1/containers/c1")
+		if fetched.ID != "c1" || fetched.Image != "nginx" {
+			t.Errorf("got %+v, want c1", fetched)
+		}
+	})
+
+	t.Run("GetAssignedContainers", func(t *testing.T) {
+		c, got := serve(t, []*api.Container{container})
+		assigned, err := c.GetAssignedContainers("w1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodGet, "/v1/containers/assigned")
+		if got.query != "node_id=w1" || len(assigned) != 1 {
+			t.Errorf("query %q returned %d containers, want node_id=w1 and 1", got.query, len(assigned))
+		}
+	})
+
+	t.Run("ListContainersPage", func(t *testing.T) {
+		c, got := serve(t, []*api.Container{container})
+		filter := api.ContainerFilter{States: []api.ContainerState{api.Failed}, Selector: map[string]string{"app": "web"}}
+		if _, _, err := c.ListContainersPage("c0", 10, filter); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodGet, "/v1/containers")
+		if want := "cursor=c0&limit=10&selector=app%3Dweb&state=failed"; got.query != want {
+			t.Errorf("query %q, want %q", got.query, want)
+		}
+	})
+
+	t.Run("UpdateContainer", func(t *testing.T) {
+		c, got := serve(t, container)
+		image := "nginx:2"
+		if _, err := c.UpdateContainer("c1", &api.ContainerPatch{Image: &image}); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodPatch, "/v1/containers/c1")
+		if !strings.Contains(string(got.body), `"image":"nginx:2"`) {
+			t.Errorf("sent %s, want the patched image", got.body)
+		}
+	})
+
+	t.Run("UpdateContainerStatus", func(t *testing.T) {
+		c, got := serve(t, nil)
+		if err := c.UpdateContainerStatus(container); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodPost, "/v1/containers/status")
+	})
+
+	t.Run("UpdateContainerStatuses", func(t *testing.T) {
+		c, got := serve(t, nil)
+		if err := c.UpdateContainerStatuses([]*api.Container{container}); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodPost, "/v1/containers/status/batch")
+	})
+
+	t.Run("DeleteContainer", func(t *testing.T) {
+		c, got := serve(t, nil)
+		if err := c.DeleteContainer("c1", false); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodDelete, "/v1/containers/c1")
+	})
+
+	t.Run("DeleteContainers", func(t *testing.T) {
+		c, got := serve(t, api.BulkDeleteResult{Destroyed: []string{"c1"}})
+		result, err := c.DeleteContainers(false, map[string]string{"app": "web"}, true, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodDelete, "/v1/containers")
+		if want := "dry_run=true&force_protected=true&selector=app%3Dweb"; got.query != want {
+			t.Errorf("query %q, want %q", got.query, want)
+		}
+		if !slices.Equal(result.Destroyed, []string{"c1"}) {
+			t.Errorf("got %+v, want c1 destroyed", result)
+		}
+	})
+
+	t.Run("GetContainerStats", func(t *testing.T) {
+		c, got := serve(t, api.RuntimeStats{CPUPercent: 12.5})
+		stats, err := c.GetContainerStats("c1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodGet, "/v1/containers/c1/stats")
+		if stats.CPUPercent != 12.5 {
+			t.Errorf("got %+v, want 12.5%% CPU", stats)
+		}
+	})
+
+	t.Run("TriggerReconcile", func(t *testing.T) {
+		c, got := serve(t, nil)
+		if err := c.TriggerReconcile("w1"); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodPost, "/v1/reconcile")
+		if got.query != "node_id=w1" {
+			t.Errorf("query %q, want node_id=w1", got.query)
+		}
+	})
+}
+
+func TestStreamingMethods(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/containers/c1/logs":
+			if r.URL.RawQuery != "follow=true&tail=5" {
+				t.Errorf("logs query %q, want follow=true&tail=5", r.URL.RawQuery)
+			}
+			fmt.Fprint(w, "log line\n")
+		case "/v1/containers/c1/exec":
+			var req api.ExecRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			fmt.Fprint(w, strings.Join(req.Cmd, " "))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	c := New(srv.URL, "", nil)
+	ctx := context.Background()
+
+	for want, open := range map[string]func() (io.ReadCloser, error){
+		"log line\n": func() (io.ReadCloser, error) { return c.StreamLogs(ctx, "c1", 5, true) },
+		"echo hi":    func() (io.ReadCloser, error) { return c.Exec(ctx, "c1", []string{"echo", "hi"}) },
+	} {
+		stream, err := open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := io.ReadAll(stream)
+		stream.Close()
+		if string(out) != want {
+			t.Errorf("got %q, want %q", out, want)
+		}
+	}
+}
+
+func TestWatchAssignedContainers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, ids := range [][]string{{"c1"}, {"c1", "c2"}} {
+			var containers []*api.Container
+			for _, id := range ids {
+				containers = append(containers, &api.Container{ID: id})
+			}
+			data, _ := json.Marshal(api.Response{Data: containers, Meta: api.Meta{Version: api.Version}})
+			fmt.Fprintf(w, ": keepalive\n\nevent: containers\ndata: %s\n\n", data)
+		}
+	}))
+	defer srv.Close()
+
+	var seen []int
+	err := New(srv.URL, "", nil).WatchAssignedContainers(context.Background(), "w1", func(containers []*api.Container) {
+		seen = append(seen, len(containers))
+	})
+	if err == nil {
+		t.Errorf("a stream that ended returned no error")
+	}
+	if !slices.Equal(seen, []int{1, 2}) {
+		t.Errorf("saw sets of %v containers, want [1 2]", seen)
+	}
+}
+
+func TestFilterContainersPagesThrough(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cursor := r.URL.Query().Get("cursor")
+		next := map[string]string{"": "c2", "c2": ""}[cursor]
+		json.NewEncoder(w).Encode(api.Response{
+			Data: []*api.Container{{ID: "from-" + cursor}},
+			Meta: api.Meta{Version: api.Version, NextCursor: next},
+		})
+	}))
+	defer srv.Close()
+
+	containers, err := New(srv.URL, "", nil).ListContainers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, c := range containers {
+		ids = append(ids, c.ID)
+	}
+	if !slices.Equal(ids, []string{"from-", "from-c2"}) {
+		t.Errorf("got %v, want both pages", ids)
+	}
+}
+
+func TestNodeMethods(t *testing.T) {
+	node := &api.Node{ID: "w1", Role: api.Worker, State: api.NodeReady}
+
+	t.Run("Register", func(t *testing.T) {
+		c, got := serve(t, node)
+		if err := c.Register(node); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodPost, "/v1/nodes/register")
+	})
+
+	t.Run("SendHeartbeat", func(t *testing.T) {
+		c, got := serve(t, nil)
+		if err := c.ForNode("w1").SendHeartbeat(errors.New("docker down"), api.Resources{CPUCores: 2}); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodPost, "/v1/nodes/heartbeat")
+		var hb api.Heartbeat
+		if err := json.Unmarshal(got.body, &hb); err != nil {
+			t.Fatal(err)
+		}
+		if hb.NodeID != "w1" || hb.RuntimeError != "docker down" || hb.Allocated.CPUCores != 2 {
+			t.Errorf("sent %+v, want w1's heartbeat with its runtime error", hb)
+		}
+	})
+
+	t.Run("ListNodes", func(t *testing.T) {
+		c, got := serve(t, []*api.NodeSummary{{Node: node, Containers: 3}})
+		nodes, err := c.ListNodes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodGet, "/v1/nodes")
+		if len(nodes) != 1 || nodes[0].ID != "w1" || nodes[0].Containers != 3 {
+			t.Errorf("got %+v, want w1 with 3 containers", nodes)
+		}
+	})
+
+	t.Run("GetStatus", func(t *testing.T) {
+		c, got := serve(t, api.ClusterStatus{Nodes: 2})
+		status, err := c.GetStatus()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodGet, "/v1/status")
+		if status.Nodes != 2 {
+			t.Errorf("got %+v, want 2 nodes", status)
+		}
+	})
+
+	t.Run("DeleteNode", func(t *testing.T) {
+		c, got := serve(t, nil)
+		if err := c.DeleteNode("w1", true); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodDelete, "/v1/nodes/w1")
+		if got.query != "force=true" {
+			t.Errorf("query %q, want force=true", got.query)
+		}
+	})
+
+	t.Run("ListNodeContainers", func(t *testing.T) {
+		c, got := serve(t, []*api.Container{{ID: "c1"}})
+		if _, err := c.ListNodeContainers("w1"); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodGet, "/v1/nodes/w1/containers")
+	})
+
+	t.Run("SetNodeSchedulable", func(t *testing.T) {
+		c, got := serve(t, node)
+		if _, err := c.SetNodeSchedulable("w1", false); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodPost, "/v1/nodes/w1/cordon")
+		if _, err := c.SetNodeSchedulable("w1", true); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodPost, "/v1/nodes/w1/uncordon")
+	})
+
+	t.Run("SetNodeMaintenance", func(t *testing.T) {
+		c, got := serve(t, node)
+		window := &api.MaintenanceWindow{Start: time.Now(), Duration: time.Hour}
+		if _, err := c.SetNodeMaintenance("w1", window); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodPost, "/v1/nodes/w1/maintenance")
+		if _, err := c.SetNodeMaintenance("w1", nil); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodDelete, "/v1/nodes/w1/maintenance")
+	})
+}
+
+func TestResourceMethods(t *testing.T) {
+	t.Run("GetSecret", func(t *testing.T) {
+		c, got := serve(t, api.Secret{Name: "db", Data: map[string]string{"PASSWORD": "x"}})
+		secret, err := c.GetSecret("db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodGet, "/v1/secrets/db")
+		if secret.Data["PASSWORD"] != "x" {
+			t.Errorf("got %+v, want the secret's data", secret)
+		}
+	})
+
+	svc := &api.Service{Name: "web", Selector: map[string]string{"app": "web"}}
+	t.Run("SaveService", func(t *testing.T) {
+		c, got := serve(t, svc)
+		if _, err := c.SaveService(svc); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodPost, "/v1/services")
+	})
+	t.Run("GetService", func(t *testing.T) {
+		c, got := serve(t, svc)
+		if _, err := c.GetService("web"); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodGet, "/v1/services/web")
+	})
+	t.Run("ListServices", func(t *testing.T) {
+		c, got := serve(t, []*api.Service{svc})
+		if services, err := c.ListServices(); err != nil || len(services) != 1 {
+			t.Fatalf("got %v, %v, want one service", services, err)
+		}
+		got.expect(t, http.MethodGet, "/v1/services")
+	})
+	t.Run("DeleteService", func(t *testing.T) {
+		c, got := serve(t, nil)
+		if err := c.DeleteService("web"); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodDelete, "/v1/services/web")
+	})
+
+	hpa := &api.HorizontalAutoscaler{Deployment: "web", MinReplicas: 1, MaxReplicas: 3, TargetCPUPercent: 50}
+	t.Run("SaveAutoscaler", func(t *testing.T) {
+		c, got := serve(t, hpa)
+		if _, err := c.SaveAutoscaler(hpa); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodPost, "/v1/autoscalers")
+	})
+	t.Run("GetAutoscaler", func(t *testing.T) {
+		c, got := serve(t, hpa)
+		if _, err := c.GetAutoscaler("web"); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodGet, "/v1/autoscalers/web")
+	})
+	t.Run("ListAutoscalers", func(t *testing.T) {
+		c, got := serve(t, []*api.HorizontalAutoscaler{hpa})
+		if autoscalers, err := c.ListAutoscalers(); err != nil || len(autoscalers) != 1 {
+			t.Fatalf("got %v, %v, want one autoscaler", autoscalers, err)
+		}
+		got.expect(t, http.MethodGet, "/v1/autoscalers")
+	})
+	t.Run("DeleteAutoscaler", func(t *testing.T) {
+		c, got := serve(t, nil)
+		if err := c.DeleteAutoscaler("web"); err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodDelete, "/v1/autoscalers/web")
+	})
+
+	t.Run("Scale", func(t *testing.T) {
+		c, got := serve(t, api.Scale{Deployment: "web", Replicas: 4})
+		scale, err := c.Scale("web", 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodPost, "/v1/deployments/web/scale")
+		var sent api.Scale
+		json.Unmarshal(got.body, &sent)
+		if sent.Replicas != 5 || scale.Replicas != 4 {
+			t.Errorf("sent %d and got %d replicas, want 5 asked and 4 reached", sent.Replicas, scale.Replicas)
+		}
+	})
+
+	t.Run("Version", func(t *testing.T) {
+		c, got := serve(t, api.VersionInfo{Version: "v1.2.0", APIVersion: api.Version})
+		info, err := c.Version()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.expect(t, http.MethodGet, "/version")
+		if info.Version != "v1.2.0" {
+			t.Errorf("got %+v, want v1.2.0", info)
+		}
+	})
+}
+
+func TestErrorsMatchSentinels(t *testing.T) {
+	for code, want := range map[string]error{
+		api.CodeNotFound:  api.ErrNotFound,
+		api.CodeConflict:  api.ErrConflict,
+		api.CodeGone:      api.ErrNodeRemoved,
+		api.CodeKeyReused: api.ErrKeyReused,
+	} {
+		_, err := serveError(t, http.StatusBadRequest, code, nil).GetContainer("c1")
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.Code != code || !errors.Is(err, want) {
+			t.Errorf("%s: got %v, want an *APIError matching %v", code, err, want)
+		}
+	}
+}
+
+func TestCreateContainersReturnsRejectedResults(t *testing.T) {
+	rejected := []api.BatchItemResult{{Index: 0, ID: "c1", Error: "image is required"}}
+	c := serveError(t, http.StatusBadRequest, api.CodeBadRequest, rejected)
+
+	results, err := c.CreateContainers([]*api.Container{{ID: "c1"}})
+	if !errors.Is(err, api.ErrBadRequest) {
+		t.Errorf("got %v, want ErrBadRequest", err)
+	}
+	if len(results) != 1 || results[0].Error != "image is required" {
+		t.Errorf("got %+v, want the per-item results", results)
+	}
+}
+
+func TestRejectsUnknownEnvelopeVersion(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(api.Response{Data: nil, Meta: api.Meta{Version: "v2"}})
+	}))
+	defer srv.Close()
+
+	if _, err := New(srv.URL, "", nil).GetStatus(); err == nil || !strings.Contains(err.Error(), "v2") {
+		t.Errorf("got %v, want the unsupported version rejected", err)
+	}
+}
+
+func TestSendsTokenAndNodeID(t *testing.T) {
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		json.NewEncoder(w).Encode(api.Response{Meta: api.Meta{Version: api.Version}})
+	}))
+	defer srv.Close()
+
+	plain := New(srv.URL, "secret", nil)
+	if err := plain.TriggerReconcile(""); err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("Authorization") != "Bearer secret" || header.Get(api.NodeIDHeader) != "" {
+		t.Errorf("sent %v, want the token and no node ID", header)
+	}
+
+	if err := plain.ForNode("w1").TriggerReconcile(""); err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("Authorization") != "Bearer secret" || header.Get(api.NodeIDHeader) != "w1" {
+		t.Errorf("sent %v, want the token and node ID w1", header)
+	}
+}
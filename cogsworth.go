@@ -4,10 +4,32 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"time"
 )
 
+const (
+	storeBolt   = "bolt"
+	storeMemory = "memory"
+)
+
+// OpenStore opens the named store backend. path is ignored by the memory
+// backend, which keeps its state in maps and loses it when the process
+// exits.
+func OpenStore(backend, path string) (Store, error) {
+	switch backend {
+	case storeBolt, "":
+		return NewBoltStore(path)
+	case storeMemory:
+		return NewMemStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown store backend %q, expected %s or %s", backend, storeBolt, storeMemory)
+	}
+}
+
 type Cogsworth struct {
 	store      Store
 	runtime    Runtime
@@ -19,26 +41,57 @@ type Cogsworth struct {
 	role      NodeRole
 	apiServer *APIServer
 	apiClient *APIClient
+
+	// cache holds a worker's last-known assignments so it can keep them
+	// running while the control plane is unreachable. nil disables it.
+	cache Store
 }
 
-func NewControlPlane(storePath, apiAddr string) (*Cogsworth, error) {
-	store, err := NewBoltStore(storePath)
-	if err != nil {
-		return nil, err
-	}
+// NewControlPlane builds a control plane on top of any Store backend.
+func NewControlPlane(backend Store, apiAddr string) (*Cogsworth, error) {
+	store := newNotifyingStore(backend)
 
 	cogs := &Cogsworth{
 		store:     store,
 		scheduler: NewScheduler(store),
 		nodeID:    "control-plane-1",
 		role:      ControlPlane,
-		apiServer: NewAPIServer(store, apiAddr),
+		apiServer: NewAPIServer(store, store.feed, apiAddr),
+	}
+
+	if err := cogs.registerSelf(context.Background()); err != nil {
+		return nil, err
 	}
 
 	cogs.reconciler = NewReconciler(cogs, 5*time.Second)
+	cogs.apiServer.trigger = cogs.reconciler.Trigger
 	return cogs, nil
 }
 
+// registerSelf saves or refreshes the control plane's own node record so the
+// cluster view includes it. It is never a scheduling target.
+func (c *Cogsworth) registerSelf(ctx context.Context) error {
+	now := time.Now()
+
+	node, err := c.store.GetNode(ctx, c.nodeID)
+	if err != nil {
+		node = &Node{
+			ID:        c.nodeID,
+			Role:      ControlPlane,
+			CreatedAt: now,
+		}
+	}
+
+	node.Address = getLocalIP()
+	node.State = NodeReady
+	node.LastSeen = now
+
+	if err := c.store.SaveNode(ctx, node); err != nil {
+		return fmt.Errorf("failed to register control plane node: %w", err)
+	}
+	return nil
+}
+
 func NewWorkerNode(nodeID, controlPlaneURL string) (*Cogsworth, error) {
 	runtime, err := NewDockerRuntime()
 	if err != nil {
@@ -56,6 +109,51 @@ func NewWorkerNode(nodeID, controlPlaneURL string) (*Cogsworth, error) {
 	return cogs, nil
 }
 
+// deregisterTimeout bounds how long a shutting-down worker spends stopping
+// its containers before removing its node record.
+const deregisterTimeout = 30 * time.Second
+
+// Deregister stops and removes the containers this worker runs, reports
+// them stopped and removes its node, so the control plane reschedules them
+// at once rather than after the heartbeat timeout. They are removed here
+// because nothing cleans up after a node once it is gone. A container that
+// fails to stop keeps the node registered.
+func (c *Cogsworth) Deregister(ctx context.Context) error {
+	containers, err := c.apiClient.GetAssignedContainers(c.nodeID)
+	if err != nil {
+		return fmt.Errorf("failed to list assigned containers: %w", err)
+	}
+
+	var stopped []*Container
+	for _, container := range containers {
+		if container.ContainerID == "" {
+			continue
+		}
+		if err := c.runtime.Stop(ctx, container.ContainerID, container.StopTimeoutSeconds()); err != nil {
+			log.Printf("Failed to stop %s before deregistering: %v", container.ID, err)
+			continue
+		}
+		if err := c.runtime.Remove(ctx, container.ContainerID, true); err != nil {
+			log.Printf("Failed to remove %s before deregistering: %v", container.ID, err)
+			continue
+		}
+
+		container.State = Stopped
+		container.ContainerID = ""
+		container.IPAddress = ""
+		container.Ready = false
+		container.UpdatedAt = time.Now()
+		stopped = append(stopped, container)
+	}
+
+	if len(stopped) > 0 {
+		if err := c.apiClient.UpdateContainerStatuses(stopped); err != nil {
+			return fmt.Errorf("failed to report stopped containers: %w", err)
+		}
+	}
+	return c.apiClient.DeleteNode(c.nodeID, false)
+}
+
 func NewCogsworth(store Store, runtime Runtime) *Cogsworth {
 	c := &Cogsworth{
 		store:   store,
@@ -68,8 +166,13 @@ func NewCogsworth(store Store, runtime Runtime) *Cogsworth {
 }
 
 func (c *Cogsworth) CreateContainer(ctx context.Context, image string, ports []PortMapping) (*Container, error) {
+	id, err := GenerateID()
+	if err != nil {
+		return nil, err
+	}
+
 	container := &Container{
-		ID:           generateID(),
+		ID:           id,
 		Image:        image,
 		State:        Requested,
 		DesiredState: Running,
@@ -80,7 +183,7 @@ func (c *Cogsworth) CreateContainer(ctx context.Context, image string, ports []P
 		RestartCount: 0,
 	}
 
-	err := c.store.SaveContainer(ctx, container)
+	err = c.store.SaveContainer(ctx, container)
 	if err != nil {
 		return nil, err
 	}
@@ -88,7 +191,14 @@ func (c *Cogsworth) CreateContainer(ctx context.Context, image string, ports []P
 	container.State = Pulling
 	c.store.SaveContainer(ctx, container)
 
-	err = c.runtime.Pull(ctx, image)
+	err = c.reconciler.ensureImage(ctx, container)
+	if err != nil {
+		container.State = Failed
+		c.store.SaveContainer(ctx, container)
+		return nil, err
+	}
+
+	env, err := c.reconciler.containerEnv(ctx, container)
 	if err != nil {
 		container.State = Failed
 		c.store.SaveContainer(ctx, container)
@@ -96,10 +206,20 @@ func (c *Cogsworth) CreateContainer(ctx context.Context, image string, ports []P
 	}
 
 	spec := &ContainerSpec{
-		Image: image,
-		Ports: ports,
-		Env:   container.Env,
-		Name:  container.ID,
+		Image:      image,
+		Ports:      ports,
+		Env:        env,
+		Name:       containerName(c.reconciler.nameTemplate, container),
+		LegacyName: container.ID,
+
+		Command: container.Command,
+		Args:    container.Args,
+
+		StopSignal:  container.StopSignal,
+		StopTimeout: container.StopTimeoutSeconds(),
+
+		Resources: container.Resources,
+		SpecHash:  specHash(container),
 	}
 
 	dockerId, err := c.runtime.Create(ctx, spec)
@@ -111,6 +231,8 @@ func (c *Cogsworth) CreateContainer(ctx context.Context, image string, ports []P
 
 	container.ContainerID = dockerId
 	container.State = Created
+	container.SpecHash = specHash(container)
+	c.reconciler.applyImageLabels(ctx, container)
 	container.UpdatedAt = time.Now()
 	c.store.SaveContainer(ctx, container)
 
@@ -194,7 +316,7 @@ func (c *Cogsworth) StopContainer(ctx context.Context, id string) error {
 	container.State = Stopping
 	c.store.SaveContainer(ctx, container)
 
-	err = c.runtime.Stop(ctx, container.ContainerID, 10)
+	err = c.runtime.Stop(ctx, container.ContainerID, container.StopTimeoutSeconds())
 	if err != nil {
 		container.State = Failed
 		c.store.SaveContainer(ctx, container)
@@ -203,21 +325,27 @@ func (c *Cogsworth) StopContainer(ctx context.Context, id string) error {
 
 	container.State = Stopped
 	container.DesiredState = Stopped
+	container.IPAddress = ""
 	container.UpdatedAt = time.Now()
 	c.store.SaveContainer(ctx, container)
 
 	return nil
 }
 
+// DeleteContainer removes a container and its runtime container. Deleting
+// one that is already gone is not an error.
 func (c *Cogsworth) DeleteContainer(ctx context.Context, id string) error {
 	container, err := c.store.GetContainer(ctx, id)
-	if err != nil {
+	if errors.Is(err, ErrNotFound) {
 		return nil
 	}
+	if err != nil {
+		return err
+	}
 
 	if container.ContainerID != "" {
-		err = c.runtime.Remove(ctx, container.ContainerID)
-		if err != nil {
+		err = c.runtime.Remove(ctx, container.ContainerID, true)
+		if err != nil && !errors.Is(err, ErrContainerNotFound) {
 			return err
 		}
 	}
@@ -230,8 +358,29 @@ func (c *Cogsworth) DeleteContainer(ctx context.Context, id string) error {
 	return nil
 }
 
+// idBytes is the number of random bytes in a generated ID. 8 bytes keeps
+// collisions out of reach even for very large clusters.
+const idBytes = 8
+
+// GenerateID returns a new random container ID.
+func GenerateID() (string, error) {
+	return generateIDFrom(rand.Reader)
+}
+
+func generateIDFrom(r io.Reader) (string, error) {
+	bytes := make([]byte, idBytes)
+	if _, err := io.ReadFull(r, bytes); err != nil {
+		return "", fmt.Errorf("failed to generate ID: %w", err)
+	}
+	return fmt.Sprintf("cont-%s", hex.EncodeToString(bytes)), nil
+}
+
+// generateID is GenerateID for callers that cannot propagate an error. A
+// failing system RNG is not recoverable, so it panics.
 func generateID() string {
-	bytes := make([]byte, 6)
-	rand.Read(bytes)
-	return fmt.Sprintf("cont-%s", hex.EncodeToString(bytes))
+	id, err := GenerateID()
+	if err != nil {
+		panic(err)
+	}
+	return id
 }
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/galadd/cogsworth/client"
+)
+
+// testToken is the cluster token newTestWorker's worker and API server
+// share.
+const testToken = "test-token"
+
+// newTestWorker returns a worker with a FakeRuntime, talking to an API
+// server on store.
+func newTestWorker(t *testing.T, nodeID string) (*Cogsworth, *MemStore, *FakeRuntime) {
+	t.Helper()
+
+	s, store, handler := newTestAPI(t)
+	s.token = testToken
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	runtime := NewFakeRuntime()
+	cogs := &Cogsworth{
+		runtime:   runtime,
+		nodeID:    nodeID,
+		role:      Worker,
+		apiClient: client.New(srv.URL, testToken, nil).ForNode(nodeID),
+	}
+	cogs.reconciler = NewReconciler(cogs, 0)
+	return cogs, store, runtime
+}
+
+func TestDeleteContainerIsIdempotent(t *testing.T) {
+	store, runtime := NewMemStore(), NewFakeRuntime()
+	cogs := NewCogsworth(store, runtime)
+	ctx := context.Background()
+
+	id, err := runtime.Create(ctx, &ContainerSpec{Name: "cogs-c1", Image: "nginx"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSave(t, store, &Container{ID: "c1", Image: "nginx", State: Stopped, DesiredState: Stopped, ContainerID: id})
+
+	if err := cogs.DeleteContainer(ctx, "c1"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := store.GetContainer(ctx, "c1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("record: got %v, want it deleted", err)
+	}
+	if _, err := runtime.Inspect(ctx, id); !errors.Is(err, ErrContainerNotFound) {
+		t.Errorf("runtime container: got %v, want it removed", err)
+	}
+
+	if err := cogs.DeleteContainer(ctx, "c1"); err != nil {
+		t.Errorf("deleting it again: %v, want nil", err)
+	}
+
+	// removed from the runtime behind our back
+	mustSave(t, store, &Container{ID: "c2", Image: "nginx", State: Stopped, DesiredState: Stopped, ContainerID: "gone"})
+	if err := cogs.DeleteContainer(ctx, "c2"); err != nil {
+		t.Errorf("delete with the runtime container gone: %v", err)
+	}
+}
+
+func TestDeleteContainerReturnsStoreError(t *testing.T) {
+	broken := errors.New("disk on fire")
+	cogs := NewCogsworth(failingStore{Store: NewMemStore(), err: broken}, NewFakeRuntime())
+	if err := cogs.DeleteContainer(context.Background(), "c1"); !errors.Is(err, broken) {
+		t.Errorf("got %v, want the store error", err)
+	}
+}
+
+func TestWorkerReportsUsageToControlPlane(t *testing.T) {
+	cogs, store, runtime := newTestWorker(t, "w1")
+	r := cogs.reconciler
+	r.actionInterval = 0
+	ctx := context.Background()
+	mustSave(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running, NodeID: "w1", Scheduled: true})
+
+	// the first pass creates the runtime container the stats are set on
+	if err := r.reconcileWorker(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c, err := store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.SetStats(c.ContainerID, &RuntimeStats{CPUPercent: 12.5, MemoryBytes: 64 << 20})
+	if err := r.reconcileWorker(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Usage == nil || c.Usage.CPUPercent != 12.5 || c.Usage.MemoryBytes != 64<<20 || c.Usage.SampledAt.IsZero() {
+		t.Errorf("stored usage %+v, want the sampled stats", c.Usage)
+	}
+}
+
+func TestPublicDeleteTearsDownRunningContainer(t *testing.T) {
+	s, store, handler := newTestAPI(t)
+	s.token = testToken
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	runtime := NewFakeRuntime()
+	cogs := &Cogsworth{runtime: runtime, nodeID: "w1", role: Worker,
+		apiClient: client.New(srv.URL, testToken, nil).ForNode("w1")}
+	cogs.reconciler = NewReconciler(cogs, 0)
+	r := cogs.reconciler
+	r.actionInterval = 0
+	ctx := context.Background()
+	mustSave(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running, NodeID: "w1", Scheduled: true})
+
+	if err := r.reconcileWorker(ctx); err != nil {
+		t.Fatal(err)
+	}
+	c, err := store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.State != Running {
+		t.Fatalf("container is %s, want Running before the delete", c.State)
+	}
+
+	// a plain API client, not the worker
+	if err := client.New(srv.URL, testToken, nil).DeleteContainer("c1", false); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	c, err = store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatalf("record gone before the worker removed the runtime container: %v", err)
+	}
+	if c.DesiredState != Destroyed {
+		t.Errorf("deleted container wants %s, want Destroyed", c.DesiredState)
+	}
+
+	if err := r.reconcileWorker(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runtime.Inspect(ctx, c.ContainerID); !errors.Is(err, ErrContainerNotFound) {
+		t.Errorf("runtime container: got %v, want it removed", err)
+	}
+	if _, err := store.GetContainer(ctx, "c1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("record: got %v, want it dropped once torn down", err)
+	}
+}
+
+func TestDeregisterStopsRemovesAndDropsNode(t *testing.T) {
+	cogs, store, runtime := newTestWorker(t, "w1")
+	ctx := context.Background()
+
+	runtime.SetStatus("rt-1", &RuntimeStatus{State: "running"})
+	mustSave(t, store,
+		&Node{ID: "w1", Role: Worker, State: NodeReady},
+		&Container{ID: "c1", Image: "nginx", State: Running, DesiredState: Running,
+			NodeID: "w1", Scheduled: true, ContainerID: "rt-1"})
+
+	if err := cogs.Deregister(ctx); err != nil {
+		t.Fatalf("Deregister: %v", err)
+	}
+
+	if got := runtime.Methods(); !slices.Equal(got, []string{"Stop", "Remove"}) {
+		t.Errorf("runtime calls = %v, want Stop then Remove", got)
+	}
+	if _, err := store.GetNode(ctx, "w1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("node still stored: %v", err)
+	}
+
+	c, err := store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Scheduled || c.NodeID != "" || c.State != Stopped || c.LastNodeID != "w1" {
+		t.Errorf("container = %s on %q (scheduled %v, last %q), want stopped and unscheduled from w1",
+			c.State, c.NodeID, c.Scheduled, c.LastNodeID)
+	}
+}
+
+func TestDeregisterKeepsNodeWhenStopFails(t *testing.T) {
+	cogs, store, runtime := newTestWorker(t, "w1")
+	ctx := context.Background()
+
+	runtime.SetStatus("rt-1", &RuntimeStatus{State: "running"})
+	runtime.FailStop("rt-1", errors.New("daemon hung"))
+	mustSave(t, store,
+		&Node{ID: "w1", Role: Worker, State: NodeReady},
+		&Container{ID: "c1", Image: "nginx", State: Running, DesiredState: Running,
+			NodeID: "w1", Scheduled: true, ContainerID: "rt-1"})
+
+	if err := cogs.Deregister(ctx); !errors.Is(err, ErrConflict) {
+		t.Errorf("Deregister: got %v, want a conflict", err)
+	}
+	if slices.Contains(runtime.Methods(), "Remove") {
+		t.Errorf("removed a container that failed to stop")
+	}
+	if _, err := store.GetNode(ctx, "w1"); err != nil {
+		t.Errorf("node removed with a container still running: %v", err)
+	}
+}
+
+func TestHeartbeatAfterRemovalIsNodeRemoved(t *testing.T) {
+	cogs, store, _ := newTestWorker(t, "w1")
+	mustSave(t, store, &Node{ID: "w1", Role: Worker, State: NodeReady, LastSeen: time.Now()})
+
+	if err := cogs.apiClient.DeleteNode("w1", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cogs.apiClient.SendHeartbeat(nil, Resources{}); !errors.Is(err, ErrNodeRemoved) {
+		t.Errorf("got %v, want ErrNodeRemoved", err)
+	}
+}
+
+func TestControlPlaneRegistersItsOwnNode(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemStore()
+
+	if _, err := NewControlPlane(store, "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	nodes, err := store.ListNodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].ID != "control-plane-1" || nodes[0].Role != ControlPlane || nodes[0].State != NodeReady {
+		t.Fatalf("nodes after startup = %+v, want the ready control-plane-1", nodes)
+	}
+	created := nodes[0].CreatedAt
+
+	// a restart refreshes the record instead of replacing it
+	if _, err := NewControlPlane(store, "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	node, err := store.GetNode(ctx, "control-plane-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !node.CreatedAt.Equal(created) || node.State != NodeReady {
+		t.Errorf("after a restart the node is %s, created %v, want ready and created at %v", node.State, node.CreatedAt, created)
+	}
+	if nodes, _ := store.ListNodes(ctx); len(nodes) != 1 {
+		t.Errorf("%d nodes after a restart, want 1", len(nodes))
+	}
+}
+
+func TestGenerateIDIsUnique(t *testing.T) {
+	seen := make(map[string]bool, 100_000)
+	for range 100_000 {
+		id, err := GenerateID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[id] {
+			t.Fatalf("generated %s twice", id)
+		}
+		seen[id] = true
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) { return 0, errors.New("entropy exhausted") }
+
+func TestGenerateIDSurfacesReaderError(t *testing.T) {
+	if id, err := generateIDFrom(failingReader{}); err == nil {
+		t.Errorf("got %q, want the reader's error", id)
+	}
+	// a short read must fail too rather than pad the ID with zeros
+	if id, err := generateIDFrom(strings.NewReader("abc")); err == nil {
+		t.Errorf("got %q from a short read, want an error", id)
+	}
+}
+
+func TestControlPlaneOnMemoryBackendSchedulesAddedContainer(t *testing.T) {
+	store, err := OpenStore(storeMemory, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	cogs, err := NewControlPlane(store, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cogs.apiServer.logRequests = false
+	srv := httptest.NewServer(cogs.apiServer.handler())
+	t.Cleanup(srv.Close)
+	c := client.New(srv.URL, "", nil)
+
+	if err := c.Register(&Node{ID: "w1", Role: Worker, Capacity: Resources{CPUCores: 2, MemoryMB: 1024}}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := c.CreateContainer(&Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := cogs.reconciler.reconcile(context.Background()); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+
+	assigned, err := c.GetAssignedContainers("w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assigned) != 1 || assigned[0].ID != "c1" {
+		t.Errorf("w1 was assigned %d containers, want c1", len(assigned))
+	}
+}
+
+func TestOpenStoreRejectsUnknownBackend(t *testing.T) {
+	if _, err := OpenStore("etcd", ""); err == nil {
+		t.Error("opened an unknown backend")
+	}
+}
+
+func TestMemoryBackendKeepsStateInMemory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), dbFile)
+	store, err := OpenStore(storeMemory, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, ok := store.(*MemStore); !ok {
+		t.Errorf("memory backend is a %T, want a *MemStore", store)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("memory backend touched %s: %v", path, err)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// dataDirEnv overrides the default data directory.
+const dataDirEnv = "COGS_DATA_DIR"
+
+const (
+	dbFile          = "cogsworth.db"
+	workerCacheFile = "cogsworth-worker.db"
+
+	// memoryStoreFile holds the pid of a control plane running on the
+	// in-memory store, whose state the database file doesn't have.
+	memoryStoreFile = "cogsworth-memory.pid"
+)
+
+// dataDir holds every database file. It is set once from --data-dir or
+// COGS_DATA_DIR before the command runs.
+var dataDir = "."
+
+// parseGlobalFlags consumes the flags in front of the command name and
+// returns the remaining arguments, starting with the command.
+func parseGlobalFlags(args []string) []string {
+	fs := flag.NewFlagSet("cogs", flag.ExitOnError)
+	fs.StringVar(&dataDir, "data-dir", resolveDataDir("", os.Getenv(dataDirEnv)),
+		"directory holding the cluster database (env "+dataDirEnv+")")
+	fs.Func("log-level", "structured log level: debug, info, warn or error (debug traces every reconcile decision)", func(value string) error {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			return err
+		}
+		slog.SetLogLoggerLevel(level)
+		return nil
+	})
+	fs.Parse(args)
+	return fs.Args()
+}
+
+// resolveDataDir picks the flag value over the environment, defaulting to
+// the working directory.
+func resolveDataDir(flagValue, envValue string) string {
+	return cmp.Or(flagValue, envValue, ".")
+}
+
+// dbPath is the control plane database every command shares.
+func dbPath() string {
+	return filepath.Join(dataDir, dbFile)
+}
+
+// markMemoryStore records that this process serves the in-memory store, so
+// commands that edit the database directly refuse instead of changing a
+// file the control plane never reads. The returned func removes the mark.
+func markMemoryStore() (func(), error) {
+	path := filepath.Join(dataDir, memoryStoreFile)
+	if err := os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())), 0600); err != nil {
+		return nil, err
+	}
+	return func() { os.Remove(path) }, nil
+}
+
+// memoryStorePID returns the pid of a live control plane on the in-memory
+// store. A mark left by one that died is ignored.
+func memoryStorePID() (int, bool) {
+	data, err := os.ReadFile(filepath.Join(dataDir, memoryStoreFile))
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, false
+	}
+	if !processAlive(pid) {
+		return 0, false
+	}
+	return pid, true
+}
+
+// checkSharedStore fails while the control plane keeps its state in memory.
+func checkSharedStore() error {
+	if pid, ok := memoryStorePID(); ok {
+		return fmt.Errorf("the control plane (pid %d) runs on the in-memory store, so %s isn't the cluster's state; restart it with --store bolt to use this command", pid, dbPath())
+	}
+	return nil
+}
+
+// openSharedStore opens the control plane database for a command that
+// edits it directly.
+func openSharedStore() (*BoltStore, error) {
+	if err := checkSharedStore(); err != nil {
+		return nil, err
+	}
+	return NewBoltStore(dbPath())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// useDataDir points dataDir at a fresh directory for the test.
+func useDataDir(t *testing.T) string {
+	t.Helper()
+
+	old := dataDir
+	dataDir = t.TempDir()
+	t.Cleanup(func() { dataDir = old })
+	return dataDir
+}
+
+func TestSharedStoreRefusedWhileMemoryStoreRuns(t *testing.T) {
+	useDataDir(t)
+
+	if err := checkSharedStore(); err != nil {
+		t.Fatalf("without a memory store: %v", err)
+	}
+
+	unmark, err := markMemoryStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSharedStore(); err == nil {
+		t.Errorf("opened the database while this process serves the memory store")
+	}
+	if _, err := openSharedStore(); err == nil {
+		t.Errorf("openSharedStore succeeded while the memory store runs")
+	}
+
+	unmark()
+	if err := checkSharedStore(); err != nil {
+		t.Errorf("after the control plane exits: %v", err)
+	}
+}
+
+func TestSharedStoreIgnoresStaleMark(t *testing.T) {
+	dir := useDataDir(t)
+
+	// above the kernel's pid limit, so no process has it
+	dead := strconv.Itoa(1<<22 + 1)
+	if err := os.WriteFile(filepath.Join(dir, memoryStoreFile), []byte(dead), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSharedStore(); err != nil {
+		t.Errorf("refused on the mark of a dead process: %v", err)
+	}
+}
+
+func TestResolveDataDir(t *testing.T) {
+	for _, tc := range []struct {
+		flag, env, want string
+	}{
+		{"", "", "."},
+		{"", "/var/lib/cogs", "/var/lib/cogs"},
+		{"/srv/cogs", "/var/lib/cogs", "/srv/cogs"},
+		{"/srv/cogs", "", "/srv/cogs"},
+	} {
+		if got := resolveDataDir(tc.flag, tc.env); got != tc.want {
+			t.Errorf("resolveDataDir(%q, %q) = %q, want %q", tc.flag, tc.env, got, tc.want)
+		}
+	}
+}
+
+func TestGlobalDataDirFlagSetsDBPath(t *testing.T) {
+	old := dataDir
+	t.Cleanup(func() { dataDir = old })
+	t.Setenv(dataDirEnv, "/from/env")
+
+	rest := parseGlobalFlags([]string{"--data-dir", "/from/flag", "ps", "-a"})
+	if want := []string{"ps", "-a"}; !slices.Equal(rest, want) {
+		t.Errorf("remaining args = %q, want %q", rest, want)
+	}
+	if want := filepath.Join("/from/flag", dbFile); dbPath() != want {
+		t.Errorf("dbPath() = %q, want %q", dbPath(), want)
+	}
+
+	parseGlobalFlags([]string{"ps"})
+	if want := filepath.Join("/from/env", dbFile); dbPath() != want {
+		t.Errorf("without the flag dbPath() = %q, want %q", dbPath(), want)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// validateDependencies checks a submitted container's DependsOn against the
+// containers already stored: every dependency must exist and adding the
+// container must not close a cycle.
+func validateDependencies(container *Container, existing []*Container) error {
+	graph := make(map[string][]string, len(existing)+1)
+	for _, c := range existing {
+		graph[c.ID] = c.DependsOn
+	}
+
+	for _, dep := range container.DependsOn {
+		if dep == container.ID {
+			return fmt.Errorf("container %s cannot depend on itself", container.ID)
+		}
+		if _, ok := graph[dep]; !ok {
+			return fmt.Errorf("dependency %s of container %s does not exist", dep, container.ID)
+		}
+	}
+	graph[container.ID] = container.DependsOn
+
+	if cycle := dependencyCycle(container.ID, graph); cycle != nil {
+		return fmt.Errorf("dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// dependencyCycle returns the first cycle reachable from start as a path
+// that begins and ends with the same ID, or nil.
+func dependencyCycle(start string, graph map[string][]string) []string {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	marks := make(map[string]int)
+	var path []string
+
+	var visit func(id string) []string
+	visit = func(id string) []string {
+		switch marks[id] {
+		case visiting:
+			for i, p := range path {
+				if p == id {
+					return append(append([]string{}, path[i:]...), id)
+				}
+			}
+		case done:
+			return nil
+		}
+
+		marks[id] = visiting
+		path = append(path, id)
+		for _, dep := range graph[id] {
+			if cycle := visit(dep); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		marks[id] = done
+		return nil
+	}
+
+	return visit(start)
+}
+
+// dependents returns the containers that depend on one of ids and aren't
+// being destroyed or among ids themselves.
+func dependents(ids map[string]bool, containers []*Container) []string {
+	var found []string
+	for _, c := range containers {
+		if ids[c.ID] || c.DesiredState == Destroyed {
+			continue
+		}
+		if slices.ContainsFunc(c.DependsOn, func(dep string) bool { return ids[dep] }) {
+			found = append(found, c.ID)
+		}
+	}
+	return found
+}
+
+// pendingDependency returns the first dependency of container that isn't
+// Running yet, or "" when all are. peers holds the states of the
+// containers assigned to this node this tick; dependencies placed
+// elsewhere are looked up remotely. A dependency that no longer exists is
+// reported as ErrNotFound.
+func (r *Reconciler) pendingDependency(ctx context.Context, container *Container, peers map[string]ContainerState) (string, error) {
+	for _, dep := range container.DependsOn {
+		state, ok := peers[dep]
+		if !ok {
+			c, err := r.getContainer(ctx, dep)
+			if err != nil {
+				return dep, fmt.Errorf("failed to look up dependency %s: %w", dep, err)
+			}
+			state = c.State
+		}
+
+		if state != Running {
+			return dep, nil
+		}
+	}
+	return "", nil
+}
+
+// noteWaiting records which dependency container is waiting for, "" once
+// it waits no more, and reports whether that changed.
+func (r *Reconciler) noteWaiting(id, dep string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.waiting[id] == dep {
+		return false
+	}
+	if dep == "" {
+		delete(r.waiting, id)
+	} else {
+		if r.waiting == nil {
+			r.waiting = make(map[string]string)
+		}
+		r.waiting[id] = dep
+	}
+	return true
+}
+
+func (r *Reconciler) getContainer(ctx context.Context, id string) (*Container, error) {
+	if r.cogsworth.role == Worker {
+		return r.cogsworth.apiClient.GetContainer(id)
+	}
+	return r.cogsworth.store.GetContainer(ctx, id)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestValidateDependencies(t *testing.T) {
+	existing := []*Container{{ID: "db"}, {ID: "cache", DependsOn: []string{"web"}}}
+
+	if err := validateDependencies(&Container{ID: "web", DependsOn: []string{"db"}}, existing); err != nil {
+		t.Errorf("existing dependency: %v", err)
+	}
+	if err := validateDependencies(&Container{ID: "web", DependsOn: []string{"missing"}}, existing); err == nil {
+		t.Errorf("accepted a missing dependency")
+	}
+	if err := validateDependencies(&Container{ID: "web", DependsOn: []string{"web"}}, existing); err == nil {
+		t.Errorf("accepted a container depending on itself")
+	}
+	if err := validateDependencies(&Container{ID: "web", DependsOn: []string{"cache"}}, existing); err == nil {
+		t.Errorf("accepted a cycle web -> cache -> web")
+	}
+}
+
+func TestDependents(t *testing.T) {
+	containers := []*Container{
+		{ID: "db"},
+		{ID: "web", DependsOn: []string{"db"}},
+		{ID: "old", DependsOn: []string{"db"}, DesiredState: Destroyed},
+	}
+
+	if got := dependents(map[string]bool{"db": true}, containers); !slices.Equal(got, []string{"web"}) {
+		t.Errorf("got %v, want [web]", got)
+	}
+	if got := dependents(map[string]bool{"db": true, "web": true}, containers); len(got) != 0 {
+		t.Errorf("got %v, want none when the dependents go too", got)
+	}
+}
+
+func TestDeleteRefusesContainerOthersDependOn(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	mustSave(t, store,
+		&Container{ID: "db", Image: "postgres", State: Running, DesiredState: Running},
+		&Container{ID: "web", Image: "nginx", State: Running, DesiredState: Running, DependsOn: []string{"db"}})
+
+	rec := call(t, handler, http.MethodDelete, "/containers/db", nil)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("got %d %s, want 409", rec.Code, rec.Body.String())
+	}
+
+	if rec := call(t, handler, http.MethodDelete, "/containers/web", nil); rec.Code != http.StatusOK {
+		t.Fatalf("delete the dependent: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := call(t, handler, http.MethodDelete, "/containers/db", nil); rec.Code != http.StatusOK {
+		t.Errorf("once nothing depends on it: got %d %s, want 200", rec.Code, rec.Body.String())
+	}
+}
+
+func TestBulkDeleteKeepsDependenciesOfKeptContainers(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	mustSave(t, store,
+		&Container{ID: "db", Image: "postgres", State: Running, DesiredState: Running,
+			Labels: map[string]string{"tier": "data"}},
+		&Container{ID: "web", Image: "nginx", State: Running, DesiredState: Running, DependsOn: []string{"db"}})
+
+	rec := call(t, handler, http.MethodDelete, "/containers?selector=tier=data", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+	var result BulkDeleteResult
+	decodeData(t, rec, &result)
+	if len(result.Destroyed) != 0 || !slices.Equal(result.Skipped, []string{"db"}) {
+		t.Errorf("got %+v, want db skipped", result)
+	}
+
+	rec = call(t, handler, http.MethodDelete, "/containers?all=true", nil)
+	decodeData(t, rec, &result)
+	if len(result.Destroyed) != 2 {
+		t.Errorf("deleting everything: got %+v, want both destroyed", result)
+	}
+}
+
+func TestReconcileWaitsForDependency(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	ctx := context.Background()
+
+	saveTestContainer(t, store, &Container{ID: "db", Image: "postgres", State: Requested, DesiredState: Running})
+	web := saveTestContainer(t, store, &Container{ID: "web", Image: "nginx", State: Requested, DesiredState: Running,
+		DependsOn: []string{"db"}})
+
+	for range 2 {
+		if err := r.reconcileContainer(ctx, web, nil); err != nil {
+			t.Fatalf("reconcileContainer: %v", err)
+		}
+		web, _ = store.GetContainer(ctx, "web")
+	}
+	if slices.Contains(runtime.Methods(), "Start") {
+		t.Errorf("started web before db runs")
+	}
+	if r.noteWaiting("web", "db") {
+		t.Errorf("the wait wasn't recorded, so it would be logged again")
+	}
+}
+
+func TestReconcileFailsContainerWhoseDependencyIsGone(t *testing.T) {
+	r, store, _ := newTestReconciler(t)
+	ctx := context.Background()
+
+	// saved around the admission check, e.g. deleted straight from the store
+	web := saveTestContainer(t, store, &Container{ID: "web", Image: "nginx", State: Requested, DesiredState: Running,
+		DependsOn: []string{"db"}})
+	if err := r.reconcileContainer(ctx, web, nil); err != nil {
+		t.Fatalf("reconcileContainer: %v", err)
+	}
+
+	stored, err := store.GetContainer(ctx, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.State != Failed || stored.DesiredState != Stopped {
+		t.Errorf("web is %s, desired %s, want failed and given up", stored.State, stored.DesiredState)
+	}
+}
+
+// dependencyStore fails reads of the container dep.
+type dependencyStore struct {
+	Store
+	dep string
+	err error
+}
+
+func (s dependencyStore) GetContainer(ctx context.Context, id string) (*Container, error) {
+	if id == s.dep {
+		return nil, s.err
+	}
+	return s.Store.GetContainer(ctx, id)
+}
+
+func TestReconcileSurfacesDependencyLookupError(t *testing.T) {
+	failed := errors.New("store unavailable")
+	store := dependencyStore{Store: NewMemStore(), dep: "db", err: failed}
+	cogs := NewCogsworth(store, NewFakeRuntime())
+	cogs.reconciler.actionInterval = 0
+	ctx := context.Background()
+
+	web := saveTestContainer(t, store, &Container{ID: "web", Image: "nginx", State: Requested, DesiredState: Running,
+		DependsOn: []string{"db"}})
+	if err := cogs.reconciler.reconcileContainer(ctx, web, nil); !errors.Is(err, failed) {
+		t.Errorf("got %v, want the store's error", err)
+	}
+	stored, err := store.GetContainer(ctx, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.State == Failed {
+		t.Errorf("failed web over a store error")
+	}
+}
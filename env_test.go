@@ -0,0 +1,72 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	input := `# database settings
+DB_HOST=db.internal
+export DB_PORT = 5432
+
+GREETING="hello\n\"world\""
+LITERAL='no $expansion \n here'
+TRAILING="quoted" # a comment
+EMPTY=
+URL=postgres://u:p@db/app?sslmode=disable
+DB_HOST=db.override
+`
+	got, err := parseEnvFile(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"DB_HOST":  "db.override",
+		"DB_PORT":  "5432",
+		"GREETING": "hello\n\"world\"",
+		"LITERAL":  `no $expansion \n here`,
+		"TRAILING": "quoted",
+		"EMPTY":    "",
+		"URL":      "postgres://u:p@db/app?sslmode=disable",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("got %q,\nwant %q", got, want)
+	}
+}
+
+func TestParseEnvFileRejectsMalformedLines(t *testing.T) {
+	for _, input := range []string{
+		"NO_EQUALS",
+		"=value",
+		"TWO WORDS=x",
+		`OPEN="never closed`,
+		`AFTER="x" y`,
+	} {
+		if _, err := parseEnvFile(strings.NewReader(input)); err == nil || !strings.Contains(err.Error(), "line 1") {
+			t.Errorf("%q: got %v, want an error naming line 1", input, err)
+		}
+	}
+}
+
+func TestLoadEnvFlagsOverrideFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.env")
+	if err := os.WriteFile(path, []byte("MODE=prod\nDEBUG=0\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := loadEnv(path, envFlag{"DEBUG": "1", "EXTRA": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"MODE": "prod", "DEBUG": "1", "EXTRA": "x"}; !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := loadEnv(filepath.Join(t.TempDir(), "missing.env"), nil); err == nil {
+		t.Errorf("loaded a missing env file")
+	}
+}
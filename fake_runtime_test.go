@@ -0,0 +1,585 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// FakeCall records a single call made against a FakeRuntime. Arg is the
+// image for Pull, the spec name for Create and the runtime ID otherwise.
+type FakeCall struct {
+	Method string
+	Arg    string
+}
+
+// FakeRuntime is an in-memory Runtime for driving the reconciler without a
+// Docker daemon. Responses are programmable per container and every call is
+// recorded in order.
+type FakeRuntime struct {
+	mu            sync.Mutex
+	calls         []FakeCall
+	statuses      map[string]*RuntimeStatus
+	names         map[string]string
+	hashes        map[string]string
+	specs         map[string]ContainerSpec
+	logs          map[string]string
+	stats         map[string]*RuntimeStats
+	exec          map[string]string
+	labels        map[string]map[string]string
+	timeouts      map[string]int
+	pullErr       map[string]error
+	createErr     map[string]error
+	startErr      map[string]error
+	stopErr       map[string]error
+	inspectErr    map[string]error
+	images        map[string]bool
+	pingErr       error
+	blocked       map[string]bool
+	blockedCreate map[string]bool
+	creating      int
+	maxCreating   int
+	networks      map[string]map[string][]string // network -> container -> aliases
+	nextID        int
+	nextPort      int
+	nextIP        int
+}
+
+// fakeEphemeralBase is where FakeRuntime starts handing out host ports for
+// bindings that leave the choice to the runtime, like Docker's range.
+const fakeEphemeralBase = 32768
+
+func NewFakeRuntime() *FakeRuntime {
+	return &FakeRuntime{
+		statuses:      make(map[string]*RuntimeStatus),
+		names:         make(map[string]string),
+		hashes:        make(map[string]string),
+		specs:         make(map[string]ContainerSpec),
+		logs:          make(map[string]string),
+		stats:         make(map[string]*RuntimeStats),
+		exec:          make(map[string]string),
+		labels:        make(map[string]map[string]string),
+		timeouts:      make(map[string]int),
+		pullErr:       make(map[string]error),
+		createErr:     make(map[string]error),
+		startErr:      make(map[string]error),
+		stopErr:       make(map[string]error),
+		inspectErr:    make(map[string]error),
+		images:        make(map[string]bool),
+		blocked:       make(map[string]bool),
+		blockedCreate: make(map[string]bool),
+		networks:      make(map[string]map[string][]string),
+	}
+}
+
+// SetStatus programs the status Inspect returns for a runtime ID.
+func (f *FakeRuntime) SetStatus(containerID string, status *RuntimeStatus) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	status.ContainerID = containerID
+	f.statuses[containerID] = status
+}
+
+func (f *FakeRuntime) SetLogs(containerID, logs string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.logs[containerID] = logs
+}
+
+func (f *FakeRuntime) SetStats(containerID string, stats *RuntimeStats) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.stats[containerID] = stats
+}
+
+// SetExecOutput programs what every Exec in a runtime ID prints.
+func (f *FakeRuntime) SetExecOutput(containerID, output string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.exec[containerID] = output
+}
+
+// SetImageLabels programs the labels ImageLabels returns for an image.
+func (f *FakeRuntime) SetImageLabels(image string, labels map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.labels[image] = labels
+}
+
+func (f *FakeRuntime) FailPull(image string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pullErr[image] = err
+}
+
+// FailCreate makes Create fail for the given spec name (the cogs container ID).
+func (f *FakeRuntime) FailCreate(name string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.createErr[name] = err
+}
+
+// FailStart makes Start fail with err, for a runtime ID or for whatever is
+// created under a spec name.
+func (f *FakeRuntime) FailStart(containerID string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.startErr[containerID] = err
+}
+
+func (f *FakeRuntime) FailStop(containerID string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.stopErr[containerID] = err
+}
+
+// FailInspect makes Inspect of a runtime ID fail with err, e.g. a transient
+// daemon error, even though the container exists.
+func (f *FakeRuntime) FailInspect(containerID string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.inspectErr[containerID] = err
+}
+
+// SetImagePresent marks an image as already available locally.
+func (f *FakeRuntime) SetImagePresent(image string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.images[image] = true
+}
+
+// BlockPull makes Pull of image hang until its context is done, like a
+// stalled registry.
+func (f *FakeRuntime) BlockPull(image string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.blocked[image] = true
+}
+
+// BlockCreate makes Create of a spec name hang until its context is done,
+// like a daemon stuck on one container.
+func (f *FakeRuntime) BlockCreate(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.blockedCreate[name] = true
+}
+
+// FailPing makes Ping report err, simulating a daemon that is down. Pass nil
+// to bring it back.
+func (f *FakeRuntime) FailPing(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.pingErr = err
+}
+
+// Calls returns a copy of the recorded calls in the order they were made.
+func (f *FakeRuntime) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]FakeCall(nil), f.calls...)
+}
+
+// Methods returns just the method names of the recorded calls.
+func (f *FakeRuntime) Methods() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	methods := make([]string, 0, len(f.calls))
+	for _, c := range f.calls {
+		methods = append(methods, c.Method)
+	}
+	return methods
+}
+
+// Spec returns the spec of the last Create for a spec name.
+func (f *FakeRuntime) Spec(name string) (ContainerSpec, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	spec, ok := f.specs[name]
+	return spec, ok
+}
+
+// StopTimeout returns the timeout passed to the last Stop of a runtime ID.
+func (f *FakeRuntime) StopTimeout(containerID string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.timeouts[containerID]
+}
+
+// MaxConcurrentCreates is the most Create calls that were in progress at
+// once.
+func (f *FakeRuntime) MaxConcurrentCreates() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.maxCreating
+}
+
+func (f *FakeRuntime) record(method, arg string) {
+	f.calls = append(f.calls, FakeCall{Method: method, Arg: arg})
+}
+
+// Ping is not recorded since workers call it on every tick and heartbeat.
+func (f *FakeRuntime) Ping(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.pingErr
+}
+
+func (f *FakeRuntime) Pull(ctx context.Context, image string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.record("Pull", image)
+	if f.blocked[image] {
+		f.mu.Unlock()
+		<-ctx.Done()
+		f.mu.Lock()
+		return fmt.Errorf("failed to pull image: %w", ctx.Err())
+	}
+	if err := f.pullErr[image]; err != nil {
+		return err
+	}
+	f.images[image] = true
+	return nil
+}
+
+// HasImage reports images that were pulled or marked with SetImagePresent.
+func (f *FakeRuntime) HasImage(ctx context.Context, image string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.record("HasImage", image)
+	return f.images[image], nil
+}
+
+func (f *FakeRuntime) ImageLabels(ctx context.Context, image string) (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.record("ImageLabels", image)
+	return f.labels[image], nil
+}
+
+func (f *FakeRuntime) Create(ctx context.Context, spec *ContainerSpec) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.record("Create", spec.Name)
+	f.creating++
+	f.maxCreating = max(f.maxCreating, f.creating)
+	defer func() { f.creating-- }()
+	f.specs[spec.Name] = *spec
+	if f.blockedCreate[spec.Name] {
+		f.mu.Unlock()
+		<-ctx.Done()
+		f.mu.Lock()
+		return "", fmt.Errorf("failed to create container: %w", ctx.Err())
+	}
+	if err := f.createErr[spec.Name]; err != nil {
+		return "", err
+	}
+
+	// like DockerRuntime, adopt an existing container with the same name
+	// and spec, and replace one with another spec
+	id, ok := f.names[spec.Name]
+	if _, exists := f.statuses[id]; !exists && spec.LegacyName != "" {
+		id, ok = f.names[spec.LegacyName]
+	}
+	if ok {
+		if _, exists := f.statuses[id]; exists {
+			if f.hashes[id] == spec.SpecHash {
+				return id, nil
+			}
+			delete(f.statuses, id)
+		}
+	}
+
+	f.nextID++
+	id = fmt.Sprintf("fake-%012d", f.nextID)
+	f.statuses[id] = &RuntimeStatus{ContainerID: id, State: "created", Ports: slices.Clone(spec.Ports)}
+	f.names[spec.Name] = id
+	f.hashes[id] = spec.SpecHash
+
+	return id, nil
+}
+
+func (f *FakeRuntime) Start(ctx context.Context, containerID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.record("Start", containerID)
+	if err := f.startErr[containerID]; err != nil {
+		return err
+	}
+	for name, id := range f.names {
+		if err := f.startErr[name]; id == containerID && err != nil {
+			return err
+		}
+	}
+
+	status, ok := f.statuses[containerID]
+	if !ok {
+		return fmt.Errorf("failed to start container: %s not found", containerID)
+	}
+	status.State = "running"
+	if status.IPAddress == "" {
+		f.nextIP++
+		status.IPAddress = fmt.Sprintf("172.17.0.%d", 1+f.nextIP)
+	}
+
+	// like Docker, pick host ports for bindings that didn't name one
+	for i := range status.Ports {
+		if status.Ports[i].HostPort == 0 {
+			status.Ports[i].HostPort = fakeEphemeralBase + f.nextPort
+			f.nextPort++
+		}
+	}
+
+	return nil
+}
+
+func (f *FakeRuntime) Stop(ctx context.Context, containerID string, timeout int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.record("Stop", containerID)
+	f.timeouts[containerID] = timeout
+	if err := f.stopErr[containerID]; err != nil {
+		return err
+	}
+
+	status, ok := f.statuses[containerID]
+	if !ok {
+		return fmt.Errorf("failed to stop container: %s not found", containerID)
+	}
+	status.State = "exited"
+	// Docker releases the address of a stopped container
+	status.IPAddress = ""
+
+	return nil
+}
+
+func (f *FakeRuntime) Pause(ctx context.Context, containerID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.record("Pause", containerID)
+	status, ok := f.statuses[containerID]
+	if !ok {
+		return fmt.Errorf("failed to pause container: %s not found", containerID)
+	}
+	if status.State != "running" {
+		return fmt.Errorf("failed to pause container: %s is not running", containerID)
+	}
+	status.State = "paused"
+
+	return nil
+}
+
+func (f *FakeRuntime) Unpause(ctx context.Context, containerID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.record("Unpause", containerID)
+	status, ok := f.statuses[containerID]
+	if !ok {
+		return fmt.Errorf("failed to unpause container: %s not found", containerID)
+	}
+	if status.State != "paused" {
+		return fmt.Errorf("failed to unpause container: %s is not paused", containerID)
+	}
+	status.State = "running"
+
+	return nil
+}
+
+func (f *FakeRuntime) Remove(ctx context.Context, containerID string, force bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.record("Remove", containerID)
+	status, ok := f.statuses[containerID]
+	if !ok {
+		return fmt.Errorf("failed to remove container %s: %w", containerID, ErrContainerNotFound)
+	}
+	if !force && status.State == "running" {
+		return fmt.Errorf("failed to remove container: %s is running", containerID)
+	}
+	delete(f.statuses, containerID)
+
+	return nil
+}
+
+func (f *FakeRuntime) Inspect(ctx context.Context, containerID string) (*RuntimeStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.record("Inspect", containerID)
+	if err := f.inspectErr[containerID]; err != nil {
+		return nil, err
+	}
+	status, ok := f.statuses[containerID]
+	if !ok {
+		return nil, fmt.Errorf("failed to inspect container %s: %w", containerID, ErrContainerNotFound)
+	}
+
+	copied := *status
+	return &copied, nil
+}
+
+func (f *FakeRuntime) List(ctx context.Context) ([]*RuntimeStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.record("List", "")
+	statuses := make([]*RuntimeStatus, 0, len(f.statuses))
+	for _, status := range f.statuses {
+		copied := *status
+		statuses = append(statuses, &copied)
+	}
+
+	return statuses, nil
+}
+
+func (f *FakeRuntime) Logs(ctx context.Context, containerID string, tail int) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.record("Logs", containerID)
+	return f.logs[containerID], nil
+}
+
+// LogStream serves the programmed logs. With follow the stream then stays
+// open, like a quiet container, until ctx is cancelled.
+func (f *FakeRuntime) LogStream(ctx context.Context, containerID string, tail int, follow bool) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.record("LogStream", containerID)
+	logs := f.logs[containerID]
+	if !follow {
+		return io.NopCloser(strings.NewReader(logs)), nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		if _, err := io.WriteString(pw, logs); err != nil {
+			return
+		}
+		<-ctx.Done()
+		pw.CloseWithError(ctx.Err())
+	}()
+	return pr, nil
+}
+
+func (f *FakeRuntime) Stats(ctx context.Context, containerID string) (*RuntimeStats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.record("Stats", containerID)
+	stats, ok := f.stats[containerID]
+	if !ok {
+		return nil, fmt.Errorf("failed to get stats: %s not found", containerID)
+	}
+
+	copied := *stats
+	return &copied, nil
+}
+
+// Exec ignores cmd and serves the programmed output. Input written to the
+// stream is kept in its Stdin buffer.
+func (f *FakeRuntime) Exec(ctx context.Context, containerID string, cmd []string) (io.ReadWriteCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.record("Exec", containerID)
+	status, ok := f.statuses[containerID]
+	if !ok {
+		return nil, fmt.Errorf("failed to create exec: %s not found", containerID)
+	}
+	if status.State != "running" {
+		return nil, fmt.Errorf("failed to exec in %s: %w", containerID, ErrContainerNotRunning)
+	}
+
+	return &FakeExec{Reader: strings.NewReader(f.exec[containerID])}, nil
+}
+
+// FakeExec is the stream returned by FakeRuntime.Exec.
+type FakeExec struct {
+	*strings.Reader
+	Stdin bytes.Buffer
+}
+
+func (e *FakeExec) Write(p []byte) (int, error) {
+	return e.Stdin.Write(p)
+}
+
+func (e *FakeExec) Close() error {
+	return nil
+}
+
+func (f *FakeRuntime) CreateNetwork(ctx context.Context, name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.record("CreateNetwork", name)
+	if _, ok := f.networks[name]; !ok {
+		f.networks[name] = make(map[string][]string)
+	}
+	return nil
+}
+
+func (f *FakeRuntime) ConnectNetwork(ctx context.Context, name, containerID string, aliases []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.record("ConnectNetwork", containerID)
+	attached, ok := f.networks[name]
+	if !ok {
+		return fmt.Errorf("failed to connect container to network %s: no such network", name)
+	}
+	if _, ok := f.statuses[containerID]; !ok {
+		return fmt.Errorf("failed to connect container to network %s: %w", name, ErrContainerNotFound)
+	}
+	if _, ok := attached[containerID]; !ok {
+		attached[containerID] = slices.Clone(aliases)
+	}
+	return nil
+}
+
+// NetworkAliases returns the aliases a container was attached to a network
+// with, and whether it is attached at all.
+func (f *FakeRuntime) NetworkAliases(name, containerID string) ([]string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	aliases, ok := f.networks[name][containerID]
+	return slices.Clone(aliases), ok
+}
+
+func (f *FakeRuntime) Close() error {
+	return nil
+}
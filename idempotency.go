@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/galadd/cogsworth/api"
+)
+
+const idempotencyKeyHeader = api.IdempotencyKeyHeader
+
+// idempotencyKeyTTL is how long a key is remembered, long enough to cover
+// a client's retries.
+const idempotencyKeyTTL = 24 * time.Hour
+
+// idempotencyKeySweepInterval is how often expired keys are deleted from
+// the store. Until then an expired key is ignored, not honoured.
+const idempotencyKeySweepInterval = 10 * time.Minute
+
+// bodyHash identifies a request body, so a replay can be told apart from
+// a key reused for a different request.
+func bodyHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// keyLocks serializes the requests sharing a key, leaving requests under
+// other keys free to run alongside them.
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	mu sync.Mutex
+	// holders counts the requests holding or waiting for mu, so the lock
+	// is dropped with the last of them.
+	holders int
+}
+
+// lock blocks until no other request holds key and returns the function
+// that releases it.
+func (k *keyLocks) lock(key string) (unlock func()) {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyLock)
+	}
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyLock{}
+		k.locks[key] = l
+	}
+	l.holders++
+	k.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+
+		k.mu.Lock()
+		l.holders--
+		if l.holders == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// sweepIdempotencyKeys deletes expired keys from the store every interval
+// until the server shuts down.
+func (s *APIServer) sweepIdempotencyKeys(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			n, err := s.store.DelExpiredIdempotencyKeys(context.Background(), time.Now())
+			if err != nil {
+				log.Printf("[API] Failed to sweep idempotency keys: %v", err)
+			} else if n > 0 {
+				log.Printf("[API] Swept %d expired idempotency keys", n)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
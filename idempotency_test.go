@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const keyedBody = `{"image":"nginx","state":"requested","desired_state":"running"}`
+
+func TestKeyedCreateReplaysFirstContainer(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+
+	var first, second struct{ ID string }
+	rec := call(t, handler, http.MethodPost, "/containers", keyedBody, idempotencyKeyHeader, "k1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body.String())
+	}
+	decodeData(t, rec, &first)
+
+	rec = call(t, handler, http.MethodPost, "/containers", keyedBody, idempotencyKeyHeader, "k1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("replay: %d %s", rec.Code, rec.Body.String())
+	}
+	decodeData(t, rec, &second)
+
+	if second.ID != first.ID {
+		t.Errorf("replay created %s, want the first container %s", second.ID, first.ID)
+	}
+	if containers, _ := store.ListContainers(context.Background()); len(containers) != 1 {
+		t.Errorf("stored %d containers, want 1", len(containers))
+	}
+}
+
+func TestKeyedCreateRejectsDifferentBody(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+
+	if rec := call(t, handler, http.MethodPost, "/containers", keyedBody, idempotencyKeyHeader, "k1"); rec.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body.String())
+	}
+
+	other := `{"image":"redis","state":"requested","desired_state":"running"}`
+	rec := call(t, handler, http.MethodPost, "/containers", other, idempotencyKeyHeader, "k1")
+	if rec.Code != http.StatusUnprocessableEntity || errorCode(t, rec) != codeKeyReused {
+		t.Errorf("got %d %s, want 422 %s", rec.Code, rec.Body.String(), codeKeyReused)
+	}
+	if containers, _ := store.ListContainers(context.Background()); len(containers) != 1 {
+		t.Errorf("stored %d containers, want 1", len(containers))
+	}
+}
+
+func TestKeyedCreateIgnoresExpiredKey(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	mustSave(t, store, &Container{ID: "old", Image: "nginx", State: Requested, DesiredState: Running})
+	if err := store.SaveIdempotencyKey(context.Background(), &IdempotencyKey{
+		Key: "k1", ContainerID: "old", BodyHash: "other", ExpiresAt: time.Now().Add(-time.Minute),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := call(t, handler, http.MethodPost, "/containers", keyedBody, idempotencyKeyHeader, "k1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s, want the expired key ignored", rec.Code, rec.Body.String())
+	}
+	var created struct{ ID string }
+	decodeData(t, rec, &created)
+	if created.ID == "old" {
+		t.Errorf("honoured an expired key")
+	}
+}
+
+func TestIdempotencyKeysSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), dbFile)
+	open := func() (Store, http.Handler) {
+		store, err := NewBoltStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := NewAPIServer(store, newChangeFeed(), "")
+		s.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		s.logRequests = false
+		return store, s.handler()
+	}
+
+	store, handler := open()
+	var first, second struct{ ID string }
+	rec := call(t, handler, http.MethodPost, "/containers", keyedBody, idempotencyKeyHeader, "k1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create: %d %s", rec.Code, rec.Body.String())
+	}
+	decodeData(t, rec, &first)
+	store.Close()
+
+	store, handler = open()
+	defer store.Close()
+	rec = call(t, handler, http.MethodPost, "/containers", keyedBody, idempotencyKeyHeader, "k1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("replay: %d %s", rec.Code, rec.Body.String())
+	}
+	decodeData(t, rec, &second)
+	if second.ID != first.ID {
+		t.Errorf("after a restart the replay created %s, want %s", second.ID, first.ID)
+	}
+}
+
+func TestStoreIdempotencyKeys(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		now := time.Now()
+
+		if _, err := store.GetIdempotencyKey(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("missing key: got %v, want ErrNotFound", err)
+		}
+
+		for _, key := range []*IdempotencyKey{
+			{Key: "live", ContainerID: "c1", BodyHash: "h1", ExpiresAt: now.Add(time.Hour)},
+			{Key: "expired", ContainerID: "c2", BodyHash: "h2", ExpiresAt: now.Add(-time.Hour)},
+		} {
+			if err := store.SaveIdempotencyKey(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := store.GetIdempotencyKey(ctx, "live")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ContainerID != "c1" || got.BodyHash != "h1" {
+			t.Errorf("got %+v, want the saved key", got)
+		}
+
+		n, err := store.DelExpiredIdempotencyKeys(ctx, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("deleted %d keys, want 1", n)
+		}
+		if _, err := store.GetIdempotencyKey(ctx, "expired"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expired key: got %v, want it swept", err)
+		}
+		if _, err := store.GetIdempotencyKey(ctx, "live"); err != nil {
+			t.Errorf("live key: %v, want it kept", err)
+		}
+	})
+}
+
+func TestKeyLocksSerializeOnlySameKey(t *testing.T) {
+	var locks keyLocks
+	unlock := locks.lock("a")
+
+	other := make(chan struct{})
+	go func() {
+		locks.lock("b")()
+		close(other)
+	}()
+	<-other
+
+	same := make(chan struct{})
+	go func() {
+		locks.lock("a")()
+		close(same)
+	}()
+	select {
+	case <-same:
+		t.Fatal("a second holder took the same key")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	unlock()
+	<-same
+	if len(locks.locks) != 0 {
+		t.Errorf("%d locks left after every holder released, want 0", len(locks.locks))
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// defaultJitter spreads reconcile ticks and heartbeats by up to ±10% so
+// workers started together don't keep hitting the control plane in step.
+const defaultJitter = 0.1
+
+// newJitterRand returns a source seeded per instance, so processes started
+// at the same moment still draw different offsets.
+func newJitterRand() *rand.Rand {
+	return rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+}
+
+// jittered returns base moved by a random amount of up to ±fraction of it.
+func jittered(base time.Duration, fraction float64, rnd *rand.Rand) time.Duration {
+	if fraction <= 0 || base <= 0 {
+		return base
+	}
+	offset := (rnd.Float64()*2 - 1) * fraction * float64(base)
+	return base + time.Duration(offset)
+}
+
+func validateJitter(fraction float64) error {
+	if fraction < 0 || fraction >= 1 {
+		return fmt.Errorf("jitter must be in [0, 1), got %v", fraction)
+	}
+	return nil
+}
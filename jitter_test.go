@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func TestJitteredStaysWithinBoundAndVaries(t *testing.T) {
+	base := 10 * time.Second
+	rnd := rand.New(rand.NewPCG(1, 2))
+
+	seen := make(map[time.Duration]bool)
+	for range 100 {
+		d := jittered(base, 0.1, rnd)
+		if d < 9*time.Second || d > 11*time.Second {
+			t.Fatalf("jittered(%v, 0.1) = %v, outside ±10%%", base, d)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 50 {
+		t.Errorf("got %d distinct intervals in 100 draws, want them to vary", len(seen))
+	}
+}
+
+func TestJitteredWithoutFractionKeepsBase(t *testing.T) {
+	rnd := rand.New(rand.NewPCG(1, 2))
+	if d := jittered(time.Second, 0, rnd); d != time.Second {
+		t.Errorf("jittered with no fraction = %v, want the base", d)
+	}
+}
+
+func TestValidateJitter(t *testing.T) {
+	for _, ok := range []float64{0, 0.1, 0.99} {
+		if err := validateJitter(ok); err != nil {
+			t.Errorf("validateJitter(%v): %v", ok, err)
+		}
+	}
+	for _, bad := range []float64{-0.1, 1, 2} {
+		if err := validateJitter(bad); err == nil {
+			t.Errorf("validateJitter(%v) accepted it", bad)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/galadd/cogsworth/api"
+)
+
+// parseSelector parses a comma-separated list of key=value requirements.
+func parseSelector(value string) (map[string]string, error) {
+	selector := make(map[string]string)
+	for _, term := range splitList(value) {
+		key, val, ok := strings.Cut(term, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid selector term %q, expected key=value", term)
+		}
+		selector[key] = strings.TrimSpace(val)
+	}
+	return selector, nil
+}
+
+// matchesSelector reports whether labels satisfy every term of the selector.
+// An empty selector matches everything.
+func matchesSelector(labels, selector map[string]string) bool {
+	for key, want := range selector {
+		if have, ok := labels[key]; !ok || have != want {
+			return false
+		}
+	}
+	return true
+}
+
+// labelFlag collects repeatable --label key=value flags.
+type labelFlag map[string]string
+
+func (l labelFlag) String() string {
+	return api.FormatSelector(l)
+}
+
+func (l labelFlag) Set(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("invalid label %q, expected key=value", value)
+	}
+	l[key] = val
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	got, err := parseSelector("app=web, tier = frontend,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"app": "web", "tier": "frontend"}; !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{"app", "=web", "app=web,tier"} {
+		if _, err := parseSelector(bad); err == nil {
+			t.Errorf("%q: accepted", bad)
+		}
+	}
+}
+
+func TestMatchesSelector(t *testing.T) {
+	labels := map[string]string{"app": "web", "tier": "frontend"}
+	for _, tc := range []struct {
+		selector map[string]string
+		want     bool
+	}{
+		{nil, true},
+		{map[string]string{"app": "web"}, true},
+		{map[string]string{"app": "web", "tier": "frontend"}, true},
+		{map[string]string{"app": "db"}, false},
+		{map[string]string{"app": "web", "zone": "a"}, false},
+	} {
+		if got := matchesSelector(labels, tc.selector); got != tc.want {
+			t.Errorf("selector %v: got %v, want %v", tc.selector, got, tc.want)
+		}
+	}
+}
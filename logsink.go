@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	defaultLogMaxSizeMB = 10
+	defaultLogMaxFiles  = 5
+)
+
+// rotatingFile appends to path and, once a write would take it past
+// maxSize, shifts it to path.1, path.1 to path.2 and so on, keeping at most
+// maxFiles rotated files.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	f    *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	rf.f = f
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.maxFiles))
+	for i := rf.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	if rf.maxFiles > 0 {
+		if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	} else if err := os.Remove(rf.path); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+
+	return rf.open()
+}
+
+func (rf *rotatingFile) Close() error {
+	return rf.f.Close()
+}
+
+// logForwarder follows the output of every running container on the node
+// into <dir>/<container ID>.log. Forwarding starts with the output produced
+// from then on; history the runtime already holds is not copied.
+type logForwarder struct {
+	runtime  Runtime
+	dir      string
+	maxSize  int64
+	maxFiles int
+
+	mu     sync.Mutex
+	active map[string]*forwardedLog
+
+	// stopping holds the done channels of cancelled forwarders that may
+	// still be writing <id>.log, so a successor waits for the file to be
+	// closed first.
+	stopping map[string]chan struct{}
+}
+
+type forwardedLog struct {
+	runtimeID string
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+func newLogForwarder(runtime Runtime, dir string, maxSizeMB int64, maxFiles int) (*logForwarder, error) {
+	if maxSizeMB <= 0 {
+		return nil, fmt.Errorf("log max size must be positive, got %d MB", maxSizeMB)
+	}
+	if maxFiles < 0 {
+		return nil, fmt.Errorf("log max files must not be negative, got %d", maxFiles)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create log dir: %w", err)
+	}
+
+	return &logForwarder{
+		runtime:  runtime,
+		dir:      dir,
+		maxSize:  maxSizeMB << 20,
+		maxFiles: maxFiles,
+		active:   make(map[string]*forwardedLog),
+		stopping: make(map[string]chan struct{}),
+	}, nil
+}
+
+// Sync forwards the logs of the running containers and stops forwarding
+// for the rest, including runtime containers that have been replaced.
+func (lf *logForwarder) Sync(ctx context.Context, containers []*Container) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	running := make(map[string]string, len(containers))
+	for _, c := range containers {
+		if c.State == Running && c.ContainerID != "" {
+			running[c.ID] = c.ContainerID
+		}
+	}
+
+	for id, done := range lf.stopping {
+		select {
+		case <-done:
+			delete(lf.stopping, id)
+		default:
+		}
+	}
+
+	for id, fwd := range lf.active {
+		select {
+		case <-fwd.done:
+			delete(lf.active, id)
+			continue
+		default:
+		}
+		if running[id] != fwd.runtimeID {
+			fwd.cancel()
+			lf.stopping[id] = fwd.done
+			delete(lf.active, id)
+		}
+	}
+
+	for id, runtimeID := range running {
+		if _, ok := lf.active[id]; ok {
+			continue
+		}
+
+		fwdCtx, cancel := context.WithCancel(ctx)
+		fwd := &forwardedLog{runtimeID: runtimeID, cancel: cancel, done: make(chan struct{})}
+		lf.active[id] = fwd
+		previous := lf.stopping[id]
+		delete(lf.stopping, id)
+		go func() {
+			defer close(fwd.done)
+			defer cancel()
+			// waited for even when cancelled, so done never closes while an
+			// older forwarder still has the file open
+			if previous != nil {
+				<-previous
+			}
+			if fwdCtx.Err() != nil {
+				return
+			}
+			if err := lf.forward(fwdCtx, id, runtimeID); err != nil && fwdCtx.Err() == nil {
+				log.Printf("Log forwarding for %s stopped: %v", id, err)
+			}
+		}()
+	}
+}
+
+func (lf *logForwarder) forward(ctx context.Context, id, runtimeID string) error {
+	stream, err := lf.runtime.LogStream(ctx, runtimeID, 0, true)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	rf, err := openRotatingFile(filepath.Join(lf.dir, id+".log"), lf.maxSize, lf.maxFiles)
+	if err != nil {
+		return err
+	}
+	defer rf.Close()
+
+	_, err = io.Copy(rf, stream)
+	return err
+}
+
+// Stop ends every forwarder and waits for their files to be closed.
+func (lf *logForwarder) Stop() {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	for id, fwd := range lf.active {
+		fwd.cancel()
+		<-fwd.done
+		delete(lf.active, id)
+	}
+	for id, done := range lf.stopping {
+		<-done
+		delete(lf.stopping, id)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c1.log")
+	rf, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		path:        "dddddddd\n",
+		path + ".1": "cccccccc\n",
+		path + ".2": "bbbbbbbb\n",
+	} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), data, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("kept more than 2 rotated files")
+	}
+}
+
+// readLogEventually polls path until it holds want.
+func readLogEventually(t *testing.T, path, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		data, _ := os.ReadFile(path)
+		if string(data) == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s = %q, want %q", filepath.Base(path), data, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestLogForwarderReplacedContainerWaitsForPredecessor(t *testing.T) {
+	runtime := NewFakeRuntime()
+	runtime.SetLogs("rt-1", "one\n")
+	runtime.SetLogs("rt-2", "two\n")
+	dir := t.TempDir()
+	lf, err := newLogForwarder(runtime, dir, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lf.Stop()
+	ctx := context.Background()
+	path := filepath.Join(dir, "c1.log")
+
+	lf.Sync(ctx, []*Container{{ID: "c1", State: Running, ContainerID: "rt-1"}})
+	readLogEventually(t, path, "one\n")
+	old := lf.active["c1"].done
+
+	lf.Sync(ctx, []*Container{{ID: "c1", State: Running, ContainerID: "rt-2"}})
+	readLogEventually(t, path, "one\ntwo\n")
+	select {
+	case <-old:
+	default:
+		t.Errorf("the successor wrote c1.log while the old forwarder was still running")
+	}
+}
+
+func TestLogForwarderStopWaitsForStoppingForwarders(t *testing.T) {
+	runtime := NewFakeRuntime()
+	lf, err := newLogForwarder(runtime, t.TempDir(), 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	lf.Sync(ctx, []*Container{{ID: "c1", State: Running, ContainerID: "rt-1"}})
+	done := lf.active["c1"].done
+	lf.Sync(ctx, nil)
+	lf.Stop()
+
+	select {
+	case <-done:
+	default:
+		t.Errorf("Stop returned before a cancelled forwarder closed its file")
+	}
+	if len(lf.stopping) != 0 || len(lf.active) != 0 {
+		t.Errorf("forwarders left after Stop: %d active, %d stopping", len(lf.active), len(lf.stopping))
+	}
+}
+
+func TestLogForwarderSkipsContainersNotRunning(t *testing.T) {
+	lf, err := newLogForwarder(NewFakeRuntime(), t.TempDir(), 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lf.Stop()
+
+	lf.Sync(context.Background(), []*Container{
+		{ID: "c1", State: Stopped, ContainerID: "rt-1"},
+		{ID: "c2", State: Running},
+	})
+	if len(lf.active) != 0 {
+		t.Errorf("forwarding %d containers, want none", len(lf.active))
+	}
+	if _, err := newLogForwarder(NewFakeRuntime(), t.TempDir(), 0, 1); err == nil || !strings.Contains(err.Error(), "positive") {
+		t.Errorf("accepted a zero max size: %v", err)
+	}
+}
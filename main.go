@@ -1,39 +1,84 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
+	"maps"
 	"net"
-	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/galadd/cogsworth/api"
 )
 
+const defaultControlPlaneURL = "http://localhost:8080"
+
 func main() {
-	usage := `Usage:
+	usage := `Usage: ./cogs [--data-dir <dir>] [--log-level debug] <command>
+
 		./cogs start-control                    Start control plane
-		./cogs start-worker <control-url>       Start worker node
-		./cogs add <image> [host:container]     Add a container
-		./cogs list                             List containers
-		./cogs delete <id>                      Delete a container`
+		./cogs start-worker <control-url> [flags] Start worker node
+		./cogs add <image> [host:container]     Add a container (-p host:container[/proto], --name, --protect, --wait)
+		./cogs apply <file.json>                Submit a JSON list of containers all-or-nothing
+		./cogs apply -f <manifest.json>         Create, update (--prune: delete) to match a manifest
+		./cogs update <id> --image <image>      Roll a container to a new image
+		./cogs pause|unpause <id>               Freeze a container's processes, or resume them
+		./cogs list [--wide] [-o json]          List containers (--state failed,stopped, --selector k=v)
+		./cogs inspect <id> [-o json]           Show a container's details
+		./cogs nodes [-o json]                  List nodes
+		./cogs status [-o json]                 Summarize cluster health
+		./cogs cordon|uncordon <node-id>        Stop or resume scheduling onto a node
+		./cogs node rm <node-id> [--force]      Remove a decommissioned node
+		./cogs node maintenance <node-id>       Cordon and drain a node --for a window (--at <time>)
+		./cogs node containers <node-id>        List containers assigned to a node
+		./cogs logs <id> [--tail N] [-f]        Show or follow a container's output
+		./cogs exec <id> -- <cmd> [args...]     Run a command in a running container
+		./cogs top [--interval 2s]              Show live container resource usage
+		./cogs reconcile [--node <id>]          Reconcile now instead of on the next tick
+		./cogs delete <id>                      Delete a container (--force-protected)
+		./cogs delete --all | --selector k=v    Delete many containers (--yes to skip confirmation)
+		./cogs secret create <name> KEY=VALUE.. Create or replace a secret
+		./cogs service create <name>            Name the containers matching --selector k=v (--port N)
+		./cogs service get|rm <name>            Show a service's endpoints, or remove it
+		./cogs autoscale create <deployment>    Scale a deployment on CPU (--min N --max N --cpu-percent P)
+		./cogs autoscale get|rm <deployment>    Show a deployment's autoscaler, or remove it
+		./cogs scale <deployment> <replicas>    Run that many replicas of a deployment
+		./cogs backup <file>                    Snapshot the cluster state
+		./cogs restore <file>                   Replace the cluster state with a backup
+		./cogs version [-o json]                Show the client and control plane builds`
 
 	examples := `Examples:
 		./cogs start
 		./cogs add nginx:alpine 8080:80
+		./cogs add coredns/coredns 5353:53/udp
+		./cogs add alpine -- sleep 1000
+		./cogs exec cont-abc123 -- ls /etc
 		./cogs list
+		./cogs list --state failed
 		./cogs delete cont-abc123`
 
+	os.Args = append(os.Args[:1], parseGlobalFlags(os.Args[1:])...)
+
 	if len(os.Args) < 2 {
 		fmt.Println("Cogsworth - Container Orchestrator")
 
 		fmt.Println("\n", usage)
 		fmt.Println("\n", examples)
+		os.Exit(1)
 	}
 
 	command := os.Args[1]
@@ -45,14 +90,54 @@ func main() {
 		startWorker()
 	case "add":
 		addContainer()
+	case "reconcile":
+		triggerReconcile()
+	case "node":
+		nodeCommand()
+	case "cordon":
+		cordonNode(false)
+	case "uncordon":
+		cordonNode(true)
+	case "apply":
+		applyContainers()
+	case "update":
+		updateContainer()
+	case "pause":
+		setDesiredState(Paused)
+	case "unpause":
+		setDesiredState(Running)
 	case "list", "ls":
 		listContainers()
+	case "inspect":
+		inspectContainer()
 	case "delete", "rm":
 		deleteContainer()
 	case "nodes":
 		listNodes()
+	case "status":
+		clusterStatus()
+	case "logs":
+		containerLogs()
+	case "exec":
+		execContainer()
+	case "top":
+		topContainers()
 	case "clean":
 		cleanupAll()
+	case "backup":
+		backupStore()
+	case "restore":
+		restoreStore()
+	case "secret":
+		secretCommand()
+	case "service":
+		serviceCommand()
+	case "autoscale":
+		autoscaleCommand()
+	case "scale":
+		scaleCommand()
+	case "version":
+		showVersion()
 	default:
 		fmt.Printf("Unknown command: %s\n\n", command)
 		fmt.Println(usage)
@@ -67,19 +152,70 @@ func startControl() {
 	fmt.Println("Control plane node ID: control-plane-1")
 	fmt.Println("Start Reconciliation loop. Interval: 5s")
 
+	fs := flag.NewFlagSet("start-control", flag.ExitOnError)
+	logRequests := fs.Bool("log-requests", true, "log every API request")
+	requireNodeCapacity := fs.Bool("require-node-capacity", false,
+		"reject workers that register without --cpus and --memory-mb, so every node's resources are bounded")
+	backend := fs.String("store", storeBolt, "state backend: bolt, or memory for throwaway clusters")
+	destroyedRetention := fs.Duration("destroyed-retention", defaultDestroyedRetention,
+		"how long deleted containers are kept for their worker to clean up before the record is dropped")
+	serviceProxy := fs.Bool("service-proxy", false, "listen on each service's proxy port and balance connections over its endpoints")
+	sticky := fs.Bool("sticky-scheduling", false, "place rescheduled containers back on their previous node when it is ready and fits")
+	args := parseInterspersed(fs, os.Args[2:])
+
 	apiAddr := ":8080"
-	if len(os.Args) > 2 {
-		apiAddr = os.Args[2]
+	if len(args) > 0 {
+		apiAddr = args[0]
+	}
+
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := OpenStore(*backend, dbPath())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *backend == storeMemory {
+		fmt.Println("Using the in-memory store, cluster state is lost on exit")
+		unmark, err := markMemoryStore()
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer unmark()
 	}
 
-	cogs, err := NewControlPlane("./cogsworth.db", apiAddr)
+	cogs, err := NewControlPlane(store, apiAddr)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer cogs.store.Close()
 
-	go cogs.apiServer.Start()
-	cogs.reconciler.Start(context.Background())
+	cogs.apiServer.logRequests = *logRequests
+	cogs.apiServer.requireNodeCapacity = *requireNodeCapacity
+	cogs.reconciler.destroyedRetention = *destroyedRetention
+	cogs.scheduler.sticky = *sticky
+	if *serviceProxy {
+		cogs.reconciler.proxy = newServiceProxy()
+		defer cogs.reconciler.proxy.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := cogs.apiServer.Start(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	cogs.reconciler.Start(ctx)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := cogs.apiServer.Shutdown(shutdownCtx); err != nil {
+		log.Printf("API server shutdown error: %v", err)
+	}
 }
 
 func startWorker() {
@@ -90,198 +226,1704 @@ func startWorker() {
 	controlUrl := os.Args[2]
 	nodeID := fmt.Sprintf("worker-%s", generateID())
 
+	fs := flag.NewFlagSet("start-worker", flag.ExitOnError)
+	labelPrefixes := fs.String("image-label-prefixes", strings.Join(defaultImageLabelPrefixes, ","),
+		"comma-separated image label prefixes copied to container annotations")
+	maxConcurrency := fs.Int("max-concurrency", defaultMaxConcurrency, "maximum containers reconciled in parallel")
+	maxConcurrentPulls := fs.Int("max-concurrent-pulls", defaultMaxConcurrentPulls,
+		"maximum containers pulling images and being created at once (0 is unlimited)")
+	actionInterval := fs.Duration("container-action-interval", defaultActionInterval,
+		"once its burst is spent, act on a container at most this often (0 is unlimited)")
+	actionBurst := fs.Int("container-action-burst", defaultActionBurst, "actions a container may take in a row before --container-action-interval applies")
+	nameTemplate := fs.String("name-template", defaultNameTemplate,
+		"runtime container name, using {id}, {shortid} and {image}")
+	workerAddr := fs.String("worker-addr", defaultWorkerAddr, "listen address of the worker agent API, including /healthz and /metrics")
+	cachePath := fs.String("cache-path", filepath.Join(dataDir, workerCacheFile),
+		"local database of assigned containers used while the control plane is down (empty disables)")
+	jitter := fs.Float64("jitter", defaultJitter, "fraction by which reconcile ticks and heartbeats are randomly spread")
+	pullTimeout := fs.Duration("pull-timeout", defaultRuntimeTimeouts.Pull, "give up on an image pull after this long (0 disables)")
+	createTimeout := fs.Duration("create-timeout", defaultRuntimeTimeouts.Create, "give up on a container create after this long (0 disables)")
+	startTimeout := fs.Duration("start-timeout", defaultRuntimeTimeouts.Start, "give up on a container start after this long (0 disables)")
+	stopTimeout := fs.Duration("stop-timeout", defaultRuntimeTimeouts.Stop,
+		"give up on a container stop this long after its grace period (0 disables)")
+	deregister := fs.Bool("deregister", false,
+		"on shutdown stop and remove this node's containers and remove the node, so they are rescheduled at once")
+	maxRestarts := fs.Int("max-restarts", defaultMaxRestarts, "retries of a failing start; the worker gives up on failed start N+1")
+	logDir := fs.String("log-dir", "", "forward container output to rotating files in this directory (empty disables)")
+	logMaxSizeMB := fs.Int64("log-max-size-mb", defaultLogMaxSizeMB, "size at which a forwarded log file is rotated")
+	logMaxFiles := fs.Int("log-max-files", defaultLogMaxFiles, "rotated log files kept per container")
+	zone := fs.String("zone", "", "failure domain of this node; replicas of a deployment are spread across zones")
+	maxContainers := fs.Int("max-containers", 0, "most containers the scheduler places on this node (0 is unlimited)")
+	cpus := fs.Int("cpus", 0, "CPU cores the scheduler may allocate on this node (0 is unlimited)")
+	memoryMB := fs.Int64("memory-mb", 0, "memory in MB the scheduler may allocate on this node (0 is unlimited)")
+	registerTimeout := fs.Duration("register-timeout", defaultRegisterTimeout,
+		"how long to keep retrying registration while the control plane is unreachable (0 retries forever)")
+	fixedNodeID := fs.String("node-id", "", "keep this node ID across restarts instead of a random worker-<id>")
+	fs.Parse(os.Args[3:])
+
+	if *fixedNodeID != "" {
+		nodeID = *fixedNodeID
+	}
+
+	if err := validateJitter(*jitter); err != nil {
+		log.Fatal(err)
+	}
+	if *actionBurst < 1 {
+		log.Fatal("--container-action-burst must be at least 1")
+	}
+
 	cogs, err := NewWorkerNode(nodeID, controlUrl)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer cogs.runtime.Close()
 
+	cogs.runtime = withTimeouts(cogs.runtime, RuntimeTimeouts{
+		Pull:   *pullTimeout,
+		Create: *createTimeout,
+		Start:  *startTimeout,
+		Stop:   *stopTimeout,
+	})
+
+	if *cachePath != "" {
+		if err := os.MkdirAll(filepath.Dir(*cachePath), 0700); err != nil {
+			log.Fatal(err)
+		}
+		cogs.cache, err = NewBoltStore(*cachePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	cogs.reconciler.imageLabelPrefixes = splitList(*labelPrefixes)
+	cogs.reconciler.maxConcurrency = *maxConcurrency
+	cogs.reconciler.maxConcurrentPulls = *maxConcurrentPulls
+	cogs.reconciler.actionInterval = *actionInterval
+	cogs.reconciler.actionBurst = *actionBurst
+	if err := validateNameTemplate(*nameTemplate); err != nil {
+		log.Fatal(err)
+	}
+	cogs.reconciler.nameTemplate = *nameTemplate
+	cogs.reconciler.jitter = *jitter
+	cogs.reconciler.maxRestarts = *maxRestarts
+	if *logDir != "" {
+		cogs.reconciler.logs, err = newLogForwarder(cogs.runtime, *logDir, *logMaxSizeMB, *logMaxFiles)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer cogs.reconciler.logs.Stop()
+	}
+
+	agent := NewWorkerServer(cogs.runtime, *workerAddr)
+	agent.trigger = cogs.reconciler.Trigger
+	agent.reconciled = cogs.reconciler.Reconciled
+	agent.metrics = metricSet{cogs.reconciler.reconcileDuration, cogs.reconciler.panics}
+	go func() {
+		if err := agent.Start(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	address := getLocalIP()
 	node := &Node{
-		ID:      nodeID,
-		Address: getLocalIP(),
-		Role:    Worker,
-		State:   NodeReady,
+		ID:            nodeID,
+		Address:       address,
+		AgentAddr:     agentAddress(address, *workerAddr),
+		Role:          Worker,
+		State:         NodeReady,
+		Zone:          *zone,
+		MaxContainers: *maxContainers,
+		Capacity:      Resources{CPUCores: *cpus, MemoryMB: *memoryMB},
 	}
-	if err := cogs.apiClient.Register(node); err != nil {
-		log.Fatal("Failed to register with control", err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := registerWithRetry(ctx, cogs.apiClient.Register, node, *registerTimeout); err != nil {
+		log.Fatal("Failed to register with control: ", err)
+	}
+	checkControlPlaneVersion(cogs.apiClient)
+
+	if err := pingRuntime(cogs.runtime); err != nil {
+		log.Printf("Warning: %v, the node will report NotReady until it recovers", err)
 	}
 
-	// send heartbeat
+	// send heartbeat, carrying the runtime's health
+	var removed atomic.Bool
 	go func() {
-		ticker := time.NewTicker(1 * time.Second)
-		defer ticker.Stop()
-		for range ticker.C {
-			cogs.apiClient.SendHeartbeat()
+		rnd := newJitterRand()
+		for {
+			// stop once shutdown starts so a deregistered node isn't
+			// registered again below
+			select {
+			case <-time.After(jittered(1*time.Second, *jitter, rnd)):
+			case <-ctx.Done():
+				return
+			}
+			err := cogs.apiClient.SendHeartbeat(pingRuntime(cogs.runtime), cogs.reconciler.Allocated())
+			if errors.Is(err, ErrNodeRemoved) {
+				// an operator ran node rm, registering again would undo it
+				log.Printf("Node %s was removed from the cluster, shutting down", nodeID)
+				removed.Store(true)
+				stop()
+				return
+			}
+			if errors.Is(err, ErrNotFound) {
+				// the control plane lost our record, e.g. after a restore
+				log.Printf("Control plane doesn't know node %s, registering again", nodeID)
+				if err := cogs.apiClient.Register(node); err != nil {
+					log.Printf("Failed to register with control: %v", err)
+				}
+			}
 		}
 	}()
 
-	cogs.reconciler.Start(context.Background())
+	cogs.reconciler.Start(ctx)
+
+	if *deregister && !removed.Load() {
+		deregisterCtx, cancel := context.WithTimeout(context.Background(), deregisterTimeout)
+		if err := cogs.Deregister(deregisterCtx); err != nil {
+			log.Printf("Failed to deregister node %s: %v", nodeID, err)
+		} else {
+			log.Printf("Deregistered node %s", nodeID)
+		}
+		cancel()
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := agent.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Worker agent shutdown error: %v", err)
+	}
+}
+
+const (
+	defaultRegisterTimeout = 2 * time.Minute
+	maxRegisterBackoff     = 10 * time.Second
+)
+
+// registerBackoff is the wait after the first failed registration, doubled
+// on every retry. Tests shorten it.
+var registerBackoff = 500 * time.Millisecond
+
+// registerWithRetry keeps registering with exponential backoff so workers
+// can be started before the control plane. Rejections such as a bad token
+// are returned straight away since retrying won't fix them.
+func registerWithRetry(ctx context.Context, register func(*Node) error, node *Node, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	backoff := registerBackoff
+	for attempt := 1; ; attempt++ {
+		err := register(node)
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("Registered node %s after %d attempts", node.ID, attempt)
+			}
+			return nil
+		}
+		if errors.Is(err, ErrBadRequest) || errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrForbidden) {
+			return err
+		}
+
+		log.Printf("Registering node %s failed (attempt %d): %v, retrying in %s", node.ID, attempt, err, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+		}
+		backoff = min(backoff*2, maxRegisterBackoff)
+	}
+}
+
+func pingRuntime(runtime Runtime) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return runtime.Ping(ctx)
+}
+
+// agentAddress combines the node's reachable IP with the port the agent
+// listens on, since the listen address usually omits the host.
+func agentAddress(ip, listenAddr string) string {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return listenAddr
+	}
+
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = ip
+	}
+	return net.JoinHostPort(host, port)
 }
 
 func addContainer() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: ./cogs add <image> [host:container]")
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	name := fs.String("name", "", "unique name to refer to the container by instead of its ID")
+	protect := fs.Bool("protect", false, "refuse to delete the container without --force-protected")
+	secrets := fs.String("secrets", "", "comma-separated secret names injected as env vars")
+	affinity := fs.String("affinity", "", "prefer nodes running containers labelled key=value[,key=value]")
+	antiAffinity := fs.String("anti-affinity", "", "avoid nodes running containers labelled key=value[,key=value]")
+	group := fs.String("group", "", "schedule onto the same node as the other containers of this group")
+	deployment := fs.String("deployment", "", "deployment this container is a replica of, spread across zones")
+	networkName := fs.String("network", "", "bridge network to attach to (default: cogs-<deployment> for a deployment)")
+	cpus := fs.Int("cpus", 0, "CPU cores requested from the node")
+	memoryMB := fs.Int64("memory-mb", 0, "memory in MB requested from the node")
+	entrypoint := fs.String("entrypoint", "", "override the image entrypoint (space-separated)")
+	alwaysPull := fs.Bool("always-pull", false, "pull the image before every create, even if present")
+	dependsOn := fs.String("depends-on", "", "comma-separated container IDs that must be running first")
+	stopTimeout := fs.Duration("stop-timeout", api.DefaultStopTimeout, "grace period before a stopping container is killed")
+	ttl := fs.Duration("ttl", 0, "destroy the container once it has run this long (0 keeps it)")
+	stopSignal := fs.String("stop-signal", "", "signal sent to stop the container before it is killed (default: the image's)")
+	maxRestarts := fs.Int("max-restarts", 0, "retries of a failing start before giving up on failed start N+1 (0 uses the worker default)")
+	restart := fs.String("restart", RestartAlways, "restart an exited container: always, on-failure or never")
+	readinessPort := fs.Int("readiness-port", 0, "container port that must accept connections before it is ready")
+	readinessPath := fs.String("readiness-path", "", "HTTP path probed on --readiness-port instead of a TCP connect")
+	wait := fs.Bool("wait", false, "wait until the container is running, exiting non-zero if it fails")
+	waitTimeout := fs.Duration("wait-timeout", defaultAddWaitTimeout, "give up waiting with --wait after this long")
+	var portFlags portFlag
+	fs.Var(&portFlags, "p", "publish a port as host:container[/protocol], or :container for a runtime-picked host port (repeatable)")
+	labels := make(labelFlag)
+	fs.Var(labels, "label", "attach a key=value label (repeatable)")
+	envFile := fs.String("env-file", "", "read env vars from a file of KEY=VALUE lines")
+	envVars := make(envFlag)
+	fs.Var(envVars, "e", "set an env var as KEY=VALUE, overriding --env-file (repeatable)")
+	args, command := parseWithTrailing(fs, os.Args[2:])
+
+	if len(args) < 1 {
+		fmt.Println("Usage: ./cogs add <image> [host:container[/proto]] [-p host:container[/proto]]... [--protect] [--secrets a,b] [-- command args...]")
 		os.Exit(1)
 	}
 
-	image := os.Args[2]
+	image := args[0]
 	var ports []PortMapping
 
-	if len(os.Args) >= 4 {
-		parts := strings.Split(os.Args[3], ":")
-		if len(parts) == 2 {
-			host, _ := strconv.Atoi(parts[0])
-			container, _ := strconv.Atoi(parts[1])
+	affinitySelector, err := parseSelector(*affinity)
+	if err != nil {
+		log.Fatal(err)
+	}
+	antiAffinitySelector, err := parseSelector(*antiAffinity)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *stopTimeout < 0 || *stopTimeout%time.Second != 0 {
+		log.Fatalf("--stop-timeout %s must be a whole number of seconds", *stopTimeout)
+	}
 
-			if host == 8080 {
-				fmt.Println("Warning: Port 8080 may conflict with Congsworth Control Plane")
-				fmt.Println("Consider using a different port (e.g., 8081:80)")
-			}
-			ports = []PortMapping{{host, container, "tcp"}}
+	env, err := loadEnv(*envFile, envVars)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the positional form predates -p and is kept for compatibility
+	if len(args) >= 2 {
+		port, err := ParsePortMapping(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		ports = append(ports, port)
+	}
+	ports = append(ports, portFlags...)
+
+	for _, port := range ports {
+		if port.HostPort == 8080 {
+			fmt.Println("Warning: Port 8080 may conflict with Congsworth Control Plane")
+			fmt.Println("Consider using a different port (e.g., 8081:80)")
 		}
 	}
 
+	id, err := GenerateID()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	container := &Container{
-		ID:           generateID(),
+		ID:           id,
+		Name:         *name,
 		Image:        image,
 		State:        Requested,
 		DesiredState: Running,
 		Ports:        ports,
-		Env:          make(map[string]string),
+		Env:          env,
 		CreatedAt:    time.Now(),
 		UpdatedAt:    time.Now(),
 		Scheduled:    false,
 		NodeID:       "",
+		Protected:    *protect,
+		SecretRefs:   splitList(*secrets),
+		StopTimeout:  int(*stopTimeout / time.Second),
+		TTL:          *ttl,
+		MaxRestarts:  *maxRestarts,
+		StopSignal:   strings.ToUpper(*stopSignal),
+		Labels:       labels,
+		DependsOn:    splitList(*dependsOn),
+		Group:        *group,
+		Deployment:   *deployment,
+		Network:      *networkName,
+		Affinity:     affinitySelector,
+		AntiAffinity: antiAffinitySelector,
+		Command:      strings.Fields(*entrypoint),
+		Args:         command,
+		Resources:    Resources{CPUCores: *cpus, MemoryMB: *memoryMB},
+	}
+	if *alwaysPull {
+		container.ImagePullPolicy = PullAlways
+	}
+	if *restart != RestartAlways {
+		container.RestartPolicy = *restart
+	}
+	if *readinessPort != 0 || *readinessPath != "" {
+		container.ReadinessProbe = &ReadinessProbe{Port: *readinessPort, Path: *readinessPath}
 	}
 
-	controlPlaneURL := "http://localhost:8080"
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	if err := client.CreateContainer(container); err != nil {
+		log.Fatal(err)
+	}
 
-	data, err := json.Marshal(container)
-	if err != nil {
-		log.Fatal("Failed to marshal container:", err)
+	fmt.Printf("Added container: %s\n", container.ID)
+	if container.Name != "" {
+		fmt.Printf("Name: %s\n", container.Name)
+	}
+	fmt.Printf("Image: %s\n", image)
+	for _, port := range ports {
+		fmt.Printf("Port: %d:%d/%s\n", port.HostPort, port.ContainerPort, port.Protocol)
 	}
 
-	resp, err := http.Post(
-		controlPlaneURL+"/containers",
-		"application/json",
-		bytes.NewBuffer(data),
-	)
-	if err != nil {
-		log.Fatal("Failed to add container: ", err)
+	if *wait {
+		running, err := waitForRunning(client, container.ID, *waitTimeout, addWaitInterval)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Running on %s (%s)\n", orDash(running.NodeID), orDash(running.IPAddress))
 	}
-	defer resp.Body.Close()
+}
+
+const (
+	defaultAddWaitTimeout = 2 * time.Minute
+	addWaitInterval       = time.Second
+)
+
+// waitForRunning polls the container until it is Running. A Failed
+// container the worker is still retrying is waited on; one it gave up on,
+// or one that exited for good, fails the wait, as does the timeout.
+func waitForRunning(client *APIClient, id string, timeout, interval time.Duration) (*Container, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		c, err := client.GetContainer(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to wait for container %s: %w", id, err)
+		}
+
+		switch {
+		case c.State == Running:
+			return c, nil
+		case c.State == Completed, c.State == Failed && c.DesiredState != Running, c.DesiredState == Destroyed:
+			return nil, fmt.Errorf("container %s is %s: %s", id, c.State, cmp.Or(c.LastError, "it did not start"))
+		}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		log.Fatalf("API error: %s", string(body))
+		if time.Now().After(deadline) {
+			detail := ""
+			if c.LastError != "" {
+				detail = ", last error: " + c.LastError
+			}
+			return nil, fmt.Errorf("container %s is still %s after %s%s", id, c.State, timeout, detail)
+		}
+		time.Sleep(interval)
 	}
+}
 
-	fmt.Printf("Added container: %s\n", container.ID)
-	fmt.Printf("Image: %s\n", image)
-	if len(ports) > 0 {
-		fmt.Printf("Ports: %d:%d\n", ports[0].HostPort, ports[0].ContainerPort)
+func applyContainers() {
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	manifest := fs.String("f", "", "manifest to reconcile the cluster against")
+	key := fs.String("key", defaultApplyKey, "label identifying each manifest entry's container")
+	prune := fs.Bool("prune", false, "delete containers with the key label that are not in the manifest")
+	dryRun := fs.Bool("dry-run", false, "print the plan without changing anything")
+	args := parseInterspersed(fs, os.Args[2:])
+
+	if *manifest != "" {
+		applyManifest(*manifest, *key, *prune, *dryRun)
+		return
+	}
+
+	if len(args) < 1 {
+		fmt.Println("Usage: ./cogs apply <file.json> | -f <manifest.json> [--key name] [--prune] [--dry-run]")
+		os.Exit(1)
+	}
+
+	containers := readSpecs(args[0])
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	results, err := client.CreateContainers(containers)
+	for _, result := range results {
+		if result.Error != "" {
+			fmt.Printf("#%d %s: %s\n", result.Index, cmp.Or(result.ID, "-"), result.Error)
+		} else if err == nil {
+			fmt.Printf("Added container: %s (%s)\n", result.ID, containers[result.Index].Image)
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
 }
 
-func listContainers() {
-	store, err := NewBoltStore("./cogsworth.db")
+// applyManifest makes the cluster match a manifest: missing containers are
+// created, changed ones updated and, with prune, unlisted ones deleted.
+func applyManifest(path, key string, prune, dryRun bool) {
+	manifest := readSpecs(path)
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	current, err := client.ListContainers()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	containers, err := store.ListContainers(context.Background())
+	plan, err := planApply(manifest, current, key, prune)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if len(containers) == 0 {
-		fmt.Println("No containers found")
+	if plan.empty() {
+		fmt.Println("Cluster already matches the manifest")
 		return
 	}
 
-	fmt.Printf("%-20s %-20s %-10s %-10s\n", "ID", "IMAGE", "STATE", "DESIRED")
-	fmt.Println(strings.Repeat("-", 60))
-	for _, c := range containers {
-		fmt.Printf("%-20s %-20s %-10s %-10s\n",
-			c.ID,
-			c.Image,
-			c.State,
-			c.DesiredState,
-		)
+	for _, c := range plan.Create {
+		fmt.Printf("create  %s=%s (%s)\n", key, c.Labels[key], c.Image)
+	}
+	for _, u := range plan.Update {
+		fmt.Printf("update  %s=%s %s: %s\n", key, u.Current.Labels[key], u.Current.ID, strings.Join(u.Changes, ", "))
+	}
+	for _, c := range plan.Prune {
+		fmt.Printf("prune   %s=%s %s\n", key, c.Labels[key], c.ID)
+	}
+	if dryRun {
+		return
 	}
-}
 
-func deleteContainer() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: ./cogs delete <id>")
-		os.Exit(1)
+	if len(plan.Create) > 0 {
+		if _, err := client.CreateContainers(plan.Create); err != nil {
+			log.Fatalf("Failed to create containers: %v", err)
+		}
+	}
+	for _, u := range plan.Update {
+		if _, err := client.UpdateContainer(u.Current.ID, &u.Patch); err != nil {
+			log.Fatalf("Failed to update %s: %v", u.Current.ID, err)
+		}
+	}
+	for _, c := range plan.Prune {
+		// by selector so deletion goes through Destroyed and the worker
+		// cleans up, and protected containers are left alone
+		result, err := client.DeleteContainers(false, map[string]string{key: c.Labels[key]}, false, false)
+		if err != nil {
+			log.Fatalf("Failed to prune %s: %v", c.ID, err)
+		}
+		for _, id := range result.Skipped {
+			fmt.Printf("Skipped protected container %s\n", id)
+		}
 	}
 
-	id := os.Args[2]
+	fmt.Printf("Applied: %d created, %d updated, %d pruned\n", len(plan.Create), len(plan.Update), len(plan.Prune))
+}
 
-	store, err := NewBoltStore("./cogsworth.db")
+// readSpecs loads a JSON list of container specs and fills in their IDs
+// and initial states.
+func readSpecs(path string) []*Container {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	ctx := context.Background()
+	var containers []*Container
+	if err := json.Unmarshal(data, &containers); err != nil {
+		log.Fatalf("Failed to parse %s: %v", path, err)
+	}
 
-	container, err := store.GetContainer(ctx, id)
-	if err != nil {
-		log.Fatalf("Delete Container error: %v", err)
+	if err := prepareSpecs(containers); err != nil {
+		log.Fatal(err)
+	}
+	return containers
+}
+
+func updateContainer() {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	image := fs.String("image", "", "new image for the container")
+	args := parseInterspersed(fs, os.Args[2:])
+
+	if len(args) < 1 || *image == "" {
+		fmt.Println("Usage: ./cogs update <id> --image <image>")
+		os.Exit(1)
 	}
 
-	container.DesiredState = Destroyed
-	err = store.SaveContainer(ctx, container)
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	id := resolveRemoteContainerID(client, args[0])
+
+	container, err := client.UpdateContainer(id, &ContainerPatch{Image: image})
 	if err != nil {
-		log.Fatalf("Delete Container error: %v", err)
+		log.Fatalf("Update Container error: %v", err)
 	}
+
+	fmt.Printf("Updated container: %s\n", container.ID)
+	fmt.Printf("Image: %s (generation %d)\n", container.Image, container.Generation)
 }
 
-func listNodes() {
-	store, err := NewBoltStore("./cogsworth.db")
+// setDesiredState backs pause and unpause. The worker carries the change
+// out on its next reconcile.
+func setDesiredState(state ContainerState) {
+	if len(os.Args) < 3 {
+		fmt.Printf("Usage: ./cogs %s <id>\n", os.Args[1])
+		os.Exit(1)
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	id := resolveRemoteContainerID(client, os.Args[2])
+
+	container, err := client.UpdateContainer(id, &ContainerPatch{DesiredState: &state})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	nodes, err := store.ListNodes(context.Background())
+	fmt.Printf("Container %s desired state: %s\n", container.ID, container.DesiredState)
+}
+
+func listContainers() {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	wide := fs.Bool("wide", false, "also show node, IP address and published ports")
+	stateFlag := fs.String("state", "", "only list containers in these comma-separated states")
+	selectorFlag := fs.String("selector", "", "only list containers whose labels match key=value[,key=value...]")
+	output := addOutputFlag(fs)
+	fs.Parse(os.Args[2:])
+
+	var filter ContainerFilter
+	var err error
+	if filter.States, err = parseStates(*stateFlag); err != nil {
+		log.Fatal(err)
+	}
+	if filter.Selector, err = parseSelector(*selectorFlag); err != nil {
+		log.Fatal(err)
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+
+	containers, err := client.FilterContainers(filter)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if len(nodes) == 0 {
-		fmt.Println("No nodes found")
+	if containers == nil {
+		containers = []*Container{}
+	}
+
+	render(*output, containers, func(w io.Writer) {
+		if len(containers) == 0 {
+			fmt.Fprintln(w, "No containers found")
+			return
+		}
+		writeContainerTable(w, containers, *wide)
+	})
+}
+
+func inspectContainer() {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	output := addOutputFlag(fs)
+	args := parseInterspersed(fs, os.Args[2:])
+
+	if len(args) < 1 {
+		fmt.Println("Usage: ./cogs inspect <id> [-o json|table]")
+		os.Exit(1)
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	id := resolveRemoteContainerID(client, args[0])
+
+	container, err := client.GetContainer(id)
+	if err != nil {
+		log.Fatalf("Inspect Container error: %v", err)
+	}
+
+	render(*output, container, func(w io.Writer) {
+		writeContainerDetail(w, container)
+	})
+}
+
+func writeContainerDetail(w io.Writer, c *Container) {
+	row := func(key string, value any) {
+		fmt.Fprintf(w, "%-16s %v\n", key+":", value)
+	}
+
+	row("ID", c.ID)
+	row("Name", orDash(c.Name))
+	row("Image", c.Image)
+	row("State", c.State)
+	row("Desired", c.DesiredState)
+	row("Ready", c.Ready)
+	if p := c.ReadinessProbe; p != nil {
+		row("Readiness", fmt.Sprintf("%d%s", p.Port, p.Path))
+	}
+	row("Node", orDash(c.NodeID))
+	if c.LastNodeID != "" {
+		row("Last node", c.LastNodeID)
+	}
+	if c.Draining != "" {
+		row("Draining", "stopped for node maintenance, then "+string(c.Draining)+" elsewhere")
+	}
+	row("Group", orDash(c.Group))
+	row("Deployment", orDash(c.Deployment))
+	row("Network", orDash(c.Network))
+	row("Runtime ID", orDash(c.ContainerID))
+	row("IP", orDash(c.IPAddress))
+	row("Ports", orDash(formatPorts(c.Ports)))
+	if u := c.Usage; u != nil {
+		row("Usage", fmt.Sprintf("%.2f%% CPU, %s / %s memory (sampled %s)",
+			u.CPUPercent, formatBytes(u.MemoryBytes), formatBytes(u.MemoryLimit), u.SampledAt.Format(time.RFC3339)))
+	}
+	if len(c.Command) > 0 {
+		row("Command", strings.Join(c.Command, " "))
+	}
+	if len(c.Args) > 0 {
+		row("Args", strings.Join(c.Args, " "))
+	}
+	row("Restarts", c.RestartCount)
+	row("Restart policy", cmp.Or(c.RestartPolicy, RestartAlways))
+	row("Generation", fmt.Sprintf("%d (observed %d)", c.Generation, c.ObservedGeneration))
+	row("Protected", c.Protected)
+	row("Stop timeout", time.Duration(c.StopTimeoutSeconds())*time.Second)
+	row("Stop signal", cmp.Or(c.StopSignal, "image default"))
+	row("Created", c.CreatedAt.Format(time.RFC3339))
+	row("Updated", c.UpdatedAt.Format(time.RFC3339))
+	if c.TTL > 0 {
+		row("TTL", c.TTL)
+	}
+	if !c.StartedAt.IsZero() {
+		row("Started", c.StartedAt.Format(time.RFC3339))
+	}
+	if !c.LastReconcileAt.IsZero() {
+		row("Last reconcile", c.LastReconcileAt.Format(time.RFC3339))
+	}
+	row("Last error", orDash(c.LastError))
+
+	// secret values are never served here, only the names referenced
+	if len(c.SecretRefs) > 0 {
+		row("Secrets", strings.Join(c.SecretRefs, ","))
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(c.Env)) {
+		row("Env", key+"="+c.Env[key])
+	}
+	for _, key := range slices.Sorted(maps.Keys(c.Annotations)) {
+		row("Annotation", key+"="+c.Annotations[key])
+	}
+}
+
+func writeContainerTable(w io.Writer, containers []*Container, wide bool) {
+	if !wide {
+		fmt.Fprintf(w, "%-22s %-20s %-20s %-10s %-10s %-6s\n", "ID", "NAME", "IMAGE", "STATE", "DESIRED", "READY")
+		fmt.Fprintln(w, strings.Repeat("-", 90))
+		for _, c := range containers {
+			fmt.Fprintf(w, "%-22s %-20s %-20s %-10s %-10s %-6t\n",
+				c.ID,
+				orDash(c.Name),
+				c.Image,
+				c.State,
+				c.DesiredState,
+				c.Ready,
+			)
+		}
 		return
 	}
 
-	fmt.Printf("%-30s %-15s %-10s %-10s\n", "ID", "ADDRESS", "ROLE", "STATE")
-	fmt.Println(strings.Repeat("-", 65))
-	for _, node := range nodes {
-		fmt.Printf("%-30s %-15s %-10s %-10s\n",
-			node.ID,
-			node.Address,
-			node.Role,
-			node.State,
+	fmt.Fprintf(w, "%-22s %-20s %-20s %-10s %-10s %-6s %-20s %-15s %-20s %s\n",
+		"ID", "NAME", "IMAGE", "STATE", "DESIRED", "READY", "NODE", "IP", "PORTS", "LAST ERROR")
+	fmt.Fprintln(w, strings.Repeat("-", 178))
+	for _, c := range containers {
+		fmt.Fprintf(w, "%-22s %-20s %-20s %-10s %-10s %-6t %-20s %-15s %-20s %s\n",
+			c.ID,
+			orDash(c.Name),
+			c.Image,
+			c.State,
+			c.DesiredState,
+			c.Ready,
+			orDash(c.NodeID),
+			orDash(c.IPAddress),
+			orDash(formatPorts(c.Ports)),
+			orDash(truncate(c.LastError, 40)),
 		)
 	}
 }
 
-func cleanupAll() {
-	store, err := NewBoltStore("./cogsworth.db")
+func truncate(value string, n int) string {
+	if len(value) <= n {
+		return value
+	}
+	return value[:n-3] + "..."
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func containerLogs() {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	tail := fs.Int("tail", 100, "number of lines to show from the end of the logs")
+	follow := fs.Bool("follow", false, "keep streaming new output")
+	fs.BoolVar(follow, "f", false, "shorthand for --follow")
+	args := parseInterspersed(fs, os.Args[2:])
+
+	if len(args) < 1 {
+		fmt.Println("Usage: ./cogs logs <id> [--tail N] [-f]")
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	id := resolveRemoteContainerID(client, args[0])
+
+	logs, err := client.StreamLogs(ctx, id, *tail, *follow)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Logs error: %v", err)
 	}
-	defer store.Close()
+	defer logs.Close()
 
-	ctx := context.Background()
+	if _, err := io.Copy(os.Stdout, logs); err != nil && ctx.Err() == nil {
+		log.Fatalf("Logs error: %v", err)
+	}
+}
+
+func execContainer() {
+	fs := flag.NewFlagSet("exec", flag.ExitOnError)
+	args, cmd := parseWithTrailing(fs, os.Args[2:])
+
+	if len(args) < 1 || len(cmd) == 0 {
+		fmt.Println("Usage: ./cogs exec <id> -- <cmd> [args...]")
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	id := resolveRemoteContainerID(client, args[0])
+
+	output, err := client.Exec(ctx, id, cmd)
+	if errors.Is(err, ErrConflict) {
+		log.Fatalf("Exec error: container %s is not running", id)
+	}
+	if err != nil {
+		log.Fatalf("Exec error: %v", err)
+	}
+	defer output.Close()
+
+	if _, err := io.Copy(os.Stdout, output); err != nil && ctx.Err() == nil {
+		log.Fatalf("Exec error: %v", err)
+	}
+}
+
+func topContainers() {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	interval := fs.Duration("interval", 2*time.Second, "refresh interval")
+	fs.Parse(os.Args[2:])
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		containers, err := client.ListContainers()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// clear the screen and home the cursor before redrawing
+		fmt.Print("\033[H\033[2J")
+		fmt.Printf("%-20s %-20s %-20s %8s %12s %12s\n", "ID", "IMAGE", "NODE", "CPU%", "MEMORY", "LIMIT")
+		fmt.Println(strings.Repeat("-", 97))
+		for _, c := range containers {
+			if c.State != Running {
+				continue
+			}
+
+			stats, err := client.GetContainerStats(c.ID)
+			if err != nil {
+				fmt.Printf("%-20s %-20s %-20s %s\n", c.ID, c.Image, c.NodeID, err)
+				continue
+			}
+
+			fmt.Printf("%-20s %-20s %-20s %7.2f%% %12s %12s\n",
+				c.ID,
+				c.Image,
+				c.NodeID,
+				stats.CPUPercent,
+				formatBytes(stats.MemoryBytes),
+				formatBytes(stats.MemoryLimit),
+			)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func deleteContainer() {
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	forceProtected := fs.Bool("force-protected", false, "delete even if the container is protected")
+	all := fs.Bool("all", false, "delete every container")
+	selectorFlag := fs.String("selector", "", "only delete containers matching key=value[,key=value]")
+	yes := fs.Bool("yes", false, "don't ask for confirmation of a bulk delete")
+	args := parseInterspersed(fs, os.Args[2:])
+
+	if *all || *selectorFlag != "" {
+		deleteContainers(*all, *selectorFlag, *forceProtected, *yes)
+		return
+	}
+
+	if len(args) < 1 {
+		fmt.Println("Usage: ./cogs delete <id> [--force-protected]")
+		fmt.Println("       ./cogs delete --all | --selector key=value [--yes] [--force-protected]")
+		os.Exit(1)
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	id := resolveRemoteContainerID(client, args[0])
+
+	if err := client.DeleteContainer(id, *forceProtected); err != nil {
+		log.Fatalf("Delete Container error: %v", err)
+	}
+	fmt.Printf("Deleting container: %s\n", id)
+}
+
+func deleteContainers(all bool, selectorFlag string, forceProtected, yes bool) {
+	selector, err := parseSelector(selectorFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+
+	if !yes {
+		preview, err := client.DeleteContainers(all, selector, forceProtected, true)
+		if err != nil {
+			log.Fatalf("Delete Container error: %v", err)
+		}
+
+		if len(preview.Destroyed) == 0 {
+			fmt.Println("No matching containers")
+			return
+		}
+
+		if !confirm(fmt.Sprintf("Delete %d container(s)?", len(preview.Destroyed))) {
+			fmt.Println("Aborted")
+			return
+		}
+	}
+
+	result, err := client.DeleteContainers(all, selector, forceProtected, false)
+	if err != nil {
+		log.Fatalf("Delete Container error: %v", err)
+	}
+
+	for _, id := range result.Destroyed {
+		fmt.Printf("Deleting container: %s\n", id)
+	}
+	for _, id := range result.Skipped {
+		fmt.Printf("Skipping protected or depended-on container: %s\n", id)
+	}
+}
+
+// confirm asks a yes/no question on the terminal, defaulting to no.
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N]: ", question)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func triggerReconcile() {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	nodeID := fs.String("node", "", "reconcile this worker instead of the control plane")
+	fs.Parse(os.Args[2:])
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	if err := client.TriggerReconcile(*nodeID); err != nil {
+		log.Fatalf("Reconcile error: %v", err)
+	}
+
+	if *nodeID != "" {
+		fmt.Printf("Reconcile triggered on %s\n", *nodeID)
+		return
+	}
+	fmt.Println("Reconcile triggered")
+}
+
+func nodeCommand() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: ./cogs node rm <node-id> [--force]")
+		fmt.Println("       ./cogs node containers <node-id> [-o json] [--wide]")
+		fmt.Println("       ./cogs node maintenance <node-id> [--at <time>] --for <duration> | --cancel")
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "rm":
+		removeNode()
+	case "containers":
+		listNodeContainers()
+	case "maintenance":
+		scheduleMaintenance()
+	default:
+		fmt.Println("Usage: ./cogs node rm <node-id> [--force]")
+		fmt.Println("       ./cogs node containers <node-id> [-o json] [--wide]")
+		fmt.Println("       ./cogs node maintenance <node-id> [--at <time>] --for <duration> | --cancel")
+		os.Exit(1)
+	}
+}
+
+func removeNode() {
+	fs := flag.NewFlagSet("node rm", flag.ExitOnError)
+	force := fs.Bool("force", false, "remove the node even if it still runs containers")
+	args := parseInterspersed(fs, os.Args[3:])
+
+	if len(args) < 1 {
+		fmt.Println("Usage: ./cogs node rm <node-id> [--force]")
+		os.Exit(1)
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	if err := client.DeleteNode(args[0], *force); err != nil {
+		log.Fatalf("Remove Node error: %v", err)
+	}
+
+	fmt.Printf("Removed node: %s\n", args[0])
+}
+
+func scheduleMaintenance() {
+	fs := flag.NewFlagSet("node maintenance", flag.ExitOnError)
+	at := fs.String("at", "now", "when the window starts, as RFC 3339 (2006-01-02T15:04:05Z07:00) or now")
+	duration := fs.Duration("for", 0, "how long the node stays cordoned and drained")
+	cancel := fs.Bool("cancel", false, "cancel the node's window, uncordoning it if the window cordoned it")
+	args := parseInterspersed(fs, os.Args[3:])
+
+	if len(args) < 1 || (!*cancel && *duration <= 0) {
+		fmt.Println("Usage: ./cogs node maintenance <node-id> [--at <time>] --for <duration> | --cancel")
+		os.Exit(1)
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	if *cancel {
+		if _, err := client.SetNodeMaintenance(args[0], nil); err != nil {
+			log.Fatalf("Cancel maintenance error: %v", err)
+		}
+		fmt.Printf("Maintenance of node %s cancelled\n", args[0])
+		return
+	}
+
+	start := time.Now()
+	if *at != "now" {
+		var err error
+		if start, err = time.Parse(time.RFC3339, *at); err != nil {
+			log.Fatalf("Invalid --at %q, expected RFC 3339 or now", *at)
+		}
+	}
+
+	node, err := client.SetNodeMaintenance(args[0], &MaintenanceWindow{Start: start, Duration: *duration})
+	if err != nil {
+		log.Fatalf("Schedule maintenance error: %v", err)
+	}
+	fmt.Printf("Node %s will be cordoned and drained from %s until %s\n", node.ID,
+		node.Maintenance.Start.Local().Format(time.RFC3339), node.Maintenance.End().Local().Format(time.RFC3339))
+}
+
+func listNodeContainers() {
+	fs := flag.NewFlagSet("node containers", flag.ExitOnError)
+	wide := fs.Bool("wide", false, "also show node, IP address and published ports")
+	output := addOutputFlag(fs)
+	args := parseInterspersed(fs, os.Args[3:])
+
+	if len(args) < 1 {
+		fmt.Println("Usage: ./cogs node containers <node-id> [-o json] [--wide]")
+		os.Exit(1)
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	containers, err := client.ListNodeContainers(args[0])
+	if err != nil {
+		log.Fatalf("List node containers error: %v", err)
+	}
+
+	render(*output, containers, func(w io.Writer) {
+		if len(containers) == 0 {
+			fmt.Fprintf(w, "No containers assigned to node %s\n", args[0])
+			return
+		}
+		writeContainerTable(w, containers, *wide)
+	})
+}
+
+// cordonNode toggles whether new containers may be scheduled on a node.
+// Unlike a drain, containers already on the node keep running.
+func cordonNode(schedulable bool) {
+	if len(os.Args) < 3 {
+		fmt.Printf("Usage: ./cogs %s <node-id>\n", os.Args[1])
+		os.Exit(1)
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	node, err := client.SetNodeSchedulable(os.Args[2], schedulable)
+	if err != nil {
+		log.Fatalf("%s error: %v", os.Args[1], err)
+	}
+
+	if node.Unschedulable {
+		fmt.Printf("Node %s cordoned, existing containers keep running\n", node.ID)
+		return
+	}
+	fmt.Printf("Node %s uncordoned\n", node.ID)
+}
+
+func listNodes() {
+	fs := flag.NewFlagSet("nodes", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "shorthand for --output json")
+	output := addOutputFlag(fs)
+	fs.Parse(os.Args[2:])
+
+	if *asJSON {
+		*output = outputJSON
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	nodes, err := client.ListNodes()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	render(*output, nodes, func(w io.Writer) {
+		writeNodeTable(w, nodes)
+	})
+}
+
+func writeNodeTable(w io.Writer, nodes []*NodeSummary) {
+	if len(nodes) == 0 {
+		fmt.Fprintln(w, "No nodes found")
+		return
+	}
+
+	fmt.Fprintf(w, "%-30s %-15s %-14s %-12s %-18s %-8s %-14s %-10s\n",
+		"ID", "ADDRESS", "ROLE", "ZONE", "STATE", "CPU", "MEMORY(MB)", "CONTAINERS")
+	fmt.Fprintln(w, strings.Repeat("-", 128))
+	for _, node := range nodes {
+		fmt.Fprintf(w, "%-30s %-15s %-14s %-12s %-18s %-8s %-14s %-10s\n",
+			node.ID,
+			node.Address,
+			node.Role,
+			orDash(node.Zone),
+			nodeStatus(node.Node),
+			fmt.Sprintf("%d/%d", node.Allocated.CPUCores, node.Capacity.CPUCores),
+			fmt.Sprintf("%d/%d", node.Allocated.MemoryMB, node.Capacity.MemoryMB),
+			formatNodeContainers(node),
+		)
+	}
+}
+
+func formatNodeContainers(node *NodeSummary) string {
+	if node.MaxContainers > 0 {
+		return fmt.Sprintf("%d/%d (%d running)", node.Containers, node.MaxContainers, node.Running)
+	}
+	return fmt.Sprintf("%d (%d running)", node.Containers, node.Running)
+}
+
+func clusterStatus() {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "shorthand for --output json")
+	output := addOutputFlag(fs)
+	fs.Parse(os.Args[2:])
+
+	if *asJSON {
+		*output = outputJSON
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	status, err := client.GetStatus()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	render(*output, status, func(w io.Writer) {
+		writeClusterStatus(w, status)
+	})
+}
+
+// versionReport is what cogs version prints. ControlPlane is nil when it
+// could not be reached.
+type versionReport struct {
+	Client       VersionInfo  `json:"client"`
+	ControlPlane *VersionInfo `json:"control_plane,omitempty"`
+}
+
+func showVersion() {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	output := addOutputFlag(fs)
+	fs.Parse(os.Args[2:])
+
+	report := versionReport{Client: currentVersion()}
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	info, err := client.Version()
+	if err != nil {
+		log.Printf("Control plane unreachable: %v", err)
+	}
+	report.ControlPlane = info
+
+	render(*output, report, func(w io.Writer) {
+		writeVersionInfo(w, "Client", report.Client)
+		if report.ControlPlane != nil {
+			writeVersionInfo(w, "Control plane", *report.ControlPlane)
+		}
+	})
+}
+
+func writeVersionInfo(w io.Writer, title string, info VersionInfo) {
+	fmt.Fprintf(w, "%s:\n", title)
+	fmt.Fprintf(w, "  Version:     %s\n", info.Version)
+	fmt.Fprintf(w, "  Commit:      %s\n", orDash(info.Commit))
+	fmt.Fprintf(w, "  Built:       %s\n", orDash(info.BuildDate))
+	fmt.Fprintf(w, "  API version: %s\n", info.APIVersion)
+	fmt.Fprintf(w, "  Go version:  %s\n", info.GoVersion)
+}
+
+func writeClusterStatus(w io.Writer, status *ClusterStatus) {
+	fmt.Fprintf(w, "Control plane: up %s (since %s)\n", time.Duration(status.UptimeSeconds)*time.Second, status.StartedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "Nodes:         %d (%d ready, %d not ready)\n", status.Nodes, status.ReadyNodes, status.NotReadyNodes)
+	fmt.Fprintf(w, "CPU:           %d/%d cores allocated\n", status.Allocated.CPUCores, status.Capacity.CPUCores)
+	fmt.Fprintf(w, "Memory:        %d/%d MB allocated\n", status.Allocated.MemoryMB, status.Capacity.MemoryMB)
+	fmt.Fprintf(w, "Containers:    %d\n", status.Containers)
+
+	states := slices.Sorted(maps.Keys(status.ByState))
+	for _, state := range states {
+		fmt.Fprintf(w, "  %-12s %d\n", state, status.ByState[state])
+	}
+}
+
+func nodeStatus(node *Node) string {
+	switch {
+	case node.Maintenance != nil && node.Maintenance.Active(time.Now()):
+		// cordoned as well, which would overflow the column
+		return string(node.State) + ",maintenance"
+	case node.Unschedulable:
+		return string(node.State) + ",cordoned"
+	}
+	return string(node.State)
+}
+
+func cleanupAll() {
+	fs := flag.NewFlagSet("clean", flag.ExitOnError)
+	forceProtected := fs.Bool("force-protected", false, "also delete protected containers")
+	fs.Parse(os.Args[2:])
+
+	store, err := openSharedStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
 
 	containers, _ := store.ListContainers(ctx)
 	for _, c := range containers {
+		if c.Protected && !*forceProtected {
+			fmt.Printf("Skipping protected container: %s\n", c.ID)
+			continue
+		}
+
 		c.DesiredState = Destroyed
+		c.UpdatedAt = time.Now()
 		_ = store.SaveContainer(ctx, c)
 	}
 }
 
+// resolveContainerID expands a container name or ID prefix to the full ID.
+// An exact ID or name always wins; otherwise the prefix must match exactly
+// one container.
+func resolveContainerID(prefix string, containers []*Container) (string, error) {
+	var matches []string
+	for _, c := range containers {
+		if c.ID == prefix || (prefix == claimedName(c) && prefix != "") {
+			return c.ID, nil
+		}
+		if strings.HasPrefix(c.ID, prefix) {
+			matches = append(matches, c.ID)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no container matches %q", prefix)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("container ID %q is ambiguous, candidates: %s", prefix, strings.Join(matches, ", "))
+	}
+}
+
+func resolveRemoteContainerID(client *APIClient, prefix string) string {
+	containers, err := client.ListContainers()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	id, err := resolveContainerID(prefix, containers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return id
+}
+
+func backupStore() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: ./cogs backup <file>")
+		os.Exit(1)
+	}
+	path := os.Args[2]
+
+	store, err := openSharedStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// write beside the target and rename so a failed backup never leaves a
+	// truncated file behind
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = store.Backup(context.Background(), f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		log.Fatalf("Backup error: %v", err)
+	}
+
+	fmt.Printf("Backed up cluster state to %s\n", path)
+}
+
+func restoreStore() {
+	if len(os.Args) < 3 {
+		fmt.Println("Usage: ./cogs restore <file>")
+		os.Exit(1)
+	}
+
+	f, err := os.Open(os.Args[2])
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := checkSharedStore(); err != nil {
+		log.Fatal(err)
+	}
+	if err := RestoreBoltStore(dbPath(), f); err != nil {
+		log.Fatalf("Restore error: %v", err)
+	}
+
+	fmt.Printf("Restored cluster state from %s\n", os.Args[2])
+}
+
+// parseInterspersed parses flags that may appear before, between or after
+// positional arguments and returns the positionals in order.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	positional, _ := parseWithTrailing(fs, args)
+	return positional
+}
+
+// parseWithTrailing is parseInterspersed for commands that pass everything
+// after a "--" through untouched, such as a container's command line.
+func parseWithTrailing(fs *flag.FlagSet, args []string) (positional, trailing []string) {
+	if i := slices.Index(args, "--"); i >= 0 {
+		args, trailing = args[:i], args[i+1:]
+	}
+	return parseFlags(fs, args), trailing
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func secretCommand() {
+	if len(os.Args) < 5 || os.Args[2] != "create" {
+		fmt.Println("Usage: ./cogs secret create <name> KEY=VALUE...")
+		os.Exit(1)
+	}
+
+	name := os.Args[3]
+	data := make(map[string]string)
+	for _, pair := range os.Args[4:] {
+		// report only the key so a malformed entry never echoes a value
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			log.Fatalf("Invalid secret entry %q, expected KEY=VALUE", key)
+		}
+		data[key] = value
+	}
+
+	store, err := openSharedStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	secret := &Secret{
+		Name:      name,
+		Data:      data,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	if existing, err := store.GetSecret(context.Background(), name); err == nil {
+		secret.CreatedAt = existing.CreatedAt
+	}
+
+	if err := store.SaveSecret(context.Background(), secret); err != nil {
+		log.Fatalf("Create secret error: %v", err)
+	}
+
+	fmt.Printf("Saved secret: %s (%d keys, version %d)\n", name, len(data), secret.Version)
+}
+
+const serviceUsage = `Usage: ./cogs service create <name> --selector key=value[,key=value] [--port N] [--proxy-port N]
+       ./cogs service get <name> [-o json]
+       ./cogs service ls [-o json]
+       ./cogs service rm <name>`
+
+func serviceCommand() {
+	if len(os.Args) < 3 {
+		fmt.Println(serviceUsage)
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "create":
+		createService()
+	case "get":
+		getService()
+	case "ls", "list":
+		listServices()
+	case "rm":
+		removeService()
+	default:
+		fmt.Println(serviceUsage)
+		os.Exit(1)
+	}
+}
+
+func createService() {
+	fs := flag.NewFlagSet("service create", flag.ExitOnError)
+	selectorFlag := fs.String("selector", "", "labels the service's containers carry, key=value[,key=value]")
+	port := fs.Int("port", 0, "container port the service's endpoints serve on")
+	proxyPort := fs.Int("proxy-port", 0, "port the control plane's --service-proxy balances over the endpoints on")
+	args := parseInterspersed(fs, os.Args[3:])
+
+	if len(args) < 1 {
+		fmt.Println(serviceUsage)
+		os.Exit(1)
+	}
+
+	selector, err := parseSelector(*selectorFlag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	svc, err := client.SaveService(&Service{Name: args[0], Selector: selector, Port: *port, ProxyPort: *proxyPort})
+	if err != nil {
+		log.Fatalf("Create service error: %v", err)
+	}
+
+	fmt.Printf("Saved service: %s (%d endpoints)\n", svc.Name, len(svc.Endpoints))
+}
+
+func getService() {
+	fs := flag.NewFlagSet("service get", flag.ExitOnError)
+	output := addOutputFlag(fs)
+	args := parseInterspersed(fs, os.Args[3:])
+
+	if len(args) < 1 {
+		fmt.Println(serviceUsage)
+		os.Exit(1)
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	svc, err := client.GetService(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	render(*output, svc, func(w io.Writer) {
+		fmt.Fprintf(w, "Service:   %s\n", svc.Name)
+		fmt.Fprintf(w, "Selector:  %s\n", api.FormatSelector(svc.Selector))
+		if svc.ProxyPort != 0 {
+			fmt.Fprintf(w, "Proxy:     :%d\n", svc.ProxyPort)
+		}
+		if len(svc.Endpoints) == 0 {
+			fmt.Fprintln(w, "Endpoints: none")
+			return
+		}
+		fmt.Fprintln(w, "Endpoints:")
+		fmt.Fprintf(w, "  %-22s %-20s %s\n", "CONTAINER", "NODE", "ADDRESS")
+		for _, ep := range svc.Endpoints {
+			addr := ep.IPAddress
+			if ep.Port != 0 {
+				addr = net.JoinHostPort(ep.IPAddress, strconv.Itoa(ep.Port))
+			}
+			fmt.Fprintf(w, "  %-22s %-20s %s\n", ep.ContainerID, orDash(ep.NodeID), addr)
+		}
+	})
+}
+
+func listServices() {
+	fs := flag.NewFlagSet("service ls", flag.ExitOnError)
+	output := addOutputFlag(fs)
+	fs.Parse(os.Args[3:])
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	services, err := client.ListServices()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if services == nil {
+		services = []*Service{}
+	}
+
+	render(*output, services, func(w io.Writer) {
+		if len(services) == 0 {
+			fmt.Fprintln(w, "No services found")
+			return
+		}
+		fmt.Fprintf(w, "%-20s %-30s %-6s %s\n", "NAME", "SELECTOR", "PORT", "ENDPOINTS")
+		fmt.Fprintln(w, strings.Repeat("-", 68))
+		for _, svc := range services {
+			port := "-"
+			if svc.Port != 0 {
+				port = strconv.Itoa(svc.Port)
+			}
+			fmt.Fprintf(w, "%-20s %-30s %-6s %d\n", svc.Name, api.FormatSelector(svc.Selector), port, len(svc.Endpoints))
+		}
+	})
+}
+
+func removeService() {
+	if len(os.Args) < 4 {
+		fmt.Println(serviceUsage)
+		os.Exit(1)
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	if err := client.DeleteService(os.Args[3]); err != nil {
+		log.Fatalf("Remove service error: %v", err)
+	}
+
+	fmt.Printf("Removed service: %s\n", os.Args[3])
+}
+
+const autoscaleUsage = `Usage: ./cogs autoscale create <deployment> --min N --max N --cpu-percent P [--cooldown 3m]
+       ./cogs autoscale get <deployment> [-o json]
+       ./cogs autoscale ls [-o json]
+       ./cogs autoscale rm <deployment>`
+
+func autoscaleCommand() {
+	if len(os.Args) < 3 {
+		fmt.Println(autoscaleUsage)
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "create":
+		createAutoscaler()
+	case "get":
+		getAutoscaler()
+	case "ls", "list":
+		listAutoscalers()
+	case "rm":
+		removeAutoscaler()
+	default:
+		fmt.Println(autoscaleUsage)
+		os.Exit(1)
+	}
+}
+
+func createAutoscaler() {
+	fs := flag.NewFlagSet("autoscale create", flag.ExitOnError)
+	minReplicas := fs.Int("min", 1, "fewest replicas to scale down to")
+	maxReplicas := fs.Int("max", 0, "most replicas to scale up to")
+	cpuPercent := fs.Float64("cpu-percent", 0, "average CPU percent to hold the replicas at")
+	cooldown := fs.Duration("cooldown", 0, "least time between two scale changes (default 3m)")
+	args := parseInterspersed(fs, os.Args[3:])
+
+	if len(args) < 1 {
+		fmt.Println(autoscaleUsage)
+		os.Exit(1)
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	hpa, err := client.SaveAutoscaler(&HorizontalAutoscaler{
+		Deployment:       args[0],
+		MinReplicas:      *minReplicas,
+		MaxReplicas:      *maxReplicas,
+		TargetCPUPercent: *cpuPercent,
+		Cooldown:         *cooldown,
+	})
+	if err != nil {
+		log.Fatalf("Create autoscaler error: %v", err)
+	}
+
+	fmt.Printf("Saved autoscaler: %s (%d-%d replicas, target CPU %.1f%%)\n", hpa.Deployment, hpa.MinReplicas, hpa.MaxReplicas, hpa.TargetCPUPercent)
+}
+
+func getAutoscaler() {
+	fs := flag.NewFlagSet("autoscale get", flag.ExitOnError)
+	output := addOutputFlag(fs)
+	args := parseInterspersed(fs, os.Args[3:])
+
+	if len(args) < 1 {
+		fmt.Println(autoscaleUsage)
+		os.Exit(1)
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	hpa, err := client.GetAutoscaler(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	render(*output, hpa, func(w io.Writer) {
+		fmt.Fprintf(w, "Deployment:  %s\n", hpa.Deployment)
+		fmt.Fprintf(w, "Replicas:    %d (min %d, max %d)\n", hpa.Replicas, hpa.MinReplicas, hpa.MaxReplicas)
+		fmt.Fprintf(w, "CPU:         %.1f%% (target %.1f%%)\n", hpa.CurrentCPUPercent, hpa.TargetCPUPercent)
+		fmt.Fprintf(w, "Cooldown:    %s\n", cmp.Or(hpa.Cooldown, defaultAutoscaleCooldown))
+		if !hpa.LastScaleAt.IsZero() {
+			fmt.Fprintf(w, "Last scaled: %s\n", hpa.LastScaleAt.Format(time.RFC3339))
+		}
+	})
+}
+
+func listAutoscalers() {
+	fs := flag.NewFlagSet("autoscale ls", flag.ExitOnError)
+	output := addOutputFlag(fs)
+	fs.Parse(os.Args[3:])
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	autoscalers, err := client.ListAutoscalers()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if autoscalers == nil {
+		autoscalers = []*HorizontalAutoscaler{}
+	}
+
+	render(*output, autoscalers, func(w io.Writer) {
+		if len(autoscalers) == 0 {
+			fmt.Fprintln(w, "No autoscalers found")
+			return
+		}
+		fmt.Fprintf(w, "%-20s %-9s %-5s %-5s %-8s %s\n", "DEPLOYMENT", "REPLICAS", "MIN", "MAX", "CPU", "TARGET")
+		fmt.Fprintln(w, strings.Repeat("-", 60))
+		for _, hpa := range autoscalers {
+			fmt.Fprintf(w, "%-20s %-9d %-5d %-5d %-8s %.1f%%\n", hpa.Deployment, hpa.Replicas, hpa.MinReplicas, hpa.MaxReplicas,
+				fmt.Sprintf("%.1f%%", hpa.CurrentCPUPercent), hpa.TargetCPUPercent)
+		}
+	})
+}
+
+func removeAutoscaler() {
+	if len(os.Args) < 4 {
+		fmt.Println(autoscaleUsage)
+		os.Exit(1)
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	if err := client.DeleteAutoscaler(os.Args[3]); err != nil {
+		log.Fatalf("Remove autoscaler error: %v", err)
+	}
+
+	fmt.Printf("Removed autoscaler: %s\n", os.Args[3])
+}
+
+func scaleCommand() {
+	if len(os.Args) < 4 {
+		fmt.Println("Usage: ./cogs scale <deployment> <replicas>")
+		os.Exit(1)
+	}
+	replicas, err := strconv.Atoi(os.Args[3])
+	if err != nil || replicas < 0 {
+		log.Fatalf("Invalid replica count %q", os.Args[3])
+	}
+
+	client := NewAPIClient(defaultControlPlaneURL, "")
+	scale, err := client.Scale(os.Args[2], replicas)
+	if err != nil {
+		log.Fatalf("Scale error: %v", err)
+	}
+
+	fmt.Printf("Scaled deployment %s to %d replicas\n", scale.Deployment, scale.Replicas)
+	if scale.Replicas != replicas {
+		fmt.Printf("Protected replicas kept it above %d\n", replicas)
+	}
+}
+
 func getLocalIP() string {
 	conn, err := net.Dial("udp", "8.8.8.8:80")
 	if err != nil {
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWideContainerTableShowsAddressAndPorts(t *testing.T) {
+	containers := []*Container{
+		{ID: "c1", Image: "nginx", State: Running, DesiredState: Running, NodeID: "w1", IPAddress: "172.17.0.5",
+			Ports: []PortMapping{{HostPort: 8081, ContainerPort: 80, Protocol: "tcp"}, {HostPort: 53, ContainerPort: 53, Protocol: "udp"}}},
+		{ID: "c2", Image: "redis", State: Requested, DesiredState: Running},
+	}
+
+	var buf bytes.Buffer
+	writeContainerTable(&buf, containers, true)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want a header, a rule and two rows:\n%s", len(lines), buf.String())
+	}
+	for _, column := range []string{"NODE", "IP", "PORTS"} {
+		if !strings.Contains(lines[0], column) {
+			t.Errorf("header %q lacks %s", lines[0], column)
+		}
+	}
+	for _, want := range []string{"w1", "172.17.0.5", "8081:80/tcp,53:53/udp"} {
+		if !strings.Contains(lines[2], want) {
+			t.Errorf("row %q lacks %s", lines[2], want)
+		}
+	}
+	if fields := strings.Fields(lines[3]); fields[6] != "-" || fields[7] != "-" || fields[8] != "-" {
+		t.Errorf("unplaced row %q, want dashes for node, IP and ports", lines[3])
+	}
+
+	buf.Reset()
+	writeContainerTable(&buf, containers, false)
+	if strings.Contains(buf.String(), "172.17.0.5") {
+		t.Errorf("narrow table shows the IP:\n%s", buf.String())
+	}
+}
+
+func TestResolveContainerID(t *testing.T) {
+	containers := []*Container{
+		{ID: "cont-ab12", DesiredState: Running},
+		{ID: "cont-ab1234", Name: "web", DesiredState: Running},
+		{ID: "cont-cd56", DesiredState: Running},
+		{ID: "cont-ef78", Name: "old", DesiredState: Destroyed},
+	}
+
+	for _, tc := range []struct {
+		ref, want string
+	}{
+		{"cont-ab12", "cont-ab12"}, // exact, though it prefixes another ID
+		{"cont-c", "cont-cd56"},
+		{"cont-ab123", "cont-ab1234"},
+		{"web", "cont-ab1234"},
+	} {
+		got, err := resolveContainerID(tc.ref, containers)
+		if err != nil || got != tc.want {
+			t.Errorf("%q: got %q, %v, want %q", tc.ref, got, err, tc.want)
+		}
+	}
+
+	if _, err := resolveContainerID("cont-", containers); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("ambiguous prefix: got %v", err)
+	}
+	for _, ref := range []string{"cont-zz", "old"} {
+		if _, err := resolveContainerID(ref, containers); err == nil || !strings.Contains(err.Error(), "no container") {
+			t.Errorf("%q: got %v, want no match", ref, err)
+		}
+	}
+}
+
+func TestParseWithTrailingLeavesContainerFlags(t *testing.T) {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	entrypoint := fs.String("entrypoint", "", "")
+	node := fs.String("node", "", "")
+
+	positional, trailing := parseWithTrailing(fs, []string{"nginx", "--node", "w1", "--entrypoint", "/bin/sh", "--", "-c", "--node", "x"})
+	if !slices.Equal(positional, []string{"nginx"}) {
+		t.Errorf("positional = %q, want [nginx]", positional)
+	}
+	if *node != "w1" || *entrypoint != "/bin/sh" {
+		t.Errorf("node %q entrypoint %q, want the flags before --", *node, *entrypoint)
+	}
+	if !slices.Equal(trailing, []string{"-c", "--node", "x"}) {
+		t.Errorf("trailing = %q, want everything after -- untouched", trailing)
+	}
+}
+
+// fastRegisterBackoff shortens the registration backoff for the test.
+func fastRegisterBackoff(t *testing.T) {
+	old := registerBackoff
+	registerBackoff = time.Millisecond
+	t.Cleanup(func() { registerBackoff = old })
+}
+
+func TestRegisterWithRetrySucceedsAfterFailures(t *testing.T) {
+	fastRegisterBackoff(t)
+
+	attempts := 0
+	register := func(*Node) error {
+		attempts++
+		if attempts < 4 {
+			return fmt.Errorf("connection refused")
+		}
+		return nil
+	}
+	if err := registerWithRetry(context.Background(), register, &Node{ID: "w1"}, time.Minute); err != nil {
+		t.Fatalf("registerWithRetry: %v", err)
+	}
+	if attempts != 4 {
+		t.Errorf("made %d attempts, want 4", attempts)
+	}
+}
+
+func TestRegisterWithRetryStopsOnRejection(t *testing.T) {
+	fastRegisterBackoff(t)
+
+	attempts := 0
+	register := func(*Node) error {
+		attempts++
+		return ErrUnauthorized
+	}
+	if err := registerWithRetry(context.Background(), register, &Node{ID: "w1"}, time.Minute); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("got %v, want ErrUnauthorized", err)
+	}
+	if attempts != 1 {
+		t.Errorf("made %d attempts, want a rejection not to be retried", attempts)
+	}
+}
+
+func TestRegisterWithRetryGivesUpAtTimeout(t *testing.T) {
+	fastRegisterBackoff(t)
+
+	unreachable := errors.New("connection refused")
+	err := registerWithRetry(context.Background(), func(*Node) error { return unreachable }, &Node{ID: "w1"}, 20*time.Millisecond)
+	if !errors.Is(err, unreachable) {
+		t.Errorf("got %v, want the last failure after the timeout", err)
+	}
+}
+
+// fakeContainerAPI serves GET /containers/c1 with each of states in turn,
+// repeating the last, and counts the polls.
+func fakeContainerAPI(t *testing.T, states ...*Container) (*APIClient, *atomic.Int32) {
+	t.Helper()
+	var polls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, "/containers/c1") {
+			writeError(w, http.StatusNotFound, codeNotFound, "not found")
+			return
+		}
+		n := int(polls.Add(1))
+		writeData(w, http.StatusOK, states[min(n, len(states))-1])
+	}))
+	t.Cleanup(srv.Close)
+	return NewAPIClient(srv.URL, ""), &polls
+}
+
+func TestWaitForRunningReturnsOnceRunning(t *testing.T) {
+	client, polls := fakeContainerAPI(t,
+		&Container{ID: "c1", State: Requested, DesiredState: Running},
+		&Container{ID: "c1", State: Failed, DesiredState: Running, LastError: "pull failed"},
+		&Container{ID: "c1", State: Running, DesiredState: Running, IPAddress: "172.17.0.2"})
+
+	c, err := waitForRunning(client, "c1", time.Minute, time.Millisecond)
+	if err != nil {
+		t.Fatalf("waitForRunning: %v", err)
+	}
+	if c.State != Running || c.IPAddress != "172.17.0.2" {
+		t.Errorf("got %+v, want the running container", c)
+	}
+	if polls.Load() != 3 {
+		t.Errorf("polled %d times, want a retried failure waited on and 3 polls", polls.Load())
+	}
+}
+
+func TestWaitForRunningFailsWhenGivenUp(t *testing.T) {
+	client, _ := fakeContainerAPI(t,
+		&Container{ID: "c1", State: Requested, DesiredState: Running},
+		&Container{ID: "c1", State: Failed, DesiredState: Stopped, LastError: "exec format error"})
+
+	_, err := waitForRunning(client, "c1", time.Minute, time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "exec format error") {
+		t.Errorf("got %v, want the container's last error", err)
+	}
+}
+
+func TestWaitForRunningTimesOut(t *testing.T) {
+	client, _ := fakeContainerAPI(t, &Container{ID: "c1", State: Requested, DesiredState: Running})
+
+	_, err := waitForRunning(client, "c1", 10*time.Millisecond, time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "still requested") {
+		t.Errorf("got %v, want a timeout", err)
+	}
+}
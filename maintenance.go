@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+// errMaintenanceChanged aborts a maintenance write when the node's window
+// was moved or cancelled since the nodes were listed.
+var errMaintenanceChanged = errors.New("maintenance window changed")
+
+// runMaintenance cordons nodes inside their maintenance window and drains
+// them, and uncordons them once the window has passed. Draining takes two
+// passes per container: it is first stopped where it runs, so it never
+// runs twice, and then unscheduled with its old desired state back, for
+// SchedulePending to place elsewhere. A container still draining from a
+// node whose window was cancelled is left where it is.
+//
+// Nodes are written with UpdateNode, and the window checked again there,
+// so a heartbeat, cordon or cancel saved since the listing isn't lost.
+func (r *Reconciler) runMaintenance(ctx context.Context, containers []*Container, now time.Time) {
+	nodes, err := r.cogsworth.store.ListNodes(ctx)
+	if err != nil {
+		log.Printf("Failed to list nodes for maintenance: %v", err)
+		return
+	}
+
+	ready := make(map[string]bool, len(nodes))
+	draining := make(map[string]bool)
+	for _, node := range nodes {
+		ready[node.ID] = node.State == NodeReady
+
+		m := node.Maintenance
+		switch {
+		case m == nil:
+			continue
+
+		case m.Active(now):
+			// checked even once cordoned, so a cancelled window stops the drain
+			cordoned := false
+			_, err := r.cogsworth.store.UpdateNode(ctx, node.ID, func(n *Node) error {
+				if n.Maintenance == nil || !n.Maintenance.Active(now) {
+					return errMaintenanceChanged
+				}
+				cordoned = !n.Unschedulable
+				if cordoned {
+					n.Unschedulable = true
+					n.Maintenance.Cordoned = true
+				}
+				return nil
+			})
+			if errors.Is(err, errMaintenanceChanged) {
+				continue
+			}
+			if err != nil {
+				log.Printf("Failed to cordon node %s for maintenance: %v", node.ID, err)
+				continue
+			}
+			if cordoned {
+				log.Printf("Node %s entered its maintenance window until %s, cordoned", node.ID, m.End().Format(time.RFC3339))
+			}
+			draining[node.ID] = true
+			r.drainNode(ctx, node, containers, now)
+
+		case !now.Before(m.End()):
+			_, err := r.cogsworth.store.UpdateNode(ctx, node.ID, func(n *Node) error {
+				if n.Maintenance == nil || now.Before(n.Maintenance.End()) {
+					return errMaintenanceChanged
+				}
+				if n.Maintenance.Cordoned {
+					n.Unschedulable = false
+				}
+				n.Maintenance = nil
+				return nil
+			})
+			if errors.Is(err, errMaintenanceChanged) {
+				continue
+			}
+			if err != nil {
+				log.Printf("Failed to end maintenance of node %s: %v", node.ID, err)
+				continue
+			}
+			log.Printf("Node %s left its maintenance window", node.ID)
+		}
+	}
+
+	for _, c := range containers {
+		if c.Draining == "" {
+			continue
+		}
+		switch {
+		case c.DesiredState != Stopped:
+			// changed by hand since, which wins over the drain
+			c.Draining = ""
+		case !draining[c.NodeID]:
+			// the window was cancelled or is over; it stays on its node
+			c.DesiredState, c.Draining = c.Draining, ""
+		case c.State == Stopped || c.State == Failed || c.ContainerID == "" || !ready[c.NodeID]:
+			c.LastNodeID = c.NodeID
+			c.NodeID = ""
+			c.ContainerID = ""
+			c.Scheduled = false
+			c.DesiredState, c.Draining = c.Draining, ""
+		default:
+			continue
+		}
+
+		c.UpdatedAt = now
+		if err := r.cogsworth.store.SaveContainer(ctx, c); err != nil {
+			log.Printf("Failed to move drained container %s: %v", c.ID, err)
+		}
+	}
+}
+
+// drainNode stops every container on node that should be running, noting
+// the desired state it had.
+func (r *Reconciler) drainNode(ctx context.Context, node *Node, containers []*Container, now time.Time) {
+	for _, c := range containers {
+		if c.NodeID != node.ID || !c.Scheduled || c.Draining != "" {
+			continue
+		}
+		// a finished job has nothing left to move
+		if (c.DesiredState != Running && c.DesiredState != Paused) || c.State == Completed {
+			continue
+		}
+
+		c.Draining = c.DesiredState
+		c.DesiredState = Stopped
+		c.UpdatedAt = now
+		if err := r.cogsworth.store.SaveContainer(ctx, c); err != nil {
+			log.Printf("Failed to drain container %s from node %s: %v", c.ID, node.ID, err)
+			continue
+		}
+		log.Printf("Draining container %s from node %s", c.ID, node.ID)
+	}
+}
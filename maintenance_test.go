@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// maintain runs one maintenance pass at now over the stored containers and
+// returns the node and container c1 as it left them.
+func maintain(t *testing.T, r *Reconciler, store *MemStore, now time.Time) (*Node, *Container) {
+	t.Helper()
+	ctx := context.Background()
+
+	containers, err := store.ListContainers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.runMaintenance(ctx, containers, now)
+
+	node, err := store.GetNode(ctx, "w1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return node, c
+}
+
+func TestMaintenanceCordonsDrainsAndUncordons(t *testing.T) {
+	r, store, _ := newTestReconciler(t)
+	ctx := context.Background()
+	start := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+
+	node := workerNode("w1")
+	node.Maintenance = &MaintenanceWindow{Start: start, Duration: time.Hour}
+	if err := store.SaveNode(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+	mustSave(t, store, &Container{ID: "c1", Image: "nginx", State: Running, DesiredState: Running,
+		NodeID: "w1", Scheduled: true, ContainerID: "rt-1"})
+
+	node, c := maintain(t, r, store, start.Add(-time.Minute))
+	if node.Unschedulable || c.DesiredState != Running {
+		t.Fatalf("before the window: cordoned %v, container wants %s", node.Unschedulable, c.DesiredState)
+	}
+
+	node, c = maintain(t, r, store, start)
+	if !node.Unschedulable || !node.Maintenance.Cordoned {
+		t.Errorf("inside the window: cordoned %v, want the node cordoned", node.Unschedulable)
+	}
+	if c.DesiredState != Stopped || c.Draining != Running {
+		t.Errorf("inside the window: container wants %s draining %q, want it stopped to drain", c.DesiredState, c.Draining)
+	}
+
+	// once the worker has stopped it, it is handed back to the scheduler
+	c.State = Stopped
+	mustSave(t, store, c)
+	_, c = maintain(t, r, store, start.Add(30*time.Minute))
+	if c.Scheduled || c.NodeID != "" || c.LastNodeID != "w1" || c.DesiredState != Running {
+		t.Errorf("drained container: scheduled %v on %q (last %q) wanting %s, want it unscheduled and running again",
+			c.Scheduled, c.NodeID, c.LastNodeID, c.DesiredState)
+	}
+
+	node, _ = maintain(t, r, store, start.Add(time.Hour))
+	if node.Unschedulable || node.Maintenance != nil {
+		t.Errorf("after the window: cordoned %v, window %+v, want it uncordoned and cleared", node.Unschedulable, node.Maintenance)
+	}
+}
+
+func TestMaintenanceKeepsManualCordon(t *testing.T) {
+	r, store, _ := newTestReconciler(t)
+	ctx := context.Background()
+	start := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+
+	node := workerNode("w1")
+	node.Unschedulable = true
+	node.Maintenance = &MaintenanceWindow{Start: start, Duration: time.Hour}
+	if err := store.SaveNode(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+	mustSave(t, store, &Container{ID: "c1", Image: "nginx", State: Stopped, DesiredState: Stopped, NodeID: "w1", Scheduled: true})
+
+	maintain(t, r, store, start)
+	node, _ = maintain(t, r, store, start.Add(time.Hour))
+	if !node.Unschedulable || node.Maintenance != nil {
+		t.Errorf("after the window: cordoned %v, window %+v, want the manual cordon kept", node.Unschedulable, node.Maintenance)
+	}
+}
+
+// listHookStore runs afterList once the next ListNodes has returned, to
+// land a write between the reconciler's listing and its own writes.
+type listHookStore struct {
+	*MemStore
+	afterList func()
+}
+
+func (s *listHookStore) ListNodes(ctx context.Context) ([]*Node, error) {
+	nodes, err := s.MemStore.ListNodes(ctx)
+	if hook := s.afterList; hook != nil {
+		s.afterList = nil
+		hook()
+	}
+	return nodes, err
+}
+
+func TestMaintenanceCancelledDuringDrain(t *testing.T) {
+	r, store, _ := newTestReconciler(t)
+	ctx := context.Background()
+	start := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+
+	node := workerNode("w1")
+	node.Maintenance = &MaintenanceWindow{Start: start, Duration: time.Hour}
+	if err := store.SaveNode(ctx, node); err != nil {
+		t.Fatal(err)
+	}
+	mustSave(t, store, &Container{ID: "c1", Image: "nginx", State: Running, DesiredState: Running,
+		NodeID: "w1", Scheduled: true, ContainerID: "rt-1"})
+
+	if _, c := maintain(t, r, store, start); c.Draining != Running {
+		t.Fatalf("container is not draining: wants %s", c.DesiredState)
+	}
+
+	// the operator cancels the window right after the next pass lists nodes
+	hooked := &listHookStore{MemStore: store, afterList: func() {
+		if _, err := store.UpdateNode(ctx, "w1", func(n *Node) error {
+			if n.Maintenance.Cordoned {
+				n.Unschedulable = false
+			}
+			n.Maintenance = nil
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}}
+	r.cogsworth.store = hooked
+
+	node, c := maintain(t, r, store, start.Add(10*time.Minute))
+	if node.Unschedulable || node.Maintenance != nil {
+		t.Errorf("node cordoned %v with window %+v, want the cancel kept", node.Unschedulable, node.Maintenance)
+	}
+	if c.DesiredState != Running || c.Draining != "" || c.NodeID != "w1" {
+		t.Errorf("container wants %s on %q (draining %q), want it back to running where it was", c.DesiredState, c.NodeID, c.Draining)
+	}
+}
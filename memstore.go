@@ -0,0 +1,685 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// MemStore is an in-memory Store backed by maps: the memory backend, and
+// the store tests run against. Records are stored as JSON so callers get
+// the same copy semantics as BoltStore.
+type MemStore struct {
+	mu          sync.RWMutex
+	containers  map[string][]byte
+	nodes       map[string][]byte
+	secrets     map[string][]byte
+	services    map[string][]byte
+	autoscalers map[string][]byte
+	idempotency map[string][]byte
+
+	// names maps container names to the IDs holding them.
+	names map[string]string
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{
+		containers:  make(map[string][]byte),
+		nodes:       make(map[string][]byte),
+		secrets:     make(map[string][]byte),
+		services:    make(map[string][]byte),
+		autoscalers: make(map[string][]byte),
+		idempotency: make(map[string][]byte),
+		names:       make(map[string]string),
+	}
+}
+
+func (s *MemStore) SaveContainer(ctx context.Context, c *Container) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var prev *Container
+	if existing, ok := s.containers[c.ID]; ok {
+		prev = &Container{}
+		if err := json.Unmarshal(existing, prev); err != nil {
+			return fmt.Errorf("failed to unmarshal container: %w", err)
+		}
+	}
+
+	if err := checkTransition(prev, c); err != nil {
+		return err
+	}
+	if err := checkVersion(prev, c); err != nil {
+		return err
+	}
+
+	version := c.ResourceVersion
+	c.ResourceVersion = nextVersion(prev)
+	data, err := json.Marshal(c)
+	if err != nil {
+		c.ResourceVersion = version
+		return fmt.Errorf("failed to marshal container: %w", err)
+	}
+	if err := indexName(s.names, prev, c); err != nil {
+		c.ResourceVersion = version
+		return err
+	}
+
+	s.containers[c.ID] = data
+	return nil
+}
+
+func (s *MemStore) SaveContainers(ctx context.Context, cs []*Container) (err error) {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// check and encode everything before the first write so a bad entry
+	// leaves the store untouched; a container listed twice is checked
+	// against its earlier entry, as it is within a bolt transaction
+	names := maps.Clone(s.names)
+	encoded := make([][]byte, len(cs))
+	staged := make(map[string][]byte, len(cs))
+	versions := make([]int64, len(cs))
+	for i, c := range cs {
+		versions[i] = c.ResourceVersion
+	}
+	defer func() {
+		if err != nil {
+			for i, c := range cs {
+				c.ResourceVersion = versions[i]
+			}
+		}
+	}()
+
+	for i, c := range cs {
+		var prev *Container
+		existing, ok := staged[c.ID]
+		if !ok {
+			existing, ok = s.containers[c.ID]
+		}
+		if ok {
+			prev = &Container{}
+			if err := json.Unmarshal(existing, prev); err != nil {
+				return fmt.Errorf("failed to unmarshal container: %w", err)
+			}
+		}
+
+		if err := checkTransition(prev, c); err != nil {
+			return err
+		}
+		if err := checkVersion(prev, c); err != nil {
+			return err
+		}
+		if err := indexName(names, prev, c); err != nil {
+			return err
+		}
+
+		c.ResourceVersion = nextVersion(prev)
+		data, err := json.Marshal(c)
+		if err != nil {
+			return fmt.Errorf("failed to marshal container: %w", err)
+		}
+		encoded[i] = data
+		staged[c.ID] = data
+	}
+
+	for i, c := range cs {
+		s.containers[c.ID] = encoded[i]
+	}
+	s.names = names
+	return nil
+}
+
+func (s *MemStore) GetContainer(ctx context.Context, id string) (*Container, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.containers[id]
+	if !ok {
+		return nil, fmt.Errorf("container %s %w", id, ErrNotFound)
+	}
+
+	container := &Container{}
+	if err := json.Unmarshal(data, container); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal container: %w", err)
+	}
+
+	return container, nil
+}
+
+func (s *MemStore) GetContainerByName(ctx context.Context, name string) (*Container, error) {
+	s.mu.RLock()
+	id, ok := s.names[name]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("container named %s %w", name, ErrNotFound)
+	}
+	return s.GetContainer(ctx, id)
+}
+
+// indexName is the MemStore counterpart of indexContainerName.
+func indexName(names map[string]string, prev, c *Container) error {
+	release, claim := claimedName(prev), claimedName(c)
+	if release == claim {
+		return nil
+	}
+
+	if claim != "" {
+		if owner, ok := names[claim]; ok && owner != c.ID {
+			return fmt.Errorf("%w: container name %q is taken by %s", ErrNameInUse, claim, owner)
+		}
+		names[claim] = c.ID
+	}
+	if release != "" && names[release] == prev.ID {
+		delete(names, release)
+	}
+	return nil
+}
+
+func (s *MemStore) ListContainers(ctx context.Context) ([]*Container, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var containers []*Container
+	for _, id := range sortedKeys(s.containers) {
+		data := s.containers[id]
+		var container Container
+		if err := json.Unmarshal(data, &container); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal container: %w", err)
+		}
+		containers = append(containers, &container)
+	}
+
+	return containers, nil
+}
+
+func (s *MemStore) ListContainersPage(ctx context.Context, cursor string, limit int) ([]*Container, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var containers []*Container
+	for _, id := range sortedKeys(s.containers) {
+		if id < cursor {
+			continue
+		}
+
+		if len(containers) == limit {
+			return containers, id, nil
+		}
+
+		var container Container
+		if err := json.Unmarshal(s.containers[id], &container); err != nil {
+			return nil, "", fmt.Errorf("failed to unmarshal container: %w", err)
+		}
+		containers = append(containers, &container)
+	}
+
+	return containers, "", nil
+}
+
+func (s *MemStore) DelContainer(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.containers[id]; ok {
+		var prev Container
+		if err := json.Unmarshal(existing, &prev); err != nil {
+			return fmt.Errorf("failed to unmarshal container: %w", err)
+		}
+		indexName(s.names, &prev, nil)
+	}
+
+	delete(s.containers, id)
+	return nil
+}
+
+func (s *MemStore) SaveNode(ctx context.Context, n *Node) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("failed to marshal node: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nodes[n.ID] = data
+	return nil
+}
+
+func (s *MemStore) GetNode(ctx context.Context, id string) (*Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.nodes[id]
+	if !ok {
+		return nil, fmt.Errorf("node %s %w", id, ErrNotFound)
+	}
+
+	node := &Node{}
+	if err := json.Unmarshal(data, node); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal node: %w", err)
+	}
+
+	return node, nil
+}
+
+func (s *MemStore) UpdateNode(ctx context.Context, id string, update func(n *Node) error) (*Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.nodes[id]
+	if !ok {
+		return nil, fmt.Errorf("node %s %w", id, ErrNotFound)
+	}
+
+	node := &Node{}
+	if err := json.Unmarshal(data, node); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal node: %w", err)
+	}
+	if err := update(node); err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal node: %w", err)
+	}
+	s.nodes[id] = data
+	return node, nil
+}
+
+func (s *MemStore) ListNodes(ctx context.Context) ([]*Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var nodes []*Node
+	for _, id := range sortedKeys(s.nodes) {
+		data := s.nodes[id]
+		var node Node
+		if err := json.Unmarshal(data, &node); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal node: %w", err)
+		}
+		nodes = append(nodes, &node)
+	}
+
+	return nodes, nil
+}
+
+func (s *MemStore) DelNode(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.nodes, id)
+	return nil
+}
+
+func (s *MemStore) SaveSecret(ctx context.Context, secret *Secret) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var prev Secret
+	if existing, ok := s.secrets[secret.Name]; ok {
+		if err := json.Unmarshal(existing, &prev); err != nil {
+			return fmt.Errorf("failed to unmarshal secret: %w", err)
+		}
+	}
+	secret.Version = prev.Version + 1
+
+	data, err := json.Marshal(secret)
+	if err != nil {
+		return fmt.Errorf("failed to marshal secret: %w", err)
+	}
+
+	s.secrets[secret.Name] = data
+	return nil
+}
+
+func (s *MemStore) GetSecret(ctx context.Context, name string) (*Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.secrets[name]
+	if !ok {
+		return nil, fmt.Errorf("secret %s %w", name, ErrNotFound)
+	}
+
+	secret := &Secret{}
+	if err := json.Unmarshal(data, secret); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal secret: %w", err)
+	}
+
+	return secret, nil
+}
+
+func (s *MemStore) DelSecret(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.secrets, name)
+	return nil
+}
+
+func (s *MemStore) SaveService(ctx context.Context, svc *Service) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(svc)
+	if err != nil {
+		return fmt.Errorf("failed to marshal service: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.services[svc.Name] = data
+	return nil
+}
+
+func (s *MemStore) GetService(ctx context.Context, name string) (*Service, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.services[name]
+	if !ok {
+		return nil, fmt.Errorf("service %s %w", name, ErrNotFound)
+	}
+
+	svc := &Service{}
+	if err := json.Unmarshal(data, svc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal service: %w", err)
+	}
+	return svc, nil
+}
+
+func (s *MemStore) ListServices(ctx context.Context) ([]*Service, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var services []*Service
+	for _, name := range sortedKeys(s.services) {
+		var svc Service
+		if err := json.Unmarshal(s.services[name], &svc); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal service: %w", err)
+		}
+		services = append(services, &svc)
+	}
+	return services, nil
+}
+
+func (s *MemStore) DelService(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.services, name)
+	return nil
+}
+
+func (s *MemStore) SaveAutoscaler(ctx context.Context, hpa *HorizontalAutoscaler) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(hpa)
+	if err != nil {
+		return fmt.Errorf("failed to marshal autoscaler: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.autoscalers[hpa.Deployment] = data
+	return nil
+}
+
+func (s *MemStore) GetAutoscaler(ctx context.Context, deployment string) (*HorizontalAutoscaler, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.autoscalers[deployment]
+	if !ok {
+		return nil, fmt.Errorf("autoscaler for %s %w", deployment, ErrNotFound)
+	}
+
+	hpa := &HorizontalAutoscaler{}
+	if err := json.Unmarshal(data, hpa); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal autoscaler: %w", err)
+	}
+	return hpa, nil
+}
+
+func (s *MemStore) ListAutoscalers(ctx context.Context) ([]*HorizontalAutoscaler, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var autoscalers []*HorizontalAutoscaler
+	for _, deployment := range sortedKeys(s.autoscalers) {
+		var hpa HorizontalAutoscaler
+		if err := json.Unmarshal(s.autoscalers[deployment], &hpa); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal autoscaler: %w", err)
+		}
+		autoscalers = append(autoscalers, &hpa)
+	}
+	return autoscalers, nil
+}
+
+func (s *MemStore) DelAutoscaler(ctx context.Context, deployment string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.autoscalers, deployment)
+	return nil
+}
+
+func (s *MemStore) SaveIdempotencyKey(ctx context.Context, key *IdempotencyKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal idempotency key: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.idempotency[key.Key] = data
+	return nil
+}
+
+func (s *MemStore) GetIdempotencyKey(ctx context.Context, key string) (*IdempotencyKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, ok := s.idempotency[key]
+	if !ok {
+		return nil, fmt.Errorf("idempotency key %s %w", key, ErrNotFound)
+	}
+
+	record := &IdempotencyKey{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal idempotency key: %w", err)
+	}
+	return record, nil
+}
+
+func (s *MemStore) DelExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := 0
+	for key, data := range s.idempotency {
+		var record IdempotencyKey
+		if err := json.Unmarshal(data, &record); err != nil {
+			return deleted, fmt.Errorf("failed to unmarshal idempotency key: %w", err)
+		}
+		if !now.Before(record.ExpiresAt) {
+			delete(s.idempotency, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Backup writes the in-memory state to w in the same bbolt format as
+// BoltStore, so a backup of either can be restored with RestoreBoltStore.
+func (s *MemStore) Backup(ctx context.Context, w io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp("", "cogsworth-backup-*.db")
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	db, err := bbolt.Open(tmp.Name(), 0600, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open backup db: %w", err)
+	}
+	defer db.Close()
+
+	s.mu.RLock()
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for name, records := range map[string]map[string][]byte{
+			string(containersBucket):      s.containers,
+			string(nodesBucket):           s.nodes,
+			string(secretsBucket):         s.secrets,
+			string(servicesBucket):        s.services,
+			string(autoscalersBucket):     s.autoscalers,
+			string(idempotencyKeysBucket): s.idempotency,
+		} {
+			bucket, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+			for key, value := range records {
+				if err := bucket.Put([]byte(key), value); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	s.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to copy records: %w", err)
+	}
+
+	return db.View(func(tx *bbolt.Tx) error {
+		if _, err := tx.WriteTo(w); err != nil {
+			return fmt.Errorf("failed to write backup: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *MemStore) Close() error {
+	return nil
+}
+
+// sortedKeys mirrors bbolt's byte-ordered iteration so MemStore lists in the
+// same order as BoltStore.
+func sortedKeys(m map[string][]byte) []string {
+	return slices.Sorted(maps.Keys(m))
+}
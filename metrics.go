@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reconcile outcomes used to label cogs_container_reconcile_duration_seconds.
+const (
+	outcomeRecreated = "recreated"
+	outcomeStarted   = "started"
+	outcomeStopped   = "stopped"
+	outcomeDestroyed = "destroyed"
+	outcomeNoop      = "noop"
+	outcomeError     = "error"
+)
+
+// defaultDurationBuckets are the Prometheus client's default buckets, in
+// seconds.
+var defaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram is a Prometheus-style histogram with a single label. It is
+// small enough to keep the client library out of the build.
+type histogram struct {
+	name    string
+	help    string
+	label   string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(name, help, label string, buckets []float64) *histogram {
+	return &histogram{
+		name:    name,
+		help:    help,
+		label:   label,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+}
+
+func (h *histogram) Observe(value string, d time.Duration) {
+	seconds := d.Seconds()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[value]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[value] = s
+	}
+
+	if i, _ := slices.BinarySearch(h.buckets, seconds); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += seconds
+	s.count++
+}
+
+// WriteTo writes the histogram in the Prometheus text exposition format.
+func (h *histogram) WriteTo(w io.Writer) (int64, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	fmt.Fprintf(cw, "# HELP %s %s\n", h.name, h.help)
+	fmt.Fprintf(cw, "# TYPE %s histogram\n", h.name)
+
+	for _, value := range slices.Sorted(maps.Keys(h.series)) {
+		s := h.series[value]
+
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(cw, "%s_bucket{%s=%q,le=%q} %d\n",
+				h.name, h.label, value, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(cw, "%s_bucket{%s=%q,le=\"+Inf\"} %d\n", h.name, h.label, value, s.count)
+		fmt.Fprintf(cw, "%s_sum{%s=%q} %g\n", h.name, h.label, value, s.sum)
+		fmt.Fprintf(cw, "%s_count{%s=%q} %d\n", h.name, h.label, value, s.count)
+	}
+
+	return cw.n, cw.err
+}
+
+// counter is an unlabelled Prometheus counter.
+type counter struct {
+	name string
+	help string
+	n    atomic.Uint64
+}
+
+func newCounter(name, help string) *counter {
+	return &counter{name: name, help: help}
+}
+
+func (c *counter) Inc() {
+	c.n.Add(1)
+}
+
+func (c *counter) Value() uint64 {
+	return c.n.Load()
+}
+
+// WriteTo writes the counter in the Prometheus text exposition format.
+func (c *counter) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	fmt.Fprintf(cw, "# HELP %s %s\n", c.name, c.help)
+	fmt.Fprintf(cw, "# TYPE %s counter\n", c.name)
+	fmt.Fprintf(cw, "%s %d\n", c.name, c.Value())
+	return cw.n, cw.err
+}
+
+// metricSet writes several metrics one after another.
+type metricSet []io.WriterTo
+
+func (m metricSet) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for _, metric := range m {
+		n, err := metric.WriteTo(w)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+// reconcileOutcome classifies what a reconcile did to a container from its
+// runtime ID and state before and after the call.
+func reconcileOutcome(container *Container, prevID string, prevState ContainerState, err error) string {
+	switch {
+	case err != nil:
+		return outcomeError
+	case container.DesiredState == Destroyed:
+		return outcomeDestroyed
+	case container.ContainerID != prevID:
+		return outcomeRecreated
+	case container.State == Running && prevState != Running:
+		return outcomeStarted
+	case container.State == Stopped && prevState != Stopped:
+		return outcomeStopped
+	}
+	return outcomeNoop
+}
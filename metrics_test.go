@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconcileObservesDuration(t *testing.T) {
+	cogs, store, _ := newTestWorker(t, "w1")
+	r := cogs.reconciler
+	r.actionInterval = 0
+	mustSave(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running, NodeID: "w1", Scheduled: true})
+
+	if err := r.reconcileWorker(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if _, err := r.reconcileDuration.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := `cogs_container_reconcile_duration_seconds_count{outcome="recreated"} 1`
+	if !strings.Contains(out.String(), want) {
+		t.Errorf("metrics lack %s:\n%s", want, out.String())
+	}
+}
+
+func TestHistogramBucketsAreCumulative(t *testing.T) {
+	h := newHistogram("op_seconds", "Op duration.", "op", []float64{0.1, 1})
+	h.Observe("pull", 50*time.Millisecond)
+	h.Observe("pull", 500*time.Millisecond)
+	h.Observe("pull", 5*time.Second)
+
+	var out strings.Builder
+	if _, err := h.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`op_seconds_bucket{op="pull",le="0.1"} 1`,
+		`op_seconds_bucket{op="pull",le="1"} 2`,
+		`op_seconds_bucket{op="pull",le="+Inf"} 3`,
+		`op_seconds_sum{op="pull"} 5.55`,
+		`op_seconds_count{op="pull"} 3`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %s:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestReconcileOutcome(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		container *Container
+		prevID    string
+		prevState ContainerState
+		err       error
+		want      string
+	}{
+		{"error", &Container{ContainerID: "a"}, "a", Running, context.Canceled, outcomeError},
+		{"destroyed", &Container{DesiredState: Destroyed}, "a", Running, nil, outcomeDestroyed},
+		{"recreated", &Container{ContainerID: "b", State: Running}, "a", Running, nil, outcomeRecreated},
+		{"started", &Container{ContainerID: "a", State: Running}, "a", Stopped, nil, outcomeStarted},
+		{"stopped", &Container{ContainerID: "a", State: Stopped}, "a", Running, nil, outcomeStopped},
+		{"noop", &Container{ContainerID: "a", State: Running}, "a", Running, nil, outcomeNoop},
+	} {
+		if got := reconcileOutcome(tc.container, tc.prevID, tc.prevState, tc.err); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
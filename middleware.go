@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps streaming handlers such as the container watch working
+// through the wrapper.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// logRequests logs one structured record per request once it completes.
+func logRequests(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"duration", time.Since(start),
+		}
+		if nodeID := requestNodeID(r); nodeID != "" {
+			attrs = append(attrs, "node_id", nodeID)
+		}
+		logger.Info("http request", attrs...)
+	})
+}
+
+// requestNodeID identifies the calling node from the node_id query
+// parameter or the X-Node-ID header sent by workers.
+func requestNodeID(r *http.Request) string {
+	if nodeID := r.URL.Query().Get("node_id"); nodeID != "" {
+		return nodeID
+	}
+	return r.Header.Get(nodeIDHeader)
+}
+
+// deprecatedPaths serves the pre-/v1 endpoints for one more release. Each
+// endpoint is logged the first time an old client uses it, rather than on
+// every heartbeat.
+func deprecatedPaths(next http.Handler) http.Handler {
+	var warned sync.Map
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if _, seen := warned.LoadOrStore(endpoint, true); !seen {
+			log.Printf("[API] Deprecated unversioned path %s used by %s, switch to %s%s",
+				r.URL.Path, r.RemoteAddr, apiPrefix, r.URL.Path)
+		}
+
+		w.Header().Set("Deprecation", "true")
+		next.ServeHTTP(w, r)
+	})
+}
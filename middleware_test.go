@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLogRequestsRecordsRequest(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	handler := logRequests(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/containers", nil)
+	req.Header.Set(nodeIDHeader, "w1")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("decoding %q: %v", buf.String(), err)
+	}
+	for key, want := range map[string]any{
+		"msg":     "http request",
+		"method":  "POST",
+		"path":    "/v1/containers",
+		"status":  float64(http.StatusCreated),
+		"node_id": "w1",
+	} {
+		if record[key] != want {
+			t.Errorf("%s = %v, want %v", key, record[key], want)
+		}
+	}
+	if _, ok := record["duration"]; !ok {
+		t.Errorf("record %v has no duration", record)
+	}
+}
+
+func TestLogRequestsDefaultsToOK(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	handler := logRequests(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health?node_id=w2", nil))
+
+	var record struct {
+		Status int    `json:"status"`
+		NodeID string `json:"node_id"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("decoding %q: %v", buf.String(), err)
+	}
+	if record.Status != http.StatusOK || record.NodeID != "w2" {
+		t.Errorf("record = %+v, want status 200 from node w2", record)
+	}
+}
+
+func TestVersionedAndUnversionedPathsBothServe(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	mustSave(t, store, &Container{ID: "c1", Image: "nginx", State: Running, DesiredState: Running})
+
+	for _, tc := range []struct {
+		path       string
+		deprecated bool
+	}{
+		{apiPrefix + "/containers/c1", false},
+		{"/containers/c1", true},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s: %d %s", tc.path, rec.Code, rec.Body.String())
+			continue
+		}
+		var got struct{ ID string }
+		decodeData(t, rec, &got)
+		if got.ID != "c1" {
+			t.Errorf("GET %s returned %q, want c1", tc.path, got.ID)
+		}
+		if marked := rec.Header().Get("Deprecation") == "true"; marked != tc.deprecated {
+			t.Errorf("GET %s: Deprecation header %q, want it only on the unversioned path", tc.path, rec.Header().Get("Deprecation"))
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"go.etcd.io/bbolt"
+)
+
+var metaBucket = []byte("meta")
+var schemaVersionKey = []byte("schema_version")
+
+// migrations bring a database up to the current schema, one version each.
+// Entry i moves a database at version i to version i+1. Append new steps;
+// never change one that has already shipped.
+var migrations = []func(tx *bbolt.Tx) error{
+	// 1: the record buckets. Databases from before versioning may have
+	// only some of them, so every bucket is created if it is missing.
+	func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{containersBucket, nodesBucket, secretsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 2: the container name index, filled from the names already stored.
+	func(tx *bbolt.Tx) error {
+		names, err := tx.CreateBucketIfNotExists(containerNamesBucket)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(containersBucket).ForEach(func(k, v []byte) error {
+			var c Container
+			if err := json.Unmarshal(v, &c); err != nil {
+				return fmt.Errorf("failed to unmarshal container %s: %w", k, err)
+			}
+			if name := claimedName(&c); name != "" && names.Get([]byte(name)) == nil {
+				return names.Put([]byte(name), k)
+			}
+			return nil
+		})
+	},
+	// 3: services.
+	func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(servicesBucket)
+		return err
+	},
+	// 4: autoscalers.
+	func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(autoscalersBucket)
+		return err
+	},
+	// 5: idempotency keys.
+	func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(idempotencyKeysBucket)
+		return err
+	},
+}
+
+// schemaVersion is the version a fully migrated database records.
+func schemaVersion() int {
+	return len(migrations)
+}
+
+// migrate runs the migrations the database hasn't seen yet and records the
+// new version. It is a no-op on a database that is already current.
+func migrate(tx *bbolt.Tx) error {
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+
+	version := 0
+	if raw := meta.Get(schemaVersionKey); raw != nil {
+		version, err = strconv.Atoi(string(raw))
+		if err != nil {
+			return fmt.Errorf("invalid schema version %q: %w", raw, err)
+		}
+	}
+	if version > schemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than this binary supports (%d)", version, schemaVersion())
+	}
+
+	for ; version < schemaVersion(); version++ {
+		if err := migrations[version](tx); err != nil {
+			return fmt.Errorf("migration to schema version %d failed: %w", version+1, err)
+		}
+	}
+
+	return meta.Put(schemaVersionKey, []byte(strconv.Itoa(version)))
+}
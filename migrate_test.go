@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestOpenMigratesSingleBucketDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), dbFile)
+
+	// a database from before versioning, with only the containers bucket
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucket(containersBucket)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(&Container{ID: "c1", Name: "web", Image: "nginx", State: Running, DesiredState: Running})
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("c1"), data)
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// opening twice shows the migrations are safe to run on a current database
+	for range 2 {
+		store, err := NewBoltStore(path)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		c, err := store.GetContainerByName(context.Background(), "web")
+		if err != nil || c.ID != "c1" {
+			t.Errorf("lookup by name: got %v, %v, want c1 from the migrated index", c, err)
+		}
+		store.Close()
+	}
+
+	db, err = bbolt.Open(path, 0600, &bbolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.View(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{containersBucket, nodesBucket, secretsBucket, containerNamesBucket,
+			servicesBucket, autoscalersBucket, idempotencyKeysBucket, metaBucket} {
+			if tx.Bucket(name) == nil {
+				t.Errorf("bucket %s missing after the migration", name)
+			}
+		}
+		return nil
+	})
+	if got, want := storedSchemaVersion(t, path), strconv.Itoa(schemaVersion()); got != want {
+		t.Errorf("schema version %q, want %q", got, want)
+	}
+}
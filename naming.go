@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultNameTemplate names runtime containers so they are recognisable in
+// `docker ps` and can't collide with containers created by hand.
+const defaultNameTemplate = "cogs-{image}-{shortid}"
+
+// containerName renders a naming template for c. Supported placeholders are
+// {id} (the full cogs ID), {shortid} (the ID without its cont- prefix) and
+// {image} (the image name without registry, path or tag). The result is
+// sanitized to the characters Docker allows in names.
+func containerName(template string, c *Container) string {
+	if template == "" {
+		template = defaultNameTemplate
+	}
+
+	name := strings.NewReplacer(
+		"{id}", c.ID,
+		"{shortid}", strings.TrimPrefix(c.ID, "cont-"),
+		"{image}", imageBaseName(c.Image),
+	).Replace(template)
+
+	return sanitizeContainerName(name)
+}
+
+// validateNameTemplate rejects templates that would give two containers the
+// same name, since Create adopts an existing container by name.
+func validateNameTemplate(template string) error {
+	if !strings.Contains(template, "{id}") && !strings.Contains(template, "{shortid}") {
+		return fmt.Errorf("name template %q must contain {id} or {shortid}", template)
+	}
+	return nil
+}
+
+// defaultNetwork puts a deployment's replicas on a network of their own,
+// cogs-<deployment>, unless the container already names one.
+func defaultNetwork(c *Container) {
+	if c.Network == "" && c.Deployment != "" {
+		c.Network = sanitizeContainerName("cogs-" + c.Deployment)
+	}
+}
+
+// imageBaseName reduces "registry:5000/team/app:1.2@sha256:..." to "app:1.2".
+func imageBaseName(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, "/"); i >= 0 {
+		image = image[i+1:]
+	}
+	return image
+}
+
+// sanitizeContainerName maps a string onto Docker's [a-zA-Z0-9][a-zA-Z0-9_.-]*
+// name charset, replacing anything else with a dash.
+func sanitizeContainerName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '_' || r == '.' || r == '-':
+			if b.Len() == 0 {
+				// the first character must be alphanumeric
+				continue
+			}
+			b.WriteRune(r)
+		default:
+			if b.Len() > 0 {
+				b.WriteByte('-')
+			}
+		}
+	}
+
+	if b.Len() == 0 {
+		return "cogs"
+	}
+	return b.String()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestContainerName(t *testing.T) {
+	c := &Container{ID: "cont-abc123", Image: "registry:5000/team/web:1.2@sha256:feed"}
+
+	for _, tc := range []struct {
+		template, want string
+	}{
+		{"", "cogs-web-1.2-abc123"},
+		{"{id}", "cont-abc123"},
+		{"app/{image}/{shortid}", "app-web-1.2-abc123"},
+	} {
+		if got := containerName(tc.template, c); got != tc.want {
+			t.Errorf("containerName(%q) = %q, want %q", tc.template, got, tc.want)
+		}
+	}
+}
+
+func TestValidateNameTemplate(t *testing.T) {
+	if err := validateNameTemplate("cogs-{image}"); err == nil {
+		t.Errorf("accepted a template that names every replica the same")
+	}
+	for _, template := range []string{"{id}", "x-{shortid}"} {
+		if err := validateNameTemplate(template); err != nil {
+			t.Errorf("%q: %v", template, err)
+		}
+	}
+}
+
+func TestSanitizeContainerName(t *testing.T) {
+	for in, want := range map[string]string{
+		"web":      "web",
+		"_web":     "web",
+		"a b/c":    "a-b-c",
+		"!!!":      "cogs",
+		"my_app.1": "my_app.1",
+	} {
+		if got := sanitizeContainerName(in); got != want {
+			t.Errorf("sanitizeContainerName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestReconcileReplacesLegacyNamedContainer(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	ctx := context.Background()
+
+	// created by a worker that named runtime containers by ID
+	legacy, err := runtime.Create(ctx, &ContainerSpec{Image: "nginx", Name: "c1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.SetStatus(legacy, &RuntimeStatus{ContainerID: legacy, State: "running"})
+
+	c := saveTestContainer(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running})
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatalf("reconcileContainer: %v", err)
+	}
+
+	if _, err := runtime.Inspect(ctx, legacy); err == nil {
+		t.Errorf("legacy container %s left running next to the new one", legacy)
+	}
+	all, err := runtime.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 {
+		t.Errorf("runtime has %d containers, want just the replacement", len(all))
+	}
+}
+
+func TestDefaultNetworkIsPerDeployment(t *testing.T) {
+	c := &Container{Deployment: "web app"}
+	defaultNetwork(c)
+	if c.Network != "cogs-web-app" {
+		t.Errorf("network = %q, want cogs-web-app", c.Network)
+	}
+
+	c = &Container{Deployment: "web", Network: "shared"}
+	defaultNetwork(c)
+	if c.Network != "shared" {
+		t.Errorf("network = %q, want the one the container names", c.Network)
+	}
+
+	c = &Container{}
+	defaultNetwork(c)
+	if c.Network != "" {
+		t.Errorf("network = %q for a container outside any deployment, want none", c.Network)
+	}
+}
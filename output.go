@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+// addOutputFlag registers -o/--output on a command's flag set.
+func addOutputFlag(fs *flag.FlagSet) *string {
+	format := new(string)
+	fs.StringVar(format, "output", outputTable, "output format: table or json")
+	fs.StringVar(format, "o", outputTable, "shorthand for --output")
+	return format
+}
+
+// render writes v as indented JSON, or hands off to the table printer.
+func render(format string, v any, table func(w io.Writer)) {
+	switch format {
+	case outputJSON:
+		if err := writeJSON(os.Stdout, v); err != nil {
+			log.Fatal(err)
+		}
+	case outputTable:
+		table(os.Stdout)
+	default:
+		log.Fatalf("Unknown output format %q, expected %s or %s", format, outputTable, outputJSON)
+	}
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("failed to encode output: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWriteJSONProducesValidJSON(t *testing.T) {
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	container := &Container{ID: "c1", Image: "nginx", State: Running, DesiredState: Running, CreatedAt: now,
+		Ports: []PortMapping{{HostPort: 8081, ContainerPort: 80, Protocol: "tcp"}}, Labels: map[string]string{"app": "web"}}
+	node := &Node{ID: "w1", Role: Worker, State: NodeReady, Capacity: Resources{CPUCores: 4, MemoryMB: 8192}}
+
+	// one value of each shape a list or inspect command renders
+	for name, v := range map[string]any{
+		"list":       []*Container{container},
+		"empty list": []*Container{},
+		"inspect":    container,
+		"nodes":      []*NodeSummary{{Node: node, Containers: 1, Running: 1}},
+		"status":     &ClusterStatus{Nodes: 1, ReadyNodes: 1, ByState: map[ContainerState]int{Running: 1}, StartedAt: now},
+		"version":    versionReport{Client: VersionInfo{Version: "1.2.3", Commit: "abc"}},
+		"service":    &Service{Name: "web", Selector: map[string]string{"app": "web"}, Port: 80},
+		"autoscaler": &HorizontalAutoscaler{Deployment: "web", MinReplicas: 1, MaxReplicas: 3, TargetCPUPercent: 50},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeJSON(&buf, v); err != nil {
+				t.Fatal(err)
+			}
+			if !json.Valid(buf.Bytes()) {
+				t.Fatalf("invalid JSON:\n%s", buf.String())
+			}
+
+			back := reflect.New(reflect.TypeOf(v))
+			if err := json.Unmarshal(buf.Bytes(), back.Interface()); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(back.Elem().Interface(), v) {
+				t.Errorf("round trip = %+v, want %+v", back.Elem().Interface(), v)
+			}
+		})
+	}
+}
+
+func TestWriteJSONRejectsUnencodable(t *testing.T) {
+	if err := writeJSON(&bytes.Buffer{}, map[string]any{"f": func() {}}); err == nil {
+		t.Error("encoded a func")
+	}
+}
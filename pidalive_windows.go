@@ -0,0 +1,16 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// processAlive reports whether a process with pid exists. FindProcess
+// opens a handle to it on Windows, which fails once it has exited.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const defaultProtocol = "tcp"
+
+var validProtocols = map[string]bool{
+	"tcp":  true,
+	"udp":  true,
+	"sctp": true,
+}
+
+// ParsePortMapping parses a "host:container[/protocol]" port spec. The
+// protocol defaults to tcp and an empty host (":80") lets the runtime pick
+// the host port.
+func ParsePortMapping(spec string) (PortMapping, error) {
+	ports, protocol, hasProto := strings.Cut(spec, "/")
+	if !hasProto {
+		protocol = defaultProtocol
+	}
+	protocol = strings.ToLower(protocol)
+	if !validProtocols[protocol] {
+		return PortMapping{}, fmt.Errorf("invalid protocol %q in port mapping %q", protocol, spec)
+	}
+
+	parts := strings.Split(ports, ":")
+	if len(parts) != 2 {
+		return PortMapping{}, fmt.Errorf("invalid port mapping %q, expected host:container[/protocol]", spec)
+	}
+
+	container, err := parsePort(parts[1])
+	if err != nil {
+		return PortMapping{}, fmt.Errorf("invalid container port in %q: %w", spec, err)
+	}
+
+	if parts[0] == "" {
+		return PortMapping{ContainerPort: container, Protocol: protocol, Auto: true}, nil
+	}
+
+	host, err := parsePort(parts[0])
+	if err != nil {
+		return PortMapping{}, fmt.Errorf("invalid host port in %q: %w", spec, err)
+	}
+
+	return PortMapping{HostPort: host, ContainerPort: container, Protocol: protocol}, nil
+}
+
+// normalizePorts fills in what port mappings that arrived without going
+// through ParsePortMapping, such as API payloads, leave out: the default
+// protocol, and Auto for a zero host port, which the runtime picks.
+// Container.Validate checks the result.
+func normalizePorts(ports []PortMapping) {
+	for i := range ports {
+		pm := &ports[i]
+		if pm.Protocol == "" {
+			pm.Protocol = defaultProtocol
+		}
+		pm.Protocol = strings.ToLower(pm.Protocol)
+		if pm.HostPort == 0 {
+			pm.Auto = true
+		}
+	}
+}
+
+// specPorts returns ports as requested, with runtime-picked host ports
+// reset to 0, so a picked port never reads as a spec change.
+func specPorts(ports []PortMapping) []PortMapping {
+	if !slices.ContainsFunc(ports, func(pm PortMapping) bool { return pm.Auto }) {
+		return ports
+	}
+
+	spec := slices.Clone(ports)
+	for i := range spec {
+		if spec[i].Auto {
+			spec[i].HostPort = 0
+		}
+	}
+	return spec
+}
+
+// assignHostPorts fills the container's auto host ports from the bindings
+// the runtime reported and reports whether any changed.
+func assignHostPorts(container *Container, bound []PortMapping) bool {
+	changed := false
+	for i := range container.Ports {
+		pm := &container.Ports[i]
+		if !pm.Auto {
+			continue
+		}
+		for _, b := range bound {
+			if b.ContainerPort == pm.ContainerPort && b.Protocol == pm.Protocol && b.HostPort != pm.HostPort {
+				pm.HostPort = b.HostPort
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// portFlag collects repeatable -p host:container[/protocol] flags.
+type portFlag []PortMapping
+
+func (p *portFlag) String() string {
+	return formatPorts(*p)
+}
+
+func (p *portFlag) Set(value string) error {
+	pm, err := ParsePortMapping(value)
+	if err != nil {
+		return err
+	}
+
+	*p = append(*p, pm)
+	return nil
+}
+
+// formatPorts renders published ports as host:container/protocol pairs,
+// leaving the host empty while the runtime has yet to pick it.
+func formatPorts(ports []PortMapping) string {
+	specs := make([]string, 0, len(ports))
+	for _, pm := range ports {
+		if pm.HostPort == 0 {
+			specs = append(specs, fmt.Sprintf(":%d/%s", pm.ContainerPort, pm.Protocol))
+			continue
+		}
+		specs = append(specs, fmt.Sprintf("%d:%d/%s", pm.HostPort, pm.ContainerPort, pm.Protocol))
+	}
+	return strings.Join(specs, ",")
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", value)
+	}
+
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("%d is out of range 1-65535", port)
+	}
+
+	return port, nil
+}
+
+// hostPortConflict reports the first host port of container already
+// published by another container that is, or is about to be, running on
+// nodeID.
+func hostPortConflict(container *Container, nodeID string, others []*Container) (PortMapping, bool) {
+	for _, other := range others {
+		if other.ID == container.ID {
+			continue
+		}
+
+		if !activeOn(other, nodeID) {
+			continue
+		}
+
+		for _, want := range container.Ports {
+			for _, have := range other.Ports {
+				if !want.Auto && want.HostPort != 0 && want.HostPort == have.HostPort && want.Protocol == have.Protocol {
+					return want, true
+				}
+			}
+		}
+	}
+
+	return PortMapping{}, false
+}
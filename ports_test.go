@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestParsePortMapping(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		want PortMapping
+	}{
+		{"8080:80", PortMapping{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}},
+		{"53:53/UDP", PortMapping{HostPort: 53, ContainerPort: 53, Protocol: "udp"}},
+		{":80", PortMapping{ContainerPort: 80, Protocol: "tcp", Auto: true}},
+	} {
+		got, err := ParsePortMapping(tc.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tc.spec, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: got %+v, want %+v", tc.spec, got, tc.want)
+		}
+	}
+
+	for _, spec := range []string{"80", "1:2:3", "x:80", "80:x", "0:80", "8080:0", "70000:80", "8080:80/icmp"} {
+		if _, err := ParsePortMapping(spec); err == nil {
+			t.Errorf("%q: parsed, want an error", spec)
+		}
+	}
+}
+
+func TestHostPortConflict(t *testing.T) {
+	web := &Container{ID: "web", Ports: []PortMapping{{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}}}
+	running := func(id, node, protocol string) *Container {
+		return &Container{ID: id, NodeID: node, State: Running, DesiredState: Running, Scheduled: true,
+			Ports: []PortMapping{{HostPort: 8080, ContainerPort: 8080, Protocol: protocol}}}
+	}
+
+	if _, ok := hostPortConflict(web, "w1", []*Container{running("other", "w1", "tcp")}); !ok {
+		t.Errorf("no conflict with a container publishing 8080/tcp on the same node")
+	}
+	for name, others := range map[string][]*Container{
+		"other node":     {running("other", "w2", "tcp")},
+		"other protocol": {running("other", "w1", "udp")},
+		"itself":         {running("web", "w1", "tcp")},
+		"stopped":        {{ID: "old", NodeID: "w1", State: Stopped, DesiredState: Stopped, Ports: running("", "", "tcp").Ports}},
+	} {
+		if _, ok := hostPortConflict(web, "w1", others); ok {
+			t.Errorf("%s: reported a conflict", name)
+		}
+	}
+}
+
+func TestValidateRejectsDuplicateHostPort(t *testing.T) {
+	c := validContainer()
+	c.Ports = append(c.Ports, PortMapping{HostPort: 8080, ContainerPort: 81, Protocol: "tcp"})
+	if err := validateContainer(c); err == nil {
+		t.Errorf("accepted host port 8080/tcp twice")
+	}
+
+	// the same number over another protocol is a different port
+	c.Ports[1].Protocol = "udp"
+	if err := validateContainer(c); err != nil {
+		t.Errorf("8080/tcp and 8080/udp: %v", err)
+	}
+}
+
+func TestAdmitRejectsHostPortTakenOnNode(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	mustSave(t, store,
+		&Container{ID: "a", Image: "nginx", NodeID: "w1", Scheduled: true, State: Running, DesiredState: Running,
+			Ports: []PortMapping{{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}}},
+		&Container{ID: "b", Image: "nginx", NodeID: "w1", Scheduled: true, State: Running, DesiredState: Running})
+
+	rec := call(t, handler, http.MethodPatch, "/containers/b", `{"ports": [{"host_port": 8080, "container_port": 80}]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("patch onto a taken host port: got %d %s, want 400", rec.Code, rec.Body.String())
+	}
+
+	rec = call(t, handler, http.MethodPost, "/containers",
+		`{"image": "nginx", "state": "requested", "desired_state": "running", "ports": [{"host_port": 8080, "container_port": 80}, {"host_port": 8080, "container_port": 81}]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("duplicate host port: got %d %s, want 400", rec.Code, rec.Body.String())
+	}
+}
+
+func TestPortFlagCollectsRepeatedFlags(t *testing.T) {
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	var ports portFlag
+	fs.Var(&ports, "p", "")
+
+	args, _ := parseWithTrailing(fs, []string{"nginx", "-p", "8080:80", "-p", "8443:443/tcp", "-p", "53:53/udp"})
+	if !slices.Equal(args, []string{"nginx"}) {
+		t.Errorf("positional args = %v, want just the image", args)
+	}
+	want := []PortMapping{
+		{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"},
+		{HostPort: 8443, ContainerPort: 443, Protocol: "tcp"},
+		{HostPort: 53, ContainerPort: 53, Protocol: "udp"},
+	}
+	if !slices.Equal(ports, want) {
+		t.Errorf("ports = %v, want %v", ports, want)
+	}
+
+	if err := ports.Set("80:http"); err == nil {
+		t.Error("a malformed -p was accepted")
+	}
+}
+
+func TestParsePortMappingProtocol(t *testing.T) {
+	for spec, want := range map[string]string{
+		"8080:80":     "tcp",
+		"8080:80/tcp": "tcp",
+		"8080:80/TCP": "tcp",
+		"5353:53/udp": "udp",
+		":53/udp":     "udp",
+	} {
+		got, err := ParsePortMapping(spec)
+		if err != nil || got.Protocol != want {
+			t.Errorf("%q: got %+v, %v, want protocol %s", spec, got, err, want)
+		}
+	}
+
+	for _, spec := range []string{"8080:80/http", "8080:80/", "8080:80/tcp/udp", "8080/udp:80"} {
+		if _, err := ParsePortMapping(spec); err == nil {
+			t.Errorf("%q: parsed, want an error", spec)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultProbeTimeout bounds a single readiness check.
+const defaultProbeTimeout = time.Second
+
+func validateProbe(p *ReadinessProbe) error {
+	if p.Port < 1 || p.Port > 65535 {
+		return fmt.Errorf("readiness probe port %d is out of range 1-65535", p.Port)
+	}
+	if p.Path != "" && p.Path[0] != '/' {
+		return fmt.Errorf("readiness probe path %q must start with /", p.Path)
+	}
+	if p.Timeout < 0 {
+		return fmt.Errorf("readiness probe timeout %s is negative", p.Timeout)
+	}
+	return nil
+}
+
+// probeReadiness runs the container's readiness probe once.
+func probeReadiness(ctx context.Context, c *Container) error {
+	p := c.ReadinessProbe
+	if c.IPAddress == "" {
+		return errors.New("container has no IP address")
+	}
+
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(c.IPAddress, strconv.Itoa(p.Port))
+	if p.Path == "" {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+p.Path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return fmt.Errorf("readiness probe returned %s", resp.Status)
+	}
+	return nil
+}
+
+// updateReadiness probes a running container and records whether it is
+// ready. Containers without a probe are ready as soon as they run.
+func (r *Reconciler) updateReadiness(ctx context.Context, container *Container) {
+	ready := true
+	if container.ReadinessProbe != nil {
+		if err := r.probe(ctx, container); err != nil {
+			if container.Ready {
+				log.Printf("Container %s failed its readiness probe: %v", container.ID, err)
+			}
+			ready = false
+		}
+	}
+	r.setReady(ctx, container, ready)
+}
+
+func (r *Reconciler) setReady(ctx context.Context, container *Container, ready bool) {
+	if container.Ready == ready {
+		return
+	}
+	container.Ready = ready
+	container.UpdatedAt = time.Now()
+	r.saveContainerStatus(ctx, container)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestContainerRunsBeforeItIsReady(t *testing.T) {
+	r, store, _ := newTestReconciler(t)
+	ctx := context.Background()
+
+	probeErr := errors.New("connection refused")
+	r.probe = func(context.Context, *Container) error { return probeErr }
+
+	c := startedContainer(t, r, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running,
+		ReadinessProbe: &ReadinessProbe{Port: 80, Path: "/healthz"}})
+	if c.State != Running || c.Ready {
+		t.Fatalf("container is %s, ready %v; want running but not ready while the probe fails", c.State, c.Ready)
+	}
+
+	probeErr = nil
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := store.GetContainer(ctx, "c1"); stored.State != Running || !stored.Ready {
+		t.Errorf("container is %s, ready %v; want ready once the probe passes", stored.State, stored.Ready)
+	}
+}
+
+func TestContainerWithoutProbeIsReadyOnceRunning(t *testing.T) {
+	r, store, _ := newTestReconciler(t)
+	c := startedContainer(t, r, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running})
+	if c.State != Running || !c.Ready {
+		t.Errorf("container is %s, ready %v; want ready as soon as it runs", c.State, c.Ready)
+	}
+}
+
+func TestProbeReadinessChecksHTTPStatus(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(srv.Close)
+
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	portNum, _ := strconv.Atoi(port)
+	c := &Container{ID: "c1", IPAddress: host, ReadinessProbe: &ReadinessProbe{Port: portNum, Path: "/ready"}}
+
+	if err := probeReadiness(context.Background(), c); err == nil {
+		t.Errorf("a 503 passed the probe")
+	}
+	status = http.StatusOK
+	if err := probeReadiness(context.Background(), c); err != nil {
+		t.Errorf("a 200 failed the probe: %v", err)
+	}
+
+	// a TCP probe only needs the port to accept
+	c.ReadinessProbe.Path = ""
+	if err := probeReadiness(context.Background(), c); err != nil {
+		t.Errorf("tcp probe: %v", err)
+	}
+}
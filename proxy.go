@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// proxyDialTimeout bounds connecting to one backend before the next is
+// tried.
+const proxyDialTimeout = 2 * time.Second
+
+// serviceProxy listens on each service's ProxyPort and spreads incoming
+// TCP connections round-robin over the service's endpoints. It runs on the
+// control plane, so endpoint addresses must be reachable from there.
+type serviceProxy struct {
+	mu        sync.Mutex
+	listeners map[string]*proxyListener // by service name
+}
+
+func newServiceProxy() *serviceProxy {
+	return &serviceProxy{listeners: make(map[string]*proxyListener)}
+}
+
+// Sync opens, moves and closes listeners to match services and hands each
+// one its service's current backends.
+func (p *serviceProxy) Sync(services []*Service) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	wanted := make(map[string]*Service, len(services))
+	for _, svc := range services {
+		if svc.ProxyPort != 0 {
+			wanted[svc.Name] = svc
+		}
+	}
+
+	for name, pl := range p.listeners {
+		if svc, ok := wanted[name]; !ok || svc.ProxyPort != pl.port {
+			pl.Close()
+			delete(p.listeners, name)
+		}
+	}
+
+	for name, svc := range wanted {
+		pl, ok := p.listeners[name]
+		if !ok {
+			var err error
+			pl, err = listenProxy(svc.ProxyPort)
+			if err != nil {
+				log.Printf("Failed to proxy service %s: %v", name, err)
+				continue
+			}
+			log.Printf("Proxying service %s on :%d", name, svc.ProxyPort)
+			p.listeners[name] = pl
+		}
+		pl.setBackends(proxyBackends(svc))
+	}
+}
+
+// Close stops every listener. Connections already proxied are left to
+// finish.
+func (p *serviceProxy) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for name, pl := range p.listeners {
+		pl.Close()
+		delete(p.listeners, name)
+	}
+}
+
+func proxyBackends(svc *Service) []string {
+	backends := make([]string, 0, len(svc.Endpoints))
+	for _, ep := range svc.Endpoints {
+		backends = append(backends, net.JoinHostPort(ep.IPAddress, strconv.Itoa(ep.Port)))
+	}
+	return backends
+}
+
+type proxyListener struct {
+	port int
+	ln   net.Listener
+
+	mu       sync.Mutex
+	backends []string
+	next     int
+}
+
+func listenProxy(port int) (*proxyListener, error) {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on :%d: %w", port, err)
+	}
+
+	pl := &proxyListener{port: port, ln: ln}
+	go pl.serve()
+	return pl, nil
+}
+
+func (pl *proxyListener) setBackends(backends []string) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	pl.backends = backends
+}
+
+// rotation returns every backend, starting with the one whose turn it is,
+// and moves the turn on by one.
+func (pl *proxyListener) rotation() []string {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	n := len(pl.backends)
+	if n == 0 {
+		return nil
+	}
+	start := pl.next % n
+	pl.next = start + 1
+
+	order := make([]string, 0, n)
+	order = append(order, pl.backends[start:]...)
+	return append(order, pl.backends[:start]...)
+}
+
+func (pl *proxyListener) serve() {
+	for {
+		conn, err := pl.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Proxy on :%d stopped: %v", pl.port, err)
+			}
+			return
+		}
+		go pl.handle(conn)
+	}
+}
+
+// handle connects a client to the first backend in its rotation that
+// answers, so a backend that has just gone away costs a retry rather than
+// a failed connection.
+func (pl *proxyListener) handle(client net.Conn) {
+	for _, addr := range pl.rotation() {
+		backend, err := net.DialTimeout("tcp", addr, proxyDialTimeout)
+		if err != nil {
+			log.Printf("Proxy on :%d could not reach %s: %v", pl.port, addr, err)
+			continue
+		}
+		pipe(client, backend)
+		return
+	}
+
+	client.Close()
+}
+
+func (pl *proxyListener) Close() error {
+	return pl.ln.Close()
+}
+
+// pipe copies between a and b until both directions are done. A clean EOF
+// is passed on as a half-close; an error, such as the backend dying
+// mid-connection, tears both connections down.
+func pipe(a, b net.Conn) {
+	var wg sync.WaitGroup
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		if _, err := io.Copy(dst, src); err != nil {
+			a.Close()
+			b.Close()
+			return
+		}
+		if tc, ok := dst.(*net.TCPConn); ok {
+			tc.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+
+	wg.Add(2)
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	wg.Wait()
+
+	a.Close()
+	b.Close()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// nameServer accepts connections on a local port and answers each with
+// name, standing in for one backend of a service.
+func nameServer(t *testing.T, name string) net.Listener {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(name))
+			conn.Close()
+		}
+	}()
+	return ln
+}
+
+// proxyRead opens a connection through pl and returns what the backend sent.
+func proxyRead(t *testing.T, pl *proxyListener) string {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", pl.ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestProxyAlternatesBetweenBackends(t *testing.T) {
+	a, b := nameServer(t, "a"), nameServer(t, "b")
+	pl, err := listenProxy(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pl.Close() })
+	pl.setBackends([]string{a.Addr().String(), b.Addr().String()})
+
+	var got []string
+	for range 4 {
+		got = append(got, proxyRead(t, pl))
+	}
+	for i := 1; i < len(got); i++ {
+		if got[i] == got[i-1] {
+			t.Fatalf("backends answered %v, want them to alternate", got)
+		}
+	}
+}
+
+func TestProxySkipsBackendThatIsGone(t *testing.T) {
+	a, b := nameServer(t, "a"), nameServer(t, "b")
+	pl, err := listenProxy(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pl.Close() })
+	pl.setBackends([]string{a.Addr().String(), b.Addr().String()})
+
+	a.Close()
+	for range 3 {
+		if got := proxyRead(t, pl); got != "b" {
+			t.Errorf("got %q with a gone, want every connection on b", got)
+		}
+	}
+}
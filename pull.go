@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+const (
+	// PullAlways pulls the image before every create.
+	PullAlways = "always"
+	// PullIfNotPresent only pulls when the runtime doesn't have the image.
+	PullIfNotPresent = "ifnotpresent"
+)
+
+func validPullPolicy(policy string) bool {
+	return policy == "" || policy == PullAlways || policy == PullIfNotPresent
+}
+
+// pullGroup collapses concurrent pulls of the same image into one, so
+// containers sharing an image don't download it in parallel.
+type pullGroup struct {
+	mu    sync.Mutex
+	calls map[string]*pullCall
+}
+
+type pullCall struct {
+	done chan struct{}
+	err  error
+}
+
+// Do runs fn for image unless a pull of it is already in flight, and waits
+// for the pull's result. The pull runs on a context detached from ctx, so
+// the caller that started it giving up doesn't fail the others waiting;
+// each caller stops waiting when its own ctx is done.
+func (g *pullGroup) Do(ctx context.Context, image string, fn func(ctx context.Context) error) error {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*pullCall)
+	}
+	call, ok := g.calls[image]
+	if !ok {
+		call = &pullCall{done: make(chan struct{})}
+		g.calls[image] = call
+
+		pullCtx := context.WithoutCancel(ctx)
+		go func() {
+			call.err = fn(pullCtx)
+
+			// forget the call before waking the waiters, so a pull asked for
+			// after this one failed starts afresh
+			g.mu.Lock()
+			delete(g.calls, image)
+			g.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.err
+	case <-ctx.Done():
+		return fmt.Errorf("gave up waiting for the pull of %s: %w", image, ctx.Err())
+	}
+}
+
+// defaultMaxConcurrentPulls bounds how many containers a worker pulls and
+// creates at once, so a burst of new containers can't saturate the node.
+const defaultMaxConcurrentPulls = 2
+
+// slots is a non-blocking counting semaphore. The limit is passed on every
+// acquire so it can be configured after the reconciler is built.
+type slots struct {
+	mu     sync.Mutex
+	used   int
+	denied bool
+}
+
+// tryAcquire takes a slot if fewer than limit are in use. A limit of zero
+// or less is unbounded.
+func (s *slots) tryAcquire(limit int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if limit > 0 && s.used >= limit {
+		s.denied = true
+		return false
+	}
+	s.used++
+	return true
+}
+
+// release frees a slot and reports whether anyone was turned away since
+// the last release that did.
+func (s *slots) release() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.used--
+	denied := s.denied
+	s.denied = false
+	return denied
+}
+
+// ensureImage makes the container's image available according to its pull
+// policy, skipping the pull when the image is present and the policy allows.
+func (r *Reconciler) ensureImage(ctx context.Context, container *Container) error {
+	if container.ImagePullPolicy != PullAlways {
+		present, err := r.cogsworth.runtime.HasImage(ctx, container.Image)
+		if err != nil {
+			return err
+		}
+		if present {
+			return nil
+		}
+	}
+
+	return r.pulls.Do(ctx, container.Image, func(ctx context.Context) error {
+		if err := r.cogsworth.runtime.Pull(ctx, container.Image); err != nil {
+			return fmt.Errorf("failed to pull %s: %w", container.Image, err)
+		}
+		return nil
+	})
+}
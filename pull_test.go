@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// blockedPull starts a pull of image that runs until release is closed and
+// returns the in-flight call along with the first caller's result.
+func blockedPull(t *testing.T, g *pullGroup, ctx context.Context, image string, release <-chan struct{}) (*pullCall, <-chan error) {
+	t.Helper()
+
+	started := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		result <- g.Do(ctx, image, func(ctx context.Context) error {
+			close(started)
+			select {
+			case <-release:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	<-started
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.calls[image], result
+}
+
+func TestPullGroupJoinsPullInFlight(t *testing.T) {
+	var g pullGroup
+	release := make(chan struct{})
+	_, first := blockedPull(t, &g, context.Background(), "nginx", release)
+
+	// a caller that is already done joins the pull and leaves at once
+	done, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := g.Do(done, "nginx", func(context.Context) error {
+		t.Error("started a second pull while one was in flight")
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("joined caller: got %v, want context.Canceled", err)
+	}
+
+	close(release)
+	if err := <-first; err != nil {
+		t.Errorf("first caller: %v", err)
+	}
+}
+
+func TestPullGroupCallerGivingUpDoesNotCancelPull(t *testing.T) {
+	var g pullGroup
+	release := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	call, first := blockedPull(t, &g, ctx, "nginx", release)
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("first caller: got %v, want context.Canceled", err)
+	}
+
+	// the pull carries on for whoever else is waiting
+	close(release)
+	<-call.done
+	if call.err != nil {
+		t.Errorf("the shared pull failed with its first caller: %v", call.err)
+	}
+}
+
+func TestPullGroupRetriesAfterFailure(t *testing.T) {
+	var g pullGroup
+	failed := errors.New("registry down")
+
+	if err := g.Do(context.Background(), "nginx", func(context.Context) error { return failed }); !errors.Is(err, failed) {
+		t.Fatalf("got %v, want the pull's error", err)
+	}
+	ran := false
+	if err := g.Do(context.Background(), "nginx", func(context.Context) error { ran = true; return nil }); err != nil || !ran {
+		t.Errorf("second pull: ran %v, err %v, want a fresh pull", ran, err)
+	}
+}
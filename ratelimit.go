@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// defaultActionInterval is how often, once its burst is spent, the
+	// worker may act on one container: create, start, stop or recreate it,
+	// or retry after an error.
+	defaultActionInterval = 10 * time.Second
+
+	// defaultActionBurst is how many actions a container may take in a row
+	// before defaultActionInterval applies.
+	defaultActionBurst = 3
+)
+
+// rateLimiter is a set of token buckets keyed by container ID. Like slots,
+// it takes the rate on every call so it can be configured after the
+// reconciler is built.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// bucket returns key's bucket refilled up to now, at one token per every and
+// holding at most burst. The caller holds l.mu.
+func (l *rateLimiter) bucket(key string, now time.Time, every time.Duration, burst int) *tokenBucket {
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed.Seconds()/every.Seconds(), float64(max(burst, 1)))
+		b.last = now
+	}
+	return b
+}
+
+// allowed reports whether key has a token to spend. An interval of zero or
+// less is unlimited.
+func (l *rateLimiter) allowed(key string, now time.Time, every time.Duration, burst int) bool {
+	if every <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.bucket(key, now, every, burst).tokens >= 1
+}
+
+// take spends one of key's tokens.
+func (l *rateLimiter) take(key string, now time.Time, every time.Duration, burst int) {
+	if every <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, now, every, burst)
+	b.tokens = max(b.tokens-1, 0)
+}
+
+// retain forgets every key not in keys, so containers that have left the
+// node don't hold on to their buckets.
+func (l *rateLimiter) retain(keys map[string]bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key := range l.buckets {
+		if !keys[key] {
+			delete(l.buckets, key)
+		}
+	}
+}
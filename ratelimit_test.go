@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterSpendsBurstThenRefills(t *testing.T) {
+	var l rateLimiter
+	now := time.Now()
+	every := 10 * time.Second
+
+	for i := range 2 {
+		if !l.allowed("c1", now, every, 2) {
+			t.Fatalf("action %d of a burst of 2 held back", i+1)
+		}
+		l.take("c1", now, every, 2)
+	}
+	if l.allowed("c1", now, every, 2) {
+		t.Errorf("allowed a third action with the burst spent")
+	}
+	if !l.allowed("c2", now, every, 2) {
+		t.Errorf("another container's bucket was drained too")
+	}
+	if !l.allowed("c1", now.Add(every), every, 2) {
+		t.Errorf("no token after waiting the interval")
+	}
+	if !l.allowed("c1", now, 0, 2) {
+		t.Errorf("a zero interval held back an action")
+	}
+
+	l.retain(map[string]bool{"c2": true})
+	if _, ok := l.buckets["c1"]; ok {
+		t.Errorf("kept the bucket of a container that left")
+	}
+}
+
+func TestReconcileWorkerSkipsRateLimitedContainer(t *testing.T) {
+	cogs, store, runtime := newTestWorker(t, "w1")
+	r := cogs.reconciler
+	r.actionInterval, r.actionBurst = time.Hour, 1
+	ctx := context.Background()
+
+	flaky := &Container{ID: "flaky", Image: "nginx", State: Requested, DesiredState: Running, NodeID: "w1", Scheduled: true}
+	mustSave(t, store, flaky)
+	runtime.FailStart(containerName(r.nameTemplate, flaky), errors.New("exec format error"))
+
+	// the failed start spends flaky's one token
+	if err := r.reconcileWorker(ctx); err == nil {
+		t.Fatal("first tick succeeded, want flaky's start error")
+	}
+	flaky, err := store.GetContainer(ctx, "flaky")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSave(t, store, &Container{ID: "web", Image: "nginx", State: Requested, DesiredState: Running, NodeID: "w1", Scheduled: true})
+
+	before := len(runtime.Calls())
+	if err := r.reconcileWorker(ctx); err != nil {
+		t.Fatalf("second tick: %v, want flaky skipped", err)
+	}
+	for _, call := range runtime.Calls()[before:] {
+		if call.Arg == flaky.ContainerID || call.Arg == containerName(r.nameTemplate, flaky) {
+			t.Errorf("rate-limited container got %s", call.Method)
+		}
+	}
+	web, err := store.GetContainer(ctx, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if web.State != Running {
+		t.Errorf("container within its rate is %s, want Running", web.State)
+	}
+}
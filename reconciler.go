@@ -1,38 +1,177 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
+	"math/rand/v2"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// defaultImageLabelPrefixes selects which image labels are copied onto a
+// container's annotations when it is created.
+var defaultImageLabelPrefixes = []string{"org.opencontainers.image."}
+
+// defaultMaxConcurrency bounds how many containers a worker reconciles at
+// once, so one slow pull can't hold up the rest of the tick.
+const defaultMaxConcurrency = 4
+
+// defaultTriggerDebounce is the minimum gap between a reconcile and an
+// out-of-band one. Triggers arriving sooner are merged into a single run at
+// the end of the window.
+const defaultTriggerDebounce = time.Second
+
+// defaultMaxRestarts is how many times the worker retries a start that
+// keeps failing, unless the container sets its own limit. RestartCount
+// counts the failed starts, so the worker gives up once it goes past the
+// limit, on failed start defaultMaxRestarts+1.
+const defaultMaxRestarts = 3
+
+// defaultDestroyedRetention is how long the control plane keeps a container
+// marked Destroyed. Workers normally delete the record once the runtime
+// container is gone; this drops the ones whose worker never will, such as
+// unscheduled containers or ones on a node that was removed.
+const defaultDestroyedRetention = time.Hour
+
 type Reconciler struct {
 	cogsworth *Cogsworth
 	interval  time.Duration
 	stopCh    chan struct{}
+	triggerCh chan struct{}
+
+	imageLabelPrefixes []string
+	maxConcurrency     int
+	triggerDebounce    time.Duration
+	nameTemplate       string
+	destroyedRetention time.Duration
+	maxRestarts        int
+
+	// jitter moves every tick by up to ±jitter of the interval.
+	jitter    float64
+	jitterRnd *rand.Rand
+
+	pulls pullGroup
+
+	// createSlots caps concurrent pull-and-create work at
+	// maxConcurrentPulls; containers that find no free slot wait for a
+	// later reconcile.
+	createSlots        slots
+	maxConcurrentPulls int
+
+	// actions allows each container actionBurst actions in a row, then one
+	// per actionInterval, so one stuck recreating can't take every tick;
+	// a container without a token waits for a later reconcile.
+	actions        rateLimiter
+	actionInterval time.Duration
+	actionBurst    int
+
+	// logs copies container output to files when log forwarding is on.
+	logs *logForwarder
+
+	// proxy balances service traffic on the control plane when enabled.
+	proxy *serviceProxy
+
+	// logger receives a debug record of every container reconcile.
+	logger *slog.Logger
+
+	// probe runs a container's readiness probe.
+	probe func(ctx context.Context, container *Container) error
+
+	// reconcileDuration times each reconcileContainer call by outcome.
+	reconcileDuration *histogram
+
+	// panics counts panics recovered from reconcile passes and containers.
+	panics *counter
+
+	mu         sync.Mutex
+	allocated  Resources
+	reconciled []*Container
+
+	// statuses holds the status updates of the running worker tick until
+	// reportStatuses sends them.
+	statuses []*Container
+
+	// waiting maps each container held back by a dependency to the one it
+	// waits for, so the wait is logged once rather than every tick.
+	waiting map[string]string
 }
 
 func NewReconciler(cogsworth *Cogsworth, interval time.Duration) *Reconciler {
 	return &Reconciler{
-		cogsworth: cogsworth,
-		interval:  interval,
-		stopCh:    make(chan struct{}),
+		cogsworth:          cogsworth,
+		interval:           interval,
+		stopCh:             make(chan struct{}),
+		triggerCh:          make(chan struct{}, 1),
+		imageLabelPrefixes: defaultImageLabelPrefixes,
+		maxConcurrency:     defaultMaxConcurrency,
+		triggerDebounce:    defaultTriggerDebounce,
+		nameTemplate:       defaultNameTemplate,
+		destroyedRetention: defaultDestroyedRetention,
+		maxRestarts:        defaultMaxRestarts,
+		maxConcurrentPulls: defaultMaxConcurrentPulls,
+		actionInterval:     defaultActionInterval,
+		actionBurst:        defaultActionBurst,
+		jitter:             defaultJitter,
+		jitterRnd:          newJitterRand(),
+		probe:              probeReadiness,
+		logger:             slog.Default(),
+		reconcileDuration: newHistogram(
+			"cogs_container_reconcile_duration_seconds",
+			"Time spent reconciling a single container, by outcome.",
+			"outcome",
+			defaultDurationBuckets,
+		),
+		panics: newCounter(
+			"cogs_reconcile_panics_total",
+			"Panics recovered while reconciling.",
+		),
 	}
 }
 
 func (r *Reconciler) Start(ctx context.Context) {
-	ticker := time.NewTicker(r.interval)
-	defer ticker.Stop()
+	timer := time.NewTimer(r.nextInterval())
+	defer timer.Stop()
+
+	if r.cogsworth.role == Worker {
+		go r.watch(ctx)
+	}
+
+	var last time.Time
+	var deferred <-chan time.Time
+	run := func() {
+		last = time.Now()
+		if err := r.safeReconcile(ctx); err != nil {
+			log.Printf("Reconcile error: %v", err)
+		}
+	}
 
-	r.reconcile(ctx)
+	run()
 
 	for {
 		select {
-		case <-ticker.C:
-			if err := r.reconcile(ctx); err != nil {
-				log.Printf("Reconcile error: %v", err)
+		case <-timer.C:
+			run()
+			timer.Reset(r.nextInterval())
+		case <-r.triggerCh:
+			if wait := r.triggerDebounce - time.Since(last); wait > 0 {
+				if deferred == nil {
+					deferred = time.After(wait)
+				}
+				continue
 			}
+			run()
+		case <-deferred:
+			deferred = nil
+			run()
 		case <-r.stopCh:
 			fmt.Println("Stopping reconciliation loop")
 			return
@@ -44,6 +183,75 @@ func (r *Reconciler) Start(ctx context.Context) {
 
 }
 
+func (r *Reconciler) nextInterval() time.Duration {
+	return jittered(r.interval, r.jitter, r.jitterRnd)
+}
+
+// Allocated returns the summed requests of the containers that were running
+// after the last worker reconcile.
+func (r *Reconciler) Allocated() Resources {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.allocated
+}
+
+// Reconciled returns this node's containers as the last worker reconcile
+// left them.
+func (r *Reconciler) Reconciled() []*Container {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.reconciled)
+}
+
+// Trigger requests an out-of-band reconcile. Triggers arriving while one is
+// already pending are coalesced.
+func (r *Reconciler) Trigger() {
+	select {
+	case r.triggerCh <- struct{}{}:
+	default:
+	}
+}
+
+// watch reacts to assignment pushes from the control plane, reconnecting
+// after failures. The periodic tick remains the safety net.
+func (r *Reconciler) watch(ctx context.Context) {
+	for {
+		err := r.cogsworth.apiClient.WatchAssignedContainers(ctx, r.cogsworth.nodeID, func([]*Container) {
+			r.Trigger()
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Watch disconnected: %v", err)
+
+		select {
+		case <-time.After(r.interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// safeReconcile runs one pass, turning a panic into an error so that a bad
+// record cannot take the loop down with it.
+func (r *Reconciler) safeReconcile(ctx context.Context) (err error) {
+	defer r.recoverPanic("reconcile", &err)
+	return r.reconcile(ctx)
+}
+
+// recoverPanic must be deferred directly. It logs a recovered panic with
+// its stack and reports it through err.
+func (r *Reconciler) recoverPanic(what string, err *error) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	r.panics.Inc()
+	log.Printf("Recovered from panic in %s: %v\n%s", what, p, debug.Stack())
+	*err = fmt.Errorf("panic in %s: %v", what, p)
+}
+
 func (r *Reconciler) reconcile(ctx context.Context) error {
 	if r.cogsworth.role == ControlPlane {
 		return r.reconcileControlPlane(ctx)
@@ -54,17 +262,26 @@ func (r *Reconciler) reconcile(ctx context.Context) error {
 
 func (r *Reconciler) reconcileControlPlane(ctx context.Context) error {
 	containers, _ := r.cogsworth.store.ListContainers(ctx)
-	for _, container := range containers {
-		if !container.Scheduled && container.DesiredState == Running {
-			r.cogsworth.scheduler.Schedule(ctx, container)
-		}
+	r.compactDestroyed(ctx, containers, time.Now())
+	r.expireContainers(ctx, containers, time.Now())
+	containers = append(containers, r.autoscale(ctx, containers, time.Now())...)
+	r.runMaintenance(ctx, containers, time.Now())
+
+	if err := r.cogsworth.scheduler.SchedulePending(ctx, containers); err != nil {
+		log.Printf("Scheduling error: %v", err)
+	}
+	r.refreshServices(ctx, containers)
+
+	if err := r.cogsworth.registerSelf(ctx); err != nil {
+		log.Printf("Failed to refresh control plane node: %v", err)
 	}
 
 	nodes, _ := r.cogsworth.store.ListNodes(ctx)
 	for _, node := range nodes {
-		if time.Since(node.LastSeen) > 30*time.Second {
+		if heartbeatExpired(node, time.Now()) {
 			log.Printf("Node %s is unhealthy, marking as NotReady\n", node.ID)
 			node.State = NodeNotReady
+			node.Reason = "heartbeat timeout"
 			r.cogsworth.store.SaveNode(ctx, node)
 		}
 	}
@@ -72,32 +289,268 @@ func (r *Reconciler) reconcileControlPlane(ctx context.Context) error {
 	return nil
 }
 
+// nodeHeartbeatTimeout is how long a node may go without a heartbeat
+// before it is marked NotReady.
+const nodeHeartbeatTimeout = 30 * time.Second
+
+// heartbeatExpired judges node health on the control plane's clock alone:
+// LastSeen is stamped when a heartbeat arrives, never taken from the node.
+func heartbeatExpired(node *Node, now time.Time) bool {
+	return now.Sub(node.LastSeen) > nodeHeartbeatTimeout
+}
+
+// compactDestroyed deletes records that have been Destroyed for longer than
+// the retention period.
+func (r *Reconciler) compactDestroyed(ctx context.Context, containers []*Container, now time.Time) {
+	if r.destroyedRetention <= 0 {
+		return
+	}
+
+	for _, container := range containers {
+		if container.DesiredState != Destroyed || now.Sub(container.UpdatedAt) < r.destroyedRetention {
+			continue
+		}
+
+		if err := r.cogsworth.store.DelContainer(ctx, container.ID); err != nil {
+			log.Printf("Failed to compact destroyed container %s: %v", container.ID, err)
+			continue
+		}
+		log.Printf("Dropped container %s, destroyed since %s", container.ID, container.UpdatedAt.Format(time.RFC3339))
+	}
+}
+
+// expireContainers marks Running containers that have outlived their TTL
+// Destroyed.
+func (r *Reconciler) expireContainers(ctx context.Context, containers []*Container, now time.Time) {
+	for _, container := range containers {
+		if container.TTL <= 0 || container.State != Running || container.DesiredState == Destroyed || container.StartedAt.IsZero() {
+			continue
+		}
+		if now.Sub(container.StartedAt) < container.TTL {
+			continue
+		}
+
+		container.DesiredState = Destroyed
+		container.UpdatedAt = now
+		if err := r.cogsworth.store.SaveContainer(ctx, container); err != nil {
+			log.Printf("Failed to expire container %s: %v", container.ID, err)
+			continue
+		}
+		log.Printf("Container %s expired after its TTL of %s", container.ID, container.TTL)
+	}
+}
+
 func (r *Reconciler) reconcileWorker(ctx context.Context) error {
+	// without a runtime every container would fail and burn through its
+	// restart budget, so wait for the daemon instead
+	if err := r.cogsworth.runtime.Ping(ctx); err != nil {
+		return fmt.Errorf("skipping reconcile: %w", err)
+	}
+
 	containers, err := r.cogsworth.apiClient.GetAssignedContainers(r.cogsworth.nodeID)
 	if err != nil {
 		log.Printf("Failed to fetch containers from control plane: %v", err)
-		return err
+		if r.cogsworth.cache == nil {
+			return err
+		}
+
+		containers, err = r.cogsworth.cache.ListContainers(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read container cache: %w", err)
+		}
+		log.Printf("Reconciling %d cached containers until the control plane returns", len(containers))
+	} else {
+		r.syncCache(ctx, containers)
 	}
 
+	// dependency checks use this snapshot so every goroutine sees the same
+	// view of the tick
+	peers := make(map[string]ContainerState, len(containers))
+	for _, container := range containers {
+		peers[container.ID] = container.State
+	}
+
+	// every goroutine owns its own decoded container, so nothing mutable is
+	// shared between them
+	sem := make(chan struct{}, max(r.maxConcurrency, 1))
+	var wg sync.WaitGroup
+	var failed atomic.Int32
+	total := 0
+	assigned := make(map[string]bool, len(containers))
+
 	for _, container := range containers {
 		if container.NodeID != r.cogsworth.nodeID {
 			continue
 		}
+		total++
+		assigned[container.ID] = true
+
+		// a container being destroyed is never held back
+		if container.DesiredState != Destroyed && !r.actions.allowed(container.ID, time.Now(), r.actionInterval, r.actionBurst) {
+			r.logger.Debug("reconcile skipped", "container", container.ID, "reason", "rate limited")
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(container *Container) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			prevID, prevState := container.ContainerID, container.State
+			start := time.Now()
+			err := r.safeReconcileContainer(ctx, container, peers)
+			outcome := reconcileOutcome(container, prevID, prevState, err)
+			r.reconcileDuration.Observe(outcome, time.Since(start))
+			if outcome != outcomeNoop {
+				r.actions.take(container.ID, time.Now(), r.actionInterval, r.actionBurst)
+			}
+			if err != nil {
+				log.Printf("Failed to reconcile container %s: %v", container.ID, err)
+				failed.Add(1)
+			}
+			r.recordReconcileError(ctx, container, err)
+		}(container)
+	}
+	wg.Wait()
+	r.reportStatuses()
+	r.actions.retain(assigned)
 
-		if err := r.reconcileContainer(ctx, container); err != nil {
-			log.Printf("Failed to reconcile container %s: %v", container.ID, err)
+	var allocated Resources
+	reconciled := make([]*Container, 0, total)
+	for _, container := range containers {
+		if container.NodeID != r.cogsworth.nodeID {
+			continue
+		}
+		reconciled = append(reconciled, container)
+		if container.State == Running || container.State == Paused {
+			allocated = allocated.Add(container.Resources)
 		}
 	}
+	r.mu.Lock()
+	r.allocated = allocated
+	r.reconciled = reconciled
+	r.mu.Unlock()
+
+	if r.logs != nil {
+		r.logs.Sync(ctx, reconciled)
+	}
+
+	if n := failed.Load(); n > 0 {
+		return fmt.Errorf("%d of %d containers failed to reconcile", n, total)
+	}
 
 	return nil
 }
 
-func (r *Reconciler) reconcileContainer(ctx context.Context, container *Container) error {
+// safeReconcileContainer recovers here as well, since a panic in one of
+// the worker goroutines would otherwise bring down the process.
+func (r *Reconciler) safeReconcileContainer(ctx context.Context, container *Container, peers map[string]ContainerState) (err error) {
+	defer r.recoverPanic("reconcile of container "+container.ID, &err)
+	return r.reconcileContainer(ctx, container, peers)
+}
+
+// recordReconcileError persists the outcome of a failed reconcile on the
+// container, or clears a previous error after a success. A Failed
+// container keeps the error that failed it. A repeat of the same error is
+// only rewritten once per interval so a stuck container doesn't flood the
+// control plane with identical updates.
+func (r *Reconciler) recordReconcileError(ctx context.Context, container *Container, err error) {
+	now := time.Now()
+
+	if err == nil {
+		if container.LastError == "" || container.State == Failed {
+			return
+		}
+		container.LastError = ""
+	} else {
+		if container.LastError == err.Error() && now.Sub(container.LastReconcileAt) < r.interval {
+			return
+		}
+		container.LastError = err.Error()
+	}
+
+	container.LastReconcileAt = now
+	r.saveContainerStatus(ctx, container)
+}
+
+// syncCache replaces the worker's cached assignments with the latest list
+// from the control plane.
+func (r *Reconciler) syncCache(ctx context.Context, containers []*Container) {
+	if r.cogsworth.cache == nil {
+		return
+	}
+
+	cached, err := r.cogsworth.cache.ListContainers(ctx)
+	if err != nil {
+		log.Printf("Failed to read container cache: %v", err)
+		return
+	}
+
+	assigned := make(map[string]bool, len(containers))
+	for _, container := range containers {
+		assigned[container.ID] = true
+		r.cacheContainer(ctx, container)
+	}
+
+	for _, container := range cached {
+		if !assigned[container.ID] {
+			if err := r.cogsworth.cache.DelContainer(ctx, container.ID); err != nil {
+				log.Printf("Failed to evict cached container %s: %v", container.ID, err)
+			}
+		}
+	}
+}
+
+func (r *Reconciler) cacheContainer(ctx context.Context, container *Container) {
+	if r.cogsworth.cache == nil {
+		return
+	}
+
+	// the cache keeps its own versions, which must not leak into the
+	// control plane's copy
+	cached := *container
+	err := r.cogsworth.cache.SaveContainer(ctx, &cached)
+	if errors.Is(err, ErrInvalidTransition) || errors.Is(err, ErrConflict) {
+		// the control plane is authoritative, so a stale cached state must
+		// not block the refresh
+		if err = r.cogsworth.cache.DelContainer(ctx, container.ID); err == nil {
+			err = r.cogsworth.cache.SaveContainer(ctx, &cached)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to cache container %s: %v", container.ID, err)
+	}
+}
+
+func (r *Reconciler) reconcileContainer(ctx context.Context, container *Container, peers map[string]ContainerState) (err error) {
 	var actualState ContainerState
+	if r.logger.Enabled(ctx, slog.LevelDebug) {
+		prevID, prevState := container.ContainerID, container.State
+		defer func() {
+			r.logger.Debug("reconcile",
+				"container", container.ID,
+				"desired", container.DesiredState,
+				"observed", cmp.Or(actualState, "none"),
+				"state", container.State,
+				"decision", reconcileOutcome(container, prevID, prevState, err))
+		}()
+	}
+
+	if container.State == Completed && container.DesiredState != Destroyed {
+		// a finished job is left as it is until it is deleted
+		return nil
+	}
+
 	var runtimeExists bool
 
 	if container.ContainerID != "" {
 		status, err := r.cogsworth.runtime.Inspect(ctx, container.ContainerID)
+		if err != nil && !errors.Is(err, ErrContainerNotFound) {
+			// a daemon hiccup is not proof the container is gone, and
+			// recreating on it would leave a duplicate behind
+			return err
+		}
 		if err != nil {
 			runtimeExists = false
 			actualState = ""
@@ -107,8 +560,24 @@ func (r *Reconciler) reconcileContainer(ctx context.Context, container *Containe
 			switch status.State {
 			case "running":
 				actualState = Running
+				// a start whose inspect failed left the picked ports and
+				// address unread, and a runtime restart may change the latter
+				changed := assignHostPorts(container, status.Ports)
+				if status.IPAddress != "" && status.IPAddress != container.IPAddress {
+					container.IPAddress = status.IPAddress
+					changed = true
+				}
+				if changed {
+					r.saveContainerStatus(ctx, container)
+				}
+			case "paused":
+				actualState = Paused
 			case "exited", "dead":
 				actualState = Stopped
+				if (container.DesiredState == Running || container.DesiredState == Paused) && !restartsAfter(container, status.ExitCode) {
+					r.finishContainer(ctx, container, status)
+					return nil
+				}
 			case "created":
 				actualState = Created
 			default:
@@ -119,9 +588,20 @@ func (r *Reconciler) reconcileContainer(ctx context.Context, container *Containe
 		runtimeExists = false
 	}
 
+	// an address is only current while the container runs or is paused;
+	// once it stops the runtime may hand it to another container
+	if actualState != Running && actualState != Paused && container.IPAddress != "" {
+		container.IPAddress = ""
+		container.Ready = false
+		container.UpdatedAt = time.Now()
+		r.saveContainerStatus(ctx, container)
+	}
+
 	switch container.DesiredState {
 	case Running:
-		return r.reconcileRunning(ctx, container, actualState, runtimeExists)
+		return r.reconcileRunning(ctx, container, actualState, runtimeExists, peers)
+	case Paused:
+		return r.reconcilePaused(ctx, container, actualState, runtimeExists, peers)
 	case Stopped:
 		return r.reconcileStopped(ctx, container, actualState, runtimeExists)
 	case Destroyed:
@@ -131,43 +611,112 @@ func (r *Reconciler) reconcileContainer(ctx context.Context, container *Containe
 	return nil
 }
 
-func (r *Reconciler) reconcileRunning(ctx context.Context, container *Container, actualState ContainerState, exists bool) error {
-	if container.RestartCount >= 3 {
+func (r *Reconciler) reconcileRunning(ctx context.Context, container *Container, actualState ContainerState, exists bool, peers map[string]ContainerState) error {
+	maxRestarts := r.restartLimit(container)
+	if container.RestartCount > maxRestarts {
 		return nil
 	}
-	if !exists {
-		fmt.Printf("Container %s is missing, recreating...\n", container.ID)
 
-		err := r.cogsworth.runtime.Pull(ctx, container.Image)
-		if err != nil {
+	if exists && container.SpecHash == "" {
+		// created before spec hashes were recorded; adopt it as-is rather
+		// than restarting every existing container
+		container.SpecHash = specHash(container)
+		r.saveContainerStatus(ctx, container)
+	}
+
+	if exists && (container.ObservedGeneration != container.Generation || container.SpecHash != specHash(container)) {
+		fmt.Printf("Container %s spec changed (generation %d), recreating...\n", container.ID, container.Generation)
+
+		if err := r.removeRuntimeContainer(ctx, container, actualState); err != nil {
 			return err
 		}
+		exists = false
+		actualState = ""
+	}
 
-		spec := &ContainerSpec{
-			Image: container.Image,
-			Ports: container.Ports,
-			Env:   container.Env,
-			Name:  container.ID,
+	if !exists {
+		if !r.createSlots.tryAcquire(r.maxConcurrentPulls) {
+			fmt.Printf("Container %s is waiting for a free pull slot\n", container.ID)
+			return nil
 		}
 
-		dockerID, err := r.cogsworth.runtime.Create(ctx, spec)
+		fmt.Printf("Container %s is missing, recreating...\n", container.ID)
+
+		dockerID, err := r.createRuntimeContainer(ctx, container)
+		if r.createSlots.release() {
+			r.Trigger()
+		}
 		if err != nil {
 			return err
 		}
 
 		container.ContainerID = dockerID
 		container.State = Created
+		container.ObservedGeneration = container.Generation
+		container.SpecHash = specHash(container)
+		r.applyImageLabels(ctx, container)
+
+		r.saveContainerStatus(ctx, container)
+	}
 
+	if actualState == Paused {
+		if err := r.cogsworth.runtime.Unpause(ctx, container.ContainerID); err != nil {
+			return err
+		}
+		fmt.Printf("Container %s unpaused\n", container.ID)
+
+		container.State = Running
+		container.UpdatedAt = time.Now()
 		r.saveContainerStatus(ctx, container)
+		actualState = Running
 	}
 
 	if actualState != Running {
-		err := r.cogsworth.runtime.Start(ctx, container.ContainerID)
+		dep, err := r.pendingDependency(ctx, container, peers)
+		if errors.Is(err, ErrNotFound) {
+			// it was deleted regardless, so this container can never start
+			fmt.Printf("Container %s depends on %s, which no longer exists, giving up\n", container.ID, dep)
+			r.noteWaiting(container.ID, "")
+			container.State = Failed
+			container.DesiredState = Stopped
+			container.Ready = false
+			container.LastError = fmt.Sprintf("dependency %s no longer exists", dep)
+			container.UpdatedAt = time.Now()
+			r.saveContainerStatus(ctx, container)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if dep != "" {
+			if r.noteWaiting(container.ID, dep) {
+				fmt.Printf("Container %s is waiting for dependency %s\n", container.ID, dep)
+			}
+			r.setReady(ctx, container, false)
+			return nil
+		}
+		r.noteWaiting(container.ID, "")
+
+		err = r.cogsworth.runtime.Start(ctx, container.ContainerID)
 		if err != nil {
+			// the daemon's error is often terse; what it recorded on the
+			// container (an OOM kill, the exit code) says more
+			if status, inspectErr := r.cogsworth.runtime.Inspect(ctx, container.ContainerID); inspectErr == nil {
+				if reason := exitReason(status); reason != "" && !strings.Contains(err.Error(), reason) {
+					err = fmt.Errorf("%w (%s)", err, reason)
+				}
+			}
+
 			container.RestartCount++
+			container.Ready = false
+			container.IPAddress = ""
+			container.LastError = err.Error()
 			container.UpdatedAt = time.Now()
+			container.LastReconcileAt = container.UpdatedAt
 
-			if container.RestartCount >= 3 {
+			// the first failed start is not a retry, so the limit is
+			// reached on the failure after it
+			if container.RestartCount > maxRestarts {
 				fmt.Printf("Max restart: container %s failed %d times, giving up\n", container.ID, container.RestartCount)
 				container.State = Failed
 				container.DesiredState = Stopped
@@ -182,36 +731,207 @@ func (r *Reconciler) reconcileRunning(ctx context.Context, container *Container,
 		status, _ := r.cogsworth.runtime.Inspect(ctx, container.ContainerID)
 		if status != nil {
 			container.IPAddress = status.IPAddress
+			assignHostPorts(container, status.Ports)
 		}
 
 		container.State = Running
 		container.UpdatedAt = time.Now()
+		container.StartedAt = container.UpdatedAt
 
 		r.saveContainerStatus(ctx, container)
 	}
 
+	r.updateReadiness(ctx, container)
+	r.sampleUsage(ctx, container)
+	return nil
+}
+
+// usageSampleTimeout bounds a stats request, which the daemon answers
+// only after taking two samples.
+const usageSampleTimeout = 5 * time.Second
+
+// sampleUsage records the running container's current resource usage for
+// the control plane. UpdatedAt is left alone since the spec and state are
+// unchanged.
+func (r *Reconciler) sampleUsage(ctx context.Context, container *Container) {
+	ctx, cancel := context.WithTimeout(ctx, usageSampleTimeout)
+	defer cancel()
+
+	stats, err := r.cogsworth.runtime.Stats(ctx, container.ContainerID)
+	if err != nil {
+		r.logger.Debug("usage sample failed", "container", container.ID, "error", err)
+		return
+	}
+
+	container.Usage = &ContainerUsage{RuntimeStats: *stats, SampledAt: time.Now()}
+	r.saveContainerStatus(ctx, container)
+}
+
+// reconcilePaused freezes the container, starting it first if it isn't
+// running yet. A paused container is never ready.
+func (r *Reconciler) reconcilePaused(ctx context.Context, container *Container, actualState ContainerState, exists bool, peers map[string]ContainerState) error {
+	if actualState != Running && actualState != Paused {
+		if err := r.reconcileRunning(ctx, container, actualState, exists, peers); err != nil {
+			return err
+		}
+		if container.State != Running {
+			return nil
+		}
+		actualState = Running
+	}
+
+	if actualState == Running {
+		if err := r.cogsworth.runtime.Pause(ctx, container.ContainerID); err != nil {
+			return err
+		}
+		fmt.Printf("Container %s paused\n", container.ID)
+	}
+
+	if container.State != Paused || container.Ready {
+		container.State = Paused
+		container.Ready = false
+		container.UpdatedAt = time.Now()
+		r.saveContainerStatus(ctx, container)
+	}
+	return nil
+}
+
+// finishContainer records a container that exited and is not restarted:
+// Completed after exit 0, Failed otherwise.
+func (r *Reconciler) finishContainer(ctx context.Context, container *Container, status *RuntimeStatus) {
+	state := Completed
+	if status.ExitCode != 0 {
+		state = Failed
+	}
+	if container.State == state {
+		return
+	}
+
+	fmt.Printf("Container %s exited with code %d, marking it %s\n", container.ID, status.ExitCode, state)
+	container.State = state
+	container.Ready = false
+	container.IPAddress = ""
+	container.UpdatedAt = time.Now()
+	if state == Failed {
+		container.LastError = "exited: " + exitReason(status)
+		container.LastReconcileAt = container.UpdatedAt
+	}
+	r.saveContainerStatus(ctx, container)
+}
+
+// createRuntimeContainer pulls the image as needed and creates the runtime
+// container, returning its ID.
+func (r *Reconciler) createRuntimeContainer(ctx context.Context, container *Container) (string, error) {
+	if err := r.ensureImage(ctx, container); err != nil {
+		return "", err
+	}
+
+	env, err := r.containerEnv(ctx, container)
+	if err != nil {
+		return "", err
+	}
+
+	spec := &ContainerSpec{
+		Image:      container.Image,
+		Ports:      specPorts(container.Ports),
+		Env:        env,
+		Name:       containerName(r.nameTemplate, container),
+		LegacyName: container.ID,
+
+		Command: container.Command,
+		Args:    container.Args,
+
+		StopSignal:  container.StopSignal,
+		StopTimeout: container.StopTimeoutSeconds(),
+
+		Resources: container.Resources,
+		SpecHash:  specHash(container),
+	}
+
+	if container.Network != "" {
+		if err := r.cogsworth.runtime.CreateNetwork(ctx, container.Network); err != nil {
+			return "", err
+		}
+	}
+
+	id, err := r.cogsworth.runtime.Create(ctx, spec)
+	if err != nil || container.Network == "" {
+		return id, err
+	}
+
+	aliases := []string{container.ID}
+	if container.Name != "" {
+		aliases = append(aliases, container.Name)
+	}
+	if err := r.cogsworth.runtime.ConnectNetwork(ctx, container.Network, id, aliases); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// restartLimit is the container's MaxRestarts, or the reconciler's default
+// when the container doesn't set one.
+func (r *Reconciler) restartLimit(container *Container) int {
+	if container.MaxRestarts > 0 {
+		return container.MaxRestarts
+	}
+	return r.maxRestarts
+}
+
+// removeRuntimeContainer stops and removes the current runtime container so
+// it can be recreated from an updated spec.
+func (r *Reconciler) removeRuntimeContainer(ctx context.Context, container *Container, actualState ContainerState) error {
+	if actualState == Running {
+		if err := r.cogsworth.runtime.Stop(ctx, container.ContainerID, container.StopTimeoutSeconds()); err != nil {
+			return err
+		}
+	}
+
+	if err := r.cogsworth.runtime.Remove(ctx, container.ContainerID, true); err != nil && !errors.Is(err, ErrContainerNotFound) {
+		return err
+	}
+
+	container.ContainerID = ""
+	container.IPAddress = ""
+	container.Ports = specPorts(container.Ports)
+	container.State = Stopped
+	container.Ready = false
+	container.UpdatedAt = time.Now()
+
 	return nil
 }
 
 func (r *Reconciler) reconcileStopped(ctx context.Context, container *Container, actualState ContainerState, exists bool) error {
+	if exists && actualState == Paused {
+		// thaw it so the stop signal is handled rather than waited out
+		if err := r.cogsworth.runtime.Unpause(ctx, container.ContainerID); err != nil {
+			return err
+		}
+		actualState = Running
+	}
+
 	if exists && actualState == Running {
-		err := r.cogsworth.runtime.Stop(ctx, container.ContainerID, 10)
+		err := r.cogsworth.runtime.Stop(ctx, container.ContainerID, container.StopTimeoutSeconds())
 		if err != nil {
 			return err
 		}
 
 		container.State = Stopped
+		container.Ready = false
+		container.IPAddress = ""
 		container.UpdatedAt = time.Now()
 		r.saveContainerStatus(ctx, container)
 	}
 
+	r.setReady(ctx, container, false)
 	return nil
 }
 
 func (r *Reconciler) reconcileDestroyed(ctx context.Context, container *Container, exists bool) error {
+	r.noteWaiting(container.ID, "")
 	if exists {
-		err := r.cogsworth.runtime.Remove(ctx, container.ContainerID)
-		if err != nil {
+		// removed behind our back since the inspect; nothing left to do
+		if err := r.gracefulRemove(ctx, container); err != nil && !errors.Is(err, ErrContainerNotFound) {
 			return err
 		}
 	}
@@ -221,11 +941,98 @@ func (r *Reconciler) reconcileDestroyed(ctx context.Context, container *Containe
 	return nil
 }
 
-func (r *Reconciler) saveContainerStatus(ctx context.Context, container *Container) {
+// gracefulRemove stops the runtime container within its stop timeout before
+// removing it, and only forces the removal when that fails.
+func (r *Reconciler) gracefulRemove(ctx context.Context, container *Container) error {
+	runtime := r.cogsworth.runtime
+
+	err := runtime.Stop(ctx, container.ContainerID, container.StopTimeoutSeconds())
+	if err == nil {
+		err = runtime.Remove(ctx, container.ContainerID, false)
+	}
+	if err != nil {
+		log.Printf("Graceful removal of %s failed, forcing it: %v", container.ID, err)
+		return runtime.Remove(ctx, container.ContainerID, true)
+	}
+
+	return nil
+}
+
+// containerEnv returns the container's env with its referenced secrets
+// merged in. The result is only handed to the runtime; it must never be
+// stored back on the container or reported to the control plane.
+func (r *Reconciler) containerEnv(ctx context.Context, container *Container) (map[string]string, error) {
+	if len(container.SecretRefs) == 0 {
+		return container.Env, nil
+	}
+
+	env := make(map[string]string, len(container.Env))
+	for k, v := range container.Env {
+		env[k] = v
+	}
+
+	for _, name := range container.SecretRefs {
+		secret, err := r.getSecret(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve secret %s: %w", name, err)
+		}
+
+		for k, v := range secret.Data {
+			env[k] = v
+		}
+	}
+
+	return env, nil
+}
+
+func (r *Reconciler) getSecret(ctx context.Context, name string) (*Secret, error) {
 	if r.cogsworth.role == Worker {
-		if err := r.cogsworth.apiClient.UpdateContainerStatus(container); err != nil {
-			log.Printf("Failed to report status: %v", err)
+		return r.cogsworth.apiClient.GetSecret(name)
+	}
+	return r.cogsworth.store.GetSecret(ctx, name)
+}
+
+// applyImageLabels copies the image's labels matching the configured prefixes
+// into the container's annotations. Annotations already set are kept.
+func (r *Reconciler) applyImageLabels(ctx context.Context, container *Container) {
+	labels, err := r.cogsworth.runtime.ImageLabels(ctx, container.Image)
+	if err != nil {
+		log.Printf("Failed to read labels of image %s: %v", container.Image, err)
+		return
+	}
+
+	container.Annotations = mergeImageLabels(container.Annotations, labels, r.imageLabelPrefixes)
+}
+
+func mergeImageLabels(annotations, labels map[string]string, prefixes []string) map[string]string {
+	for key, value := range labels {
+		if _, ok := annotations[key]; ok {
+			continue
 		}
+
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				if annotations == nil {
+					annotations = make(map[string]string)
+				}
+				annotations[key] = value
+				break
+			}
+		}
+	}
+
+	return annotations
+}
+
+func (r *Reconciler) saveContainerStatus(ctx context.Context, container *Container) {
+	if !container.State.Valid() {
+		log.Printf("Refusing to save container %s with unknown state %q", container.ID, container.State)
+		return
+	}
+
+	if r.cogsworth.role == Worker {
+		r.cacheContainer(ctx, container)
+		r.queueStatus(container)
 	} else {
 		if err := r.cogsworth.store.SaveContainer(ctx, container); err != nil {
 			log.Printf("Failed to save container: %v", err)
@@ -233,9 +1040,70 @@ func (r *Reconciler) saveContainerStatus(ctx context.Context, container *Contain
 	}
 }
 
+// queueStatus keeps a snapshot of the container, which its goroutine goes
+// on changing, for reportStatuses.
+func (r *Reconciler) queueStatus(container *Container) {
+	data, err := json.Marshal(container)
+	if err != nil {
+		log.Printf("Failed to queue status of %s: %v", container.ID, err)
+		return
+	}
+	snapshot := &Container{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		log.Printf("Failed to queue status of %s: %v", container.ID, err)
+		return
+	}
+
+	r.mu.Lock()
+	r.statuses = append(r.statuses, snapshot)
+	r.mu.Unlock()
+}
+
+// reportStatuses sends the queued statuses to the control plane in one
+// request. Every intermediate state is included so each step passes the
+// transition check.
+func (r *Reconciler) reportStatuses() {
+	r.mu.Lock()
+	statuses := r.statuses
+	r.statuses = nil
+	r.mu.Unlock()
+
+	if len(statuses) == 0 {
+		return
+	}
+
+	err := r.cogsworth.apiClient.UpdateContainerStatuses(statuses)
+	var apiErr *APIError
+	if err == nil || !errors.As(err, &apiErr) {
+		if err != nil {
+			log.Printf("Failed to report status: %v", err)
+		}
+		return
+	}
+
+	// one stale container rejects the whole batch, and older control
+	// planes lack the endpoint, so fall back to one request per update
+	log.Printf("Status batch rejected, reporting one by one: %v", err)
+	for _, container := range statuses {
+		if err := r.cogsworth.apiClient.UpdateContainerStatus(container); err != nil {
+			log.Printf("Failed to report status: %v", err)
+		}
+	}
+}
+
 func (r *Reconciler) deleteContainer(ctx context.Context, containerID string) {
 	if r.cogsworth.role == Worker {
-		if err := r.cogsworth.apiClient.DeleteContainer(containerID); err != nil {
+		// a queued status would bring the deleted record back
+		r.mu.Lock()
+		r.statuses = slices.DeleteFunc(r.statuses, func(c *Container) bool { return c.ID == containerID })
+		r.mu.Unlock()
+
+		if r.cogsworth.cache != nil {
+			if err := r.cogsworth.cache.DelContainer(ctx, containerID); err != nil {
+				log.Printf("Failed to evict cached container %s: %v", containerID, err)
+			}
+		}
+		if err := r.cogsworth.apiClient.DeleteContainer(containerID, false); err != nil && !errors.Is(err, ErrNotFound) {
 			log.Printf("Failed to notify control plane of deletion: %v", err)
 		}
 	} else {
@@ -0,0 +1,1120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/galadd/cogsworth/api"
+	"github.com/galadd/cogsworth/client"
+)
+
+// newTestReconciler wires a reconciler to a MemStore and a FakeRuntime, in
+// the standalone role where statuses are saved straight to the store.
+func newTestReconciler(t *testing.T) (*Reconciler, *MemStore, *FakeRuntime) {
+	t.Helper()
+
+	store := NewMemStore()
+	runtime := NewFakeRuntime()
+	cogs := NewCogsworth(store, runtime)
+	// every action in a test is deliberate
+	cogs.reconciler.actionInterval = 0
+	return cogs.reconciler, store, runtime
+}
+
+// saveTestContainer saves c and returns the stored copy, the way the
+// reconciler reads it.
+func saveTestContainer(t *testing.T, store Store, c *Container) *Container {
+	t.Helper()
+	ctx := context.Background()
+
+	if err := store.SaveContainer(ctx, c); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.GetContainer(ctx, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+func TestReconcileMissingContainerPullsCreatesAndStarts(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	ctx := context.Background()
+
+	c := saveTestContainer(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running})
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatalf("reconcileContainer: %v", err)
+	}
+
+	want := []string{"Pull", "Create", "Start"}
+	var got []string
+	for _, m := range runtime.Methods() {
+		if slices.Contains(want, m) {
+			got = append(got, m)
+		}
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("runtime calls = %v, want %v in order", runtime.Methods(), want)
+	}
+
+	stored, err := store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.State != Running || stored.ContainerID == "" {
+		t.Errorf("stored container is %s with runtime ID %q, want running with one", stored.State, stored.ContainerID)
+	}
+}
+
+func TestReconcileAdoptsSameNamedContainerOnlyWithSameSpec(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	ctx := context.Background()
+
+	c := saveTestContainer(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running})
+	name := containerName(r.nameTemplate, c)
+
+	// left behind by an earlier assignment with the same spec
+	same, err := runtime.Create(ctx, &ContainerSpec{Image: "nginx", Name: name, SpecHash: specHash(c)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatalf("reconcileContainer: %v", err)
+	}
+	stored, err := store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ContainerID != same {
+		t.Errorf("runtime container = %q, want the same-spec %q adopted", stored.ContainerID, same)
+	}
+}
+
+func TestReconcileReplacesSameNamedContainerWithOtherSpec(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	ctx := context.Background()
+
+	c := saveTestContainer(t, store, &Container{ID: "c1", Image: "nginx:2", State: Requested, DesiredState: Running})
+	name := containerName(r.nameTemplate, c)
+
+	stale, err := runtime.Create(ctx, &ContainerSpec{Image: "nginx:1", Name: name, SpecHash: "older"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatalf("reconcileContainer: %v", err)
+	}
+	stored, err := store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ContainerID == "" || stored.ContainerID == stale {
+		t.Errorf("runtime container = %q, want a new one replacing %q", stored.ContainerID, stale)
+	}
+	if _, err := runtime.Inspect(ctx, stale); err == nil {
+		t.Errorf("stale container %s still exists", stale)
+	}
+}
+
+func TestReconcileSurfacesImageLabelsAsAnnotations(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	ctx := context.Background()
+
+	runtime.SetImageLabels("nginx", map[string]string{
+		"org.opencontainers.image.version": "1.27",
+		"org.opencontainers.image.source":  "https://example.com/nginx",
+		"maintainer":                       "someone",
+	})
+	c := saveTestContainer(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running,
+		Annotations: map[string]string{"org.opencontainers.image.version": "pinned"}})
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatalf("reconcileContainer: %v", err)
+	}
+
+	stored, err := store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"org.opencontainers.image.version": "pinned",
+		"org.opencontainers.image.source":  "https://example.com/nginx",
+	}
+	if !maps.Equal(stored.Annotations, want) {
+		t.Errorf("annotations = %v, want %v", stored.Annotations, want)
+	}
+}
+
+func TestWorkerResolvesSecretsOnlyIntoRuntimeEnv(t *testing.T) {
+	cogs, store, runtime := newTestWorker(t, "w1")
+	r := cogs.reconciler
+	ctx := context.Background()
+
+	mustSave(t, store, &Secret{Name: "db", Data: map[string]string{"PASSWORD": "hunter2"}})
+	c := saveTestContainer(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running,
+		NodeID: "w1", Scheduled: true, Env: map[string]string{"MODE": "prod"}, SecretRefs: []string{"db"}})
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatalf("reconcileContainer: %v", err)
+	}
+
+	spec, ok := runtime.Spec(containerName(r.nameTemplate, c))
+	if !ok {
+		t.Fatal("nothing was created")
+	}
+	if want := map[string]string{"MODE": "prod", "PASSWORD": "hunter2"}; !maps.Equal(spec.Env, want) {
+		t.Errorf("runtime env = %v, want %v", spec.Env, want)
+	}
+
+	stored, err := store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, leaked := stored.Env["PASSWORD"]; leaked {
+		t.Errorf("secret value stored on the container: %v", stored.Env)
+	}
+}
+
+func TestWorkerFailsCreateOnMissingSecret(t *testing.T) {
+	cogs, store, runtime := newTestWorker(t, "w1")
+	ctx := context.Background()
+
+	c := saveTestContainer(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running,
+		NodeID: "w1", Scheduled: true, SecretRefs: []string{"missing"}})
+	if err := cogs.reconciler.reconcileContainer(ctx, c, nil); err == nil {
+		t.Error("reconcileContainer succeeded without its secret")
+	}
+	if slices.Contains(runtime.Methods(), "Create") {
+		t.Error("created a container whose secret couldn't be resolved")
+	}
+}
+
+func TestReconcileImageChangePullsAndRecreates(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	ctx := context.Background()
+
+	c := saveTestContainer(t, store, &Container{ID: "c1", Image: "nginx:1", State: Requested, DesiredState: Running})
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatalf("first reconcile: %v", err)
+	}
+	c, err := store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := c.ContainerID
+
+	// what PATCH /containers/c1 with a new image saves
+	c.Image = "nginx:2"
+	c.Generation++
+	c = saveTestContainer(t, store, c)
+	before := len(runtime.Calls())
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatalf("reconcile after the image change: %v", err)
+	}
+
+	var got []FakeCall
+	for _, call := range runtime.Calls()[before:] {
+		if slices.Contains([]string{"Stop", "Remove", "Pull", "Create", "Start"}, call.Method) {
+			got = append(got, call)
+		}
+	}
+	name := containerName(r.nameTemplate, c)
+	stored, err := store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FakeCall{{"Stop", old}, {"Remove", old}, {"Pull", "nginx:2"}, {"Create", name}, {"Start", stored.ContainerID}}
+	if !slices.Equal(got, want) {
+		t.Errorf("runtime calls = %v, want %v", got, want)
+	}
+	if stored.ContainerID == old || stored.State != Running || stored.ObservedGeneration != stored.Generation {
+		t.Errorf("stored %s as %s at generation %d/%d, want a new running container at the new generation",
+			stored.ContainerID, stored.State, stored.ObservedGeneration, stored.Generation)
+	}
+}
+
+func TestReconcileWorkerProgressesPastBlockedCreate(t *testing.T) {
+	cogs, store, runtime := newTestWorker(t, "w1")
+	r := cogs.reconciler
+	// a create slot for each, so only the blocked create holds anyone up
+	r.maxConcurrentPulls = 3
+
+	for _, id := range []string{"stuck", "c2", "c3"} {
+		mustSave(t, store, &Container{ID: id, Image: "nginx", State: Requested, DesiredState: Running,
+			NodeID: "w1", Scheduled: true})
+	}
+	runtime.BlockCreate(containerName(r.nameTemplate, &Container{ID: "stuck", Image: "nginx"}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- r.reconcileWorker(ctx) }()
+
+	deadline := time.After(5 * time.Second)
+	for started := 0; started < 2; {
+		select {
+		case err := <-done:
+			t.Fatalf("tick ended before the others started: %v", err)
+		case <-deadline:
+			t.Fatalf("runtime calls = %v, want c2 and c3 started while stuck blocks", runtime.Methods())
+		case <-time.After(10 * time.Millisecond):
+		}
+		started = 0
+		for _, m := range runtime.Methods() {
+			if m == "Start" {
+				started++
+			}
+		}
+	}
+
+	cancel()
+	if err := <-done; err == nil {
+		t.Error("tick reported success with a create cancelled")
+	}
+}
+
+func TestReconcileWorkerKeepsCachedContainersWhileControlPlaneIsDown(t *testing.T) {
+	cogs, store, runtime := newTestWorker(t, "w1")
+	cogs.cache = NewMemStore()
+	r := cogs.reconciler
+	ctx := context.Background()
+
+	mustSave(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running,
+		NodeID: "w1", Scheduled: true})
+	if err := r.reconcileWorker(ctx); err != nil {
+		t.Fatalf("first tick: %v", err)
+	}
+	creates := func() int {
+		n := 0
+		for _, m := range runtime.Methods() {
+			if m == "Create" {
+				n++
+			}
+		}
+		return n
+	}
+	if creates() != 1 {
+		t.Fatalf("runtime calls = %v, want one Create", runtime.Methods())
+	}
+
+	// the control plane goes away and the runtime container with it
+	cogs.apiClient = client.New("http://127.0.0.1:1", testToken, nil).ForNode("w1")
+	cached, err := cogs.cache.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatalf("c1 wasn't cached: %v", err)
+	}
+	if err := runtime.Remove(ctx, cached.ContainerID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.reconcileWorker(ctx); err != nil {
+		t.Fatalf("tick from the cache: %v", err)
+	}
+	if creates() != 2 {
+		t.Errorf("runtime calls = %v, want c1 recreated from the cache", runtime.Methods())
+	}
+
+	// without a cache the tick fails instead
+	cogs.cache = nil
+	if err := r.reconcileWorker(ctx); err == nil {
+		t.Error("tick without a cache or control plane succeeded")
+	}
+}
+
+func TestTriggerReconcilesWithoutWaitingForTick(t *testing.T) {
+	store := NewMemStore()
+	cogs, err := NewControlPlane(store, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := cogs.reconciler
+	r.interval = time.Hour
+	r.jitter = 0
+	r.triggerDebounce = 0
+	handler := cogs.apiServer.handler()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Start(ctx)
+	// let the initial pass finish on the empty store
+	time.Sleep(50 * time.Millisecond)
+
+	mustSave(t, store,
+		&Node{ID: "w1", Role: Worker, State: NodeReady, LastSeen: time.Now()},
+		&Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running})
+	scheduled := func() bool {
+		c, err := store.GetContainer(ctx, "c1")
+		return err == nil && c.Scheduled
+	}
+	time.Sleep(50 * time.Millisecond)
+	if scheduled() {
+		t.Fatal("c1 was scheduled before any trigger")
+	}
+
+	if rec := call(t, handler, http.MethodPost, "/reconcile", nil); rec.Code != http.StatusAccepted {
+		t.Fatalf("trigger: %d %s", rec.Code, rec.Body.String())
+	}
+	for deadline := time.Now().Add(5 * time.Second); !scheduled(); {
+		if time.Now().After(deadline) {
+			t.Fatal("the triggered reconcile never scheduled c1")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReconcileWorkerWaitsForUnreachableRuntime(t *testing.T) {
+	cogs, store, runtime := newTestWorker(t, "w1")
+	ctx := context.Background()
+
+	mustSave(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running,
+		NodeID: "w1", Scheduled: true})
+	runtime.FailPing(errors.New("cannot connect to the Docker daemon"))
+
+	if err := cogs.reconciler.reconcileWorker(ctx); err == nil {
+		t.Error("tick succeeded with the runtime down")
+	}
+	if calls := runtime.Methods(); len(calls) != 0 {
+		t.Errorf("runtime calls = %v with the daemon down, want none", calls)
+	}
+	if c, _ := store.GetContainer(ctx, "c1"); c.RestartCount != 0 || c.State != Requested {
+		t.Errorf("c1 is %s after %d restarts, want it untouched", c.State, c.RestartCount)
+	}
+
+	runtime.FailPing(nil)
+	if err := cogs.reconciler.reconcileWorker(ctx); err != nil {
+		t.Fatalf("tick after the daemon returned: %v", err)
+	}
+	if c, _ := store.GetContainer(ctx, "c1"); c.State != Running {
+		t.Errorf("c1 is %s once the daemon is back, want running", c.State)
+	}
+}
+
+func TestReconcileWorkerPersistsAndClearsLastError(t *testing.T) {
+	cogs, store, runtime := newTestWorker(t, "w1")
+	r := cogs.reconciler
+	r.actionInterval = 0
+	ctx := context.Background()
+
+	c := &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running, NodeID: "w1", Scheduled: true}
+	mustSave(t, store, c)
+	name := containerName(r.nameTemplate, c)
+	runtime.FailCreate(name, errors.New("no space left on device"))
+
+	if err := r.reconcileWorker(ctx); err == nil {
+		t.Fatal("tick succeeded with Create failing")
+	}
+	stored, err := store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stored.LastError, "no space left on device") || stored.LastReconcileAt.IsZero() {
+		t.Errorf("last error %q at %v, want the create failure recorded", stored.LastError, stored.LastReconcileAt)
+	}
+
+	runtime.FailCreate(name, nil)
+	if err := r.reconcileWorker(ctx); err != nil {
+		t.Fatalf("tick after Create recovered: %v", err)
+	}
+	stored, err = store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.LastError != "" || stored.State != Running {
+		t.Errorf("container is %s with last error %q, want running with it cleared", stored.State, stored.LastError)
+	}
+}
+
+func TestSecretUpdateRecreatesDependentContainers(t *testing.T) {
+	cogs, store, runtime := newTestWorker(t, "w1")
+	r := cogs.reconciler
+	r.actionInterval = 0
+	ctx := context.Background()
+
+	mustSave(t, store,
+		&Secret{Name: "db", Data: map[string]string{"PASSWORD": "old"}},
+		&Container{ID: "app", Image: "nginx", State: Requested, DesiredState: Running,
+			NodeID: "w1", Scheduled: true, SecretRefs: []string{"db"}},
+		&Container{ID: "other", Image: "redis", State: Requested, DesiredState: Running,
+			NodeID: "w1", Scheduled: true})
+	if err := r.reconcileWorker(ctx); err != nil {
+		t.Fatalf("first tick: %v", err)
+	}
+	runtimeID := func(id string) string {
+		c, err := store.GetContainer(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.ContainerID
+	}
+	app, other := runtimeID("app"), runtimeID("other")
+
+	mustSave(t, store, &Secret{Name: "db", Data: map[string]string{"PASSWORD": "new"}})
+	if err := r.reconcileWorker(ctx); err != nil {
+		t.Fatalf("tick after the secret changed: %v", err)
+	}
+
+	if runtimeID("app") == app {
+		t.Error("app kept its runtime container after its secret changed")
+	}
+	if runtimeID("other") != other {
+		t.Error("other was recreated though it uses no secret")
+	}
+	spec, _ := runtime.Spec(containerName(r.nameTemplate, &Container{ID: "app", Image: "nginx"}))
+	if spec.Env["PASSWORD"] != "new" {
+		t.Errorf("runtime env = %v, want the updated secret", spec.Env)
+	}
+}
+
+func TestReconcilePassesCommandAndArgsToRuntime(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	c := saveTestContainer(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running,
+		Command: []string{"/bin/sh", "-c"}, Args: []string{"echo hi", "--verbose"}})
+	if err := r.reconcileContainer(context.Background(), c, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	spec, ok := runtime.Spec(containerName(r.nameTemplate, c))
+	if !ok {
+		t.Fatal("nothing was created")
+	}
+	if !slices.Equal(spec.Command, c.Command) || !slices.Equal(spec.Args, c.Args) {
+		t.Errorf("runtime got command %q args %q, want %q %q", spec.Command, spec.Args, c.Command, c.Args)
+	}
+}
+
+// destroyRunning starts c and then reconciles it again as destroyed,
+// returning the runtime container ID and the Stop and Remove calls the
+// destroy made.
+func destroyRunning(t *testing.T, r *Reconciler, store *MemStore, runtime *FakeRuntime, c *Container, before func(runtimeID string)) (string, []FakeCall) {
+	t.Helper()
+	ctx := context.Background()
+
+	c = saveTestContainer(t, store, c)
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	c, err := store.GetContainer(ctx, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != nil {
+		before(c.ContainerID)
+	}
+
+	c.DesiredState = Destroyed
+	c = saveTestContainer(t, store, c)
+	from := len(runtime.Calls())
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatalf("destroy: %v", err)
+	}
+	if _, err := store.GetContainer(ctx, c.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("container record: got %v, want it deleted", err)
+	}
+
+	var calls []FakeCall
+	for _, call := range runtime.Calls()[from:] {
+		if call.Method == "Stop" || call.Method == "Remove" {
+			calls = append(calls, call)
+		}
+	}
+	return c.ContainerID, calls
+}
+
+func TestDestroyStopsBeforeRemoving(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	id, calls := destroyRunning(t, r, store, runtime,
+		&Container{ID: "c1", Image: "postgres", State: Requested, DesiredState: Running, StopTimeout: 30}, nil)
+
+	if want := []FakeCall{{"Stop", id}, {"Remove", id}}; !slices.Equal(calls, want) {
+		t.Errorf("runtime calls = %v, want %v", calls, want)
+	}
+	if got := runtime.StopTimeout(id); got != 30 {
+		t.Errorf("stopped with a %ds timeout, want the container's 30s", got)
+	}
+}
+
+func TestDestroyForcesRemovalWhenStopFails(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	id, calls := destroyRunning(t, r, store, runtime,
+		&Container{ID: "c1", Image: "postgres", State: Requested, DesiredState: Running},
+		func(runtimeID string) { runtime.FailStop(runtimeID, errors.New("daemon timed out")) })
+
+	// the failed stop skips the graceful remove and goes straight to forcing it
+	if want := []FakeCall{{"Stop", id}, {"Remove", id}}; !slices.Equal(calls, want) {
+		t.Errorf("runtime calls = %v, want a stop and then one forced remove", calls)
+	}
+	if _, err := runtime.Inspect(context.Background(), id); err == nil {
+		t.Errorf("runtime container %s left behind", id)
+	}
+}
+
+func TestCompactDestroyedDropsOnlyOldRecords(t *testing.T) {
+	r, store, _ := newTestReconciler(t)
+	r.destroyedRetention = time.Hour
+	ctx := context.Background()
+	now := time.Now()
+
+	containers := []*Container{
+		{ID: "old", Image: "nginx", State: Stopped, DesiredState: Destroyed, UpdatedAt: now.Add(-2 * time.Hour)},
+		{ID: "recent", Image: "nginx", State: Stopped, DesiredState: Destroyed, UpdatedAt: now.Add(-time.Minute)},
+		{ID: "live", Image: "nginx", State: Running, DesiredState: Running, UpdatedAt: now.Add(-2 * time.Hour)},
+	}
+	for _, c := range containers {
+		mustSave(t, store, c)
+	}
+
+	r.compactDestroyed(ctx, containers, now)
+	left, err := store.ListContainers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := containerIDs(left), []string{"live", "recent"}; !slices.Equal(got, want) {
+		t.Errorf("left %v, want %v", got, want)
+	}
+}
+
+// startedContainer reconciles c until it runs and returns the stored copy.
+func startedContainer(t *testing.T, r *Reconciler, store *MemStore, c *Container) *Container {
+	t.Helper()
+	ctx := context.Background()
+
+	c = saveTestContainer(t, store, c)
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	c, err := store.GetContainer(ctx, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestReconcileAbortsOnTransientInspectError(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	c := startedContainer(t, r, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running})
+
+	old := c.ContainerID
+	runtime.FailInspect(old, errors.New("daemon busy"))
+	before := len(runtime.Calls())
+	if err := r.reconcileContainer(context.Background(), c, nil); err == nil {
+		t.Errorf("reconcile succeeded, want the inspect error")
+	}
+	for _, call := range runtime.Calls()[before:] {
+		if call.Method == "Create" {
+			t.Errorf("created %s after a transient inspect error, want no duplicate", call.Arg)
+		}
+	}
+	if stored, _ := store.GetContainer(context.Background(), "c1"); stored.ContainerID != old {
+		t.Errorf("runtime container %s, want %s kept", stored.ContainerID, old)
+	}
+}
+
+func TestReconcileRecreatesContainerNotFoundInRuntime(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	ctx := context.Background()
+	c := startedContainer(t, r, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running})
+
+	old := c.ContainerID
+
+	// removed outside cogsworth, so Inspect reports ErrContainerNotFound
+	if err := runtime.Remove(ctx, old, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	stored, err := store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ContainerID == old || stored.State != Running {
+		t.Errorf("stored %s as %s, want a new running container replacing %s", stored.ContainerID, stored.State, old)
+	}
+}
+
+func TestReconcileHonoursContainerMaxRestarts(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	ctx := context.Background()
+
+	c := saveTestContainer(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running, MaxRestarts: 5})
+	runtime.FailStart(containerName(r.nameTemplate, c), errors.New("exec format error"))
+
+	for failure := 1; failure <= 6; failure++ {
+		c, err := store.GetContainer(ctx, "c1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.reconcileContainer(ctx, c, nil); err == nil {
+			t.Fatalf("start %d succeeded, want it to fail", failure)
+		}
+
+		stored, err := store.GetContainer(ctx, "c1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		gaveUp := stored.DesiredState == Stopped
+		if stored.RestartCount != failure || gaveUp != (failure == 6) {
+			t.Fatalf("after failure %d: %d restarts, given up %v; want giving up only on the sixth", failure, stored.RestartCount, gaveUp)
+		}
+	}
+}
+
+func TestReconcileRestartLimitBoundary(t *testing.T) {
+	for _, tc := range []struct {
+		restarts int
+		retried  bool
+	}{
+		{defaultMaxRestarts, true},
+		{defaultMaxRestarts + 1, false},
+	} {
+		r, store, runtime := newTestReconciler(t)
+		c := saveTestContainer(t, store, &Container{ID: "c1", Image: "nginx", State: Failed, DesiredState: Running,
+			RestartCount: tc.restarts})
+
+		if err := r.reconcileContainer(context.Background(), c, nil); err != nil {
+			t.Fatal(err)
+		}
+		if retried := slices.Contains(runtime.Methods(), "Start"); retried != tc.retried {
+			t.Errorf("after %d failed starts: retried %v, want %v", tc.restarts, retried, tc.retried)
+		}
+	}
+}
+
+func TestStopSignalAndTimeoutReachRuntime(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	ctx := context.Background()
+
+	c := startedContainer(t, r, store, &Container{ID: "c1", Image: "postgres", State: Requested, DesiredState: Running,
+		StopSignal: "SIGINT", StopTimeout: 45})
+	spec, ok := runtime.Spec(containerName(r.nameTemplate, c))
+	if !ok {
+		t.Fatal("nothing was created")
+	}
+	if spec.StopSignal != "SIGINT" || spec.StopTimeout != 45 {
+		t.Errorf("created with signal %q timeout %d, want SIGINT and 45", spec.StopSignal, spec.StopTimeout)
+	}
+
+	c.DesiredState = Stopped
+	c = saveTestContainer(t, store, c)
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := runtime.StopTimeout(c.ContainerID); got != 45 {
+		t.Errorf("stopped with a %ds timeout, want 45", got)
+	}
+}
+
+func TestStopTimeoutDefaults(t *testing.T) {
+	if got, want := (&Container{}).StopTimeoutSeconds(), int(api.DefaultStopTimeout/time.Second); got != want {
+		t.Errorf("StopTimeoutSeconds() = %d without a timeout, want the %d default", got, want)
+	}
+}
+
+func TestReconcileWorkerCapsConcurrentCreates(t *testing.T) {
+	cogs, store, runtime := newTestWorker(t, "w1")
+	r := cogs.reconciler
+	r.maxConcurrentPulls = 2
+
+	for _, id := range []string{"c1", "c2", "c3", "c4", "c5"} {
+		c := &Container{ID: id, Image: "nginx", State: Requested, DesiredState: Running, NodeID: "w1", Scheduled: true}
+		mustSave(t, store, c)
+		runtime.BlockCreate(containerName(r.nameTemplate, c))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- r.reconcileWorker(ctx) }()
+
+	creates := func() int {
+		n := 0
+		for _, m := range runtime.Methods() {
+			if m == "Create" {
+				n++
+			}
+		}
+		return n
+	}
+	deadline := time.After(5 * time.Second)
+	for creates() < 2 {
+		select {
+		case <-deadline:
+			t.Fatalf("runtime calls = %v, want two creates under way", runtime.Methods())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	// give the other three a moment to find no free slot
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	<-done
+	if got := runtime.MaxConcurrentCreates(); got != 2 {
+		t.Errorf("%d creates ran at once, want the cap of 2", got)
+	}
+	if got := creates(); got != 2 {
+		t.Errorf("%d creates this tick, want the rest to wait for a slot", got)
+	}
+}
+
+func TestReconcileReadsBackAutoHostPort(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	ctx := context.Background()
+
+	pm, err := ParsePortMapping(":80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := startedContainer(t, r, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running,
+		Ports: []PortMapping{pm}})
+
+	spec, _ := runtime.Spec(containerName(r.nameTemplate, c))
+	if len(spec.Ports) != 1 || spec.Ports[0].HostPort != 0 {
+		t.Errorf("created with ports %v, want the host port left to the runtime", spec.Ports)
+	}
+	if got := c.Ports[0]; got.HostPort != fakeEphemeralBase || !got.Auto {
+		t.Fatalf("stored port %+v, want the runtime's host port %d read back", got, fakeEphemeralBase)
+	}
+
+	// the picked port isn't part of the spec, so it doesn't force a recreate
+	id := c.ContainerID
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := store.GetContainer(ctx, "c1"); stored.ContainerID != id || stored.Ports[0].HostPort != fakeEphemeralBase {
+		t.Errorf("second reconcile left %s with %v, want %s unchanged", stored.ContainerID, stored.Ports, id)
+	}
+}
+
+// exitContainer marks c's runtime container exited with code and reconciles
+// it, returning the stored result and the runtime calls the reconcile made.
+func exitContainer(t *testing.T, r *Reconciler, store *MemStore, runtime *FakeRuntime, c *Container, code int) (*Container, []string) {
+	t.Helper()
+	ctx := context.Background()
+
+	runtime.SetStatus(c.ContainerID, &RuntimeStatus{State: "exited", ExitCode: code})
+	before := len(runtime.Calls())
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.GetContainer(ctx, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored, runtime.Methods()[before:]
+}
+
+func TestReconcileCompletesJobThatExitsZero(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	c := startedContainer(t, r, store, &Container{ID: "job", Image: "busybox", State: Requested, DesiredState: Running,
+		RestartPolicy: RestartOnFailure})
+
+	stored, calls := exitContainer(t, r, store, runtime, c, 0)
+	if stored.State != Completed || slices.Contains(calls, "Start") {
+		t.Fatalf("job is %s after calls %v, want Completed and not restarted", stored.State, calls)
+	}
+
+	// a completed job is left alone on later ticks
+	before := len(runtime.Calls())
+	if err := r.reconcileContainer(context.Background(), stored, nil); err != nil {
+		t.Fatal(err)
+	}
+	if calls := runtime.Methods()[before:]; len(calls) != 0 {
+		t.Errorf("reconciling a completed job made calls %v, want none", calls)
+	}
+}
+
+func TestReconcileRestartsWhenPolicyAsks(t *testing.T) {
+	for _, tc := range []struct {
+		policy string
+		code   int
+		want   ContainerState
+	}{
+		{RestartOnFailure, 1, Running},
+		{"", 0, Running},
+		{RestartNever, 1, Failed},
+	} {
+		r, store, runtime := newTestReconciler(t)
+		c := startedContainer(t, r, store, &Container{ID: "job", Image: "busybox", State: Requested, DesiredState: Running,
+			RestartPolicy: tc.policy})
+
+		stored, calls := exitContainer(t, r, store, runtime, c, tc.code)
+		if stored.State != tc.want || slices.Contains(calls, "Start") != (tc.want == Running) {
+			t.Errorf("policy %q, exit %d: %s after calls %v, want %s", tc.policy, tc.code, stored.State, calls, tc.want)
+		}
+	}
+}
+
+func TestReconcileAttachesDeploymentNetwork(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	ctx := context.Background()
+
+	c := &Container{ID: "web-1", Name: "frontend", Image: "nginx:1", State: Requested, DesiredState: Running, Deployment: "web"}
+	defaultNetwork(c)
+	c = startedContainer(t, r, store, c)
+
+	if !slices.Contains(runtime.Methods(), "CreateNetwork") {
+		t.Errorf("runtime calls = %v, want the network created", runtime.Methods())
+	}
+	aliases, ok := runtime.NetworkAliases("cogs-web", c.ContainerID)
+	if !ok || !slices.Equal(aliases, []string{"web-1", "frontend"}) {
+		t.Errorf("attached %v (%v), want web-1 on cogs-web with its ID and name as aliases", aliases, ok)
+	}
+
+	// a recreate attaches the replacement too
+	old := c.ContainerID
+	c.Image = "nginx:2"
+	c.Generation++
+	c = saveTestContainer(t, store, c)
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.GetContainer(ctx, "web-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ContainerID == old {
+		t.Fatalf("container %s not recreated", old)
+	}
+	if _, ok := runtime.NetworkAliases("cogs-web", stored.ContainerID); !ok {
+		t.Errorf("replacement %s not attached to cogs-web", stored.ContainerID)
+	}
+}
+
+func TestReconcilePersistsStartError(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	ctx := context.Background()
+
+	c := saveTestContainer(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running})
+	runtime.FailStart(containerName(r.nameTemplate, c), errors.New("Bind for 0.0.0.0:8080 failed: port is already allocated"))
+	if err := r.reconcileContainer(ctx, c, nil); err == nil {
+		t.Fatal("reconcile succeeded with Start failing")
+	}
+
+	stored, err := store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.State != Failed || !strings.Contains(stored.LastError, "port is already allocated") {
+		t.Errorf("container is %s with last error %q, want Failed with the runtime's reason", stored.State, stored.LastError)
+	}
+}
+
+func TestReconcileStartErrorIncludesExitReason(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	c := startedContainer(t, r, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running})
+
+	runtime.SetStatus(c.ContainerID, &RuntimeStatus{State: "exited", ExitCode: 137, OOMKilled: true})
+	runtime.FailStart(c.ContainerID, errors.New("failed to start container"))
+	if err := r.reconcileContainer(context.Background(), c, nil); err == nil {
+		t.Fatal("reconcile succeeded with Start failing")
+	}
+
+	stored, err := store.GetContainer(context.Background(), "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"failed to start container", "OOM killed", "exit code 137"} {
+		if !strings.Contains(stored.LastError, want) {
+			t.Errorf("last error %q lacks %q", stored.LastError, want)
+		}
+	}
+}
+
+func TestExpireContainersAfterTTL(t *testing.T) {
+	r, store, _ := newTestReconciler(t)
+	ctx := context.Background()
+	started := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	containers := []*Container{
+		{ID: "short", Image: "nginx", State: Running, DesiredState: Running, StartedAt: started, TTL: time.Minute},
+		{ID: "long", Image: "nginx", State: Running, DesiredState: Running, StartedAt: started, TTL: time.Hour},
+		{ID: "forever", Image: "nginx", State: Running, DesiredState: Running, StartedAt: started},
+		{ID: "stopped", Image: "nginx", State: Stopped, DesiredState: Stopped, StartedAt: started, TTL: time.Minute},
+	}
+	for _, c := range containers {
+		mustSave(t, store, c)
+	}
+
+	destroyed := func(now time.Time) []string {
+		t.Helper()
+		r.expireContainers(ctx, containers, now)
+		all, err := store.ListContainers(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, c := range all {
+			if c.DesiredState == Destroyed {
+				ids = append(ids, c.ID)
+			}
+		}
+		return ids
+	}
+
+	if got := destroyed(started.Add(59 * time.Second)); len(got) != 0 {
+		t.Errorf("before any TTL ran out: %v destroyed", got)
+	}
+	if got := destroyed(started.Add(time.Minute)); !slices.Equal(got, []string{"short"}) {
+		t.Errorf("at one minute: %v destroyed, want [short]", got)
+	}
+	if got := destroyed(started.Add(2 * time.Hour)); !slices.Equal(got, []string{"long", "short"}) {
+		t.Errorf("after two hours: %v destroyed, want [long short]", got)
+	}
+}
+
+// panickingRuntime panics on Create of one spec name, standing in for a bug
+// hit by a single container.
+type panickingRuntime struct {
+	*FakeRuntime
+	name string
+}
+
+func (p panickingRuntime) Create(ctx context.Context, spec *ContainerSpec) (string, error) {
+	if spec.Name == p.name {
+		panic("nil map in create")
+	}
+	return p.FakeRuntime.Create(ctx, spec)
+}
+
+func TestReconcileWorkerSurvivesPanickingContainer(t *testing.T) {
+	cogs, store, runtime := newTestWorker(t, "w1")
+	r := cogs.reconciler
+	r.actionInterval = 0
+
+	bad := &Container{ID: "bad", Image: "nginx", State: Requested, DesiredState: Running, NodeID: "w1", Scheduled: true}
+	good := &Container{ID: "good", Image: "nginx", State: Requested, DesiredState: Running, NodeID: "w1", Scheduled: true}
+	mustSave(t, store, bad, good)
+	cogs.runtime = panickingRuntime{FakeRuntime: runtime, name: containerName(r.nameTemplate, bad)}
+
+	for tick := 1; tick <= 2; tick++ {
+		if err := r.safeReconcile(context.Background()); err == nil {
+			t.Errorf("tick %d succeeded with a container panicking", tick)
+		}
+		if got := r.panics.Value(); got != uint64(tick) {
+			t.Errorf("after tick %d: %d panics counted, want %d", tick, got, tick)
+		}
+	}
+	if stored, _ := store.GetContainer(context.Background(), "good"); stored.State != Running {
+		t.Errorf("good is %s, want it started despite the panic next to it", stored.State)
+	}
+}
+
+func TestReconcileClearsIPOnStop(t *testing.T) {
+	r, store, _ := newTestReconciler(t)
+	c := startedContainer(t, r, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running})
+	if c.IPAddress == "" {
+		t.Fatal("started container has no IP")
+	}
+
+	c.DesiredState = Stopped
+	if err := r.reconcileContainer(context.Background(), c, nil); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.GetContainer(context.Background(), c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.State != Stopped || stored.IPAddress != "" || stored.Ready {
+		t.Errorf("got state %s, IP %q, ready %v; want Stopped with no IP", stored.State, stored.IPAddress, stored.Ready)
+	}
+}
+
+func TestReconcileClearsIPWhenContainerExits(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	c := startedContainer(t, r, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running,
+		RestartPolicy: RestartNever})
+
+	stored, _ := exitContainer(t, r, store, runtime, c, 1)
+	if stored.IPAddress != "" {
+		t.Errorf("exited container kept IP %q", stored.IPAddress)
+	}
+}
+
+func TestReconcilePauseAndUnpause(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	ctx := context.Background()
+	c := startedContainer(t, r, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running})
+
+	for _, step := range []struct {
+		desired ContainerState
+		call    string
+		state   ContainerState
+		runtime string
+	}{
+		{Paused, "Pause", Paused, "paused"},
+		{Running, "Unpause", Running, "running"},
+		{Paused, "Pause", Paused, "paused"},
+		// a paused container is thawed before it is stopped
+		{Stopped, "Unpause", Stopped, "exited"},
+	} {
+		c.DesiredState = step.desired
+		before := len(runtime.Calls())
+		if err := r.reconcileContainer(ctx, c, nil); err != nil {
+			t.Fatalf("to %s: %v", step.desired, err)
+		}
+		if calls := runtime.Methods()[before:]; !slices.Contains(calls, step.call) {
+			t.Errorf("to %s: calls %v, want %s", step.desired, calls, step.call)
+		}
+
+		stored, err := store.GetContainer(ctx, c.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.State != step.state || stored.Ready && step.state != Running {
+			t.Errorf("to %s: state %s ready %v, want %s", step.desired, stored.State, stored.Ready, step.state)
+		}
+		status, err := runtime.Inspect(ctx, stored.ContainerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.State != step.runtime {
+			t.Errorf("to %s: runtime state %q, want %q", step.desired, status.State, step.runtime)
+		}
+		c = stored
+	}
+}
+
+func TestReconcileLogsDecisionAtDebug(t *testing.T) {
+	for _, level := range []slog.Level{slog.LevelDebug, slog.LevelInfo} {
+		r, store, _ := newTestReconciler(t)
+		var buf bytes.Buffer
+		r.logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: level}))
+
+		startedContainer(t, r, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running})
+
+		if level != slog.LevelDebug {
+			if buf.Len() != 0 {
+				t.Errorf("logged %q at %s, want nothing", buf.String(), level)
+			}
+			continue
+		}
+		// other debug records may come first; find the reconcile one
+		var record map[string]any
+		for dec := json.NewDecoder(&buf); record["msg"] != "reconcile"; {
+			record = nil
+			if err := dec.Decode(&record); err != nil {
+				t.Fatalf("no reconcile record: %v", err)
+			}
+		}
+		for key, want := range map[string]any{
+			"level":     "DEBUG",
+			"msg":       "reconcile",
+			"container": "c1",
+			"desired":   string(Running),
+			"observed":  "none",
+			"state":     string(Running),
+			"decision":  outcomeRecreated,
+		} {
+			if record[key] != want {
+				t.Errorf("%s = %v, want %v", key, record[key], want)
+			}
+		}
+	}
+}
@@ -2,43 +2,134 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/moby/moby/api/pkg/stdcopy"
 	"github.com/moby/moby/api/types/container"
 	"github.com/moby/moby/api/types/network"
 	"github.com/moby/moby/client"
 )
 
 type Runtime interface {
+	// Ping reports whether the container daemon is reachable.
+	Ping(ctx context.Context) error
+
 	Pull(ctx context.Context, image string) error
+	HasImage(ctx context.Context, image string) (bool, error)
+	ImageLabels(ctx context.Context, image string) (map[string]string, error)
 	Create(ctx context.Context, spec *ContainerSpec) (string, error)
 	Start(ctx context.Context, containerID string) error
 	Stop(ctx context.Context, containerID string, timeout int) error
-	Remove(ctx context.Context, containerID string) error
+	// Pause freezes every process in a running container, and Unpause
+	// thaws them.
+	Pause(ctx context.Context, containerID string) error
+	Unpause(ctx context.Context, containerID string) error
+	// Remove deletes a container. Without force the runtime refuses to
+	// remove one that is still running.
+	Remove(ctx context.Context, containerID string, force bool) error
 
 	Inspect(ctx context.Context, containerID string) (*RuntimeStatus, error)
 	List(ctx context.Context) ([]*RuntimeStatus, error)
 	Logs(ctx context.Context, containerID string, tail int) (string, error)
+	// LogStream returns the last tail lines of output and, with follow,
+	// keeps streaming new output until ctx is cancelled or the stream is
+	// closed.
+	LogStream(ctx context.Context, containerID string, tail int, follow bool) (io.ReadCloser, error)
+	Stats(ctx context.Context, containerID string) (*RuntimeStats, error)
+	// Exec runs cmd inside a running container. Writes go to its stdin and
+	// reads return its combined stdout and stderr until it exits.
+	Exec(ctx context.Context, containerID string, cmd []string) (io.ReadWriteCloser, error)
+
+	// CreateNetwork creates a bridge network, doing nothing if one with that
+	// name already exists.
+	CreateNetwork(ctx context.Context, name string) error
+	// ConnectNetwork attaches a container to a network, where the other
+	// containers on it can resolve it by any of aliases. Connecting one
+	// that is already attached is a no-op.
+	ConnectNetwork(ctx context.Context, name, containerID string, aliases []string) error
 
 	Close() error
 }
 
+// ErrContainerNotRunning is returned by Exec for a container that isn't
+// running.
+var ErrContainerNotRunning = errors.New("container is not running")
+
+// ErrContainerNotFound is returned by Inspect when the runtime has no such
+// container, as opposed to failing to answer.
+var ErrContainerNotFound = errors.New("runtime container not found")
+
 type ContainerSpec struct {
 	Image string
 	Env   map[string]string
 	Ports []PortMapping
 	Name  string
+
+	// LegacyName is what the container was called before names came from
+	// a template. Create looks it up when nothing is called Name, so an
+	// upgraded worker doesn't start a duplicate next to the old container.
+	LegacyName string
+
+	// Command replaces the image's entrypoint and Args its default command.
+	// Either left empty keeps what the image defines.
+	Command []string
+	Args    []string
+
+	// StopSignal is sent first when the container is stopped (the image's,
+	// usually SIGTERM, when empty); SIGKILL follows after StopTimeout
+	// seconds.
+	StopSignal  string
+	StopTimeout int
+
+	// Resources caps the container's CPU cores and memory; zero leaves that
+	// resource unlimited. Disk isn't enforced.
+	Resources Resources
+
+	// SpecHash is the specHash of the container the spec was built from.
+	// Create labels the runtime container with it and only adopts a
+	// same-named container carrying the same one.
+	SpecHash string
 }
 
+// specHashLabel holds a runtime container's SpecHash.
+const specHashLabel = "cogsworth.spec-hash"
+
 type RuntimeStatus struct {
 	ContainerID string
 	State       string
 	IPAddress   string
 	StartedAt   string
 	ExitCode    int
+	OOMKilled   bool
 	Error       string
+
+	// Ports are the host bindings the runtime actually made.
+	Ports []PortMapping
+}
+
+// exitReason summarizes why a runtime container stopped or failed to start:
+// an OOM kill, a non-zero exit code and the daemon's own error, if any.
+func exitReason(status *RuntimeStatus) string {
+	var parts []string
+	if status.OOMKilled {
+		parts = append(parts, "OOM killed")
+	}
+	if status.ExitCode != 0 {
+		parts = append(parts, fmt.Sprintf("exit code %d", status.ExitCode))
+	}
+	if status.Error != "" {
+		parts = append(parts, status.Error)
+	}
+	return strings.Join(parts, ", ")
 }
 
 type DockerRuntime struct {
@@ -54,6 +145,13 @@ func NewDockerRuntime() (*DockerRuntime, error) {
 	return &DockerRuntime{cli: cli}, nil
 }
 
+func (d *DockerRuntime) Ping(ctx context.Context) error {
+	if _, err := d.cli.Ping(ctx); err != nil {
+		return fmt.Errorf("docker daemon unreachable: %w", err)
+	}
+	return nil
+}
+
 func (d *DockerRuntime) Pull(ctx context.Context, image string) error {
 	reader, err := d.cli.ImagePull(ctx, image, client.ImagePullOptions{})
 	if err != nil {
@@ -70,7 +168,56 @@ func (d *DockerRuntime) Pull(ctx context.Context, image string) error {
 	return nil
 }
 
-func (d DockerRuntime) Create(ctx context.Context, spec *ContainerSpec) (string, error) {
+func (d *DockerRuntime) HasImage(ctx context.Context, image string) (bool, error) {
+	if _, err := d.cli.ImageInspect(ctx, image); err != nil {
+		if cerrdefs.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to inspect image: %w", err)
+	}
+	return true, nil
+}
+
+func (d *DockerRuntime) ImageLabels(ctx context.Context, image string) (map[string]string, error) {
+	info, err := d.cli.ImageInspect(ctx, image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image: %w", err)
+	}
+
+	if info.Config == nil {
+		return nil, nil
+	}
+
+	return info.Config.Labels, nil
+}
+
+// Create creates the container named spec.Name. If a container with that
+// name already exists (for example after a control-plane restart handed the
+// same assignment out twice) it is adopted instead of duplicated, as long
+// as it was created from the same spec; one left from an older spec, or
+// from before specs were labelled, is removed and created again.
+func (d *DockerRuntime) Create(ctx context.Context, spec *ContainerSpec) (string, error) {
+	existingID, labels, err := d.findByName(ctx, spec.Name)
+	if err != nil {
+		return "", err
+	}
+	if existingID == "" && spec.LegacyName != "" && spec.LegacyName != spec.Name {
+		existingID, labels, err = d.findByName(ctx, spec.LegacyName)
+		if err != nil {
+			return "", err
+		}
+	}
+	if existingID != "" {
+		if labels[specHashLabel] == spec.SpecHash {
+			fmt.Printf("Adopted existing container: %s\n", existingID[:12])
+			return existingID, nil
+		}
+		fmt.Printf("Replacing container %s, created from another spec\n", existingID[:12])
+		if err := d.Remove(ctx, existingID, true); err != nil {
+			return "", err
+		}
+	}
+
 	portBindings := network.PortMap{}
 	exposedPorts := network.PortSet{}
 
@@ -80,11 +227,17 @@ func (d DockerRuntime) Create(ctx context.Context, spec *ContainerSpec) (string,
 			return "", err
 		}
 
+		// an empty host port has Docker pick a free one
+		hostPort := ""
+		if pm.HostPort != 0 {
+			hostPort = strconv.Itoa(pm.HostPort)
+		}
+
 		exposedPorts[containerPort] = struct{}{}
 		portBindings[containerPort] = []network.PortBinding{
 			{
 				HostIP:   netip.MustParseAddr("0.0.0.0"),
-				HostPort: fmt.Sprintf("%d", pm.HostPort),
+				HostPort: hostPort,
 			},
 		}
 	}
@@ -100,9 +253,18 @@ func (d DockerRuntime) Create(ctx context.Context, spec *ContainerSpec) (string,
 			Image:        spec.Image,
 			Env:          env,
 			ExposedPorts: exposedPorts,
+			Entrypoint:   spec.Command,
+			Cmd:          spec.Args,
+			StopSignal:   spec.StopSignal,
+			StopTimeout:  &spec.StopTimeout,
+			Labels:       map[string]string{specHashLabel: spec.SpecHash},
 		},
 		&container.HostConfig{
 			PortBindings: portBindings,
+			Resources: container.Resources{
+				NanoCPUs: int64(spec.Resources.CPUCores) * 1e9,
+				Memory:   spec.Resources.MemoryMB * 1024 * 1024,
+			},
 		},
 		nil,
 		nil,
@@ -117,6 +279,29 @@ func (d DockerRuntime) Create(ctx context.Context, spec *ContainerSpec) (string,
 	return resp.ID, nil
 }
 
+// findByName returns the ID and labels of the container called name, or an
+// empty ID when there is none.
+func (d *DockerRuntime) findByName(ctx context.Context, name string) (string, map[string]string, error) {
+	containers, err := d.cli.ContainerList(ctx, client.ContainerListOptions{
+		All:     true,
+		Filters: make(client.Filters).Add("name", "^/"+regexp.QuoteMeta(name)+"$"),
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to look up container %s: %w", name, err)
+	}
+
+	// the name filter is a regex match, so confirm the exact name
+	for _, c := range containers {
+		for _, n := range c.Names {
+			if strings.TrimPrefix(n, "/") == name {
+				return c.ID, c.Labels, nil
+			}
+		}
+	}
+
+	return "", nil, nil
+}
+
 func (d *DockerRuntime) Start(ctx context.Context, containerID string) error {
 	err := d.cli.ContainerStart(ctx, containerID, client.ContainerStartOptions{})
 	if err != nil {
@@ -127,7 +312,19 @@ func (d *DockerRuntime) Start(ctx context.Context, containerID string) error {
 	return nil
 }
 
+// Stop asks the daemon to stop the container, killing it after timeout
+// seconds. The grace period is shortened to fit the context's deadline so a
+// shutting-down worker isn't held up per container.
 func (d *DockerRuntime) Stop(ctx context.Context, containerID string, timeout int) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to stop container: %w", err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := int(time.Until(deadline) / time.Second)
+		timeout = max(min(timeout, remaining), 0)
+	}
+
 	err := d.cli.ContainerStop(ctx, containerID, client.ContainerStopOptions{Timeout: &timeout})
 	if err != nil {
 		return fmt.Errorf("failed to stop container: %w", err)
@@ -137,9 +334,30 @@ func (d *DockerRuntime) Stop(ctx context.Context, containerID string, timeout in
 	return nil
 }
 
-func (d *DockerRuntime) Remove(ctx context.Context, containerID string) error {
-	err := d.cli.ContainerRemove(ctx, containerID, client.ContainerRemoveOptions{Force: true})
+func (d *DockerRuntime) Pause(ctx context.Context, containerID string) error {
+	if err := d.cli.ContainerPause(ctx, containerID); err != nil {
+		return fmt.Errorf("failed to pause container: %w", err)
+	}
+
+	fmt.Printf("Paused container: %s\n", containerID[:12])
+	return nil
+}
+
+func (d *DockerRuntime) Unpause(ctx context.Context, containerID string) error {
+	if err := d.cli.ContainerUnpause(ctx, containerID); err != nil {
+		return fmt.Errorf("failed to unpause container: %w", err)
+	}
+
+	fmt.Printf("Unpaused container: %s\n", containerID[:12])
+	return nil
+}
+
+func (d *DockerRuntime) Remove(ctx context.Context, containerID string, force bool) error {
+	err := d.cli.ContainerRemove(ctx, containerID, client.ContainerRemoveOptions{Force: force})
 	if err != nil {
+		if cerrdefs.IsNotFound(err) {
+			return fmt.Errorf("failed to remove container %s: %w", containerID, ErrContainerNotFound)
+		}
 		return fmt.Errorf("failed to remove container: %w", err)
 	}
 
@@ -150,6 +368,9 @@ func (d *DockerRuntime) Remove(ctx context.Context, containerID string) error {
 func (d *DockerRuntime) Inspect(ctx context.Context, containerID string) (*RuntimeStatus, error) {
 	info, err := d.cli.ContainerInspect(ctx, containerID)
 	if err != nil {
+		if cerrdefs.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to inspect container %s: %w", containerID, ErrContainerNotFound)
+		}
 		return nil, fmt.Errorf("failed to inspect container: %w", err)
 	}
 
@@ -158,6 +379,7 @@ func (d *DockerRuntime) Inspect(ctx context.Context, containerID string) (*Runti
 		State:       info.State.Status,
 		StartedAt:   info.State.StartedAt,
 		ExitCode:    info.State.ExitCode,
+		OOMKilled:   info.State.OOMKilled,
 	}
 
 	if info.NetworkSettings != nil {
@@ -166,6 +388,20 @@ func (d *DockerRuntime) Inspect(ctx context.Context, containerID string) (*Runti
 				status.IPAddress = netConf.IPAddress.String()
 			}
 		}
+
+		for port, bindings := range info.NetworkSettings.Ports {
+			for _, b := range bindings {
+				hostPort, err := strconv.Atoi(b.HostPort)
+				if err != nil {
+					continue
+				}
+				status.Ports = append(status.Ports, PortMapping{
+					HostPort:      hostPort,
+					ContainerPort: int(port.Num()),
+					Protocol:      string(port.Proto()),
+				})
+			}
+		}
 	}
 
 	if info.State.Error != "" {
@@ -175,6 +411,41 @@ func (d *DockerRuntime) Inspect(ctx context.Context, containerID string) (*Runti
 	return status, nil
 }
 
+func (d *DockerRuntime) CreateNetwork(ctx context.Context, name string) error {
+	if _, err := d.cli.NetworkInspect(ctx, name, client.NetworkInspectOptions{}); err == nil {
+		return nil
+	} else if !cerrdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to inspect network %s: %w", name, err)
+	}
+
+	if _, err := d.cli.NetworkCreate(ctx, name, client.NetworkCreateOptions{Driver: "bridge"}); err != nil {
+		if cerrdefs.IsConflict(err) {
+			// a concurrent create got there first
+			return nil
+		}
+		return fmt.Errorf("failed to create network %s: %w", name, err)
+	}
+
+	fmt.Printf("Created network: %s\n", name)
+	return nil
+}
+
+func (d *DockerRuntime) ConnectNetwork(ctx context.Context, name, containerID string, aliases []string) error {
+	info, err := d.cli.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if info.NetworkSettings != nil && info.NetworkSettings.Networks[name] != nil {
+		return nil
+	}
+
+	err = d.cli.NetworkConnect(ctx, name, containerID, &network.EndpointSettings{Aliases: aliases})
+	if err != nil {
+		return fmt.Errorf("failed to connect container to network %s: %w", name, err)
+	}
+	return nil
+}
+
 func (d *DockerRuntime) List(ctx context.Context) ([]*RuntimeStatus, error) {
 	containers, err := d.cli.ContainerList(ctx, client.ContainerListOptions{All: true})
 	if err != nil {
@@ -194,24 +465,150 @@ func (d *DockerRuntime) List(ctx context.Context) ([]*RuntimeStatus, error) {
 }
 
 func (d *DockerRuntime) Logs(ctx context.Context, containerID string, tail int) (string, error) {
+	reader, err := d.LogStream(ctx, containerID, tail, false)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	logs, err := io.ReadAll(reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to read logs: %w", err)
+	}
+
+	return string(logs), nil
+}
+
+func (d *DockerRuntime) LogStream(ctx context.Context, containerID string, tail int, follow bool) (io.ReadCloser, error) {
 	options := client.ContainerLogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
+		Follow:     follow,
 		Tail:       fmt.Sprintf("%d", tail),
 	}
 
 	reader, err := d.cli.ContainerLogs(ctx, containerID, options)
 	if err != nil {
-		return "", fmt.Errorf("failed to get logs: %w", err)
+		return nil, fmt.Errorf("failed to get logs: %w", err)
 	}
-	defer reader.Close()
 
-	logs, err := io.ReadAll(reader)
+	// containers run without a TTY, so stdout and stderr arrive
+	// multiplexed and have to be split back into plain text
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pw, pw, reader)
+		pw.CloseWithError(err)
+	}()
+
+	return &logStream{PipeReader: pr, source: reader}, nil
+}
+
+// logStream closes the Docker response along with the demultiplexed pipe so
+// the copying goroutine exits.
+type logStream struct {
+	*io.PipeReader
+	source io.Closer
+}
+
+func (s *logStream) Close() error {
+	s.PipeReader.Close()
+	return s.source.Close()
+}
+
+func (d *DockerRuntime) Exec(ctx context.Context, containerID string, cmd []string) (io.ReadWriteCloser, error) {
+	exec, err := d.cli.ContainerExecCreate(ctx, containerID, client.ExecCreateOptions{
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          cmd,
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to read logs: %w", err)
+		if cerrdefs.IsConflict(err) {
+			return nil, fmt.Errorf("failed to exec in %s: %w", containerID, ErrContainerNotRunning)
+		}
+		return nil, fmt.Errorf("failed to create exec: %w", err)
 	}
 
-	return string(logs), nil
+	hijacked, err := d.cli.ContainerExecAttach(ctx, exec.ID, client.ExecAttachOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to attach exec: %w", err)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pw, pw, hijacked.Reader)
+		pw.CloseWithError(err)
+	}()
+
+	return &execStream{PipeReader: pr, conn: hijacked}, nil
+}
+
+// execStream reads the demultiplexed output of an exec and writes to its
+// stdin over the hijacked connection.
+type execStream struct {
+	*io.PipeReader
+	conn client.HijackedResponse
+}
+
+func (s *execStream) Write(p []byte) (int, error) {
+	return s.conn.Conn.Write(p)
+}
+
+// CloseWrite signals EOF on the process's stdin.
+func (s *execStream) CloseWrite() error {
+	return s.conn.CloseWrite()
+}
+
+func (s *execStream) Close() error {
+	s.PipeReader.Close()
+	s.conn.Close()
+	return nil
+}
+
+func (d *DockerRuntime) Stats(ctx context.Context, containerID string) (*RuntimeStats, error) {
+	// a non-streaming request waits for two samples, so precpu_stats is set
+	resp, err := d.cli.ContainerStats(ctx, containerID, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stats: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return decodeDockerStats(resp.Body)
+}
+
+// decodeDockerStats turns a Docker stats payload into a RuntimeStats,
+// computing CPU percent the same way `docker stats` does.
+func decodeDockerStats(r io.Reader) (*RuntimeStats, error) {
+	var raw container.StatsResponse
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to decode stats: %w", err)
+	}
+
+	stats := &RuntimeStats{
+		MemoryBytes: raw.MemoryStats.Usage,
+		MemoryLimit: raw.MemoryStats.Limit,
+	}
+
+	// page cache isn't really used memory: cgroup v2 reports it as
+	// inactive_file, v1 as cache
+	if cache, ok := raw.MemoryStats.Stats["inactive_file"]; ok && cache < stats.MemoryBytes {
+		stats.MemoryBytes -= cache
+	} else if cache, ok := raw.MemoryStats.Stats["cache"]; ok && cache < stats.MemoryBytes {
+		stats.MemoryBytes -= cache
+	}
+
+	cpuDelta := float64(raw.CPUStats.CPUUsage.TotalUsage) - float64(raw.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(raw.CPUStats.SystemUsage) - float64(raw.PreCPUStats.SystemUsage)
+	cpus := float64(raw.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(raw.CPUStats.CPUUsage.PercpuUsage))
+	}
+
+	if cpuDelta > 0 && systemDelta > 0 {
+		stats.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+
+	return stats, nil
 }
 
 func (d *DockerRuntime) Close() error {
@@ -2,11 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
 	"time"
 )
 
+// ErrNoSchedulableNode is returned when no ready worker can take a
+// container, for example because every one is cordoned or full.
+var ErrNoSchedulableNode = errors.New("no schedulable node")
+
 type Scheduler struct {
 	store Store
+
+	// sticky places a rescheduled container back on its LastNodeID when
+	// that node is ready and fits it.
+	sticky bool
 }
 
 func NewScheduler(store Store) *Scheduler {
@@ -15,53 +28,350 @@ func NewScheduler(store Store) *Scheduler {
 	}
 }
 
-func (s *Scheduler) Schedule(ctx context.Context, container *Container) error {
-	if container.Scheduled {
+// SchedulePending places every unscheduled container that should run,
+// keeping each group together on one node. All decisions are made against
+// a single snapshot: containers is the full container list, and placing a
+// container updates it in place so later decisions see what earlier ones
+// took. The placements are then saved in one transaction.
+func (s *Scheduler) SchedulePending(ctx context.Context, containers []*Container) error {
+	nodes, err := s.store.ListNodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	var units [][]*Container
+	groups := make(map[string][]*Container)
+	for _, container := range containers {
+		if container.Scheduled || (container.DesiredState != Running && container.DesiredState != Paused) {
+			continue
+		}
+		if container.Group != "" {
+			groups[container.Group] = append(groups[container.Group], container)
+			continue
+		}
+		units = append(units, []*Container{container})
+	}
+	for _, group := range slices.Sorted(maps.Keys(groups)) {
+		units = append(units, groups[group])
+	}
+
+	idx := indexSnapshot(containers)
+	var placed [][]*Container
+	for _, members := range units {
+		if err := s.place(nodes, members, containers, idx); err != nil {
+			log.Printf("Failed to schedule: %v", err)
+			continue
+		}
+		placed = append(placed, members)
+	}
+
+	if len(placed) == 0 {
+		return nil
+	}
+	err = s.store.SaveContainers(ctx, slices.Concat(placed...))
+	if err == nil {
 		return nil
 	}
+	log.Printf("Failed to save %d placements together, saving them one by one: %v", len(placed), err)
 
-	nodes, err := s.store.ListNodes(ctx)
+	// one stale record must not hold up the rest; groups still save as a
+	// unit so they never end up split
+	var errs []error
+	for _, members := range placed {
+		if err := s.store.SaveContainers(ctx, members); err != nil {
+			errs = append(errs, fmt.Errorf("failed to schedule %s: %w", members[0].ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// place picks a node for members and assigns them to it. Members of a group
+// follow the ones scheduled earlier.
+func (s *Scheduler) place(nodes []*Node, members []*Container, containers []*Container, idx *snapshotIndex) error {
+	group := members[0].Group
+	if group != "" {
+		if err := groupPortClash(members); err != nil {
+			return err
+		}
+		if pinned := groupNode(group, members, containers); pinned != "" {
+			nodes = slices.DeleteFunc(slices.Clone(nodes), func(n *Node) bool { return n.ID != pinned })
+		}
+	}
+
+	var selected *Node
+	var err error
+	if last := lastNode(members); s.sticky && last != "" {
+		only := slices.DeleteFunc(slices.Clone(nodes), func(n *Node) bool { return n.ID != last })
+		selected, err = s.selectNode(only, members, idx)
+	}
+	if selected == nil {
+		selected, err = s.selectNode(nodes, members, idx)
+	}
 	if err != nil {
-		return err
+		if group != "" {
+			return fmt.Errorf("%w fits all %d containers of group %s", err, len(members), group)
+		}
+		return fmt.Errorf("%w for container %s", err, members[0].ID)
 	}
 
-	selected := s.selectNode(nodes)
+	now := time.Now()
+	for _, container := range members {
+		container.NodeID = selected.ID
+		container.Scheduled = true
+		container.UpdatedAt = now
+	}
+	idx.add(selected.ID, members)
 
-	container.NodeID = selected.ID
-	container.Scheduled = true
-	container.UpdatedAt = time.Now()
+	if group != "" {
+		log.Printf("Scheduled group %s (%d containers) on node %s", group, len(members), selected.ID)
+	}
+	return nil
+}
 
-	return s.store.SaveContainer(ctx, container)
+// lastNode returns the LastNodeID the members share, if they share one.
+func lastNode(members []*Container) string {
+	last := members[0].LastNodeID
+	for _, c := range members[1:] {
+		if c.LastNodeID != last {
+			return ""
+		}
+	}
+	return last
 }
 
-func (s *Scheduler) selectNode(nodes []*Node) *Node {
+// selectNode picks a ready worker that can take all members without going
+// over its MaxContainers. Nodes that satisfy the members' affinity win, then
+// nodes with the fewest replicas of the members' deployments, counted first
+// per zone and then per node; ties go to the least loaded node.
+func (s *Scheduler) selectNode(nodes []*Node, members []*Container, idx *snapshotIndex) (*Node, error) {
 	var selected *Node
+	selectedAffine := false
+	minZoneReplicas := int(^uint(0) >> 1)
+	minNodeReplicas := int(^uint(0) >> 1)
 	minContainers := int(^uint(0) >> 1)
 
+	zoneReplicas, nodeReplicas := countReplicas(nodes, members, idx.active)
+
 	for _, node := range nodes {
-		if node.State != NodeReady {
+		if node.Role != Worker || node.State != NodeReady || node.Unschedulable {
+			continue
+		}
+
+		onThisNode := idx.active[node.ID]
+		if node.MaxContainers > 0 && len(onThisNode)+len(members) > node.MaxContainers {
+			continue
+		}
+		if !s.fits(node, members, idx) {
 			continue
 		}
 
-		count := s.countContainersOnNode(node.ID)
-		if count < minContainers {
-			selected = node
-			minContainers = count
+		affine := hasAffinity(members, onThisNode)
+		inZone, onNode := zoneReplicas[node.Zone], nodeReplicas[node.ID]
+		count := len(onThisNode)
+		var better bool
+		switch {
+		case affine != selectedAffine:
+			better = affine
+		case inZone != minZoneReplicas:
+			better = inZone < minZoneReplicas
+		case onNode != minNodeReplicas:
+			better = onNode < minNodeReplicas
+		default:
+			better = count < minContainers
 		}
+		if !better {
+			continue
+		}
+
+		selected = node
+		selectedAffine = affine
+		minZoneReplicas = inZone
+		minNodeReplicas = onNode
+		minContainers = count
 	}
 
-	return selected
+	if selected == nil {
+		return nil, ErrNoSchedulableNode
+	}
+	return selected, nil
 }
 
-func (s *Scheduler) countContainersOnNode(nodeId string) int {
-	count := 0
-	containers, _ := s.store.ListContainers(context.Background())
+// activeOn reports whether c is running or paused, or about to be, on
+// nodeID.
+func activeOn(c *Container, nodeID string) bool {
+	return c.NodeID == nodeID && c.State != Completed &&
+		(c.State == Running || c.State == Paused ||
+			(c.Scheduled && (c.DesiredState == Running || c.DesiredState == Paused)))
+}
 
+// countReplicas counts, per zone and per node, the active containers that
+// share a deployment with one of the members. With fewer zones than replicas
+// every zone ends up with some and the replicas spread over its nodes.
+func countReplicas(nodes []*Node, members []*Container, active map[string][]*Container) (byZone, byNode map[string]int) {
+	deployments := make(map[string]bool)
+	for _, member := range members {
+		if member.Deployment != "" {
+			deployments[member.Deployment] = true
+		}
+	}
+
+	byZone = make(map[string]int)
+	byNode = make(map[string]int)
+	if len(deployments) == 0 {
+		return byZone, byNode
+	}
+
+	for _, node := range nodes {
+		for _, c := range active[node.ID] {
+			if deployments[c.Deployment] &&
+				!slices.ContainsFunc(members, func(m *Container) bool { return m.ID == c.ID }) {
+				byZone[node.Zone]++
+				byNode[node.ID]++
+			}
+		}
+	}
+	return byZone, byNode
+}
+
+// antiAffinityConflict returns a container of active, the ones active on a
+// node, that matches the anti-affinity of one of the members.
+func antiAffinityConflict(members []*Container, active []*Container) (*Container, bool) {
+	for _, member := range members {
+		if len(member.AntiAffinity) == 0 {
+			continue
+		}
+		for _, other := range active {
+			if other.ID != member.ID && matchesSelector(other.Labels, member.AntiAffinity) {
+				return other, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// hasAffinity reports whether active, the containers active on a node,
+// holds one matching the affinity of any member.
+func hasAffinity(members []*Container, active []*Container) bool {
+	for _, member := range members {
+		if len(member.Affinity) == 0 {
+			continue
+		}
+		for _, other := range active {
+			if other.ID != member.ID && matchesSelector(other.Labels, member.Affinity) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fits checks members against node and what idx has on it.
+func (s *Scheduler) fits(node *Node, members []*Container, idx *snapshotIndex) bool {
+	active := idx.active[node.ID]
+	for _, container := range members {
+		if port, ok := hostPortConflict(container, node.ID, active); ok {
+			log.Printf("Node %s already publishes host port %d/%s, skipping for %s",
+				node.ID, port.HostPort, port.Protocol, container.ID)
+			return false
+		}
+	}
+
+	if other, ok := antiAffinityConflict(members, active); ok {
+		log.Printf("Node %s runs %s, which an anti-affinity rule excludes", node.ID, other.ID)
+		return false
+	}
+
+	if !fitsResources(node, members, idx.requested[node.ID]) {
+		log.Printf("Node %s lacks resources for %d container(s)", node.ID, len(members))
+		return false
+	}
+
+	return true
+}
+
+// fitsResources checks the members' requests against what is left of the
+// node's capacity. Dimensions the node doesn't report are not limited.
+// What is used is the larger of requested, the requests of the records
+// placed on the node, and what its last heartbeat reported running, so
+// neither placements the worker hasn't started yet nor containers the
+// store no longer places there are overlooked.
+func fitsResources(node *Node, members []*Container, requested Resources) bool {
+	var want Resources
+	used := requested.Max(node.Allocated)
+	for _, c := range members {
+		want = want.Add(c.Resources)
+	}
+
+	total := used.Add(want)
+	capacity := node.Capacity
+	return (capacity.CPUCores == 0 || total.CPUCores <= capacity.CPUCores) &&
+		(capacity.MemoryMB == 0 || total.MemoryMB <= capacity.MemoryMB) &&
+		(capacity.DiskGB == 0 || total.DiskGB <= capacity.DiskGB)
+}
+
+// groupNode returns the node already hosting scheduled members of group.
+func groupNode(group string, members []*Container, containers []*Container) string {
 	for _, c := range containers {
-		if c.NodeID == nodeId && c.State == Running {
-			count++
+		if c.Group == group && c.Scheduled && c.DesiredState != Destroyed &&
+			!slices.ContainsFunc(members, func(m *Container) bool { return m.ID == c.ID }) {
+			return c.NodeID
 		}
 	}
+	return ""
+}
 
-	return count
+// groupPortClash rejects groups whose members publish the same host port,
+// since they could never share a node.
+func groupPortClash(members []*Container) error {
+	seen := make(map[PortMapping]string)
+	for _, c := range members {
+		for _, pm := range c.Ports {
+			if pm.HostPort == 0 || pm.Auto {
+				continue
+			}
+			key := PortMapping{HostPort: pm.HostPort, Protocol: pm.Protocol}
+			if other, ok := seen[key]; ok {
+				return fmt.Errorf("containers %s and %s both publish host port %d/%s", other, c.ID, pm.HostPort, pm.Protocol)
+			}
+			seen[key] = c.ID
+		}
+	}
+	return nil
+}
+
+// snapshotIndex is what one scheduling tick knows about each node, built
+// in one pass over the snapshot so the checks for a candidate node only
+// look at what runs there. Placements made later in the same tick are
+// added by place.
+type snapshotIndex struct {
+	// active holds the containers active on each node.
+	active map[string][]*Container
+	// requested sums the requests of the active containers on each node.
+	// Stopped and finished ones hold nothing.
+	requested map[string]Resources
+}
+
+func indexSnapshot(containers []*Container) *snapshotIndex {
+	idx := &snapshotIndex{
+		active:    make(map[string][]*Container),
+		requested: make(map[string]Resources),
+	}
+	for _, c := range containers {
+		if c.NodeID == "" {
+			continue
+		}
+		if activeOn(c, c.NodeID) {
+			idx.active[c.NodeID] = append(idx.active[c.NodeID], c)
+			idx.requested[c.NodeID] = idx.requested[c.NodeID].Add(c.Resources)
+		}
+	}
+	return idx
+}
+
+// add records members as placed on nodeID.
+func (idx *snapshotIndex) add(nodeID string, members []*Container) {
+	idx.active[nodeID] = append(idx.active[nodeID], members...)
+	for _, c := range members {
+		idx.requested[nodeID] = idx.requested[nodeID].Add(c.Resources)
+	}
 }
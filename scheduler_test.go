@@ -0,0 +1,593 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"testing"
+)
+
+func workerNode(id string) *Node {
+	return &Node{ID: id, Role: Worker, State: NodeReady}
+}
+
+func pendingContainer(id string) *Container {
+	return &Container{ID: id, Image: "nginx", State: Requested, DesiredState: Running}
+}
+
+// schedule saves nodes and containers to a fresh MemStore, runs one
+// scheduling pass over them and returns where each container ended up.
+func schedule(t *testing.T, sched func(*Scheduler), nodes []*Node, containers []*Container) map[string]string {
+	t.Helper()
+	ctx := context.Background()
+
+	store := NewMemStore()
+	for _, n := range nodes {
+		if err := store.SaveNode(ctx, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range containers {
+		if err := store.SaveContainer(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := NewScheduler(store)
+	if sched != nil {
+		sched(s)
+	}
+
+	snapshot, err := store.ListContainers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SchedulePending(ctx, snapshot); err != nil {
+		t.Fatalf("SchedulePending: %v", err)
+	}
+
+	saved, err := store.ListContainers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	placed := make(map[string]string, len(saved))
+	for _, c := range saved {
+		if c.Scheduled {
+			placed[c.ID] = c.NodeID
+		}
+	}
+	return placed
+}
+
+func TestSchedulePendingPicksLeastLoadedNode(t *testing.T) {
+	busy := pendingContainer("busy")
+	busy.NodeID, busy.Scheduled, busy.State = "w1", true, Running
+
+	placed := schedule(t, nil,
+		[]*Node{workerNode("w1"), workerNode("w2")},
+		[]*Container{busy, pendingContainer("c1")})
+
+	if placed["c1"] != "w2" {
+		t.Errorf("c1 placed on %q, want w2", placed["c1"])
+	}
+}
+
+func TestSchedulePendingSkipsUnschedulableNodes(t *testing.T) {
+	cordoned := workerNode("cordoned")
+	cordoned.Unschedulable = true
+	notReady := workerNode("not-ready")
+	notReady.State = NodeNotReady
+	control := workerNode("control")
+	control.Role = ControlPlane
+
+	placed := schedule(t, nil,
+		[]*Node{cordoned, notReady, control, workerNode("ok")},
+		[]*Container{pendingContainer("c1"), pendingContainer("c2")})
+
+	for _, id := range []string{"c1", "c2"} {
+		if placed[id] != "ok" {
+			t.Errorf("%s placed on %q, want ok", id, placed[id])
+		}
+	}
+}
+
+func TestSchedulePendingLeavesContainerWithoutNodePending(t *testing.T) {
+	cordoned := workerNode("w1")
+	cordoned.Unschedulable = true
+
+	placed := schedule(t, nil, []*Node{cordoned}, []*Container{pendingContainer("c1")})
+
+	if node, ok := placed["c1"]; ok {
+		t.Errorf("c1 placed on %q, want it left pending", node)
+	}
+}
+
+func TestSchedulePendingSkipsStoppedContainers(t *testing.T) {
+	stopped := pendingContainer("c1")
+	stopped.DesiredState = Stopped
+
+	placed := schedule(t, nil, []*Node{workerNode("w1")}, []*Container{stopped})
+
+	if node, ok := placed["c1"]; ok {
+		t.Errorf("stopped container placed on %q", node)
+	}
+}
+
+func TestSchedulePendingRespectsMaxContainers(t *testing.T) {
+	small := workerNode("small")
+	small.MaxContainers = 1
+
+	placed := schedule(t, nil,
+		[]*Node{small},
+		[]*Container{pendingContainer("c1"), pendingContainer("c2")})
+
+	if len(placed) != 1 {
+		t.Errorf("placed %d containers on a node capped at 1: %v", len(placed), placed)
+	}
+}
+
+func TestSchedulePendingSkipsFullNode(t *testing.T) {
+	full, empty := workerNode("full"), workerNode("empty")
+	full.MaxContainers = 1
+
+	// affinity would otherwise send c1 to the full node
+	c := pendingContainer("c1")
+	c.Affinity = map[string]string{"app": "cache"}
+	placed := schedule(t, nil, []*Node{full, empty},
+		[]*Container{runningOn("cache", "full", map[string]string{"app": "cache"}), c})
+	if placed["c1"] != "empty" {
+		t.Errorf("c1 placed on %q, want the empty node", placed["c1"])
+	}
+}
+
+func TestSelectNodeWithEveryNodeFull(t *testing.T) {
+	a, b := workerNode("a"), workerNode("b")
+	a.MaxContainers, b.MaxContainers = 1, 1
+	idx := indexSnapshot([]*Container{runningOn("x", "a", nil), runningOn("y", "b", nil)})
+
+	s := NewScheduler(NewMemStore())
+	if _, err := s.selectNode([]*Node{a, b}, []*Container{pendingContainer("c1")}, idx); !errors.Is(err, ErrNoSchedulableNode) {
+		t.Errorf("got %v, want ErrNoSchedulableNode", err)
+	}
+}
+
+func TestSchedulePendingKeepsGroupTogether(t *testing.T) {
+	var containers []*Container
+	for _, id := range []string{"a", "b", "c"} {
+		c := pendingContainer(id)
+		c.Group = "web"
+		containers = append(containers, c)
+	}
+	// loads w1 so a spread scheduler would split the group
+	other := pendingContainer("other")
+	containers = append(containers, other)
+
+	placed := schedule(t, nil, []*Node{workerNode("w1"), workerNode("w2")}, containers)
+
+	if placed["a"] == "" || placed["a"] != placed["b"] || placed["a"] != placed["c"] {
+		t.Errorf("group split across nodes: %v", placed)
+	}
+}
+
+func TestSchedulePendingAvoidsHostPortConflict(t *testing.T) {
+	running := pendingContainer("running")
+	running.NodeID, running.Scheduled, running.State = "w1", true, Running
+	running.Ports = []PortMapping{{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}}
+	// a second container keeps w2 the busier node
+	filler := pendingContainer("filler")
+	filler.NodeID, filler.Scheduled, filler.State = "w2", true, Running
+	filler2 := pendingContainer("filler2")
+	filler2.NodeID, filler2.Scheduled, filler2.State = "w2", true, Running
+
+	c := pendingContainer("c1")
+	c.Ports = []PortMapping{{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}}
+
+	placed := schedule(t, nil,
+		[]*Node{workerNode("w1"), workerNode("w2")},
+		[]*Container{running, filler, filler2, c})
+
+	if placed["c1"] != "w2" {
+		t.Errorf("c1 placed on %q, want w2 where 8080 is free", placed["c1"])
+	}
+}
+
+func TestSchedulePendingSpreadsDeploymentAcrossZones(t *testing.T) {
+	a1, a2, b1 := workerNode("a1"), workerNode("a2"), workerNode("b1")
+	a1.Zone, a2.Zone, b1.Zone = "a", "a", "b"
+
+	var containers []*Container
+	for _, id := range []string{"r1", "r2"} {
+		c := pendingContainer(id)
+		c.Deployment = "web"
+		containers = append(containers, c)
+	}
+
+	placed := schedule(t, nil, []*Node{a1, a2, b1}, containers)
+
+	zones := map[string]string{"a1": "a", "a2": "a", "b1": "b"}
+	if zones[placed["r1"]] == zones[placed["r2"]] {
+		t.Errorf("both replicas placed in zone %q: %v", zones[placed["r1"]], placed)
+	}
+}
+
+func TestSchedulePendingSpreadsOverTwoZonesOfTwoNodes(t *testing.T) {
+	zones := map[string]string{"a1": "a", "a2": "a", "b1": "b", "b2": "b"}
+	var nodes []*Node
+	for _, id := range []string{"a1", "a2", "b1", "b2"} {
+		node := workerNode(id)
+		node.Zone = zones[id]
+		nodes = append(nodes, node)
+	}
+
+	var containers []*Container
+	for _, id := range []string{"r1", "r2", "r3", "r4"} {
+		c := pendingContainer(id)
+		c.Deployment = "web"
+		containers = append(containers, c)
+	}
+
+	placed := schedule(t, nil, nodes, containers)
+
+	perZone, perNode := make(map[string]int), make(map[string]int)
+	for _, nodeID := range placed {
+		perZone[zones[nodeID]]++
+		perNode[nodeID]++
+	}
+	if want := map[string]int{"a": 2, "b": 2}; !maps.Equal(perZone, want) {
+		t.Errorf("replicas per zone = %v, want %v (placed %v)", perZone, want, placed)
+	}
+	if len(perNode) != 4 {
+		t.Errorf("replicas per node = %v, want one on each", perNode)
+	}
+}
+
+func TestSchedulePendingAntiAffinity(t *testing.T) {
+	db := pendingContainer("db")
+	db.NodeID, db.Scheduled, db.State = "w1", true, Running
+	db.Labels = map[string]string{"app": "db"}
+
+	c := pendingContainer("c1")
+	c.AntiAffinity = map[string]string{"app": "db"}
+	// keep w2 the busier node so only the rule can send c1 there
+	filler := pendingContainer("filler")
+	filler.NodeID, filler.Scheduled, filler.State = "w2", true, Running
+	filler2 := pendingContainer("filler2")
+	filler2.NodeID, filler2.Scheduled, filler2.State = "w2", true, Running
+
+	placed := schedule(t, nil,
+		[]*Node{workerNode("w1"), workerNode("w2")},
+		[]*Container{db, filler, filler2, c})
+
+	if placed["c1"] != "w2" {
+		t.Errorf("c1 placed on %q next to db", placed["c1"])
+	}
+}
+
+func TestSchedulePendingResourceCapacity(t *testing.T) {
+	node := workerNode("w1")
+	node.Capacity = Resources{CPUCores: 2, MemoryMB: 1024}
+
+	big := pendingContainer("big")
+	big.Resources = Resources{CPUCores: 2, MemoryMB: 512}
+	more := pendingContainer("more")
+	more.Resources = Resources{CPUCores: 1}
+
+	placed := schedule(t, nil, []*Node{node}, []*Container{big, more})
+
+	if placed["big"] != "w1" {
+		t.Errorf("big placed on %q, want w1", placed["big"])
+	}
+	if node, ok := placed["more"]; ok {
+		t.Errorf("more placed on full node %q", node)
+	}
+}
+
+func TestSchedulePendingSticky(t *testing.T) {
+	for _, tc := range []struct {
+		sticky bool
+		want   string
+	}{
+		{sticky: true, want: "w1"},
+		{sticky: false, want: "w2"},
+	} {
+		busy := pendingContainer("busy")
+		busy.NodeID, busy.Scheduled, busy.State = "w1", true, Running
+		c := pendingContainer("c1")
+		c.LastNodeID = "w1"
+		placed := schedule(t, func(s *Scheduler) { s.sticky = tc.sticky },
+			[]*Node{workerNode("w1"), workerNode("w2")},
+			[]*Container{busy, c})
+
+		if placed["c1"] != tc.want {
+			t.Errorf("sticky=%v: c1 placed on %q, want %s", tc.sticky, placed["c1"], tc.want)
+		}
+	}
+}
+
+func TestSchedulePendingStickyFallsBackWhenLastNodeUnavailable(t *testing.T) {
+	for name, last := range map[string]func() *Node{
+		"not ready": func() *Node {
+			n := workerNode("w1")
+			n.State = NodeNotReady
+			return n
+		},
+		"full": func() *Node {
+			n := workerNode("w1")
+			n.MaxContainers = 1
+			return n
+		},
+	} {
+		busy := pendingContainer("busy")
+		busy.NodeID, busy.Scheduled, busy.State = "w1", true, Running
+		c := pendingContainer("c1")
+		c.LastNodeID = "w1"
+		placed := schedule(t, func(s *Scheduler) { s.sticky = true },
+			[]*Node{last(), workerNode("w2")},
+			[]*Container{busy, c})
+
+		if placed["c1"] != "w2" {
+			t.Errorf("%s last node: c1 placed on %q, want w2", name, placed["c1"])
+		}
+	}
+}
+
+func TestSchedulePendingCordonedNodeKeepsItsContainers(t *testing.T) {
+	cordoned := workerNode("w1")
+	cordoned.Unschedulable = true
+
+	running := pendingContainer("running")
+	running.NodeID, running.Scheduled, running.State = "w1", true, Running
+
+	placed := schedule(t, nil,
+		[]*Node{cordoned, workerNode("w2")},
+		[]*Container{running, pendingContainer("new")})
+
+	if placed["running"] != "w1" {
+		t.Errorf("running container moved to %q, want it left on w1", placed["running"])
+	}
+	if placed["new"] != "w2" {
+		t.Errorf("new container placed on %q, want w2", placed["new"])
+	}
+}
+
+func TestSchedulePendingIgnoresFinishedContainersResources(t *testing.T) {
+	node := workerNode("w1")
+	node.Capacity = Resources{CPUCores: 2, MemoryMB: 1024}
+	job := &Container{ID: "job", Image: "busybox", NodeID: "w1", Scheduled: true,
+		State: Completed, DesiredState: Running, Resources: Resources{CPUCores: 2, MemoryMB: 1024}}
+	stopped := &Container{ID: "stopped", Image: "nginx", NodeID: "w1", Scheduled: true,
+		State: Stopped, DesiredState: Stopped, Resources: Resources{CPUCores: 2, MemoryMB: 1024}}
+	c := pendingContainer("c1")
+	c.Resources = Resources{CPUCores: 2, MemoryMB: 1024}
+
+	placed := schedule(t, nil, []*Node{node}, []*Container{job, stopped, c})
+
+	if placed["c1"] != "w1" {
+		t.Errorf("c1 placed on %q, want w1, whose containers have all finished", placed["c1"])
+	}
+}
+
+func TestSchedulePendingCountsHeartbeatAllocation(t *testing.T) {
+	// w1 reports containers running that the store doesn't place there
+	full := workerNode("w1")
+	full.Capacity = Resources{CPUCores: 2}
+	full.Allocated = Resources{CPUCores: 2}
+	free := workerNode("w2")
+	free.Capacity = Resources{CPUCores: 2}
+
+	c := pendingContainer("c1")
+	c.Resources = Resources{CPUCores: 1}
+
+	placed := schedule(t, nil, []*Node{full, free}, []*Container{c})
+
+	if placed["c1"] != "w2" {
+		t.Errorf("c1 placed on %q, want w2, the node with room", placed["c1"])
+	}
+}
+
+func TestFitsResourcesUsesLargerOfRecordsAndHeartbeat(t *testing.T) {
+	node := &Node{ID: "w1", Capacity: Resources{CPUCores: 4, MemoryMB: 1024}}
+	requested := Resources{CPUCores: 3}
+	want := []*Container{{ID: "new", Resources: Resources{CPUCores: 1, MemoryMB: 512}}}
+
+	node.Allocated = Resources{CPUCores: 1, MemoryMB: 512}
+	if !fitsResources(node, want, requested) {
+		t.Errorf("3+1 cores and 512+512 MB don't fit 4 cores and 1024 MB")
+	}
+
+	node.Allocated = Resources{MemoryMB: 768}
+	if fitsResources(node, want, requested) {
+		t.Errorf("fit despite the heartbeat reporting 768 MB of 1024 in use")
+	}
+}
+
+func TestIndexSnapshotSumsRequestsPerNode(t *testing.T) {
+	idx := indexSnapshot([]*Container{
+		{ID: "a", NodeID: "w1", State: Running, DesiredState: Running, Scheduled: true, Resources: Resources{CPUCores: 1}},
+		{ID: "b", NodeID: "w1", State: Stopped, DesiredState: Stopped, Resources: Resources{CPUCores: 2}},
+		{ID: "done", NodeID: "w1", State: Completed, DesiredState: Running, Scheduled: true, Resources: Resources{CPUCores: 8}},
+		{ID: "gone", NodeID: "w1", State: Running, DesiredState: Destroyed, Resources: Resources{CPUCores: 4}},
+		{ID: "pending", Resources: Resources{CPUCores: 8}},
+	})
+	// the destroyed one holds its share until its worker has stopped it
+	if got := idx.requested["w1"]; got.CPUCores != 5 {
+		t.Errorf("w1 requests %d cores, want 5 from the active containers", got.CPUCores)
+	}
+	if got := len(idx.active["w1"]); got != 2 {
+		t.Errorf("w1 has %d active containers, want 2", got)
+	}
+
+	idx.add("w1", []*Container{{ID: "new", Resources: Resources{CPUCores: 1}}})
+	if got := idx.requested["w1"]; got.CPUCores != 6 {
+		t.Errorf("after a placement w1 requests %d cores, want 6", got.CPUCores)
+	}
+}
+
+func BenchmarkFitsResources(b *testing.B) {
+	nodes, containers := benchmarkCluster(50, 20, 1000)
+	members := containers[len(containers)-1:]
+
+	for range b.N {
+		idx := indexSnapshot(containers)
+		for _, node := range nodes {
+			fitsResources(node, members, idx.requested[node.ID])
+		}
+	}
+}
+
+// benchmarkCluster returns nodes workers each running perNode containers,
+// and pending containers waiting to be placed on them. The containers
+// belong to a few deployments, publish host ports and carry anti-affinity,
+// so every placement check has work to do.
+func benchmarkCluster(nodes, perNode, pending int) ([]*Node, []*Container) {
+	var ns []*Node
+	var cs []*Container
+	for i := range nodes {
+		node := workerNode(fmt.Sprintf("w%d", i))
+		node.Zone = fmt.Sprintf("zone-%d", i%3)
+		node.Capacity = Resources{CPUCores: 1 << 20, MemoryMB: 1 << 30}
+		ns = append(ns, node)
+
+		for j := range perNode {
+			c := pendingContainer(fmt.Sprintf("run-%d-%d", i, j))
+			c.NodeID, c.Scheduled, c.State = node.ID, true, Running
+			c.Deployment = fmt.Sprintf("app-%d", j%10)
+			c.Labels = map[string]string{"app": c.Deployment}
+			c.Ports = []PortMapping{{HostPort: 20000 + j, ContainerPort: 80, Protocol: "tcp"}}
+			c.Resources = Resources{CPUCores: 1, MemoryMB: 64}
+			cs = append(cs, c)
+		}
+	}
+	for i := range pending {
+		c := pendingContainer(fmt.Sprintf("pending-%d", i))
+		c.Deployment = fmt.Sprintf("app-%d", i%10)
+		c.Labels = map[string]string{"app": c.Deployment}
+		c.AntiAffinity = map[string]string{"app": "batch"}
+		c.Ports = []PortMapping{{HostPort: 30000 + i, ContainerPort: 80, Protocol: "tcp"}}
+		c.Resources = Resources{CPUCores: 1, MemoryMB: 64}
+		cs = append(cs, c)
+	}
+	return ns, cs
+}
+
+func BenchmarkSchedulePending(b *testing.B) {
+	nodes, containers := benchmarkCluster(50, 20, 1000)
+	ctx := context.Background()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	for range b.N {
+		b.StopTimer()
+		store := NewMemStore()
+		for _, n := range nodes {
+			store.SaveNode(ctx, n)
+		}
+		if err := store.SaveContainers(ctx, containers); err != nil {
+			b.Fatal(err)
+		}
+		snapshot, err := store.ListContainers(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		s := NewScheduler(store)
+		b.StartTimer()
+
+		if err := s.SchedulePending(ctx, snapshot); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSchedulePendingLeavesGroupThatFitsNoNode(t *testing.T) {
+	// each node has room for two of the three members
+	var nodes []*Node
+	for _, id := range []string{"w1", "w2"} {
+		n := workerNode(id)
+		n.Capacity = Resources{CPUCores: 2}
+		nodes = append(nodes, n)
+	}
+	var containers []*Container
+	for _, id := range []string{"a", "b", "c"} {
+		c := pendingContainer(id)
+		c.Group = "web"
+		c.Resources = Resources{CPUCores: 1}
+		containers = append(containers, c)
+	}
+
+	if placed := schedule(t, nil, nodes, containers); len(placed) != 0 {
+		t.Errorf("placed %v, want the whole group left pending", placed)
+	}
+}
+
+func TestSchedulePendingAddsToScheduledGroupsNode(t *testing.T) {
+	running := pendingContainer("a")
+	running.Group = "web"
+	running.NodeID, running.Scheduled, running.State = "w2", true, Running
+	late := pendingContainer("b")
+	late.Group = "web"
+
+	placed := schedule(t, nil, []*Node{workerNode("w1"), workerNode("w2")}, []*Container{running, late})
+
+	if placed["b"] != "w2" {
+		t.Errorf("late member placed on %q, want w2 with the rest of its group", placed["b"])
+	}
+}
+
+// runningOn returns a container with labels running on nodeID.
+func runningOn(id, nodeID string, labels map[string]string) *Container {
+	c := pendingContainer(id)
+	c.NodeID, c.Scheduled, c.State = nodeID, true, Running
+	c.Labels = labels
+	return c
+}
+
+func TestSchedulePendingAffinityPrefersNodeWithMatch(t *testing.T) {
+	c := pendingContainer("c1")
+	c.Affinity = map[string]string{"app": "cache"}
+	containers := []*Container{
+		// the cache's node is the busiest of the three
+		runningOn("cache", "w3", map[string]string{"app": "cache"}),
+		runningOn("filler", "w3", nil),
+		runningOn("other", "w2", map[string]string{"app": "db"}),
+		c,
+	}
+
+	placed := schedule(t, nil, []*Node{workerNode("w1"), workerNode("w2"), workerNode("w3")}, containers)
+	if placed["c1"] != "w3" {
+		t.Errorf("c1 placed on %q, want w3 next to the cache", placed["c1"])
+	}
+
+	// with no node matching it falls back to the least loaded
+	lone := pendingContainer("lone")
+	lone.Affinity = map[string]string{"app": "missing"}
+	placed = schedule(t, nil, []*Node{workerNode("w1"), workerNode("w2")},
+		[]*Container{runningOn("filler", "w1", nil), lone})
+	if placed["lone"] != "w2" {
+		t.Errorf("lone placed on %q, want the idle w2", placed["lone"])
+	}
+}
+
+func TestSchedulePendingAntiAffinityAcrossNodes(t *testing.T) {
+	c := pendingContainer("c1")
+	c.AntiAffinity = map[string]string{"app": "db"}
+	containers := []*Container{
+		runningOn("db-1", "w1", map[string]string{"app": "db"}),
+		runningOn("db-2", "w2", map[string]string{"app": "db"}),
+		c,
+	}
+
+	placed := schedule(t, nil, []*Node{workerNode("w1"), workerNode("w2"), workerNode("w3")}, containers)
+	if placed["c1"] != "w3" {
+		t.Errorf("c1 placed on %q, want w3, the only node without a db", placed["c1"])
+	}
+
+	// every node hosts a db, so c1 stays pending
+	placed = schedule(t, nil, []*Node{workerNode("w1"), workerNode("w2")}, containers)
+	if node, ok := placed["c1"]; ok {
+		t.Errorf("c1 placed on %q next to a db", node)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"slices"
+	"time"
+)
+
+// serviceEndpoints lists the containers backing svc, ordered by ID.
+func serviceEndpoints(svc *Service, containers []*Container) []Endpoint {
+	endpoints := []Endpoint{}
+	for _, c := range containers {
+		if c.State != Running || !c.Ready || c.IPAddress == "" || c.DesiredState == Destroyed {
+			continue
+		}
+		if !matchesSelector(c.Labels, svc.Selector) {
+			continue
+		}
+		endpoints = append(endpoints, Endpoint{
+			ContainerID: c.ID,
+			NodeID:      c.NodeID,
+			IPAddress:   c.IPAddress,
+			Port:        svc.Port,
+		})
+	}
+
+	slices.SortFunc(endpoints, func(a, b Endpoint) int {
+		return cmp.Compare(a.ContainerID, b.ContainerID)
+	})
+	return endpoints
+}
+
+// refreshServices recomputes every service's endpoints, saves those that
+// changed and passes them on to the proxy.
+func (r *Reconciler) refreshServices(ctx context.Context, containers []*Container) {
+	services, err := r.cogsworth.store.ListServices(ctx)
+	if err != nil {
+		log.Printf("Failed to list services: %v", err)
+		return
+	}
+
+	for _, svc := range services {
+		endpoints := serviceEndpoints(svc, containers)
+		if slices.Equal(endpoints, svc.Endpoints) {
+			continue
+		}
+
+		log.Printf("Service %s now has %d endpoints (was %d)", svc.Name, len(endpoints), len(svc.Endpoints))
+		svc.Endpoints = endpoints
+		svc.UpdatedAt = time.Now()
+		if err := r.cogsworth.store.SaveService(ctx, svc); err != nil {
+			log.Printf("Failed to save service %s: %v", svc.Name, err)
+		}
+	}
+
+	if r.proxy != nil {
+		r.proxy.Sync(services)
+	}
+}
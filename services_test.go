@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func endpointIDs(endpoints []Endpoint) []string {
+	ids := make([]string, 0, len(endpoints))
+	for _, ep := range endpoints {
+		ids = append(ids, ep.ContainerID)
+	}
+	return ids
+}
+
+func TestRefreshServicesFollowsContainers(t *testing.T) {
+	r, store, _ := newTestReconciler(t)
+	ctx := context.Background()
+	mustSave(t, store, &Service{Name: "web", Selector: map[string]string{"app": "web"}, Port: 80})
+
+	backend := func(id, ip string) *Container {
+		return &Container{ID: id, Image: "nginx", State: Running, DesiredState: Running, Ready: true,
+			IPAddress: ip, NodeID: "w1", Labels: map[string]string{"app": "web"}}
+	}
+	endpoints := func() []string {
+		t.Helper()
+		svc, err := store.GetService(ctx, "web")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return endpointIDs(svc.Endpoints)
+	}
+
+	web1, web2 := backend("web-1", "172.17.0.2"), backend("web-2", "172.17.0.3")
+	db := &Container{ID: "db", Image: "postgres", State: Running, DesiredState: Running, Ready: true,
+		IPAddress: "172.17.0.4", Labels: map[string]string{"app": "db"}}
+
+	r.refreshServices(ctx, []*Container{web1, db})
+	if got := endpoints(); !slices.Equal(got, []string{"web-1"}) {
+		t.Errorf("endpoints = %v, want [web-1]", got)
+	}
+
+	r.refreshServices(ctx, []*Container{web1, web2, db})
+	if got := endpoints(); !slices.Equal(got, []string{"web-1", "web-2"}) {
+		t.Errorf("after web-2 started: %v, want both", got)
+	}
+
+	web1.State, web1.IPAddress = Stopped, ""
+	unready := backend("web-3", "172.17.0.5")
+	unready.Ready = false
+	r.refreshServices(ctx, []*Container{web1, web2, unready, db})
+	if got := endpoints(); !slices.Equal(got, []string{"web-2"}) {
+		t.Errorf("after web-1 stopped: %v, want only web-2", got)
+	}
+}
+
+func TestGetServiceReturnsEndpoints(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	mustSave(t, store,
+		&Service{Name: "web", Selector: map[string]string{"app": "web"}, Port: 80,
+			Endpoints: []Endpoint{{ContainerID: "web-1", IPAddress: "172.17.0.2", Port: 80}}})
+
+	rec := call(t, handler, http.MethodGet, "/services/web", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+	var svc Service
+	decodeData(t, rec, &svc)
+	if len(svc.Endpoints) != 1 || svc.Endpoints[0].IPAddress != "172.17.0.2" {
+		t.Errorf("endpoints = %+v, want web-1's address", svc.Endpoints)
+	}
+
+	if rec := call(t, handler, http.MethodGet, "/services/missing", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("missing service: got %d, want 404", rec.Code)
+	}
+}
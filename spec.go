@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// specHash fingerprints the parts of a container that are baked into its
+// runtime container. A worker recreates the runtime container when the hash
+// of the desired spec no longer matches the one it applied.
+func specHash(c *Container) string {
+	// containers without requests keep the hash they had before resources
+	// were part of it
+	var resources *Resources
+	if c.Resources != (Resources{}) {
+		resources = &c.Resources
+	}
+
+	// maps marshal with sorted keys, so equal specs hash equally
+	data, _ := json.Marshal(struct {
+		Image     string            `json:"image"`
+		Env       map[string]string `json:"env"`
+		Ports     []PortMapping     `json:"ports"`
+		Command   []string          `json:"command,omitempty"`
+		Args      []string          `json:"args,omitempty"`
+		Secrets   map[string]int64  `json:"secrets,omitempty"`
+		Signal    string            `json:"stop_signal,omitempty"`
+		Network   string            `json:"network,omitempty"`
+		Resources *Resources        `json:"resources,omitempty"`
+	}{c.Image, c.Env, specPorts(c.Ports), c.Command, c.Args, c.SecretVersions, c.StopSignal, c.Network, resources})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSpecHashTracksRuntimeFields(t *testing.T) {
+	base := func() *Container {
+		return &Container{ID: "c1", Image: "nginx", Env: map[string]string{"MODE": "prod"},
+			Ports: []PortMapping{{HostPort: 8081, ContainerPort: 80, Protocol: "tcp"}}}
+	}
+	want := specHash(base())
+
+	for name, change := range map[string]func(c *Container){
+		"image":     func(c *Container) { c.Image = "nginx:2" },
+		"env":       func(c *Container) { c.Env["MODE"] = "dev" },
+		"added env": func(c *Container) { c.Env["DEBUG"] = "1" },
+		"ports":     func(c *Container) { c.Ports[0].HostPort = 8082 },
+		"command":   func(c *Container) { c.Command = []string{"nginx", "-g", "daemon off;"} },
+		"args":      func(c *Container) { c.Args = []string{"-v"} },
+		"secret":    func(c *Container) { c.SecretVersions = map[string]int64{"db": 2} },
+		"signal":    func(c *Container) { c.StopSignal = "SIGQUIT" },
+		"network":   func(c *Container) { c.Network = "cogs-web" },
+		"resources": func(c *Container) { c.Resources = Resources{CPUCores: 1, MemoryMB: 256} },
+	} {
+		c := base()
+		change(c)
+		if specHash(c) == want {
+			t.Errorf("changing the %s kept the hash", name)
+		}
+	}
+
+	for name, change := range map[string]func(c *Container){
+		"state":     func(c *Container) { c.State = Running },
+		"labels":    func(c *Container) { c.Labels = map[string]string{"app": "web"} },
+		"picked IP": func(c *Container) { c.IPAddress = "172.17.0.5" },
+	} {
+		c := base()
+		change(c)
+		if specHash(c) != want {
+			t.Errorf("changing the %s changed the hash", name)
+		}
+	}
+}
+
+func TestReconcileRecreatesOnEnvChange(t *testing.T) {
+	r, store, runtime := newTestReconciler(t)
+	ctx := context.Background()
+
+	c := saveTestContainer(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running,
+		Env: map[string]string{"MODE": "prod"}})
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatal(err)
+	}
+	c, err := store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := c.ContainerID
+
+	// changed behind the generation's back, as an older control plane would
+	c.Env = map[string]string{"MODE": "dev"}
+	c = saveTestContainer(t, store, c)
+	if err := r.reconcileContainer(ctx, c, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := store.GetContainer(ctx, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.ContainerID == old || stored.SpecHash != specHash(stored) {
+		t.Errorf("runtime container %s (hash %s), want %s replaced with the new spec applied", stored.ContainerID, stored.SpecHash, old)
+	}
+	spec, _ := runtime.Spec(containerName(r.nameTemplate, stored))
+	if spec.Env["MODE"] != "dev" {
+		t.Errorf("runtime env = %v, want the new MODE", spec.Env)
+	}
+}
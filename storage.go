@@ -3,26 +3,101 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"slices"
 	"time"
 
 	"go.etcd.io/bbolt"
 )
 
 type Store interface {
+	// SaveContainer writes c, if it is new or carries the ResourceVersion
+	// stored, and bumps c.ResourceVersion to the saved one. A stale write
+	// fails with ErrConflict; read the container again and retry, as
+	// modifyContainer does.
 	SaveContainer(ctx context.Context, c *Container) error
+	// SaveContainers saves every container or, if any write fails, none.
+	SaveContainers(ctx context.Context, cs []*Container) error
 	GetContainer(ctx context.Context, id string) (*Container, error)
+	// GetContainerByName looks a container up by its unique Name.
+	GetContainerByName(ctx context.Context, name string) (*Container, error)
+	// ListContainers loads every container into memory. Prefer
+	// ListContainersPage outside of internal full scans.
 	ListContainers(ctx context.Context) ([]*Container, error)
+	// ListContainersPage returns up to limit containers starting at the
+	// cursor key, plus the cursor for the next page ("" when exhausted).
+	ListContainersPage(ctx context.Context, cursor string, limit int) ([]*Container, string, error)
 	DelContainer(ctx context.Context, id string) error
 
 	SaveNode(ctx context.Context, n *Node) error
 	GetNode(ctx context.Context, id string) (*Node, error)
+	// UpdateNode applies update to node id and saves it in one
+	// transaction, so partial updates such as a heartbeat and a cordon
+	// never undo each other. An error from update is returned as is and
+	// nothing is saved.
+	UpdateNode(ctx context.Context, id string, update func(n *Node) error) (*Node, error)
 	ListNodes(ctx context.Context) ([]*Node, error)
 	DelNode(ctx context.Context, id string) error
 
+	SaveSecret(ctx context.Context, secret *Secret) error
+	GetSecret(ctx context.Context, name string) (*Secret, error)
+	DelSecret(ctx context.Context, name string) error
+
+	SaveService(ctx context.Context, svc *Service) error
+	GetService(ctx context.Context, name string) (*Service, error)
+	ListServices(ctx context.Context) ([]*Service, error)
+	DelService(ctx context.Context, name string) error
+
+	// Autoscalers are keyed by the deployment they scale.
+	SaveAutoscaler(ctx context.Context, hpa *HorizontalAutoscaler) error
+	GetAutoscaler(ctx context.Context, deployment string) (*HorizontalAutoscaler, error)
+	ListAutoscalers(ctx context.Context) ([]*HorizontalAutoscaler, error)
+	DelAutoscaler(ctx context.Context, deployment string) error
+
+	// Idempotency keys are stored so they outlast a restart. An expired
+	// key is still returned until DelExpiredIdempotencyKeys removes it.
+	SaveIdempotencyKey(ctx context.Context, key *IdempotencyKey) error
+	GetIdempotencyKey(ctx context.Context, key string) (*IdempotencyKey, error)
+	// DelExpiredIdempotencyKeys deletes the keys expired at now and
+	// returns how many it deleted.
+	DelExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error)
+
+	// Backup writes a consistent snapshot of the whole store to w.
+	Backup(ctx context.Context, w io.Writer) error
+
 	Close() error
 }
 
+// maxConflictRetries bounds how often a write that lost a race with another
+// writer is tried again.
+const maxConflictRetries = 5
+
+// modifyContainer reads the container id, applies update to it and saves
+// it. If another writer saved it in between, it is read again and update
+// reapplied. An error from update is returned as is.
+func modifyContainer(ctx context.Context, store Store, id string, update func(c *Container) error) (*Container, error) {
+	for attempt := 1; ; attempt++ {
+		c, err := store.GetContainer(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := update(c); err != nil {
+			return nil, err
+		}
+
+		err = store.SaveContainer(ctx, c)
+		if errors.Is(err, ErrConflict) && attempt < maxConflictRetries {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
 type BoltStore struct {
 	db   *bbolt.DB
 	path string
@@ -30,6 +105,13 @@ type BoltStore struct {
 
 var containersBucket = []byte("containers")
 var nodesBucket = []byte("nodes")
+var secretsBucket = []byte("secrets")
+var servicesBucket = []byte("services")
+var autoscalersBucket = []byte("autoscalers")
+var idempotencyKeysBucket = []byte("idempotency_keys")
+
+// containerNamesBucket maps container names to the IDs holding them.
+var containerNamesBucket = []byte("container_names")
 
 func NewBoltStore(path string) (*BoltStore, error) {
 	db, err := bbolt.Open(path, 0600, nil)
@@ -37,28 +119,17 @@ func NewBoltStore(path string) (*BoltStore, error) {
 		return nil, fmt.Errorf("failed to open db: %w", err)
 	}
 
-	err = db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(containersBucket)
-		if err != nil {
-			return err
-		}
-
-		_, err = tx.CreateBucketIfNotExists(nodesBucket)
-		if err != nil {
-			return err
-		}
-
-		return nil
-	})
+	err = db.Update(migrate)
 	db.Close()
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to create buckets: %w", err)
+		return nil, fmt.Errorf("failed to migrate db: %w", err)
 	}
 	return &BoltStore{db: db, path: path}, nil
 }
 
 func (s *BoltStore) SaveContainer(ctx context.Context, c *Container) error {
+	version := c.ResourceVersion
 	err := s.withDB(s.path, func(db *bbolt.DB) error {
 		return db.Update(func(tx *bbolt.Tx) error {
 			bucket := tx.Bucket(containersBucket)
@@ -66,6 +137,25 @@ func (s *BoltStore) SaveContainer(ctx context.Context, c *Container) error {
 				return fmt.Errorf("container's bucket not found")
 			}
 
+			var prev *Container
+			if existing := bucket.Get([]byte(c.ID)); existing != nil {
+				prev = &Container{}
+				if err := json.Unmarshal(existing, prev); err != nil {
+					return fmt.Errorf("failed to unmarshal container: %w", err)
+				}
+			}
+
+			if err := checkTransition(prev, c); err != nil {
+				return err
+			}
+			if err := checkVersion(prev, c); err != nil {
+				return err
+			}
+			if err := indexContainerName(tx, prev, c); err != nil {
+				return err
+			}
+
+			c.ResourceVersion = nextVersion(prev)
 			data, err := json.Marshal(c)
 			if err != nil {
 				return fmt.Errorf("failed to marshal container: %w", err)
@@ -81,6 +171,64 @@ func (s *BoltStore) SaveContainer(ctx context.Context, c *Container) error {
 		})
 	})
 
+	if err != nil {
+		c.ResourceVersion = version
+	}
+	return err
+}
+
+func (s *BoltStore) SaveContainers(ctx context.Context, cs []*Container) error {
+	versions := make([]int64, len(cs))
+	for i, c := range cs {
+		versions[i] = c.ResourceVersion
+	}
+
+	err := s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.Update(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(containersBucket)
+			if bucket == nil {
+				return fmt.Errorf("container's bucket not found")
+			}
+
+			for _, c := range cs {
+				var prev *Container
+				if existing := bucket.Get([]byte(c.ID)); existing != nil {
+					prev = &Container{}
+					if err := json.Unmarshal(existing, prev); err != nil {
+						return fmt.Errorf("failed to unmarshal container: %w", err)
+					}
+				}
+
+				if err := checkTransition(prev, c); err != nil {
+					return err
+				}
+				if err := checkVersion(prev, c); err != nil {
+					return err
+				}
+				if err := indexContainerName(tx, prev, c); err != nil {
+					return err
+				}
+
+				c.ResourceVersion = nextVersion(prev)
+				data, err := json.Marshal(c)
+				if err != nil {
+					return fmt.Errorf("failed to marshal container: %w", err)
+				}
+
+				if err := bucket.Put([]byte(c.ID), data); err != nil {
+					return fmt.Errorf("failed to save container %s: %w", c.ID, err)
+				}
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		for i, c := range cs {
+			c.ResourceVersion = versions[i]
+		}
+	}
 	return err
 }
 
@@ -96,7 +244,7 @@ func (s *BoltStore) GetContainer(ctx context.Context, id string) (*Container, er
 
 			data := bucket.Get([]byte(id))
 			if data == nil {
-				return fmt.Errorf("container %s not found", id)
+				return fmt.Errorf("container %s %w", id, ErrNotFound)
 			}
 
 			container = &Container{}
@@ -112,6 +260,61 @@ func (s *BoltStore) GetContainer(ctx context.Context, id string) (*Container, er
 	return container, err
 }
 
+func (s *BoltStore) GetContainerByName(ctx context.Context, name string) (*Container, error) {
+	var id []byte
+
+	err := s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.View(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(containerNamesBucket)
+			if bucket == nil {
+				return fmt.Errorf("container names bucket not found")
+			}
+
+			if owner := bucket.Get([]byte(name)); owner != nil {
+				id = slices.Clone(owner)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if id == nil {
+		return nil, fmt.Errorf("container named %s %w", name, ErrNotFound)
+	}
+
+	return s.GetContainer(ctx, string(id))
+}
+
+// indexContainerName moves c's entry in the name index from the name prev
+// held to the one c claims, refusing a name held by another container.
+func indexContainerName(tx *bbolt.Tx, prev, c *Container) error {
+	release, claim := claimedName(prev), claimedName(c)
+	if release == claim {
+		return nil
+	}
+
+	bucket := tx.Bucket(containerNamesBucket)
+	if bucket == nil {
+		return fmt.Errorf("container names bucket not found")
+	}
+
+	if claim != "" {
+		if owner := bucket.Get([]byte(claim)); owner != nil && string(owner) != c.ID {
+			return fmt.Errorf("%w: container name %q is taken by %s", ErrNameInUse, claim, owner)
+		}
+		if err := bucket.Put([]byte(claim), []byte(c.ID)); err != nil {
+			return fmt.Errorf("failed to index container name: %w", err)
+		}
+	}
+	if release != "" && string(bucket.Get([]byte(release))) == prev.ID {
+		if err := bucket.Delete([]byte(release)); err != nil {
+			return fmt.Errorf("failed to release container name: %w", err)
+		}
+	}
+	return nil
+}
+
 func (s *BoltStore) ListContainers(ctx context.Context) ([]*Container, error) {
 	var containers []*Container
 
@@ -119,7 +322,8 @@ func (s *BoltStore) ListContainers(ctx context.Context) ([]*Container, error) {
 		return db.View(func(tx *bbolt.Tx) error {
 			bucket := tx.Bucket(containersBucket)
 			if bucket == nil {
-				return fmt.Errorf("containers bucket not found")
+				// a database the migrations have yet to reach lists as empty
+				return nil
 			}
 
 			return bucket.ForEach(func(k, v []byte) error {
@@ -138,6 +342,45 @@ func (s *BoltStore) ListContainers(ctx context.Context) ([]*Container, error) {
 	return containers, err
 }
 
+func (s *BoltStore) ListContainersPage(ctx context.Context, cursor string, limit int) ([]*Container, string, error) {
+	var containers []*Container
+	var next string
+
+	err := s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.View(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(containersBucket)
+			if bucket == nil {
+				return nil
+			}
+
+			c := bucket.Cursor()
+			k, v := c.First()
+			if cursor != "" {
+				k, v = c.Seek([]byte(cursor))
+			}
+
+			for ; k != nil; k, v = c.Next() {
+				if len(containers) == limit {
+					next = string(k)
+					return nil
+				}
+
+				var container Container
+				err := json.Unmarshal(v, &container)
+				if err != nil {
+					return fmt.Errorf("failed to unmarshal container: %w", err)
+				}
+
+				containers = append(containers, &container)
+			}
+
+			return nil
+		})
+	})
+
+	return containers, next, err
+}
+
 func (s *BoltStore) DelContainer(ctx context.Context, id string) error {
 	err := s.withDB(s.path, func(db *bbolt.DB) error {
 		return db.Update(func(tx *bbolt.Tx) error {
@@ -146,6 +389,16 @@ func (s *BoltStore) DelContainer(ctx context.Context, id string) error {
 				return fmt.Errorf("container's bucket not found")
 			}
 
+			if existing := bucket.Get([]byte(id)); existing != nil {
+				var prev Container
+				if err := json.Unmarshal(existing, &prev); err != nil {
+					return fmt.Errorf("failed to unmarshal container: %w", err)
+				}
+				if err := indexContainerName(tx, &prev, nil); err != nil {
+					return err
+				}
+			}
+
 			return bucket.Delete([]byte(id))
 		})
 	})
@@ -189,7 +442,7 @@ func (s *BoltStore) GetNode(ctx context.Context, id string) (*Node, error) {
 
 			data := bucket.Get([]byte(id))
 			if data == nil {
-				return fmt.Errorf("node %s not found", id)
+				return fmt.Errorf("node %s %w", id, ErrNotFound)
 			}
 
 			node = &Node{}
@@ -205,6 +458,45 @@ func (s *BoltStore) GetNode(ctx context.Context, id string) (*Node, error) {
 	return node, err
 }
 
+func (s *BoltStore) UpdateNode(ctx context.Context, id string, update func(n *Node) error) (*Node, error) {
+	var node *Node
+
+	err := s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.Update(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(nodesBucket)
+			if bucket == nil {
+				return fmt.Errorf("node's bucket not found")
+			}
+
+			data := bucket.Get([]byte(id))
+			if data == nil {
+				return fmt.Errorf("node %s %w", id, ErrNotFound)
+			}
+
+			node = &Node{}
+			if err := json.Unmarshal(data, node); err != nil {
+				return fmt.Errorf("failed to unmarshal node: %w", err)
+			}
+			if err := update(node); err != nil {
+				return err
+			}
+
+			data, err := json.Marshal(node)
+			if err != nil {
+				return fmt.Errorf("failed to marshal node: %w", err)
+			}
+			if err := bucket.Put([]byte(id), data); err != nil {
+				return fmt.Errorf("failed to save node: %w", err)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
 func (s *BoltStore) ListNodes(ctx context.Context) ([]*Node, error) {
 	var nodes []*Node
 
@@ -212,7 +504,7 @@ func (s *BoltStore) ListNodes(ctx context.Context) ([]*Node, error) {
 		return db.View(func(tx *bbolt.Tx) error {
 			bucket := tx.Bucket(nodesBucket)
 			if bucket == nil {
-				return fmt.Errorf("nodes bucket not found")
+				return nil
 			}
 
 			return bucket.ForEach(func(k, v []byte) error {
@@ -246,6 +538,338 @@ func (s *BoltStore) DelNode(ctx context.Context, id string) error {
 	return err
 }
 
+func (s *BoltStore) SaveSecret(ctx context.Context, secret *Secret) error {
+	err := s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.Update(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(secretsBucket)
+			if bucket == nil {
+				return fmt.Errorf("secret's bucket not found")
+			}
+
+			// every write is a new version so dependent containers can tell
+			// their values are stale
+			var prev Secret
+			if existing := bucket.Get([]byte(secret.Name)); existing != nil {
+				if err := json.Unmarshal(existing, &prev); err != nil {
+					return fmt.Errorf("failed to unmarshal secret: %w", err)
+				}
+			}
+			secret.Version = prev.Version + 1
+
+			data, err := json.Marshal(secret)
+			if err != nil {
+				return fmt.Errorf("failed to marshal secret: %w", err)
+			}
+
+			err = bucket.Put([]byte(secret.Name), data)
+			if err != nil {
+				return fmt.Errorf("failed to save secret: %w", err)
+			}
+
+			return nil
+		})
+	})
+
+	return err
+}
+
+func (s *BoltStore) GetSecret(ctx context.Context, name string) (*Secret, error) {
+	var secret *Secret
+
+	err := s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.View(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(secretsBucket)
+			if bucket == nil {
+				return fmt.Errorf("secret's bucket not found")
+			}
+
+			data := bucket.Get([]byte(name))
+			if data == nil {
+				return fmt.Errorf("secret %s %w", name, ErrNotFound)
+			}
+
+			secret = &Secret{}
+			err := json.Unmarshal(data, secret)
+			if err != nil {
+				return fmt.Errorf("failed to unmarshal secret: %w", err)
+			}
+
+			return nil
+		})
+	})
+
+	return secret, err
+}
+
+func (s *BoltStore) DelSecret(ctx context.Context, name string) error {
+	err := s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.Update(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(secretsBucket)
+			if bucket == nil {
+				return fmt.Errorf("secret's bucket not found")
+			}
+
+			return bucket.Delete([]byte(name))
+		})
+	})
+
+	return err
+}
+
+func (s *BoltStore) SaveService(ctx context.Context, svc *Service) error {
+	return s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.Update(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(servicesBucket)
+			if bucket == nil {
+				return fmt.Errorf("services bucket not found")
+			}
+
+			data, err := json.Marshal(svc)
+			if err != nil {
+				return fmt.Errorf("failed to marshal service: %w", err)
+			}
+
+			if err := bucket.Put([]byte(svc.Name), data); err != nil {
+				return fmt.Errorf("failed to save service: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+func (s *BoltStore) GetService(ctx context.Context, name string) (*Service, error) {
+	var svc *Service
+
+	err := s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.View(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(servicesBucket)
+			if bucket == nil {
+				return fmt.Errorf("services bucket not found")
+			}
+
+			data := bucket.Get([]byte(name))
+			if data == nil {
+				return fmt.Errorf("service %s %w", name, ErrNotFound)
+			}
+
+			svc = &Service{}
+			if err := json.Unmarshal(data, svc); err != nil {
+				return fmt.Errorf("failed to unmarshal service: %w", err)
+			}
+			return nil
+		})
+	})
+
+	return svc, err
+}
+
+func (s *BoltStore) ListServices(ctx context.Context) ([]*Service, error) {
+	var services []*Service
+
+	err := s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.View(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(servicesBucket)
+			if bucket == nil {
+				return nil
+			}
+
+			return bucket.ForEach(func(k, v []byte) error {
+				var svc Service
+				if err := json.Unmarshal(v, &svc); err != nil {
+					return fmt.Errorf("failed to unmarshal service: %w", err)
+				}
+				services = append(services, &svc)
+				return nil
+			})
+		})
+	})
+
+	return services, err
+}
+
+func (s *BoltStore) DelService(ctx context.Context, name string) error {
+	return s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.Update(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(servicesBucket)
+			if bucket == nil {
+				return fmt.Errorf("services bucket not found")
+			}
+
+			return bucket.Delete([]byte(name))
+		})
+	})
+}
+
+func (s *BoltStore) SaveAutoscaler(ctx context.Context, hpa *HorizontalAutoscaler) error {
+	return s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.Update(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(autoscalersBucket)
+			if bucket == nil {
+				return fmt.Errorf("autoscalers bucket not found")
+			}
+
+			data, err := json.Marshal(hpa)
+			if err != nil {
+				return fmt.Errorf("failed to marshal autoscaler: %w", err)
+			}
+
+			if err := bucket.Put([]byte(hpa.Deployment), data); err != nil {
+				return fmt.Errorf("failed to save autoscaler: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+func (s *BoltStore) GetAutoscaler(ctx context.Context, deployment string) (*HorizontalAutoscaler, error) {
+	var hpa *HorizontalAutoscaler
+
+	err := s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.View(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(autoscalersBucket)
+			if bucket == nil {
+				return fmt.Errorf("autoscalers bucket not found")
+			}
+
+			data := bucket.Get([]byte(deployment))
+			if data == nil {
+				return fmt.Errorf("autoscaler for %s %w", deployment, ErrNotFound)
+			}
+
+			hpa = &HorizontalAutoscaler{}
+			if err := json.Unmarshal(data, hpa); err != nil {
+				return fmt.Errorf("failed to unmarshal autoscaler: %w", err)
+			}
+			return nil
+		})
+	})
+
+	return hpa, err
+}
+
+func (s *BoltStore) ListAutoscalers(ctx context.Context) ([]*HorizontalAutoscaler, error) {
+	var autoscalers []*HorizontalAutoscaler
+
+	err := s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.View(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(autoscalersBucket)
+			if bucket == nil {
+				return nil
+			}
+
+			return bucket.ForEach(func(k, v []byte) error {
+				var hpa HorizontalAutoscaler
+				if err := json.Unmarshal(v, &hpa); err != nil {
+					return fmt.Errorf("failed to unmarshal autoscaler: %w", err)
+				}
+				autoscalers = append(autoscalers, &hpa)
+				return nil
+			})
+		})
+	})
+
+	return autoscalers, err
+}
+
+func (s *BoltStore) DelAutoscaler(ctx context.Context, deployment string) error {
+	return s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.Update(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(autoscalersBucket)
+			if bucket == nil {
+				return fmt.Errorf("autoscalers bucket not found")
+			}
+
+			return bucket.Delete([]byte(deployment))
+		})
+	})
+}
+
+func (s *BoltStore) SaveIdempotencyKey(ctx context.Context, key *IdempotencyKey) error {
+	return s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.Update(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(idempotencyKeysBucket)
+			if bucket == nil {
+				return fmt.Errorf("idempotency keys bucket not found")
+			}
+
+			data, err := json.Marshal(key)
+			if err != nil {
+				return fmt.Errorf("failed to marshal idempotency key: %w", err)
+			}
+
+			if err := bucket.Put([]byte(key.Key), data); err != nil {
+				return fmt.Errorf("failed to save idempotency key: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+func (s *BoltStore) GetIdempotencyKey(ctx context.Context, key string) (*IdempotencyKey, error) {
+	var record *IdempotencyKey
+
+	err := s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.View(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(idempotencyKeysBucket)
+			if bucket == nil {
+				return fmt.Errorf("idempotency keys bucket not found")
+			}
+
+			data := bucket.Get([]byte(key))
+			if data == nil {
+				return fmt.Errorf("idempotency key %s %w", key, ErrNotFound)
+			}
+
+			record = &IdempotencyKey{}
+			if err := json.Unmarshal(data, record); err != nil {
+				return fmt.Errorf("failed to unmarshal idempotency key: %w", err)
+			}
+			return nil
+		})
+	})
+
+	return record, err
+}
+
+func (s *BoltStore) DelExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	deleted := 0
+
+	err := s.withDB(s.path, func(db *bbolt.DB) error {
+		return db.Update(func(tx *bbolt.Tx) error {
+			bucket := tx.Bucket(idempotencyKeysBucket)
+			if bucket == nil {
+				return fmt.Errorf("idempotency keys bucket not found")
+			}
+
+			// bbolt cursors skip entries when deleting mid-iteration
+			var expired [][]byte
+			err := bucket.ForEach(func(k, v []byte) error {
+				var record IdempotencyKey
+				if err := json.Unmarshal(v, &record); err != nil {
+					return fmt.Errorf("failed to unmarshal idempotency key: %w", err)
+				}
+				if !now.Before(record.ExpiresAt) {
+					expired = append(expired, k)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, k := range expired {
+				if err := bucket.Delete(k); err != nil {
+					return err
+				}
+			}
+			deleted = len(expired)
+			return nil
+		})
+	})
+
+	return deleted, err
+}
+
 func (s *BoltStore) Close() error {
 	if s.db != nil {
 		return s.db.Close()
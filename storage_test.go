@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+// forEachStore runs fn against a fresh BoltStore and a fresh MemStore, so
+// the test double is held to the semantics of the real store.
+func forEachStore(t *testing.T, fn func(t *testing.T, store Store)) {
+	t.Run("bolt", func(t *testing.T) {
+		store, err := NewBoltStore(filepath.Join(t.TempDir(), dbFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { store.Close() })
+		fn(t, store)
+	})
+	t.Run("memory", func(t *testing.T) {
+		fn(t, NewMemStore())
+	})
+}
+
+func TestStoreNotFound(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+
+		if _, err := store.GetContainer(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetContainer: got %v, want ErrNotFound", err)
+		}
+		if _, err := store.GetContainerByName(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetContainerByName: got %v, want ErrNotFound", err)
+		}
+		if _, err := store.GetNode(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetNode: got %v, want ErrNotFound", err)
+		}
+		if _, err := store.GetSecret(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetSecret: got %v, want ErrNotFound", err)
+		}
+		if _, err := store.GetService(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetService: got %v, want ErrNotFound", err)
+		}
+		if _, err := store.GetAutoscaler(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetAutoscaler: got %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestStoreContainerRoundTrip(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+
+		c := &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running,
+			Labels: map[string]string{"app": "web"}}
+		if err := store.SaveContainer(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := store.GetContainer(ctx, "c1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Image != "nginx" || got.Labels["app"] != "web" {
+			t.Errorf("got %+v, want the saved container", got)
+		}
+
+		// the stored record is a copy
+		got.Labels["app"] = "changed"
+		again, err := store.GetContainer(ctx, "c1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.Labels["app"] != "web" {
+			t.Errorf("changing a returned container changed the stored one")
+		}
+
+		if err := store.DelContainer(ctx, "c1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.GetContainer(ctx, "c1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("after delete: got %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestMemStoreHonoursCancellation(t *testing.T) {
+	store := NewMemStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := store.SaveContainer(ctx, &Container{ID: "c1"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("SaveContainer: got %v, want context.Canceled", err)
+	}
+	if _, err := store.ListContainers(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("ListContainers: got %v, want context.Canceled", err)
+	}
+	if _, err := store.GetNode(ctx, "n1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetNode: got %v, want context.Canceled", err)
+	}
+}
+
+func TestStoreUpdateNode(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+
+		if _, err := store.UpdateNode(ctx, "missing", func(*Node) error { return nil }); !errors.Is(err, ErrNotFound) {
+			t.Errorf("missing node: got %v, want ErrNotFound", err)
+		}
+
+		if err := store.SaveNode(ctx, &Node{ID: "w1", Zone: "a"}); err != nil {
+			t.Fatal(err)
+		}
+		node, err := store.UpdateNode(ctx, "w1", func(n *Node) error {
+			n.Unschedulable = true
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !node.Unschedulable || node.Zone != "a" {
+			t.Errorf("got %+v, want the stored node with the update applied", node)
+		}
+
+		failed := errors.New("no")
+		if _, err := store.UpdateNode(ctx, "w1", func(n *Node) error {
+			n.Zone = "b"
+			return failed
+		}); !errors.Is(err, failed) {
+			t.Errorf("got %v, want the update's error", err)
+		}
+		stored, err := store.GetNode(ctx, "w1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Zone != "a" || !stored.Unschedulable {
+			t.Errorf("stored %+v, want the failed update discarded", stored)
+		}
+	})
+}
+
+func TestStoreContainerPagesStayStable(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		for _, id := range []string{"c1", "c2", "c3", "c4", "c5"} {
+			if err := store.SaveContainer(ctx, &Container{ID: id, Image: "nginx", State: Requested, DesiredState: Running}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		page, cursor, err := store.ListContainersPage(ctx, "", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen := containerIDs(page)
+
+		// changes behind the cursor must not shift what the next pages hold
+		if err := store.SaveContainer(ctx, &Container{ID: "c0", Image: "nginx", State: Requested, DesiredState: Running}); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.DelContainer(ctx, "c1"); err != nil {
+			t.Fatal(err)
+		}
+
+		for cursor != "" {
+			page, cursor, err = store.ListContainersPage(ctx, cursor, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen = append(seen, containerIDs(page)...)
+		}
+		if want := []string{"c1", "c2", "c3", "c4", "c5"}; !slices.Equal(seen, want) {
+			t.Errorf("paged through %v, want each of %v once", seen, want)
+		}
+	})
+}
+
+func containerIDs(containers []*Container) []string {
+	ids := make([]string, 0, len(containers))
+	for _, c := range containers {
+		ids = append(ids, c.ID)
+	}
+	return ids
+}
+
+func TestStoreContainerNamesAreUnique(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		web := &Container{ID: "c1", Name: "web", Image: "nginx", State: Running, DesiredState: Running}
+		if err := store.SaveContainer(ctx, web); err != nil {
+			t.Fatal(err)
+		}
+		if c, err := store.GetContainerByName(ctx, "web"); err != nil || c.ID != "c1" {
+			t.Fatalf("lookup: got %v, %v, want c1", c, err)
+		}
+
+		err := store.SaveContainer(ctx, &Container{ID: "c2", Name: "web", Image: "nginx", State: Requested, DesiredState: Running})
+		if !errors.Is(err, ErrNameInUse) {
+			t.Errorf("second web: got %v, want ErrNameInUse", err)
+		}
+
+		// renaming frees the old name
+		web.Name = "frontend"
+		if err := store.SaveContainer(ctx, web); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.GetContainerByName(ctx, "web"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("old name after a rename: got %v, want ErrNotFound", err)
+		}
+		if err := store.SaveContainer(ctx, &Container{ID: "c2", Name: "web", Image: "nginx", State: Requested, DesiredState: Running}); err != nil {
+			t.Errorf("reusing a released name: %v", err)
+		}
+	})
+}
+
+func TestBoltListsWithoutBucketsAreEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), dbFile)
+	store, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// as a partial migration or a hand-edited file might leave it
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{containersBucket, nodesBucket, servicesBucket, autoscalersBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if containers, err := store.ListContainers(ctx); err != nil || len(containers) != 0 {
+		t.Errorf("ListContainers = %v, %v, want empty", containers, err)
+	}
+	if page, next, err := store.ListContainersPage(ctx, "", 10); err != nil || len(page) != 0 || next != "" {
+		t.Errorf("ListContainersPage = %v, %q, %v, want empty", page, next, err)
+	}
+	if nodes, err := store.ListNodes(ctx); err != nil || len(nodes) != 0 {
+		t.Errorf("ListNodes = %v, %v, want empty", nodes, err)
+	}
+	if services, err := store.ListServices(ctx); err != nil || len(services) != 0 {
+		t.Errorf("ListServices = %v, %v, want empty", services, err)
+	}
+	if autoscalers, err := store.ListAutoscalers(ctx); err != nil || len(autoscalers) != 0 {
+		t.Errorf("ListAutoscalers = %v, %v, want empty", autoscalers, err)
+	}
+}
+
+func TestStoreRejectsStaleWrite(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		mustSave(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running})
+
+		// two writers read the same version and race to save it
+		const writers = 8
+		copies := make([]*Container, writers)
+		for i := range copies {
+			c, err := store.GetContainer(ctx, "c1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			copies[i] = c
+		}
+		base := copies[0].ResourceVersion
+
+		errs := make([]error, writers)
+		var wg sync.WaitGroup
+		for i, c := range copies {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.Labels = map[string]string{"writer": strconv.Itoa(i)}
+				errs[i] = store.SaveContainer(ctx, c)
+			}()
+		}
+		wg.Wait()
+
+		saved := 0
+		for i, err := range errs {
+			switch {
+			case err == nil:
+				saved++
+			case errors.Is(err, ErrConflict):
+				if copies[i].ResourceVersion != base {
+					t.Errorf("rejected write moved its version to %d, want %d kept", copies[i].ResourceVersion, base)
+				}
+			default:
+				t.Errorf("writer %d: %v", i, err)
+			}
+		}
+		if saved != 1 {
+			t.Errorf("%d writes saved from the same version, want 1", saved)
+		}
+
+		stored, err := store.GetContainer(ctx, "c1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.ResourceVersion <= base {
+			t.Errorf("stored version %d, want it bumped past %d", stored.ResourceVersion, base)
+		}
+	})
+}
+
+func TestModifyContainerRetriesOnConflict(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		mustSave(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running})
+
+		// another writer slips in after the first read
+		interfered := false
+		_, err := modifyContainer(ctx, store, "c1", func(c *Container) error {
+			if !interfered {
+				interfered = true
+				if _, err := modifyContainer(ctx, store, "c1", func(c *Container) error {
+					c.MaxRestarts = 3
+					return nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			c.Protected = true
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := store.GetContainer(ctx, "c1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stored.Protected || stored.MaxRestarts != 3 {
+			t.Errorf("got protected %v, max restarts %d; want both updates kept", stored.Protected, stored.MaxRestarts)
+		}
+	})
+}
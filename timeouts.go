@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RuntimeTimeouts bounds individual runtime operations so a stuck registry
+// or daemon fails the operation instead of stalling the reconcile loop. A
+// zero timeout leaves the operation bounded only by the caller's context.
+type RuntimeTimeouts struct {
+	Pull   time.Duration
+	Create time.Duration
+	Start  time.Duration
+	// Stop is allowed on top of the container's own stop grace period.
+	Stop time.Duration
+}
+
+var defaultRuntimeTimeouts = RuntimeTimeouts{
+	Pull:   5 * time.Minute,
+	Create: 30 * time.Second,
+	Start:  30 * time.Second,
+	Stop:   30 * time.Second,
+}
+
+// timeoutRuntime applies RuntimeTimeouts to any Runtime.
+type timeoutRuntime struct {
+	Runtime
+	timeouts RuntimeTimeouts
+}
+
+func withTimeouts(runtime Runtime, timeouts RuntimeTimeouts) Runtime {
+	return &timeoutRuntime{Runtime: runtime, timeouts: timeouts}
+}
+
+func withOpTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// deadlineErr makes a timed-out operation say which limit it hit.
+func deadlineErr(ctx context.Context, op string, timeout time.Duration, err error) error {
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s timed out after %s: %w", op, timeout, err)
+	}
+	return err
+}
+
+func (t *timeoutRuntime) Pull(ctx context.Context, image string) error {
+	ctx, cancel := withOpTimeout(ctx, t.timeouts.Pull)
+	defer cancel()
+	return deadlineErr(ctx, "pull", t.timeouts.Pull, t.Runtime.Pull(ctx, image))
+}
+
+func (t *timeoutRuntime) Create(ctx context.Context, spec *ContainerSpec) (string, error) {
+	ctx, cancel := withOpTimeout(ctx, t.timeouts.Create)
+	defer cancel()
+	id, err := t.Runtime.Create(ctx, spec)
+	return id, deadlineErr(ctx, "create", t.timeouts.Create, err)
+}
+
+func (t *timeoutRuntime) Start(ctx context.Context, containerID string) error {
+	ctx, cancel := withOpTimeout(ctx, t.timeouts.Start)
+	defer cancel()
+	return deadlineErr(ctx, "start", t.timeouts.Start, t.Runtime.Start(ctx, containerID))
+}
+
+func (t *timeoutRuntime) Stop(ctx context.Context, containerID string, timeout int) error {
+	limit := t.timeouts.Stop
+	if limit > 0 {
+		limit += time.Duration(timeout) * time.Second
+	}
+
+	ctx, cancel := withOpTimeout(ctx, limit)
+	defer cancel()
+	return deadlineErr(ctx, "stop", limit, t.Runtime.Stop(ctx, containerID, timeout))
+}
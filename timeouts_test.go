@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeoutRuntimeCancelsBlockedPull(t *testing.T) {
+	fake := NewFakeRuntime()
+	fake.BlockPull("nginx")
+	runtime := withTimeouts(fake, RuntimeTimeouts{Pull: 20 * time.Millisecond})
+
+	start := time.Now()
+	err := runtime.Pull(context.Background(), "nginx")
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "pull timed out") {
+		t.Errorf("got %v, want the pull timed out", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("blocked pull returned after %s, want about the 20ms limit", elapsed)
+	}
+}
+
+func TestTimeoutRuntimeCancelsBlockedCreate(t *testing.T) {
+	fake := NewFakeRuntime()
+	fake.BlockCreate("cogs-app")
+	runtime := withTimeouts(fake, RuntimeTimeouts{Create: 20 * time.Millisecond})
+
+	_, err := runtime.Create(context.Background(), &ContainerSpec{Name: "cogs-app", Image: "nginx"})
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "create timed out") {
+		t.Errorf("got %v, want the create timed out", err)
+	}
+}
+
+func TestTimeoutRuntimeWithoutLimitKeepsCallerError(t *testing.T) {
+	fake := NewFakeRuntime()
+	fake.BlockPull("nginx")
+	runtime := withTimeouts(fake, RuntimeTimeouts{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := runtime.Pull(ctx, "nginx")
+	if !errors.Is(err, context.Canceled) || strings.Contains(err.Error(), "timed out") {
+		t.Errorf("got %v, want the caller's cancellation unchanged", err)
+	}
+}
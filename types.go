@@ -1,72 +1,176 @@
 package main
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
 
-type ContainerState string
+	"github.com/galadd/cogsworth/api"
+)
+
+// The resources served by the API are defined in package api, which the
+// client package shares.
+type (
+	ContainerState       = api.ContainerState
+	Container            = api.Container
+	ContainerFilter      = api.ContainerFilter
+	ContainerPatch       = api.ContainerPatch
+	ContainerUsage       = api.ContainerUsage
+	PortMapping          = api.PortMapping
+	ReadinessProbe       = api.ReadinessProbe
+	RuntimeStats         = api.RuntimeStats
+	BulkDeleteResult     = api.BulkDeleteResult
+	BatchItemResult      = api.BatchItemResult
+	ExecRequest          = api.ExecRequest
+	Node                 = api.Node
+	NodeState            = api.NodeState
+	NodeRole             = api.NodeRole
+	NodeSummary          = api.NodeSummary
+	MaintenanceWindow    = api.MaintenanceWindow
+	Heartbeat            = api.Heartbeat
+	ClusterStatus        = api.ClusterStatus
+	Resources            = api.Resources
+	Secret               = api.Secret
+	Service              = api.Service
+	Endpoint             = api.Endpoint
+	HorizontalAutoscaler = api.HorizontalAutoscaler
+	Scale                = api.Scale
+	VersionInfo          = api.VersionInfo
+)
 
 const (
-	Requested ContainerState = "requested"
-	Pulling   ContainerState = "pulling"
-	Created   ContainerState = "created"
-	Starting  ContainerState = "starting"
-	Running   ContainerState = "running"
-	Stopping  ContainerState = "stopping"
-	Stopped   ContainerState = "stopped"
-	Failed    ContainerState = "failed"
-	Destroyed ContainerState = "destroyed"
+	Requested = api.Requested
+	Pulling   = api.Pulling
+	Created   = api.Created
+	Starting  = api.Starting
+	Running   = api.Running
+	Paused    = api.Paused
+	Stopping  = api.Stopping
+	Stopped   = api.Stopped
+	Failed    = api.Failed
+	Completed = api.Completed
+	Destroyed = api.Destroyed
+
+	NodeReady    = api.NodeReady
+	NodeNotReady = api.NodeNotReady
+
+	ControlPlane = api.ControlPlane
+	Worker       = api.Worker
+
+	RestartAlways    = api.RestartAlways
+	RestartOnFailure = api.RestartOnFailure
+	RestartNever     = api.RestartNever
 )
 
-type Container struct {
-	ID           string            `json:"id"`
-	Image        string            `json:"image"`
-	State        ContainerState    `json:"state"`
-	DesiredState ContainerState    `json:"desired_state"`
-	CreatedAt    time.Time         `json:"created_at"`
-	UpdatedAt    time.Time         `json:"updated_at"`
-	ContainerID  string            `json:"container_id"`
-	IPAddress    string            `json:"ip_address"`
-	Env          map[string]string `json:"env"`
-	Ports        []PortMapping     `json:"ports"`
-	RestartCount int               `json:"restart_count"`
-
-	NodeID    string `json:"node_id"`
-	Scheduled bool   `json:"scheduled"`
+var ErrInvalidTransition = errors.New("invalid state transition")
+
+// ErrNameInUse is returned when a container is saved under a name another
+// container already holds.
+var ErrNameInUse = errors.New("name already in use")
+
+// checkTransition validates a write of next over the stored prev, which is
+// nil for a new container.
+func checkTransition(prev, next *Container) error {
+	if !next.State.Valid() {
+		return fmt.Errorf("%w: unknown state %q for container %s", ErrInvalidTransition, next.State, next.ID)
+	}
+
+	if prev != nil && !api.CanTransition(prev.State, next.State) {
+		return fmt.Errorf("%w: container %s cannot go from %s to %s", ErrInvalidTransition, next.ID, prev.State, next.State)
+	}
+
+	return nil
 }
 
-type PortMapping struct {
-	HostPort      int    `json:"host_port"`
-	ContainerPort int    `json:"container_port"`
-	Protocol      string `json:"protocol"`
+// checkVersion rejects next unless it was read at prev's ResourceVersion,
+// the latest one stored. A new container has no version to match.
+func checkVersion(prev, next *Container) error {
+	if prev != nil && next.ResourceVersion != prev.ResourceVersion {
+		return fmt.Errorf("%w: container %s is at version %d, the write was based on %d",
+			ErrConflict, next.ID, prev.ResourceVersion, next.ResourceVersion)
+	}
+	return nil
 }
 
-type Node struct {
-	ID        string    `json:"id"`
-	Address   string    `json:"address"`
-	Role      NodeRole  `json:"role"`
-	State     NodeState `json:"state"`
-	CreatedAt time.Time `json:"created_at"`
-	LastSeen  time.Time `json:"last_seen"`
+// nextVersion is the ResourceVersion a container saved over prev gets.
+func nextVersion(prev *Container) int64 {
+	if prev == nil {
+		return 1
+	}
+	return prev.ResourceVersion + 1
+}
 
-	Capacity  Resources `json:"capacity,omitempty"`
-	Allocated Resources `json:"allocated,omitempty"`
+// withStatus returns a copy of stored carrying what a worker observed in
+// report: the runtime state and the details it reports alongside it.
+func withStatus(stored, report *Container) *Container {
+	c := *stored
+	c.State = report.State
+	c.ContainerID = report.ContainerID
+	c.IPAddress = report.IPAddress
+	c.RestartCount = report.RestartCount
+	c.StartedAt = report.StartedAt
+	c.Ready = report.Ready
+	c.ObservedGeneration = report.ObservedGeneration
+	c.SpecHash = report.SpecHash
+	c.LastError = report.LastError
+	c.LastReconcileAt = report.LastReconcileAt
+	c.Usage = report.Usage
+	c.UpdatedAt = report.UpdatedAt
+	return &c
 }
 
-type NodeState string
+// claimedName is the name c holds in the store's name index. A container
+// gives its name up once it is being destroyed, so the name can be reused.
+func claimedName(c *Container) string {
+	if c == nil || c.DesiredState == Destroyed {
+		return ""
+	}
+	return c.Name
+}
 
-const (
-	NodeReady    NodeState = "ready"
-	NodeNotReady NodeState = "not_ready"
-)
+func validRestartPolicy(policy string) bool {
+	return policy == "" || policy == RestartAlways || policy == RestartOnFailure || policy == RestartNever
+}
+
+// restartsAfter reports whether c is started again after exiting on its own
+// with code.
+func restartsAfter(c *Container, code int) bool {
+	switch c.RestartPolicy {
+	case RestartNever:
+		return false
+	case RestartOnFailure:
+		return code != 0
+	}
+	return true
+}
 
-type NodeRole string
+// matchesFilter reports whether c is in the list f narrows to.
+func matchesFilter(f ContainerFilter, c *Container) bool {
+	if len(f.States) > 0 && !slices.Contains(f.States, c.State) {
+		return false
+	}
+	return matchesSelector(c.Labels, f.Selector)
+}
 
-const (
-	ControlPlane NodeRole = "control-plane"
-	Worker       NodeRole = "worker"
-)
+// parseStates parses a comma-separated list of container states.
+func parseStates(value string) ([]ContainerState, error) {
+	var states []ContainerState
+	for _, term := range splitList(value) {
+		state := ContainerState(term)
+		if !state.Valid() {
+			return nil, fmt.Errorf("unknown state %q", term)
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
 
-type Resources struct {
-	CPUCores int   `json:"cpu_cores"`
-	MemoryMB int64 `json:"memory_mb"`
-	DiskGB   int64 `json:"disk_gb"`
+// IdempotencyKey records the container a keyed POST /containers created,
+// with a hash of the request body so a replay can be told from a reuse.
+type IdempotencyKey struct {
+	Key         string    `json:"key"`
+	ContainerID string    `json:"container_id"`
+	BodyHash    string    `json:"body_hash"`
+	ExpiresAt   time.Time `json:"expires_at"`
 }
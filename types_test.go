@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestStopTimeoutIsWholeSeconds(t *testing.T) {
+	var c Container
+	if err := json.Unmarshal([]byte(`{"stop_timeout_seconds": 30}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.StopTimeoutSeconds(); got != 30 {
+		t.Errorf("got %d seconds, want 30", got)
+	}
+
+	if got := (&Container{}).StopTimeoutSeconds(); got != 10 {
+		t.Errorf("unset: got %d seconds, want the 10 second default", got)
+	}
+
+	c.StopTimeout = -1
+	c.ID, c.Image, c.State, c.DesiredState = "c1", "nginx", Requested, Running
+	if err := validateContainer(&c); err == nil {
+		t.Errorf("accepted a negative stop timeout")
+	}
+}
+
+func TestStopHandsRuntimeTheContainersTimeout(t *testing.T) {
+	cogs, store, runtime := newTestWorker(t, "w1")
+
+	runtime.SetStatus("rt-1", &RuntimeStatus{State: "running"})
+	runtime.SetStatus("rt-2", &RuntimeStatus{State: "running"})
+	mustSave(t, store,
+		&Node{ID: "w1", Role: Worker, State: NodeReady},
+		&Container{ID: "c1", Image: "nginx", State: Running, DesiredState: Running,
+			NodeID: "w1", Scheduled: true, ContainerID: "rt-1", StopTimeout: 30},
+		&Container{ID: "c2", Image: "nginx", State: Running, DesiredState: Running,
+			NodeID: "w1", Scheduled: true, ContainerID: "rt-2"})
+
+	if err := cogs.Deregister(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := runtime.StopTimeout("rt-1"); got != 30 {
+		t.Errorf("c1 stopped with %d seconds, want 30", got)
+	}
+	if got := runtime.StopTimeout("rt-2"); got != 10 {
+		t.Errorf("c2 stopped with %d seconds, want the default 10", got)
+	}
+}
+
+func TestCheckTransition(t *testing.T) {
+	for _, tc := range []struct {
+		from, to ContainerState
+		allowed  bool
+	}{
+		{Requested, Requested, true},
+		{Requested, Pulling, true},
+		{Requested, Created, true},
+		{Requested, Running, false},
+		{Pulling, Created, true},
+		{Pulling, Running, false},
+		{Created, Running, true},
+		{Running, Paused, true},
+		{Running, Stopped, true},
+		{Running, Requested, false},
+		{Paused, Running, true},
+		{Stopped, Paused, false},
+		{Stopped, Running, true},
+		{Failed, Running, true},
+		{Completed, Destroyed, true},
+		{Completed, Running, false},
+		{Destroyed, Destroyed, true},
+		{Destroyed, Running, false},
+		{Running, "sleeping", false},
+	} {
+		err := checkTransition(&Container{ID: "c1", State: tc.from}, &Container{ID: "c1", State: tc.to})
+		if tc.allowed && err != nil {
+			t.Errorf("%s -> %s: %v, want it allowed", tc.from, tc.to, err)
+		}
+		if !tc.allowed && !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("%s -> %s: got %v, want ErrInvalidTransition", tc.from, tc.to, err)
+		}
+	}
+
+	if err := checkTransition(nil, &Container{ID: "c1", State: Running}); err != nil {
+		t.Errorf("a new container may start in any known state: %v", err)
+	}
+	if err := checkTransition(nil, &Container{ID: "c1", State: "sleeping"}); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("new container in an unknown state: got %v, want ErrInvalidTransition", err)
+	}
+}
+
+func TestStoreRefusesForbiddenTransition(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		ctx := context.Background()
+		if err := store.SaveContainer(ctx, &Container{ID: "c1", Image: "nginx", State: Completed, DesiredState: Stopped}); err != nil {
+			t.Fatal(err)
+		}
+		c, err := store.GetContainer(ctx, "c1")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c.State = Running
+		if err := store.SaveContainer(ctx, c); !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("completed -> running: got %v, want ErrInvalidTransition", err)
+		}
+		if stored, _ := store.GetContainer(ctx, "c1"); stored.State != Completed {
+			t.Errorf("stored state %s, want it left completed", stored.State)
+		}
+	})
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// validateContainer checks a submitted container spec for fields the
+// reconciler relies on, so bad payloads are rejected before they are
+// persisted.
+func validateContainer(c *Container) error {
+	var errs []error
+
+	if c.ID == "" {
+		errs = append(errs, errors.New("id is required"))
+	}
+	if c.Name != "" && !validContainerName(c.Name) {
+		errs = append(errs, fmt.Errorf("invalid name %q, expected up to 63 lowercase letters, digits and dashes", c.Name))
+	}
+	if c.Image == "" {
+		errs = append(errs, errors.New("image is required"))
+	} else if strings.ContainsAny(c.Image, " \t\n") {
+		errs = append(errs, fmt.Errorf("image %q contains whitespace", c.Image))
+	}
+
+	if !c.State.Valid() {
+		errs = append(errs, fmt.Errorf("unknown state %q", c.State))
+	}
+	if !c.DesiredState.Valid() {
+		errs = append(errs, fmt.Errorf("unknown desired_state %q", c.DesiredState))
+	}
+
+	hostPorts := make(map[PortMapping]bool, len(c.Ports))
+	for _, pm := range c.Ports {
+		if pm.HostPort != 0 && !pm.Auto {
+			key := PortMapping{HostPort: pm.HostPort, Protocol: pm.Protocol}
+			if hostPorts[key] {
+				errs = append(errs, fmt.Errorf("host port %d/%s is published twice", pm.HostPort, pm.Protocol))
+			}
+			hostPorts[key] = true
+		}
+		if pm.ContainerPort < 1 || pm.ContainerPort > 65535 {
+			errs = append(errs, fmt.Errorf("container port %d is out of range 1-65535", pm.ContainerPort))
+		}
+		if pm.HostPort < 0 || pm.HostPort > 65535 {
+			errs = append(errs, fmt.Errorf("host port %d is out of range 0-65535", pm.HostPort))
+		}
+		if !validProtocols[pm.Protocol] {
+			errs = append(errs, fmt.Errorf("invalid protocol %q for container port %d", pm.Protocol, pm.ContainerPort))
+		}
+	}
+
+	for key := range c.Env {
+		if key == "" || strings.ContainsAny(key, "=\x00") {
+			errs = append(errs, fmt.Errorf("invalid env var name %q", key))
+		}
+	}
+
+	if !validPullPolicy(c.ImagePullPolicy) {
+		errs = append(errs, fmt.Errorf("image_pull_policy must be %s or %s", PullAlways, PullIfNotPresent))
+	}
+	if c.Network != "" && sanitizeContainerName(c.Network) != c.Network {
+		errs = append(errs, fmt.Errorf("invalid network name %q", c.Network))
+	}
+	if !validRestartPolicy(c.RestartPolicy) {
+		errs = append(errs, fmt.Errorf("restart_policy must be %s, %s or %s", RestartAlways, RestartOnFailure, RestartNever))
+	}
+	if c.StopSignal != "" && !validSignal(c.StopSignal) {
+		errs = append(errs, fmt.Errorf("invalid stop_signal %q, expected a name like SIGTERM or a number", c.StopSignal))
+	}
+	if c.MaxRestarts < 0 {
+		errs = append(errs, fmt.Errorf("max_restarts %d is negative", c.MaxRestarts))
+	}
+	if c.StopTimeout < 0 {
+		errs = append(errs, fmt.Errorf("stop_timeout_seconds %d is negative", c.StopTimeout))
+	}
+	if c.TTL < 0 {
+		errs = append(errs, fmt.Errorf("ttl %s is negative", c.TTL))
+	}
+	if c.ReadinessProbe != nil {
+		if err := validateProbe(c.ReadinessProbe); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.Resources.CPUCores < 0 || c.Resources.MemoryMB < 0 || c.Resources.DiskGB < 0 {
+		errs = append(errs, errors.New("resources must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// admitContainer is the check POST, PATCH and batch all put a container
+// through before saving it: its ports are normalized, its spec validated
+// and its dependencies checked against existing. A container already on a
+// node must not take a host port another container there publishes.
+func admitContainer(c *Container, existing []*Container) error {
+	normalizePorts(c.Ports)
+	if err := validateContainer(c); err != nil {
+		return err
+	}
+	if len(c.DependsOn) > 0 {
+		if err := validateDependencies(c, existing); err != nil {
+			return err
+		}
+	}
+	if c.NodeID != "" {
+		if port, ok := hostPortConflict(c, c.NodeID, existing); ok {
+			return fmt.Errorf("host port %d/%s is already published on node %s", port.HostPort, port.Protocol, c.NodeID)
+		}
+	}
+	return nil
+}
+
+// validateService checks a submitted service definition.
+func validateService(s *Service) error {
+	var errs []error
+
+	if s.Name == "" || !validContainerName(s.Name) {
+		errs = append(errs, fmt.Errorf("invalid service name %q, expected up to 63 lowercase letters, digits and dashes", s.Name))
+	}
+	if len(s.Selector) == 0 {
+		errs = append(errs, errors.New("selector is required"))
+	}
+	if s.Port < 0 || s.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d is out of range 1-65535", s.Port))
+	}
+	if s.ProxyPort < 0 || s.ProxyPort > 65535 {
+		errs = append(errs, fmt.Errorf("proxy_port %d is out of range 1-65535", s.ProxyPort))
+	} else if s.ProxyPort != 0 && s.Port == 0 {
+		errs = append(errs, errors.New("proxy_port requires port"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateAutoscaler(h *HorizontalAutoscaler) error {
+	var errs []error
+
+	if h.Deployment == "" {
+		errs = append(errs, errors.New("deployment is required"))
+	}
+	if h.MinReplicas < 1 {
+		errs = append(errs, fmt.Errorf("min_replicas %d must be at least 1", h.MinReplicas))
+	}
+	if h.MaxReplicas < h.MinReplicas {
+		errs = append(errs, fmt.Errorf("max_replicas %d is below min_replicas %d", h.MaxReplicas, h.MinReplicas))
+	}
+	if h.TargetCPUPercent <= 0 {
+		errs = append(errs, fmt.Errorf("target_cpu_percent %g must be positive", h.TargetCPUPercent))
+	}
+	if h.Cooldown < 0 {
+		errs = append(errs, fmt.Errorf("cooldown %s is negative", h.Cooldown))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateNode checks a node registration. A zero capacity dimension means
+// the node doesn't limit it.
+func validateNode(n *Node) error {
+	var errs []error
+
+	if n.ID == "" {
+		errs = append(errs, errors.New("id is required"))
+	}
+	if n.Role != Worker && n.Role != ControlPlane {
+		errs = append(errs, fmt.Errorf("role must be %s or %s", Worker, ControlPlane))
+	}
+	if n.Capacity.CPUCores < 0 {
+		errs = append(errs, fmt.Errorf("capacity cpu_cores %d is negative", n.Capacity.CPUCores))
+	}
+	if n.Capacity.MemoryMB < 0 {
+		errs = append(errs, fmt.Errorf("capacity memory_mb %d is negative", n.Capacity.MemoryMB))
+	}
+	if n.Capacity.DiskGB < 0 {
+		errs = append(errs, fmt.Errorf("capacity disk_gb %d is negative", n.Capacity.DiskGB))
+	}
+	if n.MaxContainers < 0 {
+		errs = append(errs, fmt.Errorf("max_containers %d is negative", n.MaxContainers))
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateMaintenance(m *MaintenanceWindow) error {
+	var errs []error
+
+	if m.Start.IsZero() {
+		errs = append(errs, errors.New("start is required"))
+	}
+	if m.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("duration %s must be positive", m.Duration))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validContainerName accepts DNS labels: 1-63 lowercase letters, digits and
+// dashes, starting and ending with a letter or digit.
+func validContainerName(name string) bool {
+	if len(name) > 63 || name[0] == '-' || name[len(name)-1] == '-' {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// validSignal accepts what Docker does for a stop signal: a SIG-prefixed
+// name or a signal number.
+func validSignal(signal string) bool {
+	if n, err := strconv.Atoi(signal); err == nil {
+		return n > 0 && n < 65
+	}
+
+	name, ok := strings.CutPrefix(strings.ToUpper(signal), "SIG")
+	if !ok || name == "" {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '+' && r != '-' {
+			return false
+		}
+	}
+	return true
+}
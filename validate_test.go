@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func validContainer() *Container {
+	return &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running,
+		Ports: []PortMapping{{HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}}}
+}
+
+func TestContainerValidate(t *testing.T) {
+	if err := validateContainer(validContainer()); err != nil {
+		t.Fatalf("valid container: %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		edit func(c *Container)
+		want string
+	}{
+		"no image":         {func(c *Container) { c.Image = "" }, "image is required"},
+		"spaced image":     {func(c *Container) { c.Image = "ngi nx" }, "whitespace"},
+		"no id":            {func(c *Container) { c.ID = "" }, "id is required"},
+		"bad state":        {func(c *Container) { c.State = "floating" }, "unknown state"},
+		"bad desired":      {func(c *Container) { c.DesiredState = "floating" }, "unknown desired_state"},
+		"container port 0": {func(c *Container) { c.Ports[0].ContainerPort = 0 }, "container port 0"},
+		"host port":        {func(c *Container) { c.Ports[0].HostPort = 70000 }, "out of range 0-65535"},
+		"protocol":         {func(c *Container) { c.Ports[0].Protocol = "icmp" }, "invalid protocol"},
+		"env name":         {func(c *Container) { c.Env = map[string]string{"A=B": "x"} }, "invalid env var name"},
+		"negative cpu":     {func(c *Container) { c.Resources.CPUCores = -1 }, "must not be negative"},
+	} {
+		c := validContainer()
+		tc.edit(c)
+		err := validateContainer(c)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error mentioning %q", name, err, tc.want)
+		}
+	}
+}
+
+func TestAdmitContainerNormalizesPorts(t *testing.T) {
+	c := validContainer()
+	c.Ports = []PortMapping{{ContainerPort: 80, Protocol: "UDP"}}
+
+	if err := admitContainer(c, nil); err != nil {
+		t.Fatal(err)
+	}
+	if pm := c.Ports[0]; pm.Protocol != "udp" || !pm.Auto {
+		t.Errorf("got %+v, want a lower-cased protocol and an auto host port", pm)
+	}
+}
+
+func TestAdmitContainerChecksDependencies(t *testing.T) {
+	c := validContainer()
+	c.DependsOn = []string{"db"}
+
+	if err := admitContainer(c, nil); err == nil {
+		t.Errorf("admitted a container depending on a missing one")
+	}
+	if err := admitContainer(c, []*Container{{ID: "db"}}); err != nil {
+		t.Errorf("with the dependency stored: %v", err)
+	}
+}
+
+func TestCreateRejectsInvalidContainer(t *testing.T) {
+	_, _, handler := newTestAPI(t)
+
+	for _, body := range []string{
+		`{"image": ""}`,
+		`{"image": "nginx", "ports": [{"container_port": 0}]}`,
+		`{"image": "nginx", "depends_on": ["missing"]}`,
+	} {
+		rec := call(t, handler, http.MethodPost, "/containers", body)
+		if rec.Code != http.StatusBadRequest || errorCode(t, rec) != codeBadRequest {
+			t.Errorf("%s: got %d %s, want 400", body, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := call(t, handler, http.MethodPost, "/containers", `{"image": "nginx", "state": "requested", "desired_state": "running", "ports": [{"host_port": 8080, "container_port": 80}]}`)
+	if rec.Code != http.StatusOK {
+		t.Errorf("valid container: got %d %s, want 200", rec.Code, rec.Body.String())
+	}
+}
+
+func TestPatchRejectsInvalidSpec(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+	mustSave(t, store, validContainer())
+
+	for _, body := range []string{
+		`{"image": ""}`,
+		`{"image": "bad image"}`,
+		`{"ports": [{"container_port": 70000}]}`,
+		`{"env": {"": "x"}}`,
+	} {
+		rec := call(t, handler, http.MethodPatch, "/containers/c1", body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d %s, want 400", body, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := call(t, handler, http.MethodPatch, "/containers/c1", `{"image": "nginx:2"}`)
+	if rec.Code != http.StatusOK {
+		t.Errorf("valid patch: got %d %s, want 200", rec.Code, rec.Body.String())
+	}
+}
+
+func TestBatchRejectsInvalidMember(t *testing.T) {
+	_, store, handler := newTestAPI(t)
+
+	rec := call(t, handler, http.MethodPost, "/containers/batch",
+		`[{"id": "a", "image": "nginx", "state": "requested", "desired_state": "running"},
+		  {"id": "b", "image": "nginx", "state": "requested", "desired_state": "running", "ports": [{"container_port": 0}]}]`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got %d %s, want 400", rec.Code, rec.Body.String())
+	}
+	if all, _ := store.ListContainers(context.Background()); len(all) != 0 {
+		t.Errorf("saved %d containers from an invalid batch", len(all))
+	}
+
+	var envelope struct {
+		Error struct {
+			Details []BatchItemResult `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	results := envelope.Error.Details
+	if len(results) != 2 || results[0].Error != "" || results[1].ID != "b" || results[1].Error == "" {
+		t.Errorf("per-item results = %+v, want only b reported invalid", results)
+	}
+
+	rec = call(t, handler, http.MethodPost, "/containers/batch", `[{"id": "a", "image": "nginx", "state": "requested", "desired_state": "running"},
+		  {"id": "b", "image": "redis", "state": "requested", "desired_state": "running"}]`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("valid batch: got %d %s, want 200", rec.Code, rec.Body.String())
+	}
+	if all, _ := store.ListContainers(context.Background()); len(all) != 2 {
+		t.Errorf("saved %d containers from a valid batch of 2", len(all))
+	}
+}
+
+func TestNodeValidate(t *testing.T) {
+	valid := func() *Node {
+		return &Node{ID: "w1", Role: Worker, Capacity: Resources{CPUCores: 4, MemoryMB: 8192}}
+	}
+	if err := validateNode(valid()); err != nil {
+		t.Fatalf("valid node: %v", err)
+	}
+	unlimited := valid()
+	unlimited.Capacity = Resources{}
+	if err := validateNode(unlimited); err != nil {
+		t.Errorf("node without capacity: %v, want it taken as unlimited", err)
+	}
+
+	for name, tc := range map[string]struct {
+		edit func(n *Node)
+		want string
+	}{
+		"no id":           {func(n *Node) { n.ID = "" }, "id is required"},
+		"bad role":        {func(n *Node) { n.Role = "observer" }, "role must be"},
+		"negative cpu":    {func(n *Node) { n.Capacity.CPUCores = -1 }, "cpu_cores -1 is negative"},
+		"negative memory": {func(n *Node) { n.Capacity.MemoryMB = -1 }, "memory_mb -1 is negative"},
+		"negative disk":   {func(n *Node) { n.Capacity.DiskGB = -1 }, "disk_gb -1 is negative"},
+		"negative max":    {func(n *Node) { n.MaxContainers = -1 }, "max_containers -1 is negative"},
+	} {
+		n := valid()
+		tc.edit(n)
+		err := validateNode(n)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: got %v, want an error mentioning %q", name, err, tc.want)
+		}
+	}
+}
+
+func TestRegisterRejectsInvalidNode(t *testing.T) {
+	s, store, handler := newTestAPI(t)
+
+	for name, tc := range map[string]struct {
+		body    string
+		require bool
+		want    int
+	}{
+		"valid":                 {`{"id":"w1","role":"worker","capacity":{"cpu_cores":2,"memory_mb":1024}}`, true, http.StatusOK},
+		"no capacity":           {`{"id":"w2","role":"worker"}`, false, http.StatusOK},
+		"negative cpu":          {`{"id":"w3","role":"worker","capacity":{"cpu_cores":-2}}`, false, http.StatusBadRequest},
+		"no id":                 {`{"role":"worker"}`, false, http.StatusBadRequest},
+		"no capacity, required": {`{"id":"w4","role":"worker"}`, true, http.StatusBadRequest},
+		"zero memory, required": {`{"id":"w5","role":"worker","capacity":{"cpu_cores":2}}`, true, http.StatusBadRequest},
+		"malformed":             {`{"id":`, false, http.StatusBadRequest},
+	} {
+		s.requireNodeCapacity = tc.require
+		rec := call(t, handler, http.MethodPost, "/nodes/register", tc.body)
+		if rec.Code != tc.want {
+			t.Errorf("%s: got %d %s, want %d", name, rec.Code, rec.Body.String(), tc.want)
+		}
+		if tc.want == http.StatusBadRequest && errorCode(t, rec) != codeBadRequest {
+			t.Errorf("%s: code %s, want %s", name, errorCode(t, rec), codeBadRequest)
+		}
+	}
+
+	nodes, err := store.ListNodes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 {
+		t.Errorf("stored %d nodes, want only the 2 valid registrations", len(nodes))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"strings"
+)
+
+// Build metadata, set at link time:
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)" -o cogs .
+var (
+	// version is the release of this build.
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+func currentVersion() VersionInfo {
+	return VersionInfo{
+		Version:    version,
+		Commit:     commit,
+		BuildDate:  buildDate,
+		APIVersion: apiVersion,
+		GoVersion:  runtime.Version(),
+	}
+}
+
+// majorVersion returns the major component of a vX.Y.Z or X.Y.Z release.
+// Builds without one, such as dev, report false.
+func majorVersion(v string) (string, bool) {
+	major, _, _ := strings.Cut(strings.TrimPrefix(v, "v"), ".")
+	if major == "" || strings.Trim(major, "0123456789") != "" {
+		return "", false
+	}
+	return major, true
+}
+
+// checkControlPlaneVersion warns when the control plane runs a different
+// major release than this worker.
+func checkControlPlaneVersion(client *APIClient) {
+	info, err := client.Version()
+	if err != nil {
+		log.Printf("Could not read the control plane version: %v", err)
+		return
+	}
+
+	ours, ok := majorVersion(version)
+	if !ok {
+		return
+	}
+	if theirs, ok := majorVersion(info.Version); ok && theirs != ours {
+		log.Printf("Warning: control plane runs %s but this worker is %s; major versions differ", info.Version, version)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestVersionEndpointReportsBuild(t *testing.T) {
+	oldVersion, oldCommit, oldDate := version, commit, buildDate
+	version, commit, buildDate = "v1.4.2", "abc1234", "2026-10-01T12:00:00Z"
+	t.Cleanup(func() { version, commit, buildDate = oldVersion, oldCommit, oldDate })
+
+	// outside the versioned prefix, so any client can read it
+	_, _, handler := newTestAPI(t)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+
+	var got VersionInfo
+	decodeData(t, rec, &got)
+	want := VersionInfo{Version: "v1.4.2", Commit: "abc1234", BuildDate: "2026-10-01T12:00:00Z",
+		APIVersion: apiVersion, GoVersion: runtime.Version()}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestMajorVersion(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+		ok       bool
+	}{
+		{"v1.4.2", "1", true},
+		{"2.0.0", "2", true},
+		{"v10.1", "10", true},
+		{"dev", "", false},
+		{"", "", false},
+	} {
+		got, ok := majorVersion(tc.in)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("majorVersion(%q) = %q, %v, want %q, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
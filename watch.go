@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// changeFeed fans out "something changed" signals to subscribers. Each
+// subscriber channel holds at most one pending signal, so bursts of writes
+// coalesce into a single wake-up.
+type changeFeed struct {
+	mu   sync.Mutex
+	subs map[chan struct{}]struct{}
+}
+
+func newChangeFeed() *changeFeed {
+	return &changeFeed{
+		subs: make(map[chan struct{}]struct{}),
+	}
+}
+
+func (f *changeFeed) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	f.mu.Lock()
+	f.subs[ch] = struct{}{}
+	f.mu.Unlock()
+
+	cancel := func() {
+		f.mu.Lock()
+		delete(f.subs, ch)
+		f.mu.Unlock()
+	}
+
+	return ch, cancel
+}
+
+func (f *changeFeed) Notify() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for ch := range f.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// notifyingStore wraps a Store and notifies the feed after every successful
+// container or secret write, whether it came from the API or the scheduler.
+type notifyingStore struct {
+	Store
+	feed *changeFeed
+}
+
+func newNotifyingStore(store Store) *notifyingStore {
+	return &notifyingStore{
+		Store: store,
+		feed:  newChangeFeed(),
+	}
+}
+
+func (s *notifyingStore) SaveContainer(ctx context.Context, c *Container) error {
+	if err := s.Store.SaveContainer(ctx, c); err != nil {
+		return err
+	}
+
+	s.feed.Notify()
+	return nil
+}
+
+func (s *notifyingStore) SaveContainers(ctx context.Context, cs []*Container) error {
+	if err := s.Store.SaveContainers(ctx, cs); err != nil {
+		return err
+	}
+
+	s.feed.Notify()
+	return nil
+}
+
+// SaveSecret notifies too, since assignments carry the referenced secret
+// versions and workers must recreate containers using an updated secret.
+func (s *notifyingStore) SaveSecret(ctx context.Context, secret *Secret) error {
+	if err := s.Store.SaveSecret(ctx, secret); err != nil {
+		return err
+	}
+
+	s.feed.Notify()
+	return nil
+}
+
+func (s *notifyingStore) DelContainer(ctx context.Context, id string) error {
+	if err := s.Store.DelContainer(ctx, id); err != nil {
+		return err
+	}
+
+	s.feed.Notify()
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/galadd/cogsworth/client"
+)
+
+func TestWatchPushesAssignmentChanges(t *testing.T) {
+	store := newNotifyingStore(NewMemStore())
+	s := NewAPIServer(store, store.feed, "")
+	s.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	s.logRequests = false
+	srv := httptest.NewServer(s.handler())
+	t.Cleanup(srv.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := make(chan []*Container, 4)
+	done := make(chan error, 1)
+	go func() {
+		done <- client.New(srv.URL, "", nil).WatchAssignedContainers(ctx, "w1", func(cs []*Container) {
+			updates <- cs
+		})
+	}()
+
+	next := func() []*Container {
+		t.Helper()
+		select {
+		case cs := <-updates:
+			return cs
+		case err := <-done:
+			t.Fatalf("watch ended: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no update from the watch")
+		}
+		return nil
+	}
+
+	if cs := next(); len(cs) != 0 {
+		t.Fatalf("initial set = %d containers, want none", len(cs))
+	}
+
+	mustSave(t, store, &Container{ID: "c1", Image: "nginx", State: Requested, DesiredState: Running,
+		NodeID: "w1", Scheduled: true})
+	if cs := next(); len(cs) != 1 || cs[0].ID != "c1" {
+		t.Errorf("after assigning c1 got %d containers, want c1", len(cs))
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("watch didn't stop with its context")
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultWorkerAddr is where a worker's agent API listens. The control plane
+// proxies per-container operations (stats, logs, ...) to it, and node-level
+// monitoring can scrape /healthz and /metrics there. Everything but /healthz
+// and /version requires the cluster token.
+const defaultWorkerAddr = ":8090"
+
+type WorkerServer struct {
+	runtime Runtime
+	token   string
+
+	// trigger starts an immediate worker reconcile.
+	trigger func()
+
+	// reconciled lists the containers as last reconciled on this node and
+	// metrics is served at /metrics; both are optional.
+	reconciled func() []*Container
+	metrics    io.WriterTo
+
+	server *http.Server
+}
+
+func NewWorkerServer(runtime Runtime, addr string) *WorkerServer {
+	return &WorkerServer{
+		runtime: runtime,
+		token:   os.Getenv(tokenEnv),
+		server:  &http.Server{Addr: addr},
+	}
+}
+
+// guard lets only requests carrying the cluster token through to h.
+func (s *WorkerServer) guard(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checkToken(w, r, s.token, "worker") {
+			h(w, r)
+		}
+	}
+}
+
+func (s *WorkerServer) Start() error {
+	ln, err := net.Listen("tcp", cmp.Or(s.server.Addr, ":http"))
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve serves the agent API on ln until Shutdown.
+func (s *WorkerServer) Serve(ln net.Listener) error {
+	s.server.Handler = s.handler()
+	if err := s.server.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *WorkerServer) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if err := pingRuntime(s.runtime); err != nil {
+			writeError(w, http.StatusServiceUnavailable, codeUnavailable, err.Error())
+			return
+		}
+		writeData(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		writeData(w, http.StatusOK, currentVersion())
+	})
+
+	mux.HandleFunc("/metrics", s.guard(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if s.metrics != nil {
+			s.metrics.WriteTo(w)
+		}
+	}))
+
+	mux.HandleFunc("/containers", s.guard(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		containers := []*Container{}
+		if s.reconciled != nil {
+			containers = append(containers, s.reconciled()...)
+		}
+		writeData(w, http.StatusOK, containers)
+	}))
+
+	mux.HandleFunc("/reconcile", s.guard(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		if s.trigger != nil {
+			s.trigger()
+		}
+		writeData(w, http.StatusAccepted, nil)
+	}))
+
+	mux.HandleFunc("/containers/", s.guard(func(w http.ResponseWriter, r *http.Request) {
+		containerID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/containers/"), "/")
+		if containerID == "" {
+			writeError(w, http.StatusBadRequest, codeBadRequest, "Container ID required")
+			return
+		}
+
+		switch action {
+		case "stats":
+			if r.Method != http.MethodGet {
+				writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+				return
+			}
+
+			stats, err := s.runtime.Stats(r.Context(), containerID)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+				return
+			}
+
+			writeData(w, http.StatusOK, stats)
+
+		case "logs":
+			if r.Method != http.MethodGet {
+				writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+				return
+			}
+
+			tail, err := strconv.Atoi(cmp.Or(r.URL.Query().Get("tail"), "100"))
+			if err != nil || tail < 0 {
+				writeError(w, http.StatusBadRequest, codeBadRequest, "tail must be a non-negative integer")
+				return
+			}
+			follow := r.URL.Query().Get("follow") == "true"
+
+			// the stream ends when the client disconnects
+			logs, err := s.runtime.LogStream(r.Context(), containerID, tail, follow)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+				return
+			}
+			defer logs.Close()
+
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			copyFlush(w, logs)
+
+		case "exec":
+			if r.Method != http.MethodPost {
+				writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+				return
+			}
+
+			var req ExecRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+				return
+			}
+			if len(req.Cmd) == 0 {
+				writeError(w, http.StatusBadRequest, codeBadRequest, "cmd is required")
+				return
+			}
+
+			stream, err := s.runtime.Exec(r.Context(), containerID, req.Cmd)
+			if errors.Is(err, ErrContainerNotRunning) {
+				writeError(w, http.StatusConflict, codeConflict, err.Error())
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, codeInternal, err.Error())
+				return
+			}
+			defer stream.Close()
+
+			// exec is non-interactive for now, so the command gets no input
+			if cw, ok := stream.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+			}
+
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			copyFlush(w, stream)
+
+		default:
+			writeError(w, http.StatusNotFound, codeNotFound, "unknown container action")
+		}
+	}))
+
+	return mux
+}
+
+// copyFlush copies src to w, flushing after every chunk so streamed output
+// such as followed logs reaches the client as it is produced.
+func copyFlush(w http.ResponseWriter, src io.Reader) error {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *WorkerServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
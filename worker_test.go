@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWorkerServerRequiresToken(t *testing.T) {
+	s := NewWorkerServer(NewFakeRuntime(), "")
+	s.token = "secret"
+	handler := s.handler()
+
+	for _, tc := range []struct {
+		method, path string
+	}{
+		{http.MethodGet, "/metrics"},
+		{http.MethodGet, "/containers"},
+		{http.MethodPost, "/reconcile"},
+		{http.MethodGet, "/containers/abc/stats"},
+		{http.MethodGet, "/containers/abc/logs"},
+		{http.MethodPost, "/containers/abc/exec"},
+	} {
+		for _, auth := range []string{"", "Bearer wrong"} {
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(`{"cmd":["true"]}`))
+			if auth != "" {
+				req.Header.Set("Authorization", auth)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("%s %s with %q: got %d, want 401", tc.method, tc.path, auth, rec.Code)
+			}
+		}
+	}
+}
+
+func TestWorkerServerAcceptsToken(t *testing.T) {
+	s := NewWorkerServer(NewFakeRuntime(), "")
+	s.token = "secret"
+	triggered := false
+	s.trigger = func() { triggered = true }
+
+	req := httptest.NewRequest(http.MethodPost, "/reconcile", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusAccepted || !triggered {
+		t.Errorf("got %d (triggered %v), want 202 and a reconcile", rec.Code, triggered)
+	}
+}
+
+func TestWorkerServerWithoutTokenRefusesGuardedEndpoints(t *testing.T) {
+	s := NewWorkerServer(NewFakeRuntime(), "")
+	s.token = ""
+
+	req := httptest.NewRequest(http.MethodGet, "/containers", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rec := httptest.NewRecorder()
+	s.handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("got %d, want 403", rec.Code)
+	}
+}
+
+func TestWorkerServerHealthIsOpen(t *testing.T) {
+	s := NewWorkerServer(NewFakeRuntime(), "")
+	s.token = "secret"
+
+	for _, path := range []string{"/healthz", "/version"} {
+		rec := httptest.NewRecorder()
+		s.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: got %d, want 200", path, rec.Code)
+		}
+	}
+}
+
+func TestWorkerServerServesHealthAndMetrics(t *testing.T) {
+	runtime := NewFakeRuntime()
+	s := NewWorkerServer(runtime, "")
+	s.token = "secret"
+	s.metrics = newCounter("cogs_test_total", "A test counter.")
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(ln) }()
+	base := "http://" + ln.Addr().String()
+
+	get := func(path, token string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, base+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, body := get("/healthz", ""); code != http.StatusOK || !strings.Contains(body, `"ok"`) {
+		t.Errorf("/healthz: %d %s, want 200 ok", code, body)
+	}
+	if code, body := get("/metrics", "secret"); code != http.StatusOK || !strings.Contains(body, "cogs_test_total 0") {
+		t.Errorf("/metrics: %d %s, want the counter", code, body)
+	}
+
+	runtime.FailPing(errors.New("daemon unreachable"))
+	if code, _ := get("/healthz", ""); code != http.StatusServiceUnavailable {
+		t.Errorf("/healthz with the runtime down: %d, want 503", code)
+	}
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve: %v, want nil after Shutdown", err)
+	}
+}